@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Account statuses
+const (
+	// StatusActive accounts can do everything
+	StatusActive = "active"
+	// StatusPendingVerification accounts have not verified their email address yet,
+	// what they can do depends on the email policy
+	StatusPendingVerification = "pending_verification"
+	// StatusSuspended accounts were shut out by a moderator, usually for a while
+	StatusSuspended = "suspended"
+	// StatusLocked accounts were shut out because they may have been taken over
+	StatusLocked = "locked"
+	// StatusDeactivated accounts were closed until they are reactivated
+	StatusDeactivated = "deactivated"
+)
+
+const restrictedKeyPrefix = "account_restricted:"
+
+// unrestrictedTTL is how long an account the database found unrestricted is
+// trusted to stay so before the database is asked again.
+const unrestrictedTTL = time.Minute
+
+// Restricted reports whether accounts in the status can neither log in nor use their tokens.
+func Restricted(status string) bool {
+	switch status {
+	case StatusSuspended, StatusLocked, StatusDeactivated:
+		return true
+	}
+	return false
+}
+
+// CurrentStatus is the status an account is in now. Once a restriction ran out the
+// account is back to active, or to waiting for verification. A zero expiresAt never runs out.
+func CurrentStatus(status string, expiresAt time.Time, emailVerified bool) string {
+	if !Restricted(status) || expiresAt.IsZero() || time.Now().Before(expiresAt) {
+		return status
+	}
+	if !emailVerified {
+		return StatusPendingVerification
+	}
+	return StatusActive
+}
+
+// RestrictAccount marks the user's account as restricted until expiresAt, or for
+// good when it is zero, so their tokens are refused without asking the database.
+func RestrictAccount(ctx context.Context, rdb *redis.Client, subject string, status string, expiresAt time.Time) error {
+	var ttl time.Duration
+	if !expiresAt.IsZero() {
+		ttl = time.Until(expiresAt)
+		if ttl <= 0 {
+			return rdb.Del(ctx, restrictedKeyPrefix+subject).Err()
+		}
+	}
+	return rdb.Set(ctx, restrictedKeyPrefix+subject, status, ttl).Err()
+}
+
+// LiftRestriction lets the user's tokens be used again.
+func LiftRestriction(ctx context.Context, rdb *redis.Client, subject string) error {
+	return rdb.Del(ctx, restrictedKeyPrefix+subject).Err()
+}
+
+// AccountRestriction returns the status the user's account is restricted by, or an
+// empty string. known is false when Redis holds nothing about the account, for
+// example after it was flushed, and only the database can tell.
+func AccountRestriction(ctx context.Context, rdb *redis.Client, subject string) (status string, known bool, err error) {
+	status, err = rdb.Get(ctx, restrictedKeyPrefix+subject).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	if !Restricted(status) {
+		return "", true, nil
+	}
+	return status, true, nil
+}
+
+// MarkUnrestricted records that the database found the user's account unrestricted,
+// so their next requests do not ask it again for a while. A restriction set
+// meanwhile is left in place.
+func MarkUnrestricted(ctx context.Context, rdb *redis.Client, subject string) error {
+	return rdb.SetNX(ctx, restrictedKeyPrefix+subject, StatusActive, unrestrictedTTL).Err()
+}
@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// APIKeyPrefix starts every API key, so keys are easy to tell apart from JWTs
+// and to find when they leak into logs or repositories.
+const APIKeyPrefix = "umk_"
+
+// ScopesKey is the gin context key AuthMiddleware stores an API key's scopes under.
+// It is only set for requests authenticated with an API key or an access token
+// issued to another app.
+const ScopesKey = "scopes"
+
+// apiKeyDisplayLength is how much of a key is kept in the clear to tell keys apart
+const apiKeyDisplayLength = len(APIKeyPrefix) + 8
+
+// GenerateAPIKey returns a new API key and the part of it that may be shown
+// again later. The key itself is only known when it is created.
+func GenerateAPIKey() (key string, displayPrefix string, err error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	key = APIKeyPrefix + base64.RawURLEncoding.EncodeToString(buf)
+	return key, key[:apiKeyDisplayLength], nil
+}
+
+// IsAPIKey reports whether a bearer token is an API key rather than a JWT.
+func IsAPIKey(token string) bool {
+	return strings.HasPrefix(token, APIKeyPrefix)
+}
+
+// HashAPIKey returns what is stored to look an API key up by.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// ScopesFromContext returns the scopes of the API key or app token the request
+// was authenticated with. It returns false for the service's own access tokens.
+func ScopesFromContext(c *gin.Context) ([]string, bool) {
+	scopesRaw, exists := c.Get(ScopesKey)
+	if !exists {
+		return nil, false
+	}
+	scopes, ok := scopesRaw.([]string)
+	return scopes, ok
+}
+
+// HasScope reports whether the request may act within scope. Requests
+// authenticated with a JWT act with everything their user may do.
+func HasScope(c *gin.Context, scope string) bool {
+	scopes, isAPIKey := ScopesFromContext(c)
+	return !isAPIKey || slices.Contains(scopes, scope)
+}
@@ -0,0 +1,29 @@
+package auth
+
+import (
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ClaimsKey is the gin context key AuthMiddleware stores the token claims under.
+const ClaimsKey = "claims"
+
+// FromContext returns the claims of the authenticated request.
+func FromContext(c *gin.Context) (*Claims, bool) {
+	claimsRaw, exists := c.Get(ClaimsKey)
+	if !exists {
+		return nil, false
+	}
+	claims, ok := claimsRaw.(*Claims)
+	return claims, ok && claims != nil
+}
+
+// UserID returns the id of the user the token was issued to.
+func (c *Claims) UserID() (int32, bool) {
+	id, err := strconv.ParseInt(c.Subject, 10, 32)
+	if err != nil {
+		return 0, false
+	}
+	return int32(id), true
+}
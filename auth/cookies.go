@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"main/config"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// AccessTokenCookie carries the access token of a browser session
+	AccessTokenCookie = "access_token"
+	// RefreshTokenCookie carries the refresh token, only to the endpoints that use it
+	RefreshTokenCookie     = "refresh_token"
+	refreshTokenCookiePath = "/api/users"
+	// CSRFCookie carries the token scripts echo in CSRFHeader. It is readable by
+	// scripts of the site on purpose, other sites cannot read it.
+	CSRFCookie = "csrf_token"
+	CSRFHeader = "X-CSRF-Token"
+
+	// CookieAuthKey is the gin context key AuthMiddleware sets when the request was
+	// authenticated with the access token cookie rather than the Authorization header.
+	CookieAuthKey = "cookie_auth"
+)
+
+// SessionCookiesEnabled reports whether logins also set session cookies.
+func SessionCookiesEnabled() bool {
+	return config.AppConfig.SessionCookie.Enabled
+}
+
+func sameSite() http.SameSite {
+	if strings.EqualFold(config.AppConfig.SessionCookie.SameSite, "strict") {
+		return http.SameSiteStrictMode
+	}
+	return http.SameSiteLaxMode
+}
+
+func setCookie(c *gin.Context, name string, value string, path string, ttl time.Duration, httpOnly bool) {
+	c.SetSameSite(sameSite())
+	c.SetCookie(name, value, int(ttl.Seconds()), path, config.AppConfig.SessionCookie.Domain, true, httpOnly)
+}
+
+// SetSessionCookies hands the browser the session's tokens as cookies, along with
+// a new CSRF token, which it returns.
+func SetSessionCookies(c *gin.Context, accessToken string, refreshToken string, refreshTTL time.Duration) (string, error) {
+	csrfToken, err := newTokenID()
+	if err != nil {
+		return "", err
+	}
+
+	setCookie(c, AccessTokenCookie, accessToken, "/", refreshTTL, true)
+	setCookie(c, RefreshTokenCookie, refreshToken, refreshTokenCookiePath, refreshTTL, true)
+	setCookie(c, CSRFCookie, csrfToken, "/", refreshTTL, false)
+	return csrfToken, nil
+}
+
+// ClearSessionCookies makes the browser forget the session.
+func ClearSessionCookies(c *gin.Context) {
+	setCookie(c, AccessTokenCookie, "", "/", -time.Second, true)
+	setCookie(c, RefreshTokenCookie, "", refreshTokenCookiePath, -time.Second, true)
+	setCookie(c, CSRFCookie, "", "/", -time.Second, false)
+}
+
+// ValidCSRF reports whether the request echoes the CSRF cookie in the CSRF header,
+// which only scripts of the site the cookie belongs to can do.
+func ValidCSRF(c *gin.Context) bool {
+	cookie, err := c.Cookie(CSRFCookie)
+	header := c.GetHeader(CSRFHeader)
+	return err == nil && cookie != "" && subtle.ConstantTimeCompare([]byte(cookie), []byte(header)) == 1
+}
+
+// AllowedOrigin reports whether the request comes from a page of the API's own
+// origin or one of the configured ones. Browsers always send the origin of
+// cross-site WebSocket handshakes, so they cannot ride on the cookie unnoticed.
+func AllowedOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	parsed, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(parsed.Host, r.Host) {
+		return true
+	}
+	return slices.ContainsFunc(config.AppConfig.SessionCookie.AllowedOrigins, func(allowed string) bool {
+		return strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin)
+	})
+}
@@ -0,0 +1,23 @@
+package auth
+
+import "main/config"
+
+// Policies for accounts that have not verified their email address yet
+const (
+	// EmailPolicyNone lets unverified accounts do everything verified ones can
+	EmailPolicyNone = "none"
+	// EmailPolicyRestricted lets unverified accounts log in, but keeps them out of
+	// routes that require a verified address
+	EmailPolicyRestricted = "restricted"
+	// EmailPolicyLogin refuses to log unverified accounts in at all
+	EmailPolicyLogin = "login"
+)
+
+// EmailPolicy returns the configured policy for unverified accounts.
+func EmailPolicy() string {
+	switch policy := config.AppConfig.EmailVerification.Policy; policy {
+	case EmailPolicyNone, EmailPolicyLogin:
+		return policy
+	}
+	return EmailPolicyRestricted
+}
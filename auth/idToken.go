@@ -0,0 +1,26 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// IDToken tells an app who logged in through it. It is signed like access
+// tokens but is meant for the app named in its audience, so
+// AuthMiddleware does not accept it.
+type IDToken struct {
+	Nonce             string `json:"nonce,omitempty"`
+	Email             string `json:"email,omitempty"`
+	EmailVerified     *bool  `json:"email_verified,omitempty"`
+	PreferredUsername string `json:"preferred_username,omitempty"`
+	jwt.StandardClaims
+}
+
+// GenerateIDToken signs an id token that is valid for ttl from now.
+func GenerateIDToken(claims *IDToken, ttl time.Duration) (string, error) {
+	now := time.Now()
+	claims.IssuedAt = now.Unix()
+	claims.ExpiresAt = now.Add(ttl).Unix()
+	return Sign(claims)
+}
@@ -0,0 +1,195 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"main/config"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+const (
+	defaultAccessTokenTTL = 15 * time.Minute
+
+	// PurposeMFA marks the token handed out by the password step of a login when the
+	// account has two-factor authentication enabled. It only buys a second factor check.
+	PurposeMFA  = "mfa"
+	MFATokenTTL = 5 * time.Minute
+
+	// PurposeEmailVerification marks the token mailed out to prove the user owns an address.
+	PurposeEmailVerification = "verify_email"
+
+	// PurposeMagicLink marks the token of an emailed login link. It is only good once, from
+	// the browser the link was asked for in.
+	PurposeMagicLink = "magic_link"
+)
+
+var ErrInvalidToken = errors.New("invalid token")
+
+type Claims struct {
+	Username   string   `json:"username"`
+	Roles      []string `json:"roles,omitempty"`
+	Generation int64    `json:"gen"`
+	// EmailVerified is false for accounts that have not confirmed their address yet
+	EmailVerified bool `json:"email_verified"`
+	// Email is the address a verification token was issued for
+	Email string `json:"email,omitempty"`
+	// Purpose is empty for access tokens
+	Purpose string `json:"purpose,omitempty"`
+	// SessionID is the login session an access token belongs to, it stays the same across refreshes
+	SessionID string `json:"sid,omitempty"`
+	// ClientID is the app an access token was issued to through OAuth, empty for the service's own logins
+	ClientID string `json:"client_id,omitempty"`
+	// Scope lists what an app's access token may do, separated by spaces
+	Scope string `json:"scope,omitempty"`
+	// Actor is the admin acting as the user when the token was issued for impersonation
+	Actor *Actor `json:"act,omitempty"`
+	jwt.StandardClaims
+}
+
+// Actor is who acts as the token's subject, see RFC 8693 section 4.1.
+type Actor struct {
+	Subject  string `json:"sub"`
+	Username string `json:"username,omitempty"`
+}
+
+// HasRole reports whether the token carries any of the given roles.
+func (c *Claims) HasRole(roles ...string) bool {
+	for _, have := range c.Roles {
+		for _, want := range roles {
+			if have == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func AccessTokenTTL() time.Duration {
+	if ttl := config.AppConfig.JWT.AccessTokenTTL; ttl > 0 {
+		return ttl
+	}
+	return defaultAccessTokenTTL
+}
+
+func newTokenID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// GenerateJWT issues an access token for the user described by claims. The
+// subject is the user id and the generation must be the user's current token
+// generation, see TokenGeneration. The token id and lifetime are filled in here.
+func GenerateJWT(claims *Claims) (string, error) {
+	return generate(claims, AccessTokenTTL())
+}
+
+// GenerateImpersonationToken issues an access token that lets the actor act as the
+// user described by claims for ttl, capped at the access token lifetime.
+func GenerateImpersonationToken(claims *Claims, actor Actor, ttl time.Duration) (string, error) {
+	if ttl <= 0 || ttl > AccessTokenTTL() {
+		ttl = AccessTokenTTL()
+	}
+	claims.Actor = &actor
+	return generate(claims, ttl)
+}
+
+// GenerateMFAToken issues the short-lived token that carries a login from the
+// password step over to the second factor.
+func GenerateMFAToken(claims *Claims) (string, error) {
+	return GeneratePurposeToken(claims, PurposeMFA, MFATokenTTL)
+}
+
+// GeneratePurposeToken issues a token that is only good for the given purpose.
+// The authentication middleware refuses such tokens.
+func GeneratePurposeToken(claims *Claims, purpose string, ttl time.Duration) (string, error) {
+	claims.Purpose = purpose
+	return generate(claims, ttl)
+}
+
+func generate(claims *Claims, ttl time.Duration) (string, error) {
+	jti, err := newTokenID()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	claims.Id = jti
+	claims.IssuedAt = now.Unix()
+	claims.ExpiresAt = now.Add(ttl).Unix()
+
+	return Sign(claims)
+}
+
+// ParseJWT validates the signature and expiry of an access token and returns its claims.
+func ParseJWT(tokenString string) (*Claims, error) {
+	claims := &Claims{}
+	if err := Parse(tokenString, claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// Sign signs the claims with the current key of Keys, or with the shared
+// HS256 key when no asymmetric key is configured.
+func Sign(claims jwt.Claims) (string, error) {
+	key := Keys.Current()
+	if key == nil {
+		if algorithm() != AlgorithmHS256 {
+			return "", ErrUnknownKey
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		return token.SignedString([]byte(config.AppConfig.JWT.Key))
+	}
+
+	token := jwt.NewWithClaims(key.method(), claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.signingKey())
+}
+
+// Parse verifies a token signed by Sign and decodes it into claims.
+func Parse(tokenString string, claims jwt.Claims) error {
+	token, err := jwt.ParseWithClaims(tokenString, claims, keyFunc)
+	if err != nil {
+		return err
+	}
+	if !token.Valid {
+		return ErrInvalidToken
+	}
+	return nil
+}
+
+func keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		// Tokens without a key id are only accepted while the shared key is in use
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok || algorithm() != AlgorithmHS256 {
+			return nil, fmt.Errorf("unexpected signing method")
+		}
+
+		// Ensure the key is not empty before returning it
+		if config.AppConfig.JWT.Key == "" {
+			return nil, fmt.Errorf("JWT secret key is empty")
+		}
+
+		return []byte(config.AppConfig.JWT.Key), nil
+	}
+
+	key := Keys.Lookup(kid)
+	if key == nil {
+		return nil, ErrUnknownKey
+	}
+
+	// Check signing method
+	if token.Method.Alg() != key.Algorithm {
+		return nil, fmt.Errorf("unexpected signing method")
+	}
+
+	return key.verificationKey(), nil
+}
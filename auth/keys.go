@@ -0,0 +1,431 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"main/config"
+	"main/utility"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"go.uber.org/zap"
+)
+
+const (
+	AlgorithmHS256 = "HS256"
+	AlgorithmRS256 = "RS256"
+	AlgorithmEdDSA = "EdDSA"
+
+	// key ids are the creation time of the key so they sort and tell their age
+	keyIDLayout = "20060102T150405Z"
+
+	defaultKeyRetention = 24 * time.Hour
+	rsaKeyBits          = 2048
+)
+
+var ErrUnknownKey = errors.New("unknown signing key")
+
+type SigningKey struct {
+	ID        string
+	Algorithm string
+	CreatedAt time.Time
+	File      string
+	Private   crypto.Signer
+}
+
+func (k *SigningKey) method() jwt.SigningMethod {
+	return jwt.GetSigningMethod(k.Algorithm)
+}
+
+func (k *SigningKey) signingKey() interface{} {
+	if edKey, ok := k.Private.(ed25519.PrivateKey); ok {
+		return edKey
+	}
+	return k.Private
+}
+
+func (k *SigningKey) verificationKey() interface{} {
+	return k.Private.Public()
+}
+
+// KeySet holds every key tokens may be signed with. The newest key that is
+// already active signs new tokens, older ones are kept for verification until
+// they are retired.
+type KeySet struct {
+	mu      sync.RWMutex
+	keys    map[string]*SigningKey
+	current *SigningKey
+}
+
+// Keys is the key set used by GenerateJWT and ParseJWT. It stays empty until
+// InitKeys is called, in which case tokens are signed with the shared HS256 key.
+var Keys = NewKeySet()
+
+func NewKeySet() *KeySet {
+	return &KeySet{keys: make(map[string]*SigningKey)}
+}
+
+func algorithm() string {
+	if alg := config.AppConfig.JWT.Algorithm; alg != "" {
+		return alg
+	}
+	return AlgorithmHS256
+}
+
+func keyRetention() time.Duration {
+	retention := config.AppConfig.JWT.KeyRetention
+	if retention <= 0 {
+		retention = defaultKeyRetention
+	}
+	if ttl := AccessTokenTTL(); retention < ttl {
+		retention = ttl
+	}
+	return retention
+}
+
+// InitKeys loads the signing keys configured under jwt. When an asymmetric
+// algorithm is configured but no key exists yet, a first key is generated into
+// jwt.keys_dir, where the other instances find it. It fails rather than sign with
+// a key that only lives in this process.
+func InitKeys() error {
+	logger := utility.AppLogger.Logger
+
+	alg := algorithm()
+	if alg == AlgorithmHS256 {
+		logger.Info("Signing tokens with the shared HS256 key")
+		return nil
+	}
+
+	dir := config.AppConfig.JWT.KeysDir
+	if dir == "" {
+		return fmt.Errorf("jwt.keys_dir must be set to sign with %s", alg)
+	}
+	if _, err := os.ReadDir(dir); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("jwt.keys_dir cannot be read: %w", err)
+	}
+
+	if err := Keys.Load(); err != nil {
+		logger.Error("Failed to load signing keys", zap.Error(err))
+	}
+
+	if Keys.Current() == nil {
+		key, err := Keys.Rotate(alg, dir)
+		if err != nil {
+			return fmt.Errorf("generate signing key: %w", err)
+		}
+		logger.Warn("No signing key found, generated a new one", zap.String("kid", key.ID), zap.String("file", key.File))
+	}
+
+	logger.Info("Signing keys loaded", zap.String("algorithm", alg), zap.String("kid", Keys.Current().ID))
+	return nil
+}
+
+// RunKeyRotation periodically reloads the key files, generates a new signing
+// key once the current one is older than jwt.rotation_interval and retires
+// keys that have been replaced for longer than jwt.key_retention.
+func RunKeyRotation(ctx context.Context) {
+	interval := config.AppConfig.JWT.RotationInterval
+	if algorithm() == AlgorithmHS256 || interval <= 0 {
+		return
+	}
+
+	logger := utility.AppLogger.Logger
+	ticker := time.NewTicker(min(max(interval/24, time.Minute), time.Hour))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := Keys.Load(); err != nil {
+				logger.Error("Failed to reload signing keys", zap.Error(err))
+			}
+
+			current := Keys.Current()
+			if current == nil || time.Since(current.CreatedAt) >= interval {
+				key, err := Keys.Rotate(algorithm(), config.AppConfig.JWT.KeysDir)
+				if err != nil {
+					logger.Error("Failed to rotate signing key", zap.Error(err))
+					continue
+				}
+				logger.Info("Signing key rotated", zap.String("kid", key.ID))
+			}
+
+			for _, key := range Keys.Retire(keyRetention()) {
+				logger.Info("Signing key retired", zap.String("kid", key.ID))
+			}
+		}
+	}
+}
+
+// Load reads the key files listed under jwt.keys and every *.pem file in jwt.keys_dir.
+func (ks *KeySet) Load() error {
+	files := make(map[string]string)
+	for _, key := range config.AppConfig.JWT.Keys {
+		files[key.ID] = key.File
+	}
+
+	if dir := config.AppConfig.JWT.KeysDir; dir != "" {
+		matches, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+		if err != nil {
+			return err
+		}
+		for _, file := range matches {
+			files[strings.TrimSuffix(filepath.Base(file), ".pem")] = file
+		}
+	}
+
+	var errs []error
+	for kid, file := range files {
+		key, err := loadKeyFile(kid, file)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("key %s: %w", kid, err))
+			continue
+		}
+		ks.Add(key)
+	}
+	return errors.Join(errs...)
+}
+
+// Add puts the key into the set and makes it the signing key if it is the newest active one.
+func (ks *KeySet) Add(key *SigningKey) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	ks.keys[key.ID] = key
+	ks.pickCurrent()
+}
+
+func (ks *KeySet) pickCurrent() {
+	now := time.Now()
+	ks.current = nil
+	for _, key := range ks.keys {
+		if key.CreatedAt.After(now) {
+			continue
+		}
+		if ks.current == nil || key.CreatedAt.After(ks.current.CreatedAt) {
+			ks.current = key
+		}
+	}
+}
+
+// Current returns the key new tokens are signed with, or nil when the set is empty.
+func (ks *KeySet) Current() *SigningKey {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	// keys published ahead of time become the signing key once they are active
+	ks.pickCurrent()
+	return ks.current
+}
+
+func (ks *KeySet) Lookup(kid string) *SigningKey {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	return ks.keys[kid]
+}
+
+// All returns the keys of the set, oldest first.
+func (ks *KeySet) All() []*SigningKey {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	keys := make([]*SigningKey, 0, len(ks.keys))
+	for _, key := range ks.keys {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+	return keys
+}
+
+// Rotate generates a new key, stores it in dir when one is given and makes it the signing key.
+func (ks *KeySet) Rotate(alg string, dir string) (*SigningKey, error) {
+	now := time.Now().UTC().Truncate(time.Second)
+	key := &SigningKey{
+		ID:        now.Format(keyIDLayout),
+		Algorithm: alg,
+		CreatedAt: now,
+	}
+
+	switch alg {
+	case AlgorithmRS256:
+		private, err := rsa.GenerateKey(rand.Reader, rsaKeyBits)
+		if err != nil {
+			return nil, err
+		}
+		key.Private = private
+	case AlgorithmEdDSA:
+		_, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		key.Private = private
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
+
+	if dir != "" {
+		if err := writeKeyFile(key, dir); err != nil {
+			return nil, err
+		}
+	}
+
+	ks.Add(key)
+	return key, nil
+}
+
+// Retire drops keys that were replaced by a newer active key more than retention ago.
+func (ks *KeySet) Retire(retention time.Duration) []*SigningKey {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	now := time.Now()
+	var retired []*SigningKey
+	for kid, key := range ks.keys {
+		if ks.current == nil || key == ks.current || !key.CreatedAt.Before(ks.current.CreatedAt) {
+			continue
+		}
+		// keys listed under jwt.keys are managed by hand
+		if !isManagedKey(key) {
+			continue
+		}
+
+		// the key was last used to sign when its successor became active
+		replacedAt := ks.current.CreatedAt
+		for _, other := range ks.keys {
+			if other.CreatedAt.After(key.CreatedAt) && other.CreatedAt.Before(replacedAt) {
+				replacedAt = other.CreatedAt
+			}
+		}
+		if now.Sub(replacedAt) < retention {
+			continue
+		}
+
+		delete(ks.keys, kid)
+		if key.File != "" {
+			_ = os.Remove(key.File)
+		}
+		retired = append(retired, key)
+	}
+	return retired
+}
+
+func isManagedKey(key *SigningKey) bool {
+	if key.File == "" {
+		return true
+	}
+	dir := config.AppConfig.JWT.KeysDir
+	return dir != "" && filepath.Dir(key.File) == filepath.Clean(dir)
+}
+
+func loadKeyFile(kid string, file string) (*SigningKey, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+
+	var private interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		private, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		private, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		err = fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key := &SigningKey{ID: kid, File: file}
+	switch private := private.(type) {
+	case *rsa.PrivateKey:
+		key.Algorithm = AlgorithmRS256
+		key.Private = private
+	case ed25519.PrivateKey:
+		key.Algorithm = AlgorithmEdDSA
+		key.Private = private
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", private)
+	}
+
+	if createdAt, err := time.Parse(keyIDLayout, kid); err == nil {
+		key.CreatedAt = createdAt
+	} else if info, err := os.Stat(file); err == nil {
+		key.CreatedAt = info.ModTime()
+	}
+	return key, nil
+}
+
+func writeKeyFile(key *SigningKey, dir string) error {
+	der, err := x509.MarshalPKCS8PrivateKey(key.Private)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+
+	key.File = filepath.Join(dir, key.ID+".pem")
+	return os.WriteFile(key.File, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600)
+}
+
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public half of every key in the set.
+func (ks *KeySet) JWKS() JWKSet {
+	set := JWKSet{Keys: make([]JWK, 0)}
+	for _, key := range ks.All() {
+		jwk := JWK{KeyID: key.ID, Use: "sig", Algorithm: key.Algorithm}
+		switch public := key.Private.Public().(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		default:
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
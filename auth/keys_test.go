@@ -0,0 +1,130 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"main/config"
+	"main/utility"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// useKeySet swaps the package key set for the duration of the test
+func useKeySet(t *testing.T, alg string) *KeySet {
+	t.Helper()
+	previousKeys, previousConfig := Keys, config.AppConfig
+	t.Cleanup(func() {
+		Keys, config.AppConfig = previousKeys, previousConfig
+	})
+
+	Keys = NewKeySet()
+	config.AppConfig.JWT.Algorithm = alg
+	config.AppConfig.JWT.KeysDir = t.TempDir()
+	return Keys
+}
+
+func TestSignAndParseWithKeySet(t *testing.T) {
+	for _, alg := range []string{AlgorithmRS256, AlgorithmEdDSA} {
+		t.Run(alg, func(t *testing.T) {
+			ks := useKeySet(t, alg)
+			key, err := ks.Rotate(alg, config.AppConfig.JWT.KeysDir)
+			require.NoError(t, err)
+
+			token, err := GenerateJWT(&Claims{Username: "tester"})
+			require.NoError(t, err)
+
+			parsed, _ := jwt.Parse(token, nil)
+			require.NotNil(t, parsed)
+			assert.Equal(t, key.ID, parsed.Header["kid"])
+			assert.Equal(t, alg, parsed.Header["alg"])
+
+			claims, err := ParseJWT(token)
+			require.NoError(t, err)
+			assert.Equal(t, "tester", claims.Username)
+
+			// keys written to keys_dir are picked up by a fresh key set
+			Keys = NewKeySet()
+			require.NoError(t, Keys.Load())
+			_, err = ParseJWT(token)
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestInitKeys(t *testing.T) {
+	utility.Init()
+	useKeySet(t, AlgorithmEdDSA)
+	dir := config.AppConfig.JWT.KeysDir
+
+	// every instance would sign with a key of its own
+	config.AppConfig.JWT.KeysDir = ""
+	assert.Error(t, InitKeys())
+	assert.Nil(t, Keys.Current())
+
+	// the first instance generates the key, the next one signs with the same key
+	config.AppConfig.JWT.KeysDir = dir
+	require.NoError(t, InitKeys())
+	first := Keys.Current()
+	require.NotNil(t, first)
+	assert.FileExists(t, first.File)
+
+	Keys = NewKeySet()
+	require.NoError(t, InitKeys())
+	assert.Equal(t, first.ID, Keys.Current().ID)
+}
+
+func TestParseRejectsSharedKeyTokensInAsymmetricMode(t *testing.T) {
+	ks := useKeySet(t, AlgorithmEdDSA)
+	config.AppConfig.JWT.Key = "shared-secret"
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &Claims{Username: "tester"}).SignedString([]byte("shared-secret"))
+	require.NoError(t, err)
+
+	_, err = ks.Rotate(AlgorithmEdDSA, "")
+	require.NoError(t, err)
+
+	_, err = ParseJWT(token)
+	assert.Error(t, err)
+}
+
+func TestJWKS(t *testing.T) {
+	ks := useKeySet(t, AlgorithmRS256)
+	_, err := ks.Rotate(AlgorithmRS256, "")
+	require.NoError(t, err)
+	ks.Add(&SigningKey{ID: "ed", Algorithm: AlgorithmEdDSA, CreatedAt: time.Now().Add(time.Hour), Private: mustEd25519Key(t)})
+
+	set := ks.JWKS()
+	require.Len(t, set.Keys, 2)
+	assert.Equal(t, "RSA", set.Keys[0].KeyType)
+	assert.NotEmpty(t, set.Keys[0].N)
+	assert.Equal(t, "AQAB", set.Keys[0].E)
+	assert.Equal(t, "OKP", set.Keys[1].KeyType)
+	assert.Equal(t, "Ed25519", set.Keys[1].Curve)
+
+	// a key published ahead of time does not sign until it is active
+	assert.NotEqual(t, "ed", ks.Current().ID)
+}
+
+func TestRetire(t *testing.T) {
+	ks := useKeySet(t, AlgorithmEdDSA)
+	now := time.Now()
+	ks.Add(&SigningKey{ID: "old", Algorithm: AlgorithmEdDSA, CreatedAt: now.Add(-48 * time.Hour), Private: mustEd25519Key(t)})
+	ks.Add(&SigningKey{ID: "previous", Algorithm: AlgorithmEdDSA, CreatedAt: now.Add(-30 * time.Hour), Private: mustEd25519Key(t)})
+	ks.Add(&SigningKey{ID: "current", Algorithm: AlgorithmEdDSA, CreatedAt: now.Add(-time.Hour), Private: mustEd25519Key(t)})
+
+	retired := ks.Retire(24 * time.Hour)
+	require.Len(t, retired, 1)
+	assert.Equal(t, "old", retired[0].ID)
+	assert.NotNil(t, ks.Lookup("previous"))
+	assert.Equal(t, "current", ks.Current().ID)
+}
+
+func mustEd25519Key(t *testing.T) ed25519.PrivateKey {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	return key
+}
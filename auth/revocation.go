@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	denylistKeyPrefix       = "token_denylist:"
+	generationKeyPrefix     = "token_generation:"
+	revokedSessionKeyPrefix = "session_revoked:"
+)
+
+// RevokeToken puts the token's jti on the denylist until the token would have expired anyway.
+func RevokeToken(ctx context.Context, rdb *redis.Client, claims *Claims) error {
+	if claims.Id == "" {
+		return ErrInvalidToken
+	}
+
+	ttl := time.Until(time.Unix(claims.ExpiresAt, 0))
+	if ttl <= 0 {
+		return nil
+	}
+	return rdb.Set(ctx, denylistKeyPrefix+claims.Id, 1, ttl).Err()
+}
+
+// TokenGeneration returns the current token generation of the user with the subject.
+// Tokens carrying an older generation are no longer accepted. Generations follow the
+// user id rather than the username, which can change and be taken by someone else.
+func TokenGeneration(ctx context.Context, rdb *redis.Client, subject string) (int64, error) {
+	generation, err := rdb.Get(ctx, generationKeyPrefix+subject).Int64()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	return generation, err
+}
+
+// BumpTokenGeneration invalidates every access token issued to the user so far.
+func BumpTokenGeneration(ctx context.Context, rdb *redis.Client, subject string) (int64, error) {
+	return rdb.Incr(ctx, generationKeyPrefix+subject).Result()
+}
+
+// RevokeSession refuses every access token of the session from now on. Tokens
+// cannot outlive the access token lifetime, so neither does the mark.
+func RevokeSession(ctx context.Context, rdb *redis.Client, sessionID string) error {
+	return rdb.Set(ctx, revokedSessionKeyPrefix+sessionID, 1, AccessTokenTTL()).Err()
+}
+
+// IsRevoked reports whether the token was logged out, belongs to a revoked
+// session or belongs to an older generation.
+func IsRevoked(ctx context.Context, rdb *redis.Client, claims *Claims) (bool, error) {
+	pipe := rdb.Pipeline()
+	denied := pipe.Exists(ctx, denylistKeyPrefix+claims.Id)
+	var sessionRevoked *redis.IntCmd
+	if claims.SessionID != "" {
+		sessionRevoked = pipe.Exists(ctx, revokedSessionKeyPrefix+claims.SessionID)
+	}
+	generation := pipe.Get(ctx, generationKeyPrefix+claims.Subject)
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return false, err
+	}
+
+	if denied.Val() > 0 || (sessionRevoked != nil && sessionRevoked.Val() > 0) {
+		return true, nil
+	}
+
+	current, err := generation.Int64()
+	if errors.Is(err, redis.Nil) {
+		current = 0
+	} else if err != nil {
+		return false, err
+	}
+	return claims.Generation < current, nil
+}
@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRevokeToken(t *testing.T) {
+	server := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	ctx := context.Background()
+
+	claims := &Claims{
+		Username: "tester",
+		StandardClaims: jwt.StandardClaims{
+			Id:        "token-id",
+			ExpiresAt: time.Now().Add(time.Minute).Unix(),
+		},
+	}
+
+	revoked, err := IsRevoked(ctx, rdb, claims)
+	require.NoError(t, err)
+	assert.False(t, revoked)
+
+	require.NoError(t, RevokeToken(ctx, rdb, claims))
+
+	revoked, err = IsRevoked(ctx, rdb, claims)
+	require.NoError(t, err)
+	assert.True(t, revoked)
+
+	// the denylist entry goes away once the token has expired anyway
+	server.FastForward(2 * time.Minute)
+	assert.False(t, server.Exists(denylistKeyPrefix+"token-id"))
+}
+
+func TestBumpTokenGeneration(t *testing.T) {
+	server := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	ctx := context.Background()
+
+	generation, err := TokenGeneration(ctx, rdb, "1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), generation)
+
+	claims := &Claims{Username: "tester", Generation: generation, StandardClaims: jwt.StandardClaims{Id: "old", Subject: "1"}}
+
+	_, err = BumpTokenGeneration(ctx, rdb, "1")
+	require.NoError(t, err)
+
+	revoked, err := IsRevoked(ctx, rdb, claims)
+	require.NoError(t, err)
+	assert.True(t, revoked)
+
+	claims.Generation, err = TokenGeneration(ctx, rdb, "1")
+	require.NoError(t, err)
+	revoked, err = IsRevoked(ctx, rdb, claims)
+	require.NoError(t, err)
+	assert.False(t, revoked)
+}
+
+func TestRevokeSession(t *testing.T) {
+	server := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	ctx := context.Background()
+
+	claims := &Claims{Username: "tester", SessionID: "session-id", StandardClaims: jwt.StandardClaims{Id: "token-id"}}
+	other := &Claims{Username: "tester", SessionID: "other-session", StandardClaims: jwt.StandardClaims{Id: "other-token"}}
+
+	require.NoError(t, RevokeSession(ctx, rdb, "session-id"))
+
+	revoked, err := IsRevoked(ctx, rdb, claims)
+	require.NoError(t, err)
+	assert.True(t, revoked)
+
+	revoked, err = IsRevoked(ctx, rdb, other)
+	require.NoError(t, err)
+	assert.False(t, revoked)
+
+	// no access token of the session is left once the mark expires
+	server.FastForward(AccessTokenTTL() + time.Second)
+	assert.False(t, server.Exists(revokedSessionKeyPrefix+"session-id"))
+}
@@ -1,7 +1,9 @@
 package config
 
 import (
+	"errors"
 	"main/utility"
+	"time"
 
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
@@ -21,8 +23,113 @@ type Config struct {
 		Host string `mapstructure:"host"`
 	} `mapstructure:"server_address"`
 	JWT struct {
-		Key string `mapstructure:"key"`
+		Key              string        `mapstructure:"key"`
+		AccessTokenTTL   time.Duration `mapstructure:"access_token_ttl"`
+		RefreshTokenTTL  time.Duration `mapstructure:"refresh_token_ttl"`
+		Algorithm        string        `mapstructure:"algorithm"`
+		KeysDir          string        `mapstructure:"keys_dir"`
+		RotationInterval time.Duration `mapstructure:"rotation_interval"`
+		KeyRetention     time.Duration `mapstructure:"key_retention"`
+		Keys             []struct {
+			ID   string `mapstructure:"kid"`
+			File string `mapstructure:"file"`
+		} `mapstructure:"keys"`
 	} `mapstructure:"jwt"`
+	Passwords struct {
+		Algorithm  string `mapstructure:"algorithm"`
+		BcryptCost int    `mapstructure:"bcrypt_cost"`
+		Argon2id   struct {
+			Memory      uint32 `mapstructure:"memory"`
+			Iterations  uint32 `mapstructure:"iterations"`
+			Parallelism uint8  `mapstructure:"parallelism"`
+			SaltLength  uint32 `mapstructure:"salt_length"`
+			KeyLength   uint32 `mapstructure:"key_length"`
+		} `mapstructure:"argon2id"`
+		Policy struct {
+			MinLength        int    `mapstructure:"min_length"`
+			MinStrength      int    `mapstructure:"min_strength"`
+			RejectUserInputs bool   `mapstructure:"reject_user_inputs"`
+			BreachedDir      string `mapstructure:"breached_dir"`
+			History          int    `mapstructure:"history"`
+		} `mapstructure:"policy"`
+	} `mapstructure:"passwords"`
+	LoginProtection struct {
+		MaxAttempts   int           `mapstructure:"max_attempts"`
+		IPMaxAttempts int           `mapstructure:"ip_max_attempts"`
+		Window        time.Duration `mapstructure:"window"`
+		BaseLockout   time.Duration `mapstructure:"base_lockout"`
+		MaxLockout    time.Duration `mapstructure:"max_lockout"`
+	} `mapstructure:"login_protection"`
+	RateLimit struct {
+		Enabled bool                     `mapstructure:"enabled"`
+		Rules   map[string]RateLimitRule `mapstructure:"rules"`
+	} `mapstructure:"rate_limit"`
+	Mail struct {
+		Driver string `mapstructure:"driver"`
+		From   string `mapstructure:"from"`
+		Dir    string `mapstructure:"dir"`
+		SMTP   struct {
+			Host     string `mapstructure:"host"`
+			Port     int    `mapstructure:"port"`
+			Username string `mapstructure:"username"`
+			Password string `mapstructure:"password"`
+		} `mapstructure:"smtp"`
+	} `mapstructure:"mail"`
+	EmailVerification struct {
+		Policy          string        `mapstructure:"policy"`
+		TokenTTL        time.Duration `mapstructure:"token_ttl"`
+		ResendInterval  time.Duration `mapstructure:"resend_interval"`
+		VerificationURL string        `mapstructure:"verification_url"`
+	} `mapstructure:"email_verification"`
+	PasswordReset struct {
+		TokenTTL       time.Duration `mapstructure:"token_ttl"`
+		ResendInterval time.Duration `mapstructure:"resend_interval"`
+		ResetURL       string        `mapstructure:"reset_url"`
+	} `mapstructure:"password_reset"`
+	MagicLink struct {
+		TokenTTL       time.Duration `mapstructure:"token_ttl"`
+		ResendInterval time.Duration `mapstructure:"resend_interval"`
+		// LoginURL is where the emailed link points, usually the callback endpoint. It is required
+		LoginURL string `mapstructure:"login_url"`
+	} `mapstructure:"magic_link"`
+	SessionCookie struct {
+		// Enabled makes logins also set HttpOnly cookies, so browsers do not have to keep the tokens themselves
+		Enabled bool   `mapstructure:"enabled"`
+		Domain  string `mapstructure:"domain"`
+		// SameSite is lax or strict
+		SameSite string `mapstructure:"same_site"`
+		// AllowedOrigins may open WebSockets with the cookie, besides the API's own origin
+		AllowedOrigins []string `mapstructure:"allowed_origins"`
+	} `mapstructure:"session_cookie"`
+	UserPurge struct {
+		// Retention is how long deleted users can be restored before they are purged
+		Retention time.Duration `mapstructure:"retention"`
+		Interval  time.Duration `mapstructure:"interval"`
+		BatchSize int           `mapstructure:"batch_size"`
+		// Anonymize keeps the rows of purged users, stripped of everything that identifies them, instead of deleting them
+		Anonymize bool `mapstructure:"anonymize"`
+	} `mapstructure:"user_purge"`
+	Impersonation struct {
+		// TokenTTL is how long an admin may act as a user, at most the access token lifetime
+		TokenTTL time.Duration `mapstructure:"token_ttl"`
+	} `mapstructure:"impersonation"`
+	APITokens struct {
+		// MaxTTL caps the lifetime of keys created by users, service account keys may not expire
+		MaxTTL time.Duration `mapstructure:"max_ttl"`
+	} `mapstructure:"api_tokens"`
+	OIDC struct {
+		// StateTTL is how long a user has to finish logging in at the provider
+		StateTTL  time.Duration           `mapstructure:"state_ttl"`
+		Providers map[string]OIDCProvider `mapstructure:"providers"`
+	} `mapstructure:"oidc"`
+	OAuth struct {
+		// Issuer is the public URL of this service, other apps check it in id tokens. It is required
+		Issuer string `mapstructure:"issuer"`
+		// AuthorizationURL is the page that asks users for consent, it calls the JSON authorize endpoints
+		AuthorizationURL string        `mapstructure:"authorization_url"`
+		CodeTTL          time.Duration `mapstructure:"code_ttl"`
+		IDTokenTTL       time.Duration `mapstructure:"id_token_ttl"`
+	} `mapstructure:"oauth"`
 	Redis struct {
 		Addr     string `mapstructure:"addr"`
 		Password string `mapstructure:"password"`
@@ -30,8 +137,43 @@ type Config struct {
 	} `mapstructure:"connect_redis_params"`
 }
 
+// RateLimitRule limits a route group to Requests per Window for each key.
+// Key is one of "ip", "user" or "route"; "route" shares a single bucket between all callers.
+type RateLimitRule struct {
+	Requests int           `mapstructure:"requests"`
+	Window   time.Duration `mapstructure:"window"`
+	Key      string        `mapstructure:"key"`
+}
+
+// OIDCProvider is an OpenID Connect identity provider users can log in with.
+// Its endpoints are found through discovery at the issuer.
+type OIDCProvider struct {
+	Issuer       string   `mapstructure:"issuer"`
+	ClientID     string   `mapstructure:"client_id"`
+	ClientSecret string   `mapstructure:"client_secret"`
+	RedirectURL  string   `mapstructure:"redirect_url"`
+	Scopes       []string `mapstructure:"scopes"`
+}
+
 var AppConfig Config
 
+// Validate reports the first setting the service cannot run safely without.
+func (c *Config) Validate() error {
+	// Emailed links and id tokens would otherwise name whatever host the request claimed
+	if c.MagicLink.LoginURL == "" {
+		return errors.New("magic_link.login_url must be set")
+	}
+	if c.OAuth.Issuer == "" {
+		return errors.New("oauth.issuer must be set")
+	}
+	// Keys generated without a directory to keep them in would differ per instance and restart,
+	// the directory has to be persistent and shared by every instance
+	if c.JWT.Algorithm != "" && c.JWT.Algorithm != "HS256" && c.JWT.KeysDir == "" {
+		return errors.New("jwt.keys_dir must be set to sign with " + c.JWT.Algorithm)
+	}
+	return nil
+}
+
 func LoadConfig() {
 	// Load logger
 	logger := utility.AppLogger.Logger
@@ -0,0 +1,224 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"main/auth"
+	"main/db"
+	"main/dto"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"go.uber.org/zap"
+)
+
+const maxStatusReasonLength = 1000
+
+// statusAudits are the audit log actions of moving an account into each status
+var statusAudits = map[string]string{
+	auth.StatusActive:      "user.reactivate",
+	auth.StatusSuspended:   "user.suspend",
+	auth.StatusLocked:      "user.lock",
+	auth.StatusDeactivated: "user.deactivate",
+}
+
+// statusTransitions are the statuses an admin can move an account in each status to.
+// Accounts only get to wait for verification when they sign up, and leave it by
+// verifying. Restricting an account again updates the reason and expiry.
+var statusTransitions = map[string][]string{
+	auth.StatusActive:              {auth.StatusSuspended, auth.StatusLocked, auth.StatusDeactivated},
+	auth.StatusPendingVerification: {auth.StatusSuspended, auth.StatusLocked, auth.StatusDeactivated},
+	auth.StatusSuspended:           {auth.StatusActive, auth.StatusSuspended, auth.StatusLocked, auth.StatusDeactivated},
+	auth.StatusLocked:              {auth.StatusActive, auth.StatusSuspended, auth.StatusLocked, auth.StatusDeactivated},
+	auth.StatusDeactivated:         {auth.StatusActive, auth.StatusDeactivated},
+}
+
+// Disconnector hangs up the connections users keep open besides their requests, such as chat rooms.
+type Disconnector interface {
+	DisconnectUser(userID int32)
+}
+
+// accountStatus is the status the user's account is in now.
+func accountStatus(user db.User) string {
+	return auth.CurrentStatus(user.Status, user.StatusExpiresAt.Time, user.EmailVerifiedAt.Valid)
+}
+
+// refuseRestricted answers the request itself when the user's account is suspended,
+// locked or deactivated, and so may not log in.
+func refuseRestricted(c *gin.Context, user db.User) bool {
+	status := accountStatus(user)
+	if !auth.Restricted(status) {
+		return false
+	}
+	response := gin.H{"error": "account is " + status, "status": status}
+	if user.StatusExpiresAt.Valid {
+		response["until"] = user.StatusExpiresAt.Time
+	}
+	c.JSON(http.StatusForbidden, response)
+	return true
+}
+
+type UserStatusRequest struct {
+	// Status is one of active, suspended, locked and deactivated
+	Status string `json:"status" binding:"required"`
+	// Reason is required to restrict an account
+	Reason string `json:"reason"`
+	// ExpiresAt ends a suspension or lock by itself
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// validate checks the request on its own, before the account it is for is looked at.
+func (req UserStatusRequest) validate() error {
+	if _, ok := statusAudits[req.Status]; !ok {
+		return errors.New("status must be one of active, suspended, locked and deactivated")
+	}
+	if auth.Restricted(req.Status) && strings.TrimSpace(req.Reason) == "" {
+		return errors.New("reason is required to restrict an account")
+	}
+	if len(req.Reason) > maxStatusReasonLength {
+		return errors.New("reason must be at most " + strconv.Itoa(maxStatusReasonLength) + " bytes")
+	}
+	if req.ExpiresAt != nil {
+		if req.Status != auth.StatusSuspended && req.Status != auth.StatusLocked {
+			return errors.New("only suspensions and locks can expire")
+		}
+		if !req.ExpiresAt.After(time.Now()) {
+			return errors.New("expires_at must be in the future")
+		}
+	}
+	return nil
+}
+
+// SetUserStatus godoc
+// @Summary Change the status of a user's account
+// @Description Suspend, lock, deactivate or reactivate an account. Restricted accounts cannot log in, their sessions end, their tokens and API keys stop working and they are disconnected from their rooms. Suspensions and locks can run out by themselves.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Param status body UserStatusRequest true "New Status"
+// @Success 200 {object} dto.AdminUser "Updated User"
+// @Failure 400 {object} gin.H "Bad Request"
+// @Failure 401 {object} gin.H "Unauthorized"
+// @Failure 403 {object} gin.H "Forbidden"
+// @Failure 404 {object} gin.H "Not Found"
+// @Failure 409 {object} gin.H "Conflict"
+// @Failure 500 {object} gin.H "Internal Server Error"
+// @Router /admin/users/{id}/status [put]
+func (uc *UserController) SetUserStatus(c *gin.Context) {
+	userRequests.WithLabelValues("PUT", "/admin/users/:id/status").Inc()
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var req UserStatusRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := req.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	actor := actorOf(c)
+	params := db.SetUserStatusParams{ID: int32(id), Status: req.Status}
+	if actor != nil {
+		if actorID, err := strconv.ParseInt(actor.Subject, 10, 32); err == nil {
+			if actorID == int64(id) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "you cannot change the status of your own account"})
+				return
+			}
+			params.ChangedBy = pgtype.Int4{Int32: int32(actorID), Valid: true}
+		}
+	}
+
+	user, err := uc.Queries.GetUser(c.Request.Context(), int32(id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	current := accountStatus(user)
+	if !slices.Contains(statusTransitions[current], req.Status) {
+		c.JSON(http.StatusConflict, gin.H{"error": "cannot change the status of an account from " + current + " to " + req.Status})
+		return
+	}
+
+	// Reactivated accounts that never verified their address go back to waiting for it
+	if req.Status == auth.StatusActive && !user.EmailVerifiedAt.Valid {
+		params.Status = auth.StatusPendingVerification
+	}
+	if req.Reason != "" {
+		params.Reason = pgtype.Text{String: req.Reason, Valid: true}
+	}
+	if req.ExpiresAt != nil {
+		params.ExpiresAt = pgtype.Timestamp{Time: req.ExpiresAt.UTC(), Valid: true}
+	}
+
+	updated, err := uc.Queries.SetUserStatus(c.Request.Context(), params)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := uc.applyStatus(c, updated); err != nil {
+		uc.Logger.Error("Failed to apply account status", zap.Int32("user_id", updated.ID), zap.String("status", updated.Status), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "the status was saved but could not be applied, set it again"})
+		return
+	}
+
+	if err := uc.audit(c, statusAudits[req.Status], actor, updated, "", req.Reason); err != nil {
+		uc.Logger.Error("Failed to write status change to the audit log", zap.Error(err))
+	}
+
+	c.JSON(http.StatusOK, dto.NewAdminUser(updated))
+}
+
+// applyStatus makes the stored status of the user take effect. Restricted users are
+// logged out everywhere and disconnected from their rooms, and their remaining
+// tokens are refused until the restriction ends.
+func (uc *UserController) applyStatus(c *gin.Context, user db.User) error {
+	ctx := c.Request.Context()
+	subject := strconv.Itoa(int(user.ID))
+
+	if err := uc.forgetCachedUser(ctx, user.ID); err != nil {
+		uc.Logger.Warn("Failed to drop cached user", zap.Int32("user_id", user.ID), zap.Error(err))
+	}
+
+	if !auth.Restricted(user.Status) {
+		return auth.LiftRestriction(ctx, uc.RedisClient, subject)
+	}
+
+	if err := auth.RestrictAccount(ctx, uc.RedisClient, subject, user.Status, user.StatusExpiresAt.Time); err != nil {
+		return err
+	}
+	return uc.logOutEverywhere(ctx, user.ID)
+}
+
+// logOutEverywhere ends every session of the user, revokes their tokens and
+// disconnects them from their rooms.
+func (uc *UserController) logOutEverywhere(ctx context.Context, userID int32) error {
+	if err := uc.revokeAllTokens(ctx, userID); err != nil {
+		return err
+	}
+	if uc.Rooms != nil {
+		uc.Rooms.DisconnectUser(userID)
+	}
+	return nil
+}
@@ -0,0 +1,249 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"main/auth"
+	"main/config"
+	"main/db"
+	middleware "main/middlewares"
+	"main/passwords"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// statusRow is a user in the account status, restricted until expiresAt when it is set
+func statusRow(status string, verified bool, expiresAt time.Time) *MockRow {
+	return scanRow(userColumnCount, nil, func(args mock.Arguments) {
+		*args.Get(0).(*int32) = 1
+		*args.Get(1).(*string) = "tester"
+		*args.Get(7).(*pgtype.Timestamp) = pgtype.Timestamp{Time: time.Now(), Valid: verified}
+		*args.Get(10).(*string) = status
+		*args.Get(14).(*pgtype.Timestamp) = pgtype.Timestamp{Time: expiresAt, Valid: !expiresAt.IsZero()}
+	})
+}
+
+type recordingDisconnector struct {
+	disconnected []int32
+}
+
+func (d *recordingDisconnector) DisconnectUser(userID int32) {
+	d.disconnected = append(d.disconnected, userID)
+}
+
+func TestSetUserStatus(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	previous := config.AppConfig.JWT
+	t.Cleanup(func() { config.AppConfig.JWT = previous })
+	config.AppConfig.JWT.Algorithm = "HS256"
+	config.AppConfig.JWT.Key = "test-key"
+
+	until := time.Now().Add(24 * time.Hour).Truncate(time.Second)
+	mockDB := new(MockDBTX)
+	mockDB.On("Exec", mock.Anything, isQuery("CreateAuditLogEntry"), mock.Anything).Return(pgconn.NewCommandTag("INSERT 0 1"), nil)
+	mockDB.On("Exec", mock.Anything, isQuery("RevokeUserSessions"), mock.Anything).Return(pgconn.NewCommandTag("UPDATE 1"), nil)
+	logger, _ := zap.NewDevelopment()
+	redisClient := newTestRedis(t)
+	uc := NewUserController(db.New(mockDB), redisClient, logger, true)
+	rooms := &recordingDisconnector{}
+	uc.Rooms = rooms
+	admin := &auth.Claims{Username: "root", Roles: []string{"admin"}, StandardClaims: jwt.StandardClaims{Subject: "3"}}
+
+	setStatus := func(id string, req UserStatusRequest) (int, map[string]interface{}) {
+		body, _ := json.Marshal(req)
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request, _ = http.NewRequest("PUT", "/admin/users/"+id+"/status", bytes.NewBuffer(body))
+		c.Request.Header.Set("Content-Type", "application/json")
+		c.Params = []gin.Param{{Key: "id", Value: id}}
+		c.Set(auth.ClaimsKey, admin)
+
+		uc.SetUserStatus(c)
+
+		var response map[string]interface{}
+		_ = json.Unmarshal(w.Body.Bytes(), &response)
+		return w.Code, response
+	}
+
+	// requests that are wrong whatever the account
+	code, _ := setStatus("1", UserStatusRequest{Status: auth.StatusSuspended})
+	assert.Equal(t, http.StatusBadRequest, code, "restricting needs a reason")
+	code, _ = setStatus("1", UserStatusRequest{Status: auth.StatusPendingVerification})
+	assert.Equal(t, http.StatusBadRequest, code, "accounts only wait for verification after signing up")
+	code, _ = setStatus("1", UserStatusRequest{Status: auth.StatusDeactivated, Reason: "closed", ExpiresAt: &until})
+	assert.Equal(t, http.StatusBadRequest, code, "deactivations do not run out")
+	code, _ = setStatus("3", UserStatusRequest{Status: auth.StatusLocked, Reason: "oops"})
+	assert.Equal(t, http.StatusBadRequest, code, "admins cannot lock themselves out")
+
+	// suspending an active account logs them out and keeps their tokens out until it runs out
+	mockDB.On("QueryRow", mock.Anything, isQuery("GetUser"), []interface{}{int32(1)}).Return(statusRow(auth.StatusActive, true, time.Time{})).Once()
+	mockDB.On("QueryRow", mock.Anything, isQuery("SetUserStatus"), mock.MatchedBy(func(args []interface{}) bool {
+		return args[0] == auth.StatusSuspended && args[1] == pgtype.Text{String: "spam", Valid: true} &&
+			args[2] == pgtype.Int4{Int32: 3, Valid: true} && args[3].(pgtype.Timestamp).Time.Equal(until) && args[4] == int32(1)
+	})).Return(statusRow(auth.StatusSuspended, true, until)).Once()
+
+	code, response := setStatus("1", UserStatusRequest{Status: auth.StatusSuspended, Reason: "spam", ExpiresAt: &until})
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, auth.StatusSuspended, response["status"])
+	assert.Equal(t, []int32{1}, rooms.disconnected)
+	mockDB.AssertCalled(t, "Exec", mock.Anything, isQuery("RevokeUserSessions"), []interface{}{int32(1)})
+	mockDB.AssertCalled(t, "Exec", mock.Anything, isQuery("CreateAuditLogEntry"), mock.MatchedBy(func(args []interface{}) bool {
+		return args[0] == "user.suspend" && args[2] == "root" && args[4] == "tester" && args[6] == "spam"
+	}))
+
+	ttl, err := redisClient.TTL(context.Background(), "account_restricted:1").Result()
+	require.NoError(t, err)
+	assert.InDelta(t, (24 * time.Hour).Seconds(), ttl.Seconds(), 5)
+
+	generation, err := auth.TokenGeneration(context.Background(), redisClient, "1")
+	require.NoError(t, err)
+	router := gin.New()
+	router.GET("/users/", middleware.AuthMiddleware(redisClient, uc.Queries), func(c *gin.Context) { c.Status(http.StatusOK) })
+	token, err := auth.GenerateJWT(&auth.Claims{Username: "tester", Generation: generation, StandardClaims: jwt.StandardClaims{Subject: "1"}})
+	require.NoError(t, err)
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/users/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "account is suspended")
+
+	// deactivated accounts can only be reactivated
+	mockDB.On("QueryRow", mock.Anything, isQuery("GetUser"), []interface{}{int32(1)}).Return(statusRow(auth.StatusDeactivated, true, time.Time{})).Once()
+	code, _ = setStatus("1", UserStatusRequest{Status: auth.StatusSuspended, Reason: "spam"})
+	assert.Equal(t, http.StatusConflict, code)
+
+	// reactivating an account that never verified its address sends it back to waiting for that
+	mockDB.On("QueryRow", mock.Anything, isQuery("GetUser"), []interface{}{int32(1)}).Return(statusRow(auth.StatusSuspended, false, until)).Once()
+	mockDB.On("QueryRow", mock.Anything, isQuery("SetUserStatus"), mock.MatchedBy(func(args []interface{}) bool {
+		return args[0] == auth.StatusPendingVerification && args[3] == pgtype.Timestamp{}
+	})).Return(statusRow(auth.StatusPendingVerification, false, time.Time{})).Once()
+
+	code, response = setStatus("1", UserStatusRequest{Status: auth.StatusActive})
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, auth.StatusPendingVerification, response["status"])
+	restriction, _, err := auth.AccountRestriction(context.Background(), redisClient, "1")
+	require.NoError(t, err)
+	assert.Empty(t, restriction)
+
+	// an active account is active already
+	mockDB.On("QueryRow", mock.Anything, isQuery("GetUser"), []interface{}{int32(1)}).Return(statusRow(auth.StatusActive, true, time.Time{})).Once()
+	code, _ = setStatus("1", UserStatusRequest{Status: auth.StatusActive})
+	assert.Equal(t, http.StatusConflict, code)
+	mockDB.AssertExpectations(t)
+}
+
+func TestLoginRestrictedAccount(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	hashedPassword, err := passwords.Hash("password123")
+	require.NoError(t, err)
+	userRow := func(status string, expiresAt time.Time) *MockRow {
+		return scanRow(userColumnCount, nil, func(args mock.Arguments) {
+			*args.Get(0).(*int32) = 1
+			*args.Get(1).(*string) = "tester"
+			*args.Get(3).(*string) = hashedPassword
+			*args.Get(7).(*pgtype.Timestamp) = pgtype.Timestamp{Time: time.Now(), Valid: true}
+			*args.Get(10).(*string) = status
+			*args.Get(14).(*pgtype.Timestamp) = pgtype.Timestamp{Time: expiresAt, Valid: !expiresAt.IsZero()}
+		})
+	}
+
+	tests := []struct {
+		name         string
+		status       string
+		expiresAt    time.Time
+		password     string
+		expectedCode int
+	}{
+		{name: "suspended", status: auth.StatusSuspended, expiresAt: time.Now().Add(time.Hour), password: "password123", expectedCode: http.StatusForbidden},
+		{name: "locked", status: auth.StatusLocked, password: "password123", expectedCode: http.StatusForbidden},
+		{name: "deactivated", status: auth.StatusDeactivated, password: "password123", expectedCode: http.StatusForbidden},
+		// the status is not given away without the password
+		{name: "suspended with a wrong password", status: auth.StatusSuspended, password: "wrong", expectedCode: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB := new(MockDBTX)
+			mockDB.On("QueryRow", mock.Anything, isQuery("GetUserByUsername"), mock.Anything).Return(userRow(tt.status, tt.expiresAt))
+			logger, _ := zap.NewDevelopment()
+			uc := NewUserController(db.New(mockDB), newTestRedis(t), logger, true)
+
+			body, _ := json.Marshal(LoginRequest{Username: "tester", Password: tt.password})
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request, _ = http.NewRequest("POST", "/users/login", bytes.NewBuffer(body))
+			c.Request.Header.Set("Content-Type", "application/json")
+
+			uc.Login(c)
+
+			assert.Equal(t, tt.expectedCode, w.Code)
+			var response map[string]interface{}
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+			assert.NotContains(t, response, "token")
+			if tt.expectedCode == http.StatusForbidden {
+				assert.Equal(t, tt.status, response["status"])
+				assert.Equal(t, !tt.expiresAt.IsZero(), response["until"] != nil)
+			}
+		})
+	}
+}
+
+func TestRestrictionWithoutRedisMarker(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	previous := config.AppConfig.JWT
+	t.Cleanup(func() { config.AppConfig.JWT = previous })
+	config.AppConfig.JWT.Algorithm = "HS256"
+	config.AppConfig.JWT.Key = "test-key"
+
+	mockDB := new(MockDBTX)
+	logger, _ := zap.NewDevelopment()
+	redisClient := newTestRedis(t)
+	uc := NewUserController(db.New(mockDB), redisClient, logger, true)
+	router := gin.New()
+	router.GET("/users/", middleware.AuthMiddleware(redisClient, uc.Queries), func(c *gin.Context) { c.Status(http.StatusOK) })
+	token, err := auth.GenerateJWT(&auth.Claims{Username: "tester", StandardClaims: jwt.StandardClaims{Subject: "1"}})
+	require.NoError(t, err)
+	get := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/users/", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Redis lost the marker, the database still knows the account is locked
+	mockDB.On("QueryRow", mock.Anything, isQuery("GetUser"), []interface{}{int32(1)}).Return(statusRow(auth.StatusLocked, true, time.Time{})).Once()
+	w := get()
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "account is locked")
+
+	// a restriction that ran out meanwhile lets them back in, and the answer is remembered
+	mockDB.On("QueryRow", mock.Anything, isQuery("GetUser"), []interface{}{int32(1)}).Return(statusRow(auth.StatusSuspended, true, time.Now().Add(-time.Minute))).Once()
+	w = get()
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = get()
+	assert.Equal(t, http.StatusOK, w.Code)
+	mockDB.AssertNumberOfCalls(t, "QueryRow", 2)
+
+	// restricting the account again takes over from the remembered answer
+	require.NoError(t, auth.RestrictAccount(context.Background(), redisClient, "1", auth.StatusSuspended, time.Time{}))
+	w = get()
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	mockDB.AssertExpectations(t)
+}
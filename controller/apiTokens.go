@@ -0,0 +1,198 @@
+package controller
+
+import (
+	"main/auth"
+	"main/config"
+	"main/db"
+	"main/dto"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgtype"
+	"go.uber.org/zap"
+)
+
+// roleServiceAccount marks users that stand for a service rather than a person.
+// Their API keys do not have to expire.
+const roleServiceAccount = "service_account"
+
+type CreateAPITokenRequest struct {
+	Name string `json:"name" binding:"required,max=255"`
+	// Scopes are permission names such as users:read
+	Scopes []string `json:"scopes"`
+	// ExpiresAt is optional, without it the key expires after the longest allowed lifetime
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// CreateAPIToken godoc
+// @Summary Create an API key
+// @Description Create a named API key that acts as the user, limited to the given scopes. The key is only shown in this response.
+// @Tags tokens
+// @Accept json
+// @Produce json
+// @Param token body CreateAPITokenRequest true "Name, Scopes and Expiry"
+// @Success 201 {object} gin.H "Key and its Description"
+// @Failure 400 {object} gin.H "Bad Request"
+// @Failure 401 {object} gin.H "Unauthorized"
+// @Failure 403 {object} gin.H "Forbidden"
+// @Failure 500 {object} gin.H "Internal Server Error"
+// @Router /users/me/tokens [post]
+func (uc *UserController) CreateAPIToken(c *gin.Context) {
+	userRequests.WithLabelValues("POST", "/users/me/tokens").Inc()
+	claims, ok := auth.FromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	userID, ok := claims.UserID()
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var req CreateAPITokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	known, err := uc.Queries.GetPermissionNames(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	scopes := make([]string, 0, len(req.Scopes))
+	for _, scope := range req.Scopes {
+		if !slices.Contains(known, scope) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unknown scope " + scope, "scopes": known})
+			return
+		}
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	// A key can only hand out scopes it has itself
+	if callerScopes, isAPIKey := auth.ScopesFromContext(c); isAPIKey {
+		for _, scope := range scopes {
+			if !slices.Contains(callerScopes, scope) {
+				c.JSON(http.StatusForbidden, gin.H{"error": "API key cannot grant the " + scope + " scope"})
+				return
+			}
+		}
+	}
+
+	var expiresAt pgtype.Timestamp
+	if req.ExpiresAt != nil {
+		if !req.ExpiresAt.After(time.Now()) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "expires_at must be in the future"})
+			return
+		}
+		expiresAt = pgtype.Timestamp{Time: req.ExpiresAt.UTC(), Valid: true}
+	}
+	if maxTTL := config.AppConfig.APITokens.MaxTTL; maxTTL > 0 && !claims.HasRole(roleServiceAccount) {
+		latest := time.Now().Add(maxTTL)
+		if !expiresAt.Valid {
+			expiresAt = pgtype.Timestamp{Time: latest.UTC(), Valid: true}
+		} else if expiresAt.Time.After(latest) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "expires_at is too far away, keys live at most " + maxTTL.String()})
+			return
+		}
+	}
+
+	key, prefix, err := auth.GenerateAPIKey()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	token, err := uc.Queries.CreateAPIToken(c.Request.Context(), db.CreateAPITokenParams{
+		UserID:      userID,
+		Name:        req.Name,
+		TokenPrefix: prefix,
+		TokenHash:   auth.HashAPIKey(key),
+		Scopes:      scopes,
+		ExpiresAt:   expiresAt,
+	})
+	if err != nil {
+		uc.Logger.Error("Failed to create API key", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create API key"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":   "Store the key now, it cannot be shown again",
+		"key":       key,
+		"api_token": dto.NewAPIToken(token),
+	})
+}
+
+// ListAPITokens godoc
+// @Summary List API keys
+// @Description List the user's API keys with their scopes and when they were last used
+// @Tags tokens
+// @Accept json
+// @Produce json
+// @Success 200 {array} dto.APIToken "API Keys"
+// @Failure 401 {object} gin.H "Unauthorized"
+// @Failure 500 {object} gin.H "Internal Server Error"
+// @Router /users/me/tokens [get]
+func (uc *UserController) ListAPITokens(c *gin.Context) {
+	userRequests.WithLabelValues("GET", "/users/me/tokens").Inc()
+	userID, ok := currentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	tokens, err := uc.Queries.ListAPITokens(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.NewAPITokens(tokens))
+}
+
+// RevokeAPIToken godoc
+// @Summary Revoke an API key
+// @Description Revoke one of the user's API keys, it stops working right away
+// @Tags tokens
+// @Accept json
+// @Produce json
+// @Param tokenId path int true "API Key ID"
+// @Success 200 {object} gin.H "Message"
+// @Failure 400 {object} gin.H "Bad Request"
+// @Failure 401 {object} gin.H "Unauthorized"
+// @Failure 404 {object} gin.H "Not Found"
+// @Failure 500 {object} gin.H "Internal Server Error"
+// @Router /users/me/tokens/{tokenId} [delete]
+func (uc *UserController) RevokeAPIToken(c *gin.Context) {
+	userRequests.WithLabelValues("DELETE", "/users/me/tokens/:tokenId").Inc()
+	userID, ok := currentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	tokenID, err := strconv.Atoi(c.Param("tokenId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rows, err := uc.Queries.DeleteAPIToken(c.Request.Context(), db.DeleteAPITokenParams{ID: int32(tokenID), UserID: userID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if rows == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "API key revoked"})
+}
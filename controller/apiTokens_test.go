@@ -0,0 +1,150 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"main/auth"
+	"main/db"
+	middleware "main/middlewares"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func isQuery(name string) interface{} {
+	return mock.MatchedBy(func(sql string) bool {
+		return strings.Contains(sql, "-- name: "+name+" ")
+	})
+}
+
+// mockAPITokenLookup answers GetActiveAPIToken for the key with a token of user 1
+func mockAPITokenLookup(mockDB *MockDBTX, key string, scopes []string) {
+	mockRow := new(MockRow)
+	mockRow.On("Scan", anyColumns(7)...).Return(nil).Run(func(args mock.Arguments) {
+		*args.Get(0).(*int32) = 7
+		*args.Get(1).(*int32) = 1
+		*args.Get(2).(*[]string) = scopes
+		*args.Get(3).(*string) = "tester"
+		*args.Get(4).(*pgtype.Timestamp) = pgtype.Timestamp{Time: time.Now(), Valid: true}
+		*args.Get(5).(*string) = auth.StatusActive
+	})
+	mockDB.On("QueryRow", mock.Anything, isQuery("GetActiveAPIToken"), []interface{}{auth.HashAPIKey(key)}).Return(mockRow)
+}
+
+func TestAPITokens(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockDB := new(MockDBTX)
+	for i := 0; i < 2; i++ {
+		mockDB.On("Query", mock.Anything, isQuery("GetPermissionNames"), mock.Anything).Return(
+			NewMockRows([][]interface{}{{"tokens:manage"}, {"users:read"}, {"users:write"}}), nil).Once()
+	}
+	mockDB.On("Query", mock.Anything, isQuery("GetUserRoles"), mock.Anything).Return(NewMockRows(nil), nil)
+	mockDB.On("Exec", mock.Anything, isQuery("TouchAPIToken"), mock.Anything).Return(pgconn.NewCommandTag("UPDATE 1"), nil)
+
+	var created db.CreateAPITokenParams
+	createdRow := new(MockRow)
+	createdRow.On("Scan",
+		mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything,
+		mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything,
+	).Return(nil).Run(func(args mock.Arguments) {
+		*args.Get(0).(*int32) = 7
+		*args.Get(1).(*int32) = created.UserID
+		*args.Get(2).(*string) = created.Name
+		*args.Get(3).(*string) = created.TokenPrefix
+		*args.Get(4).(*string) = created.TokenHash
+		*args.Get(5).(*[]string) = created.Scopes
+		*args.Get(6).(*pgtype.Timestamp) = created.ExpiresAt
+	})
+	mockDB.On("QueryRow", mock.Anything, isQuery("CreateAPIToken"), mock.Anything).Return(createdRow).Run(func(args mock.Arguments) {
+		params := args.Get(2).([]interface{})
+		created = db.CreateAPITokenParams{
+			UserID:      params[0].(int32),
+			Name:        params[1].(string),
+			TokenPrefix: params[2].(string),
+			TokenHash:   params[3].(string),
+			Scopes:      params[4].([]string),
+			ExpiresAt:   params[5].(pgtype.Timestamp),
+		}
+	})
+
+	logger, _ := zap.NewDevelopment()
+	uc := NewUserController(db.New(mockDB), newTestRedis(t), logger, true)
+
+	create := func(req CreateAPITokenRequest) (int, map[string]interface{}) {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Set(auth.ClaimsKey, &auth.Claims{Username: "tester", StandardClaims: jwt.StandardClaims{Subject: "1"}})
+
+		jsonValue, _ := json.Marshal(req)
+		c.Request, _ = http.NewRequest("POST", "/users/me/tokens", bytes.NewBuffer(jsonValue))
+		c.Request.Header.Set("Content-Type", "application/json")
+		uc.CreateAPIToken(c)
+
+		var response map[string]interface{}
+		_ = json.Unmarshal(w.Body.Bytes(), &response)
+		return w.Code, response
+	}
+
+	code, _ := create(CreateAPITokenRequest{Name: "ci", Scopes: []string{"users:delete"}})
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	code, response := create(CreateAPITokenRequest{Name: "ci", Scopes: []string{"users:read", "users:read"}})
+	require.Equal(t, http.StatusCreated, code)
+	key, _ := response["key"].(string)
+	require.True(t, auth.IsAPIKey(key))
+	assert.Equal(t, auth.HashAPIKey(key), created.TokenHash)
+	assert.Equal(t, []string{"users:read"}, created.Scopes)
+	assert.True(t, strings.HasPrefix(key, created.TokenPrefix))
+	assert.NotContains(t, response["api_token"], "token_hash")
+
+	mockAPITokenLookup(mockDB, key, created.Scopes)
+	mockDB.On("QueryRow", mock.Anything, isQuery("GetActiveAPIToken"), mock.Anything).Return(mockNoRows())
+
+	router := gin.New()
+	authenticated := router.Group("/users", middleware.AuthMiddleware(uc.RedisClient, uc.Queries))
+	authenticated.GET("/", middleware.RequireScope("users:read"), func(c *gin.Context) {
+		scopes, _ := auth.ScopesFromContext(c)
+		c.JSON(http.StatusOK, gin.H{"username": c.GetString("username"), "scopes": scopes})
+	})
+	authenticated.GET("/me/tokens", middleware.RequireScope("tokens:manage"), uc.ListAPITokens)
+	authenticated.POST("/logout", middleware.RequireSession(), uc.Logout)
+
+	request := func(method string, path string, token string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.RemoteAddr = "10.0.0.7:1234"
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := request("GET", "/users/", key)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"username": "tester", "scopes": ["users:read"]}`, w.Body.String())
+	mockDB.AssertCalled(t, "Exec", mock.Anything, isQuery("TouchAPIToken"), []interface{}{"10.0.0.7", int32(7)})
+
+	// the key was not given the scope to manage keys, nor can it log out
+	assert.Equal(t, http.StatusForbidden, request("GET", "/users/me/tokens", key).Code)
+	assert.Equal(t, http.StatusForbidden, request("POST", "/users/logout", key).Code)
+
+	assert.Equal(t, http.StatusUnauthorized, request("GET", "/users/", auth.APIKeyPrefix+"revoked").Code)
+}
+
+func mockNoRows() *MockRow {
+	mockRow := new(MockRow)
+	mockRow.On("Scan", anyColumns(7)...).Return(pgx.ErrNoRows)
+	return mockRow
+}
@@ -0,0 +1,217 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"main/auth"
+	"main/config"
+	"main/db"
+	"main/mailer"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultVerificationTokenTTL = 24 * time.Hour
+	defaultResendInterval       = time.Minute
+	backgroundMailTimeout       = 30 * time.Second
+
+	verificationSentKeyPrefix = "email_verification_sent:"
+)
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+type ResendVerificationRequest struct {
+	Email string `json:"email" binding:"required"`
+}
+
+// sendVerificationEmail mails the user a signed link that proves they own their address.
+func (uc *UserController) sendVerificationEmail(ctx context.Context, userID int32, username string, email string) error {
+	settings := config.AppConfig.EmailVerification
+	token, err := auth.GeneratePurposeToken(&auth.Claims{
+		Username:       username,
+		Email:          email,
+		StandardClaims: jwt.StandardClaims{Subject: strconv.Itoa(int(userID))},
+	}, auth.PurposeEmailVerification, durationOr(settings.TokenTTL, defaultVerificationTokenTTL))
+	if err != nil {
+		return err
+	}
+
+	link := token
+	if settings.VerificationURL != "" {
+		link = settings.VerificationURL + "?token=" + url.QueryEscape(token)
+	}
+
+	return uc.Mailer.Send(ctx, mailer.Message{
+		To:      email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Hi %s,\r\n\r\nPlease confirm your email address by opening the link below:\r\n\r\n%s\r\n\r\n"+
+			"If you did not sign up, you can ignore this email.\r\n", username, link),
+	})
+}
+
+// throttleMail reports how long to wait before another mail of the same kind may be
+// sent to the address, starting the wait when it is zero.
+func (uc *UserController) throttleMail(ctx context.Context, keyPrefix string, email string, interval time.Duration) (time.Duration, error) {
+	key := keyPrefix + strings.ToLower(email)
+
+	ok, err := uc.RedisClient.SetNX(ctx, key, 1, interval).Result()
+	if err != nil || ok {
+		return 0, err
+	}
+	return uc.RedisClient.PTTL(ctx, key).Result()
+}
+
+// inBackground does the work after the response went out, on a context the end of
+// the request does not cancel. How long the work takes, such as looking up whether an
+// address belongs to an account and mailing it, then cannot be told from the response.
+func (uc *UserController) inBackground(work func(ctx context.Context)) {
+	uc.background.Add(1)
+	go func() {
+		defer uc.background.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), backgroundMailTimeout)
+		defer cancel()
+		work(ctx)
+	}()
+}
+
+// WaitForBackground waits for the work started by inBackground to finish, so mails
+// already promised to clients go out before the service stops. It gives up when the
+// context ends first and returns its error.
+func (uc *UserController) WaitForBackground(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		uc.background.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (uc *UserController) throttleVerificationEmail(ctx context.Context, email string) (time.Duration, error) {
+	interval := durationOr(config.AppConfig.EmailVerification.ResendInterval, defaultResendInterval)
+	return uc.throttleMail(ctx, verificationSentKeyPrefix, email, interval)
+}
+
+// VerifyEmail godoc
+// @Summary Verify an email address
+// @Description Mark the user's email address as verified with the token from the verification email
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param token body VerifyEmailRequest true "Verification Token"
+// @Success 200 {object} gin.H "Message"
+// @Failure 400 {object} gin.H "Bad Request"
+// @Failure 500 {object} gin.H "Internal Server Error"
+// @Router /users/verify-email [post]
+func (uc *UserController) VerifyEmail(c *gin.Context) {
+	userRequests.WithLabelValues("POST", "/users/verify-email").Inc()
+	var req VerifyEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	claims, err := auth.ParseJWT(req.Token)
+	if err != nil || claims.Purpose != auth.PurposeEmailVerification {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired verification token"})
+		return
+	}
+	userID, ok := claims.UserID()
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired verification token"})
+		return
+	}
+
+	rows, err := uc.Queries.VerifyUserEmail(c.Request.Context(), db.VerifyUserEmailParams{ID: userID, Email: claims.Email})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if rows == 0 {
+		// Either the address was verified already or it changed since the token was sent
+		user, err := uc.Queries.GetUser(c.Request.Context(), userID)
+		if err != nil || user.Email != claims.Email || !user.EmailVerifiedAt.Valid {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired verification token"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Email already verified"})
+		return
+	}
+
+	if err := uc.forgetCachedUser(c.Request.Context(), userID); err != nil {
+		uc.Logger.Warn("Failed to drop cached user", zap.Error(err))
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Email verified"})
+}
+
+// ResendVerificationEmail godoc
+// @Summary Resend the verification email
+// @Description Send a new verification email. The answer is the same whether or not the address belongs to an unverified account.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param email body ResendVerificationRequest true "Email Address"
+// @Success 202 {object} gin.H "Message"
+// @Failure 400 {object} gin.H "Bad Request"
+// @Failure 429 {object} gin.H "Too Many Requests"
+// @Failure 500 {object} gin.H "Internal Server Error"
+// @Router /users/verify-email/resend [post]
+func (uc *UserController) ResendVerificationEmail(c *gin.Context) {
+	userRequests.WithLabelValues("POST", "/users/verify-email/resend").Inc()
+	var req ResendVerificationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	wait, err := uc.throttleVerificationEmail(c.Request.Context(), req.Email)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if wait > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "a verification email was sent recently, try again later"})
+		return
+	}
+
+	// Failures past this point are only logged, so the answer never tells whether the address is registered
+	c.JSON(http.StatusAccepted, gin.H{"message": "If the address belongs to an unverified account, a verification email is on its way"})
+	uc.inBackground(func(ctx context.Context) { uc.resendVerificationEmail(ctx, req.Email) })
+}
+
+// resendVerificationEmail sends a new verification email to the address, if it belongs
+// to an account that has not verified it yet.
+func (uc *UserController) resendVerificationEmail(ctx context.Context, email string) {
+	user, err := uc.Queries.GetUserByEmail(ctx, email)
+	if err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			uc.Logger.Error("Failed to look up user for verification email", zap.Error(err))
+		}
+		return
+	}
+	if user.EmailVerifiedAt.Valid {
+		return
+	}
+
+	if err := uc.sendVerificationEmail(ctx, user.ID, user.Username, user.Email); err != nil {
+		uc.Logger.Error("Failed to send verification email", zap.Int32("user_id", user.ID), zap.Error(err))
+	}
+}
@@ -0,0 +1,249 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"main/auth"
+	"main/config"
+	"main/db"
+	"main/mailer"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// recordingMailer keeps the messages it was asked to send
+type recordingMailer struct {
+	sent []mailer.Message
+}
+
+func (m *recordingMailer) Send(ctx context.Context, msg mailer.Message) error {
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+func postJSON(handler gin.HandlerFunc, path string, body interface{}) (int, map[string]interface{}) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+
+	jsonValue, _ := json.Marshal(body)
+	c.Request, _ = http.NewRequest("POST", path, bytes.NewBuffer(jsonValue))
+	c.Request.Header.Set("Content-Type", "application/json")
+
+	handler(c)
+
+	var response map[string]interface{}
+	_ = json.Unmarshal(w.Body.Bytes(), &response)
+	return w.Code, response
+}
+
+func TestEmailVerification(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	previous := config.AppConfig
+	t.Cleanup(func() { config.AppConfig = previous })
+	config.AppConfig.JWT.Algorithm = "HS256"
+	config.AppConfig.JWT.Key = "test-key"
+	config.AppConfig.EmailVerification.VerificationURL = "http://localhost/verify-email"
+
+	mockDB := new(MockDBTX)
+	mockSessionWrites(mockDB)
+	mockRow := new(MockRow)
+	mockRow.On("Scan", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		*args.Get(0).(*int32) = 1
+		*args.Get(1).(*string) = "tester"
+		*args.Get(2).(*string) = "testuser@test.com"
+		*args.Get(3).(*pgtype.Int4) = pgtype.Int4{Int32: 25, Valid: true}
+	}).Return(nil)
+	mockDB.On("QueryRow", mock.Anything, mock.Anything, mock.Anything).Return(mockRow)
+	mockDB.On("Query", mock.Anything, mock.Anything, mock.Anything).Return(NewMockRows(nil), nil)
+	mockDB.On("Exec", mock.Anything, mock.Anything, mock.Anything).Return(pgconn.NewCommandTag("UPDATE 1"), nil)
+
+	logger, _ := zap.NewDevelopment()
+	redisClient := newTestRedis(t)
+	uc := NewUserController(db.New(mockDB), redisClient, logger, true)
+	mail := &recordingMailer{}
+	uc.Mailer = mail
+
+	code, _ := postJSON(uc.SignUp, "/users/signup", db.CreateUserParams{
+		Username: "tester",
+		Password: "password",
+		Email:    "testuser@test.com",
+	})
+	assert.Equal(t, http.StatusCreated, code)
+	require.Len(t, mail.sent, 1)
+	assert.Equal(t, "testuser@test.com", mail.sent[0].To)
+
+	var link *url.URL
+	for _, field := range strings.Fields(mail.sent[0].Body) {
+		if strings.HasPrefix(field, config.AppConfig.EmailVerification.VerificationURL) {
+			link, _ = url.Parse(field)
+		}
+	}
+	require.NotNil(t, link)
+	token := link.Query().Get("token")
+	require.NotEmpty(t, token)
+
+	// admins looking the user up afterwards see the address verified
+	require.NoError(t, redisClient.Set(context.Background(), userCacheKey(1), "{}", 0).Err())
+	code, response := postJSON(uc.VerifyEmail, "/users/verify-email", VerifyEmailRequest{Token: token})
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "Email verified", response["message"])
+	exists, err := redisClient.Exists(context.Background(), userCacheKey(1)).Result()
+	require.NoError(t, err)
+	assert.Zero(t, exists)
+
+	code, _ = postJSON(uc.VerifyEmail, "/users/verify-email", VerifyEmailRequest{Token: "not-a-token"})
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	// signing up already started the resend interval
+	code, _ = postJSON(uc.ResendVerificationEmail, "/users/verify-email/resend", ResendVerificationRequest{Email: "testuser@test.com"})
+	assert.Equal(t, http.StatusTooManyRequests, code)
+}
+
+// failingMailer fails to send every message, keeping the ones it was asked to send
+type failingMailer struct {
+	attempted []mailer.Message
+}
+
+func (m *failingMailer) Send(ctx context.Context, msg mailer.Message) error {
+	m.attempted = append(m.attempted, msg)
+	return errors.New("smtp server unavailable")
+}
+
+func TestResendVerificationEmailAnswersAlike(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	previous := config.AppConfig
+	t.Cleanup(func() { config.AppConfig = previous })
+	config.AppConfig.JWT.Algorithm = "HS256"
+	config.AppConfig.JWT.Key = "test-key"
+
+	mockDB := new(MockDBTX)
+	mockDB.On("QueryRow", mock.Anything, isQuery("GetUserByEmail"), []interface{}{"nobody@test.com"}).Return(scanRow(userColumnCount, pgx.ErrNoRows, nil))
+	mockDB.On("QueryRow", mock.Anything, isQuery("GetUserByEmail"), []interface{}{"verified@test.com"}).Return(userRow(true))
+	mockDB.On("QueryRow", mock.Anything, isQuery("GetUserByEmail"), []interface{}{"unverified@test.com"}).Return(userRow(false))
+	logger, _ := zap.NewDevelopment()
+	uc := NewUserController(db.New(mockDB), newTestRedis(t), logger, true)
+	mail := &failingMailer{}
+	uc.Mailer = mail
+
+	// the answer tells nothing about the address, not even when mailing fails
+	var answers []map[string]interface{}
+	for _, email := range []string{"nobody@test.com", "verified@test.com", "unverified@test.com"} {
+		code, response := postJSON(uc.ResendVerificationEmail, "/users/verify-email/resend", ResendVerificationRequest{Email: email})
+		assert.Equal(t, http.StatusAccepted, code, email)
+		answers = append(answers, response)
+	}
+	assert.Equal(t, answers[0], answers[1])
+	assert.Equal(t, answers[0], answers[2])
+
+	uc.background.Wait()
+	assert.Len(t, mail.attempted, 1)
+	mockDB.AssertExpectations(t)
+}
+
+func TestLoginUnverifiedEmailPolicy(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	previous := config.AppConfig.EmailVerification
+	t.Cleanup(func() { config.AppConfig.EmailVerification = previous })
+	config.AppConfig.EmailVerification.Policy = "login"
+
+	mockDB := new(MockDBTX)
+	mockSessionWrites(mockDB)
+	mockTOTP(mockDB, "")
+	mockUserWithPassword(mockDB, "password123")
+
+	logger, _ := zap.NewDevelopment()
+	uc := NewUserController(db.New(mockDB), newTestRedis(t), logger, true)
+
+	w, response := login(uc, "tester", "password123")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, "email address is not verified", response["error"])
+
+	config.AppConfig.EmailVerification.Policy = "restricted"
+	w, response = login(uc, "tester", "password123")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotEmpty(t, response["token"])
+}
+
+func TestUpdateUserEmailNeedsVerification(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	previous := config.AppConfig
+	t.Cleanup(func() { config.AppConfig = previous })
+	config.AppConfig.JWT.Algorithm = "HS256"
+	config.AppConfig.JWT.Key = "test-key"
+
+	// row is user 1 with the address, verified or not
+	row := func(email string, verified bool, status string) *MockRow {
+		return scanRow(userColumnCount, nil, func(args mock.Arguments) {
+			*args.Get(0).(*int32) = 1
+			*args.Get(1).(*string) = "tester"
+			*args.Get(2).(*string) = email
+			*args.Get(7).(*pgtype.Timestamp) = pgtype.Timestamp{Time: time.Now(), Valid: verified}
+			*args.Get(10).(*string) = status
+		})
+	}
+
+	mockDB := new(MockDBTX)
+	mockDB.On("QueryRow", mock.Anything, isQuery("GetUser"), mock.Anything).Return(row("old@test.com", true, auth.StatusActive))
+	mockDB.On("QueryRow", mock.Anything, isQuery("UpdateUser"), mock.MatchedBy(func(args []interface{}) bool {
+		return args[2] == "new@test.com"
+	})).Return(row("new@test.com", false, auth.StatusPendingVerification)).Once()
+	mockDB.On("QueryRow", mock.Anything, isQuery("UpdateUser"), mock.MatchedBy(func(args []interface{}) bool {
+		return args[2] == "old@test.com"
+	})).Return(row("old@test.com", true, auth.StatusActive)).Once()
+	logger, _ := zap.NewDevelopment()
+	uc := NewUserController(db.New(mockDB), newTestRedis(t), logger, true)
+	mail := &recordingMailer{}
+	uc.Mailer = mail
+
+	updateUser := func(req UpdateUserRequest) (int, map[string]interface{}) {
+		body, _ := json.Marshal(req)
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request, _ = http.NewRequest("PUT", "/users/1", bytes.NewBuffer(body))
+		c.Request.Header.Set("Content-Type", "application/json")
+		c.Params = []gin.Param{{Key: "id", Value: "1"}}
+		c.Set(auth.ClaimsKey, &auth.Claims{Username: "tester", StandardClaims: jwt.StandardClaims{Subject: "1"}})
+
+		uc.UpdateUser(c)
+
+		var response map[string]interface{}
+		_ = json.Unmarshal(w.Body.Bytes(), &response)
+		return w.Code, response
+	}
+
+	// the new address is not verified until the link sent to it is opened
+	email := "new@test.com"
+	code, response := updateUser(UpdateUserRequest{Email: &email})
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, false, response["email_verified"])
+	require.Len(t, mail.sent, 1)
+	assert.Equal(t, "new@test.com", mail.sent[0].To)
+
+	// the same address keeps its verification and gets no mail
+	age := int32(30)
+	code, response = updateUser(UpdateUserRequest{Age: &age})
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, true, response["email_verified"])
+	assert.Len(t, mail.sent, 1)
+	mockDB.AssertExpectations(t)
+}
@@ -0,0 +1,159 @@
+package controller
+
+import (
+	"errors"
+	"main/auth"
+	"main/config"
+	"main/db"
+	"main/dto"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+)
+
+const defaultImpersonationTTL = 10 * time.Minute
+
+type ImpersonateRequest struct {
+	// Reason is kept in the audit log, such as the support ticket being worked on
+	Reason string `json:"reason" binding:"required,max=1000"`
+}
+
+// ImpersonateUser godoc
+// @Summary Impersonate a user
+// @Description Issue a short-lived access token that acts as the user, so support can see what they see without their password. The token names the admin in its act claim, cannot change how the account is secured and is recorded in the audit log. Admins cannot be impersonated.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Param reason body ImpersonateRequest true "Reason"
+// @Success 201 {object} gin.H "Token and the User"
+// @Failure 400 {object} gin.H "Bad Request"
+// @Failure 401 {object} gin.H "Unauthorized"
+// @Failure 403 {object} gin.H "Forbidden"
+// @Failure 404 {object} gin.H "Not Found"
+// @Failure 500 {object} gin.H "Internal Server Error"
+// @Router /admin/users/{id}/impersonate [post]
+func (uc *UserController) ImpersonateUser(c *gin.Context) {
+	userRequests.WithLabelValues("POST", "/admin/users/:id/impersonate").Inc()
+	admin, ok := auth.FromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var req ImpersonateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if adminID, _ := admin.UserID(); adminID == int32(id) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "you cannot impersonate yourself"})
+		return
+	}
+
+	user, err := uc.Queries.GetUser(c.Request.Context(), int32(id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	// Their tokens would be refused anyway
+	if refuseRestricted(c, user) {
+		return
+	}
+
+	claims, err := uc.tokenClaims(c.Request.Context(), user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if slices.Contains(claims.Roles, roleAdmin) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "admins cannot be impersonated"})
+		return
+	}
+
+	actor := auth.Actor{Subject: admin.Subject, Username: admin.Username}
+	ttl := durationOr(config.AppConfig.Impersonation.TokenTTL, defaultImpersonationTTL)
+	token, err := auth.GenerateImpersonationToken(claims, actor, ttl)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// The token is only handed out once the audit log has it
+	if err := uc.audit(c, auditImpersonationStart, &actor, user, claims.Id, req.Reason); err != nil {
+		uc.Logger.Error("Failed to write impersonation to the audit log", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to write the audit log"})
+		return
+	}
+	uc.Logger.Info("Admin started impersonating a user", zap.String("admin", admin.Username), zap.Int32("user_id", user.ID))
+
+	c.JSON(http.StatusCreated, gin.H{
+		"token":      token,
+		"token_type": "Bearer",
+		"expires_in": claims.ExpiresAt - claims.IssuedAt,
+		"user":       dto.NewAdminUser(user),
+	})
+}
+
+// StopImpersonation godoc
+// @Summary Stop impersonating a user
+// @Description Revoke the impersonation token the request is made with and record it in the audit log
+// @Tags users
+// @Produce json
+// @Success 200 {object} gin.H "Message"
+// @Failure 400 {object} gin.H "Bad Request"
+// @Failure 401 {object} gin.H "Unauthorized"
+// @Failure 500 {object} gin.H "Internal Server Error"
+// @Router /users/impersonation/stop [post]
+func (uc *UserController) StopImpersonation(c *gin.Context) {
+	userRequests.WithLabelValues("POST", "/users/impersonation/stop").Inc()
+	claims, ok := auth.FromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	if claims.Actor == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "the token is not impersonating anyone"})
+		return
+	}
+	userID, ok := claims.UserID()
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	if err := auth.RevokeToken(c.Request.Context(), uc.RedisClient, claims); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke token"})
+		return
+	}
+
+	user, err := uc.Queries.GetUser(c.Request.Context(), userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		// The user was deleted meanwhile, the entry still names them
+		user, err = db.User{Username: claims.Username}, nil
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := uc.audit(c, auditImpersonationStop, claims.Actor, user, claims.Id, ""); err != nil {
+		uc.Logger.Error("Failed to write impersonation to the audit log", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to write the audit log"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Stopped impersonating " + claims.Username})
+}
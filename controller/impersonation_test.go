@@ -0,0 +1,123 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"main/auth"
+	"main/config"
+	"main/db"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func impersonate(uc *UserController, id string, reason string) (int, map[string]interface{}) {
+	body, _ := json.Marshal(ImpersonateRequest{Reason: reason})
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Set(auth.ClaimsKey, &auth.Claims{Username: "support", Roles: []string{roleAdmin}, StandardClaims: jwt.StandardClaims{Subject: "9"}})
+	c.Params = gin.Params{{Key: "id", Value: id}}
+	c.Request, _ = http.NewRequest("POST", "/admin/users/"+id+"/impersonate", bytes.NewBuffer(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	uc.ImpersonateUser(c)
+
+	var response map[string]interface{}
+	_ = json.Unmarshal(w.Body.Bytes(), &response)
+	return w.Code, response
+}
+
+func TestImpersonation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	previousJWT, previousImpersonation := config.AppConfig.JWT, config.AppConfig.Impersonation
+	t.Cleanup(func() {
+		config.AppConfig.JWT = previousJWT
+		config.AppConfig.Impersonation = previousImpersonation
+	})
+	config.AppConfig.JWT.Algorithm = "HS256"
+	config.AppConfig.JWT.Key = "test-key"
+	config.AppConfig.Impersonation.TokenTTL = 5 * time.Minute
+
+	mockDB := new(MockDBTX)
+	mockDB.On("QueryRow", mock.Anything, isQuery("GetUser"), mock.Anything).Return(userRow(true))
+	mockDB.On("Query", mock.Anything, isQuery("GetUserRoles"), mock.Anything).Return(NewMockRows(nil), nil)
+	mockDB.On("Exec", mock.Anything, isQuery("CreateAuditLogEntry"), mock.Anything).Return(pgconn.NewCommandTag("INSERT 0 1"), nil)
+
+	logger, _ := zap.NewDevelopment()
+	redisClient := newTestRedis(t)
+	uc := NewUserController(db.New(mockDB), redisClient, logger, true)
+
+	code, _ := impersonate(uc, "9", "ticket 42")
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = impersonate(uc, "1", "")
+	assert.Equal(t, http.StatusBadRequest, code)
+	mockDB.AssertNotCalled(t, "Exec", mock.Anything, isQuery("CreateAuditLogEntry"), mock.Anything)
+
+	code, response := impersonate(uc, "1", "ticket 42")
+	require.Equal(t, http.StatusCreated, code)
+	assert.Equal(t, float64(5*60), response["expires_in"])
+
+	claims, err := auth.ParseJWT(response["token"].(string))
+	require.NoError(t, err)
+	assert.Equal(t, "1", claims.Subject)
+	assert.Equal(t, "tester", claims.Username)
+	assert.Equal(t, &auth.Actor{Subject: "9", Username: "support"}, claims.Actor)
+	mockDB.AssertCalled(t, "Exec", mock.Anything, isQuery("CreateAuditLogEntry"), []interface{}{
+		auditImpersonationStart,
+		pgtype.Int4{Int32: 9, Valid: true}, "support",
+		pgtype.Int4{Int32: 1, Valid: true}, "tester",
+		claims.Id, "ticket 42", "", "",
+	})
+
+	stop := func(claims *auth.Claims) int {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Set(auth.ClaimsKey, claims)
+		c.Request, _ = http.NewRequest("POST", "/users/impersonation/stop", nil)
+		uc.StopImpersonation(c)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusBadRequest, stop(&auth.Claims{Username: "tester", StandardClaims: jwt.StandardClaims{Subject: "1", Id: "own"}}))
+	assert.Equal(t, http.StatusOK, stop(claims))
+	mockDB.AssertCalled(t, "Exec", mock.Anything, isQuery("CreateAuditLogEntry"), []interface{}{
+		auditImpersonationStop,
+		pgtype.Int4{Int32: 9, Valid: true}, "support",
+		pgtype.Int4{Int32: 1, Valid: true}, "tester",
+		claims.Id, "", "", "",
+	})
+
+	revoked, err := auth.IsRevoked(t.Context(), redisClient, claims)
+	require.NoError(t, err)
+	assert.True(t, revoked)
+}
+
+func TestImpersonateAdmin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	previous := config.AppConfig.JWT
+	t.Cleanup(func() { config.AppConfig.JWT = previous })
+	config.AppConfig.JWT.Algorithm = "HS256"
+	config.AppConfig.JWT.Key = "test-key"
+
+	mockDB := new(MockDBTX)
+	mockDB.On("QueryRow", mock.Anything, isQuery("GetUser"), mock.Anything).Return(userRow(true))
+	mockDB.On("Query", mock.Anything, isQuery("GetUserRoles"), mock.Anything).Return(NewMockRows([][]interface{}{{roleAdmin}}), nil)
+
+	logger, _ := zap.NewDevelopment()
+	uc := NewUserController(db.New(mockDB), newTestRedis(t), logger, true)
+
+	code, _ := impersonate(uc, "1", "ticket 42")
+	assert.Equal(t, http.StatusForbidden, code)
+	mockDB.AssertNotCalled(t, "Exec", mock.Anything, isQuery("CreateAuditLogEntry"), mock.Anything)
+}
@@ -0,0 +1,21 @@
+package controller
+
+import (
+	"main/auth"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// JWKS godoc
+// @Summary Public signing keys
+// @Description List the public keys tokens are signed with as a JSON Web Key Set
+// @Tags keys
+// @Produce json
+// @Success 200 {object} auth.JWKSet "Key Set"
+// @Router /.well-known/jwks.json [get]
+func (uc *UserController) JWKS(c *gin.Context) {
+	userRequests.WithLabelValues("GET", "/.well-known/jwks.json").Inc()
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, auth.Keys.JWKS())
+}
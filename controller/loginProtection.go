@@ -0,0 +1,193 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"main/config"
+	"main/passwords"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultMaxLoginAttempts   = 5
+	defaultMaxIPLoginAttempts = 20
+	defaultLoginWindow        = 15 * time.Minute
+	defaultBaseLockout        = time.Minute
+	defaultMaxLockout         = time.Hour
+
+	loginFailuresKeyPrefix = "login_failures:"
+	loginLockKeyPrefix     = "login_lock:"
+)
+
+const errInvalidCredentials = "invalid credentials"
+
+var (
+	dummyPasswordHash     string
+	dummyPasswordHashOnce sync.Once
+)
+
+// compareDummyPassword spends as long as a real password check so that unknown
+// usernames cannot be told apart by response time.
+func compareDummyPassword(password string) {
+	dummyPasswordHashOnce.Do(func() {
+		dummyPasswordHash, _ = passwords.Hash("dummy password")
+	})
+	_ = passwords.Verify(password, dummyPasswordHash)
+}
+
+type loginSubject struct {
+	key         string
+	maxAttempts int
+}
+
+// loginAccount is what failed logins of the user are counted against. It follows
+// the user id, so neither a new name nor the next owner of the old one resets it.
+func loginAccount(userID int32) string {
+	return "user:" + strconv.Itoa(int(userID))
+}
+
+// unknownLoginAccount counts failed logins of a username nobody has, which lock
+// out the same way as the ones of real accounts.
+func unknownLoginAccount(username string) string {
+	return "name:" + username
+}
+
+func loginSubjects(account string, ip string) []loginSubject {
+	protection := config.AppConfig.LoginProtection
+	subjects := []loginSubject{
+		{key: account, maxAttempts: intOr(protection.MaxAttempts, defaultMaxLoginAttempts)},
+	}
+	if ip != "" {
+		subjects = append(subjects, loginSubject{key: "ip:" + ip, maxAttempts: intOr(protection.IPMaxAttempts, defaultMaxIPLoginAttempts)})
+	}
+	return subjects
+}
+
+// lockoutDuration doubles the lockout for every failure past the limit, up to max_lockout.
+func lockoutDuration(failures int64, maxAttempts int) time.Duration {
+	protection := config.AppConfig.LoginProtection
+	base := durationOr(protection.BaseLockout, defaultBaseLockout)
+	maxLockout := durationOr(protection.MaxLockout, defaultMaxLockout)
+
+	exponent := float64(failures - int64(maxAttempts))
+	lockout := time.Duration(float64(base) * math.Pow(2, exponent))
+	if lockout <= 0 || lockout > maxLockout {
+		return maxLockout
+	}
+	return lockout
+}
+
+// loginLockout returns how long the account or client IP is still locked out for.
+func (uc *UserController) loginLockout(ctx context.Context, account string, ip string) (time.Duration, error) {
+	var lockout time.Duration
+	for _, subject := range loginSubjects(account, ip) {
+		ttl, err := uc.RedisClient.PTTL(ctx, loginLockKeyPrefix+subject.key).Result()
+		if err != nil {
+			return 0, err
+		}
+		if ttl > lockout {
+			lockout = ttl
+		}
+	}
+	return lockout, nil
+}
+
+// recordLoginFailure counts a failed attempt and locks the account or IP once it went over its limit.
+func (uc *UserController) recordLoginFailure(ctx context.Context, account string, ip string) error {
+	window := durationOr(config.AppConfig.LoginProtection.Window, defaultLoginWindow)
+
+	for _, subject := range loginSubjects(account, ip) {
+		var failures *redis.IntCmd
+		_, err := uc.RedisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			failures = pipe.Incr(ctx, loginFailuresKeyPrefix+subject.key)
+			pipe.Expire(ctx, loginFailuresKeyPrefix+subject.key, window)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		if failures.Val() >= int64(subject.maxAttempts) {
+			lockout := lockoutDuration(failures.Val(), subject.maxAttempts)
+			if err := uc.RedisClient.Set(ctx, loginLockKeyPrefix+subject.key, failures.Val(), lockout).Err(); err != nil {
+				return err
+			}
+			uc.Logger.Warn("Login locked after repeated failures", zap.String("subject", subject.key), zap.Duration("lockout", lockout))
+		}
+	}
+	return nil
+}
+
+// resetLoginFailures clears the failure counter and lock of a user.
+func (uc *UserController) resetLoginFailures(ctx context.Context, userID int32) error {
+	account := loginAccount(userID)
+	return uc.RedisClient.Del(ctx, loginFailuresKeyPrefix+account, loginLockKeyPrefix+account).Err()
+}
+
+func (uc *UserController) rejectLogin(c *gin.Context, account string) {
+	if err := uc.recordLoginFailure(c.Request.Context(), account, c.ClientIP()); err != nil {
+		uc.Logger.Error("Failed to record login failure", zap.Error(err))
+	}
+	c.JSON(http.StatusUnauthorized, gin.H{"error": errInvalidCredentials})
+}
+
+// UnlockUser godoc
+// @Summary Unlock a user's login
+// @Description Clear the failed login counter and lockout of a user
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {object} gin.H "Message"
+// @Failure 400 {object} gin.H "Bad Request"
+// @Failure 404 {object} gin.H "Not Found"
+// @Failure 500 {object} gin.H "Internal Server Error"
+// @Router /admin/users/{id}/unlock [post]
+func (uc *UserController) UnlockUser(c *gin.Context) {
+	userRequests.WithLabelValues("POST", "/admin/users/:id/unlock").Inc()
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := uc.Queries.GetUser(c.Request.Context(), int32(id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := uc.resetLoginFailures(c.Request.Context(), user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "User unlocked"})
+}
+
+func intOr(value int, defaultValue int) int {
+	if value > 0 {
+		return value
+	}
+	return defaultValue
+}
+
+func durationOr(value time.Duration, defaultValue time.Duration) time.Duration {
+	if value > 0 {
+		return value
+	}
+	return defaultValue
+}
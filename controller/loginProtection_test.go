@@ -0,0 +1,109 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"main/db"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func login(uc *UserController, username string, password string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+
+	jsonValue, _ := json.Marshal(LoginRequest{Username: username, Password: password})
+	c.Request, _ = http.NewRequest("POST", "/users/login", bytes.NewBuffer(jsonValue))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Request.RemoteAddr = "10.0.0.1:1234"
+
+	uc.Login(c)
+
+	var response map[string]interface{}
+	_ = json.Unmarshal(w.Body.Bytes(), &response)
+	return w, response
+}
+
+// mockUserWithPassword answers user lookups with an unverified user "tester" with the given password
+func mockUserWithPassword(mockDB *MockDBTX, password string) {
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	mockRow := new(MockRow)
+	mockRow.On("Scan", anyColumns(userColumnCount)...).Return(nil).Run(func(args mock.Arguments) {
+		*args.Get(0).(*int32) = 1
+		*args.Get(1).(*string) = "tester"
+		*args.Get(3).(*string) = string(hashedPassword)
+		*args.Get(6).(*pgtype.Timestamp) = pgtype.Timestamp{Time: time.Now(), Valid: true}
+	})
+	mockDB.On("QueryRow", mock.Anything, mock.Anything, mock.Anything).Return(mockRow)
+	mockDB.On("Query", mock.Anything, mock.Anything, mock.Anything).Return(NewMockRows(nil), nil)
+}
+
+func TestLoginLockout(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockDB := new(MockDBTX)
+	mockSessionWrites(mockDB)
+	mockTOTP(mockDB, "")
+	mockUserWithPassword(mockDB, "password123")
+
+	logger, _ := zap.NewDevelopment()
+	uc := NewUserController(db.New(mockDB), newTestRedis(t), logger, true)
+
+	for i := 0; i < defaultMaxLoginAttempts; i++ {
+		w, response := login(uc, "tester", "wrong password")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Equal(t, errInvalidCredentials, response["error"])
+	}
+
+	// the right password is refused as well while the account is locked
+	w, _ := login(uc, "tester", "password123")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+
+	assert.NoError(t, uc.resetLoginFailures(t.Context(), 1))
+	w, response := login(uc, "tester", "password123")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotEmpty(t, response["token"])
+}
+
+func TestLockoutDuration(t *testing.T) {
+	assert.Equal(t, defaultBaseLockout, lockoutDuration(5, 5))
+	assert.Equal(t, 4*defaultBaseLockout, lockoutDuration(7, 5))
+	assert.Equal(t, defaultMaxLockout, lockoutDuration(100, 5))
+}
+
+func TestUnlockUser(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockDB := new(MockDBTX)
+	mockDB.On("QueryRow", mock.Anything, isQuery("GetUser"), []interface{}{int32(1)}).Return(userRow(true))
+	mockDB.On("QueryRow", mock.Anything, isQuery("GetUser"), []interface{}{int32(2)}).Return(scanRow(userColumnCount, pgx.ErrNoRows, nil))
+	mockDB.On("QueryRow", mock.Anything, isQuery("GetUser"), []interface{}{int32(3)}).Return(scanRow(userColumnCount, errors.New("connection refused"), nil))
+	logger, _ := zap.NewDevelopment()
+	uc := NewUserController(db.New(mockDB), newTestRedis(t), logger, true)
+
+	unlock := func(id string) int {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request, _ = http.NewRequest("POST", "/admin/users/"+id+"/unlock", nil)
+		c.Params = []gin.Param{{Key: "id", Value: id}}
+		uc.UnlockUser(c)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, unlock("1"))
+	assert.Equal(t, http.StatusNotFound, unlock("2"))
+	// the database being down is not the user missing
+	assert.Equal(t, http.StatusInternalServerError, unlock("3"))
+}
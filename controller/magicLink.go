@@ -0,0 +1,254 @@
+package controller
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"main/auth"
+	"main/config"
+	"main/db"
+	"main/mailer"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultMagicLinkTTL = 15 * time.Minute
+
+	magicLinkKeyPrefix     = "magic_link:"
+	magicLinkSentKeyPrefix = "magic_link_sent:"
+
+	// magicLinkCookie holds the nonce that binds a link to the browser it was asked for in
+	magicLinkCookie     = "magic_link_nonce"
+	magicLinkCookiePath = "/api/users/login/magic-link"
+)
+
+var (
+	ErrMagicLinkInvalid     = errors.New("login link is invalid, expired or was already used")
+	ErrMagicLinkOtherDevice = errors.New("open the login link in the browser you asked for it from")
+)
+
+type MagicLinkRequest struct {
+	Email string `json:"email" binding:"required"`
+}
+
+// sendMagicLink mails the user a link that logs them in once. The hash of the
+// browser's nonce is kept under the link's token id until it is used or expires.
+func (uc *UserController) sendMagicLink(ctx context.Context, user db.User, nonce string, loginURL string) error {
+	ttl := durationOr(config.AppConfig.MagicLink.TokenTTL, defaultMagicLinkTTL)
+	claims := &auth.Claims{
+		Username:       user.Username,
+		Email:          user.Email,
+		StandardClaims: jwt.StandardClaims{Subject: strconv.Itoa(int(user.ID))},
+	}
+	token, err := auth.GeneratePurposeToken(claims, auth.PurposeMagicLink, ttl)
+	if err != nil {
+		return err
+	}
+	if err := uc.RedisClient.Set(ctx, magicLinkKeyPrefix+claims.Id, hashToken(nonce), ttl).Err(); err != nil {
+		return err
+	}
+
+	return uc.Mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Your login link",
+		Body: fmt.Sprintf("Hi %s,\r\n\r\nYou can log in by opening the link below in the browser you asked for it from. It works once and expires in %s.\r\n\r\n%s?token=%s\r\n\r\n"+
+			"If you did not ask for this, you can ignore this email.\r\n", user.Username, ttl, loginURL, url.QueryEscape(token)),
+	})
+}
+
+// useMagicLink checks the link's token against the browser's nonce and makes sure it
+// cannot be used again. Links opened elsewhere, such as by a mail scanner, are left usable.
+func (uc *UserController) useMagicLink(ctx context.Context, token string, nonce string) (*auth.Claims, error) {
+	claims, err := auth.ParseJWT(token)
+	if err != nil || claims.Purpose != auth.PurposeMagicLink {
+		return nil, ErrMagicLinkInvalid
+	}
+
+	key := magicLinkKeyPrefix + claims.Id
+	stored, err := uc.RedisClient.Get(ctx, key).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrMagicLinkInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+	if nonce == "" || subtle.ConstantTimeCompare([]byte(stored), []byte(hashToken(nonce))) != 1 {
+		return nil, ErrMagicLinkOtherDevice
+	}
+
+	deleted, err := uc.RedisClient.Del(ctx, key).Result()
+	if err != nil {
+		return nil, err
+	}
+	if deleted == 0 {
+		return nil, ErrMagicLinkInvalid
+	}
+	return claims, nil
+}
+
+// RequestMagicLink godoc
+// @Summary Request a login link
+// @Description Email a single-use link that logs the user in without a password. The link only works in the browser that asked for it, which gets a cookie to prove it. The answer is the same whether or not the address belongs to an account.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param email body MagicLinkRequest true "Email Address"
+// @Success 202 {object} gin.H "Message"
+// @Failure 400 {object} gin.H "Bad Request"
+// @Failure 429 {object} gin.H "Too Many Requests"
+// @Failure 503 {object} gin.H "Service Unavailable"
+// @Router /users/login/magic-link [post]
+func (uc *UserController) RequestMagicLink(c *gin.Context) {
+	userRequests.WithLabelValues("POST", "/users/login/magic-link").Inc()
+	var req MagicLinkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// The link is never built from the request, whoever asks for it could name their own host
+	loginURL := config.AppConfig.MagicLink.LoginURL
+	if loginURL == "" {
+		uc.Logger.Error("Refusing to send a login link, magic_link.login_url is not set")
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "login links are not available"})
+		return
+	}
+
+	message := gin.H{"message": "If the address belongs to an account, a login link is on its way"}
+	ctx := c.Request.Context()
+	interval := durationOr(config.AppConfig.MagicLink.ResendInterval, defaultResendInterval)
+	wait, err := uc.throttleMail(ctx, magicLinkSentKeyPrefix, req.Email, interval)
+	if err != nil {
+		uc.Logger.Error("Failed to throttle login link email", zap.Error(err))
+		c.JSON(http.StatusAccepted, message)
+		return
+	}
+	// A new cookie would break the link that was just sent, so a throttled request leaves it alone
+	if wait > 0 {
+		c.JSON(http.StatusAccepted, message)
+		return
+	}
+
+	nonce, err := randomToken(32)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ttl := durationOr(config.AppConfig.MagicLink.TokenTTL, defaultMagicLinkTTL)
+	secure := c.Request.TLS != nil || strings.HasPrefix(loginURL, "https://")
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(magicLinkCookie, nonce, int(ttl.Seconds()), magicLinkCookiePath, "", secure, true)
+
+	// Failures past this point are only logged, so the answer never tells whether the address is registered
+	c.JSON(http.StatusAccepted, message)
+	uc.inBackground(func(ctx context.Context) {
+		user, err := uc.Queries.GetUserByEmail(ctx, req.Email)
+		if err != nil {
+			if !errors.Is(err, pgx.ErrNoRows) {
+				uc.Logger.Error("Failed to look up user for login link", zap.Error(err))
+			}
+			return
+		}
+		if err := uc.sendMagicLink(ctx, user, nonce, loginURL); err != nil {
+			uc.Logger.Error("Failed to send login link", zap.Error(err))
+		}
+	})
+}
+
+// MagicLinkCallback godoc
+// @Summary Log in with a login link
+// @Description Exchange the token of an emailed login link for an access token and a refresh token, like a password login. The link has to be opened in the browser that asked for it. Accounts with two-factor authentication get an MFA challenge instead.
+// @Tags users
+// @Produce json
+// @Param token query string true "Login Link Token"
+// @Success 200 {object} gin.H "Tokens or MFA challenge"
+// @Failure 400 {object} gin.H "Bad Request"
+// @Failure 403 {object} gin.H "Forbidden"
+// @Failure 500 {object} gin.H "Internal Server Error"
+// @Router /users/login/magic-link/callback [get]
+func (uc *UserController) MagicLinkCallback(c *gin.Context) {
+	userRequests.WithLabelValues("GET", "/users/login/magic-link/callback").Inc()
+	nonce, _ := c.Cookie(magicLinkCookie)
+	claims, err := uc.useMagicLink(c.Request.Context(), c.Query("token"), nonce)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrMagicLinkInvalid):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, ErrMagicLinkOtherDevice):
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	c.SetCookie(magicLinkCookie, "", -1, magicLinkCookiePath, "", c.Request.TLS != nil, true)
+
+	userID, ok := claims.UserID()
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": ErrMagicLinkInvalid.Error()})
+		return
+	}
+	user, err := uc.Queries.GetUser(c.Request.Context(), userID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": ErrMagicLinkInvalid.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	// The link went to the address the account had when it was asked for
+	if !strings.EqualFold(user.Email, claims.Email) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": ErrMagicLinkInvalid.Error()})
+		return
+	}
+	if refuseRestricted(c, user) {
+		return
+	}
+
+	// The link reached the user's inbox, which proves they own the address
+	if !user.EmailVerifiedAt.Valid {
+		if _, err := uc.Queries.VerifyUserEmail(c.Request.Context(), db.VerifyUserEmailParams{ID: user.ID, Email: user.Email}); err != nil {
+			uc.Logger.Warn("Failed to mark email as verified", zap.Error(err))
+		} else {
+			user.EmailVerifiedAt.Time, user.EmailVerifiedAt.Valid = time.Now(), true
+		}
+	}
+
+	mfaEnabled, err := uc.mfaEnabled(c.Request.Context(), user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if mfaEnabled {
+		challenge, err := uc.mfaChallenge(c.Request.Context(), user)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, challenge)
+		return
+	}
+
+	if err := uc.resetLoginFailures(c.Request.Context(), user.ID); err != nil {
+		uc.Logger.Warn("Failed to reset login failures", zap.Error(err))
+	}
+
+	tokens, err := uc.issueTokens(c.Request.Context(), user, deviceOf(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	uc.respondWithTokens(c, http.StatusOK, tokens)
+}
@@ -0,0 +1,122 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"main/config"
+	"main/db"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestMagicLinkLogin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	previousJWT, previousMagicLink := config.AppConfig.JWT, config.AppConfig.MagicLink
+	t.Cleanup(func() {
+		config.AppConfig.JWT = previousJWT
+		config.AppConfig.MagicLink = previousMagicLink
+	})
+	config.AppConfig.JWT.Algorithm = "HS256"
+	config.AppConfig.JWT.Key = "test-key"
+	config.AppConfig.MagicLink.LoginURL = "https://id.example.com/api/users/login/magic-link/callback"
+
+	mockDB := new(MockDBTX)
+	mockTOTP(mockDB, "")
+	mockSessionWrites(mockDB)
+	mockDB.On("QueryRow", mock.Anything, isQuery("GetUserByEmail"), mock.Anything).Return(userRow(true))
+	mockDB.On("QueryRow", mock.Anything, isQuery("GetUser"), mock.Anything).Return(userRow(true))
+	mockDB.On("Query", mock.Anything, isQuery("GetUserRoles"), mock.Anything).Return(NewMockRows(nil), nil)
+
+	logger, _ := zap.NewDevelopment()
+	uc := NewUserController(db.New(mockDB), newTestRedis(t), logger, true)
+	mail := &recordingMailer{}
+	uc.Mailer = mail
+
+	router := gin.New()
+	router.POST("/api/users/login/magic-link", uc.RequestMagicLink)
+	router.GET("/api/users/login/magic-link/callback", uc.MagicLinkCallback)
+
+	requestLink := func() *httptest.ResponseRecorder {
+		body, _ := json.Marshal(MagicLinkRequest{Email: "alice@example.com"})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/users/login/magic-link", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Host = "evil.example"
+		router.ServeHTTP(w, req)
+		uc.background.Wait()
+		return w
+	}
+	openLink := func(link string, cookie *http.Cookie) (int, map[string]interface{}) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", link, nil)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		router.ServeHTTP(w, req)
+
+		var response map[string]interface{}
+		_ = json.Unmarshal(w.Body.Bytes(), &response)
+		return w.Code, response
+	}
+
+	w := requestLink()
+	require.Equal(t, http.StatusAccepted, w.Code)
+	cookies := w.Result().Cookies()
+	require.Len(t, cookies, 1)
+	assert.Equal(t, magicLinkCookie, cookies[0].Name)
+	assert.True(t, cookies[0].HttpOnly)
+
+	require.Len(t, mail.sent, 1)
+	assert.Equal(t, "alice@example.com", mail.sent[0].To)
+	var link *url.URL
+	for _, field := range strings.Fields(mail.sent[0].Body) {
+		if parsed, err := url.Parse(field); err == nil && parsed.Query().Get("token") != "" {
+			link = parsed
+		}
+	}
+	require.NotNil(t, link)
+	// the link points where it is configured to, never at the host the request names
+	assert.Equal(t, config.AppConfig.MagicLink.LoginURL, link.Scheme+"://"+link.Host+link.Path)
+
+	// another browser, or a mail scanner following the link, cannot use it up
+	code, response := openLink(link.RequestURI(), nil)
+	assert.Equal(t, http.StatusForbidden, code)
+	assert.Equal(t, ErrMagicLinkOtherDevice.Error(), response["error"])
+	code, _ = openLink(link.RequestURI(), &http.Cookie{Name: magicLinkCookie, Value: "other"})
+	assert.Equal(t, http.StatusForbidden, code)
+
+	code, response = openLink(link.RequestURI(), cookies[0])
+	require.Equal(t, http.StatusOK, code)
+	assert.NotEmpty(t, response["token"])
+	assert.NotEmpty(t, response["refresh_token"])
+
+	code, response = openLink(link.RequestURI(), cookies[0])
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, ErrMagicLinkInvalid.Error(), response["error"])
+
+	code, _ = openLink("/api/users/login/magic-link/callback?token=garbage", cookies[0])
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	// asking again right away sends nothing and keeps the cookie of the link already sent
+	w = requestLink()
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Empty(t, w.Result().Cookies())
+	assert.Len(t, mail.sent, 1)
+
+	// without a configured link nothing is sent at all
+	config.AppConfig.MagicLink.LoginURL = ""
+	w = requestLink()
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Empty(t, w.Result().Cookies())
+	assert.Len(t, mail.sent, 1)
+}
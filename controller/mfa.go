@@ -0,0 +1,461 @@
+package controller
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"main/auth"
+	"main/db"
+	"main/totp"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+)
+
+const (
+	totpIssuer        = "userManagementSystem"
+	recoveryCodeCount = 10
+
+	totpUsedKeyPrefix = "totp_used:"
+)
+
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+type MFACodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type LoginMFARequest struct {
+	MFAToken string `json:"mfa_token" binding:"required"`
+	Code     string `json:"code" binding:"required"`
+}
+
+// mfaEnabled reports whether the user has a confirmed TOTP secret.
+func (uc *UserController) mfaEnabled(ctx context.Context, userID int32) (bool, error) {
+	secret, err := uc.Queries.GetUserTOTP(ctx, userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return secret.ConfirmedAt.Valid, nil
+}
+
+// mfaChallenge answers the password step of a login for an account with two-factor authentication.
+func (uc *UserController) mfaChallenge(ctx context.Context, user db.User) (gin.H, error) {
+	generation, err := auth.TokenGeneration(ctx, uc.RedisClient, strconv.Itoa(int(user.ID)))
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := auth.GenerateMFAToken(&auth.Claims{
+		Username:       user.Username,
+		Generation:     generation,
+		StandardClaims: jwt.StandardClaims{Subject: strconv.Itoa(int(user.ID))},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return gin.H{
+		"mfa_required": true,
+		"mfa_token":    token,
+		"expires_in":   int(auth.MFATokenTTL.Seconds()),
+	}, nil
+}
+
+// useTOTPStep makes sure every code is accepted only once, even within its time step.
+func (uc *UserController) useTOTPStep(ctx context.Context, userID int32, step int64) (bool, error) {
+	key := totpUsedKeyPrefix + strconv.Itoa(int(userID)) + ":" + strconv.FormatInt(step, 10)
+	return uc.RedisClient.SetNX(ctx, key, 1, 3*totp.Period).Result()
+}
+
+// verifySecondFactor accepts either a current TOTP code or one of the user's unused recovery codes.
+func (uc *UserController) verifySecondFactor(ctx context.Context, userID int32, secret string, code string) (bool, error) {
+	if step, ok := totp.Validate(secret, code, time.Now()); ok {
+		return uc.useTOTPStep(ctx, userID, step)
+	}
+
+	rows, err := uc.Queries.UseRecoveryCode(ctx, db.UseRecoveryCodeParams{
+		UserID:   userID,
+		CodeHash: hashToken(normalizeRecoveryCode(code)),
+	})
+	if err != nil {
+		return false, err
+	}
+	return rows == 1, nil
+}
+
+// newRecoveryCodes replaces the user's recovery codes. Only their hashes are stored,
+// the codes themselves are shown to the user once.
+func (uc *UserController) newRecoveryCodes(ctx context.Context, userID int32) ([]string, error) {
+	if err := uc.Queries.DeleteRecoveryCodes(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		buf := make([]byte, 5)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(recoveryCodeEncoding.EncodeToString(buf))
+		codes[i] = code[:4] + "-" + code[4:]
+
+		err := uc.Queries.CreateRecoveryCode(ctx, db.CreateRecoveryCodeParams{
+			UserID:   userID,
+			CodeHash: hashToken(normalizeRecoveryCode(codes[i])),
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return codes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.ReplaceAll(code, "-", "")
+}
+
+// currentUserID returns the id of the authenticated user.
+func currentUserID(c *gin.Context) (int32, bool) {
+	claims, ok := auth.FromContext(c)
+	if !ok {
+		return 0, false
+	}
+	return claims.UserID()
+}
+
+// EnrollTOTP godoc
+// @Summary Start TOTP enrollment
+// @Description Generate a TOTP secret for the user. It is only used once it is confirmed with a code.
+// @Tags mfa
+// @Accept json
+// @Produce json
+// @Success 200 {object} gin.H "Secret and otpauth URI"
+// @Failure 401 {object} gin.H "Unauthorized"
+// @Failure 409 {object} gin.H "Conflict"
+// @Failure 500 {object} gin.H "Internal Server Error"
+// @Router /users/me/mfa/totp [post]
+func (uc *UserController) EnrollTOTP(c *gin.Context) {
+	userRequests.WithLabelValues("POST", "/users/me/mfa/totp").Inc()
+	userID, ok := currentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	enabled, err := uc.mfaEnabled(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if enabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "two-factor authentication is already enabled"})
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	err = uc.Queries.UpsertUserTOTP(c.Request.Context(), db.UpsertUserTOTPParams{UserID: userID, Secret: secret})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"secret":      secret,
+		"otpauth_uri": totp.URI(totpIssuer, c.GetString("username"), secret),
+	})
+}
+
+// ConfirmTOTP godoc
+// @Summary Confirm TOTP enrollment
+// @Description Enable two-factor authentication with a code from the enrolled secret and get the recovery codes
+// @Tags mfa
+// @Accept json
+// @Produce json
+// @Param code body MFACodeRequest true "TOTP Code"
+// @Success 200 {object} gin.H "Recovery Codes"
+// @Failure 400 {object} gin.H "Bad Request"
+// @Failure 401 {object} gin.H "Unauthorized"
+// @Failure 409 {object} gin.H "Conflict"
+// @Failure 500 {object} gin.H "Internal Server Error"
+// @Router /users/me/mfa/totp/confirm [post]
+func (uc *UserController) ConfirmTOTP(c *gin.Context) {
+	userRequests.WithLabelValues("POST", "/users/me/mfa/totp/confirm").Inc()
+	userID, ok := currentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var req MFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	secret, err := uc.Queries.GetUserTOTP(c.Request.Context(), userID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "TOTP enrollment has not been started"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if secret.ConfirmedAt.Valid {
+		c.JSON(http.StatusConflict, gin.H{"error": "two-factor authentication is already enabled"})
+		return
+	}
+
+	// A code whose time step was spent already is refused here as it is at login
+	step, valid := totp.Validate(secret.Secret, req.Code, time.Now())
+	if valid {
+		valid, err = uc.useTOTPStep(c.Request.Context(), userID, step)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if !valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid code"})
+		return
+	}
+
+	if err := uc.Queries.ConfirmUserTOTP(c.Request.Context(), userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	codes, err := uc.newRecoveryCodes(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication enabled", "recovery_codes": codes})
+}
+
+// DisableTOTP godoc
+// @Summary Disable TOTP
+// @Description Turn off two-factor authentication with a TOTP or recovery code
+// @Tags mfa
+// @Accept json
+// @Produce json
+// @Param code body MFACodeRequest true "TOTP or Recovery Code"
+// @Success 200 {object} gin.H "Message"
+// @Failure 400 {object} gin.H "Bad Request"
+// @Failure 401 {object} gin.H "Unauthorized"
+// @Failure 500 {object} gin.H "Internal Server Error"
+// @Router /users/me/mfa/totp [delete]
+func (uc *UserController) DisableTOTP(c *gin.Context) {
+	userRequests.WithLabelValues("DELETE", "/users/me/mfa/totp").Inc()
+	userID, ok := currentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var req MFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	secret, err := uc.Queries.GetUserTOTP(c.Request.Context(), userID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "two-factor authentication is not enabled"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	valid, err := uc.verifySecondFactor(c.Request.Context(), userID, secret.Secret, req.Code)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid code"})
+		return
+	}
+
+	if err := uc.Queries.DeleteUserTOTP(c.Request.Context(), userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := uc.Queries.DeleteRecoveryCodes(c.Request.Context(), userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+}
+
+// RegenerateRecoveryCodes godoc
+// @Summary Regenerate recovery codes
+// @Description Replace all recovery codes of the user, the old ones stop working
+// @Tags mfa
+// @Accept json
+// @Produce json
+// @Param code body MFACodeRequest true "TOTP Code"
+// @Success 200 {object} gin.H "Recovery Codes"
+// @Failure 400 {object} gin.H "Bad Request"
+// @Failure 401 {object} gin.H "Unauthorized"
+// @Failure 500 {object} gin.H "Internal Server Error"
+// @Router /users/me/mfa/recovery-codes [post]
+func (uc *UserController) RegenerateRecoveryCodes(c *gin.Context) {
+	userRequests.WithLabelValues("POST", "/users/me/mfa/recovery-codes").Inc()
+	userID, ok := currentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var req MFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	secret, err := uc.Queries.GetUserTOTP(c.Request.Context(), userID)
+	if err != nil || !secret.ConfirmedAt.Valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "two-factor authentication is not enabled"})
+		return
+	}
+
+	step, valid := totp.Validate(secret.Secret, req.Code, time.Now())
+	if valid {
+		valid, err = uc.useTOTPStep(c.Request.Context(), userID, step)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if !valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid code"})
+		return
+	}
+
+	codes, err := uc.newRecoveryCodes(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// LoginMFA godoc
+// @Summary Finish a two-factor login
+// @Description Exchange the mfa_token from the login step and a TOTP or recovery code for a token pair
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param login body LoginMFARequest true "MFA Token and Code"
+// @Success 200 {object} gin.H "Token Pair"
+// @Failure 400 {object} gin.H "Bad Request"
+// @Failure 401 {object} gin.H "Unauthorized"
+// @Failure 429 {object} gin.H "Too Many Requests"
+// @Failure 500 {object} gin.H "Internal Server Error"
+// @Router /users/login/mfa [post]
+func (uc *UserController) LoginMFA(c *gin.Context) {
+	userRequests.WithLabelValues("POST", "/login/mfa").Inc()
+	var req LoginMFARequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	claims, err := auth.ParseJWT(req.MFAToken)
+	if err != nil || claims.Purpose != auth.PurposeMFA {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+		return
+	}
+	userID, ok := claims.UserID()
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+		return
+	}
+
+	revoked, err := auth.IsRevoked(c.Request.Context(), uc.RedisClient, claims)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if revoked {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Token has been revoked"})
+		return
+	}
+
+	lockout, err := uc.loginLockout(c.Request.Context(), loginAccount(userID), c.ClientIP())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if lockout > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(lockout.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many failed login attempts, try again later"})
+		return
+	}
+
+	secret, err := uc.Queries.GetUserTOTP(c.Request.Context(), userID)
+	if err != nil || !secret.ConfirmedAt.Valid {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+		return
+	}
+
+	valid, err := uc.verifySecondFactor(c.Request.Context(), userID, secret.Secret, req.Code)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !valid {
+		uc.rejectLogin(c, loginAccount(userID))
+		return
+	}
+
+	// The mfa token finishes exactly one login
+	if err := auth.RevokeToken(c.Request.Context(), uc.RedisClient, claims); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := uc.resetLoginFailures(c.Request.Context(), userID); err != nil {
+		uc.Logger.Warn("Failed to reset login failures", zap.Error(err))
+	}
+
+	user, err := uc.Queries.GetUser(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if refuseRestricted(c, user) {
+		return
+	}
+
+	tokens, err := uc.issueTokens(c.Request.Context(), user, deviceOf(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	uc.respondWithTokens(c, http.StatusOK, tokens)
+}
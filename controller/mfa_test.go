@@ -0,0 +1,176 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"main/auth"
+	"main/config"
+	"main/db"
+	"main/totp"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func isTOTPQuery(sql string) bool {
+	return strings.Contains(sql, "FROM user_totp")
+}
+
+// mockTOTP answers GetUserTOTP, an empty secret means the user has no second factor.
+// It has to be set up before any catch-all QueryRow expectation.
+func mockTOTP(mockDB *MockDBTX, secret string) {
+	mockRow := new(MockRow)
+	if secret == "" {
+		mockRow.On("Scan", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(pgx.ErrNoRows)
+	} else {
+		mockRow.On("Scan", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+			*args.Get(0).(*int32) = 1
+			*args.Get(1).(*string) = secret
+			*args.Get(2).(*pgtype.Timestamp) = pgtype.Timestamp{Time: time.Now(), Valid: true}
+		})
+	}
+	mockDB.On("QueryRow", mock.Anything, mock.MatchedBy(isTOTPQuery), mock.Anything).Return(mockRow)
+}
+
+func loginMFA(uc *UserController, mfaToken string, code string) (int, map[string]interface{}) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+
+	jsonValue, _ := json.Marshal(LoginMFARequest{MFAToken: mfaToken, Code: code})
+	c.Request, _ = http.NewRequest("POST", "/users/login/mfa", bytes.NewBuffer(jsonValue))
+	c.Request.Header.Set("Content-Type", "application/json")
+
+	uc.LoginMFA(c)
+
+	var response map[string]interface{}
+	_ = json.Unmarshal(w.Body.Bytes(), &response)
+	return w.Code, response
+}
+
+func TestLoginWithTOTP(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	previous := config.AppConfig.JWT
+	t.Cleanup(func() { config.AppConfig.JWT = previous })
+	config.AppConfig.JWT.Algorithm = "HS256"
+	config.AppConfig.JWT.Key = "test-key"
+
+	secret, err := totp.GenerateSecret()
+	require.NoError(t, err)
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.DefaultCost)
+
+	mockDB := new(MockDBTX)
+	mockSessionWrites(mockDB)
+	mockTOTP(mockDB, secret)
+	mockRow := new(MockRow)
+	mockRow.On("Scan", anyColumns(userColumnCount)...).Return(nil).Run(func(args mock.Arguments) {
+		*args.Get(0).(*int32) = 1
+		*args.Get(1).(*string) = "tester"
+		*args.Get(3).(*string) = string(hashedPassword)
+	})
+	mockDB.On("QueryRow", mock.Anything, mock.Anything, mock.Anything).Return(mockRow)
+	mockDB.On("Query", mock.Anything, mock.Anything, mock.Anything).Return(NewMockRows(nil), nil)
+	// no recovery code matches
+	mockDB.On("Exec", mock.Anything, mock.Anything, mock.Anything).Return(pgconn.NewCommandTag("UPDATE 0"), nil)
+
+	logger, _ := zap.NewDevelopment()
+	uc := NewUserController(db.New(mockDB), newTestRedis(t), logger, true)
+
+	w, response := login(uc, "tester", "password123")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, true, response["mfa_required"])
+	assert.NotContains(t, response, "token")
+	mfaToken, _ := response["mfa_token"].(string)
+	require.NotEmpty(t, mfaToken)
+
+	staleCode, err := totp.Code(secret, totp.Step(time.Now())-10)
+	require.NoError(t, err)
+	code, response := loginMFA(uc, mfaToken, staleCode)
+	assert.Equal(t, http.StatusUnauthorized, code)
+	assert.Equal(t, errInvalidCredentials, response["error"])
+
+	totpCode, err := totp.Code(secret, totp.Step(time.Now()))
+	require.NoError(t, err)
+	code, response = loginMFA(uc, mfaToken, totpCode)
+	assert.Equal(t, http.StatusOK, code)
+	assert.NotEmpty(t, response["token"])
+	assert.NotEmpty(t, response["refresh_token"])
+
+	// the mfa token and the code are both single use
+	code, _ = loginMFA(uc, mfaToken, totpCode)
+	assert.Equal(t, http.StatusUnauthorized, code)
+}
+
+func TestLoginMFARejectsAccessTokens(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	previous := config.AppConfig.JWT
+	t.Cleanup(func() { config.AppConfig.JWT = previous })
+	config.AppConfig.JWT.Algorithm = "HS256"
+	config.AppConfig.JWT.Key = "test-key"
+
+	mockDB := new(MockDBTX)
+	mockUserLookup(mockDB, 1, "tester")
+	logger, _ := zap.NewDevelopment()
+	uc := NewUserController(db.New(mockDB), newTestRedis(t), logger, true)
+
+	accessToken, _, err := uc.accessToken(t.Context(), db.User{ID: 1, Username: "tester"}, "")
+	require.NoError(t, err)
+
+	code, response := loginMFA(uc, accessToken, "123456")
+	assert.Equal(t, http.StatusUnauthorized, code)
+	assert.Equal(t, "Invalid token", response["error"])
+}
+
+func TestConfirmTOTPRejectsUsedStep(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	secret, err := totp.GenerateSecret()
+	require.NoError(t, err)
+	mockDB := new(MockDBTX)
+	// enrollment was started but not confirmed yet
+	mockDB.On("QueryRow", mock.Anything, mock.MatchedBy(isTOTPQuery), mock.Anything).Return(scanRow(4, nil, func(args mock.Arguments) {
+		*args.Get(0).(*int32) = 1
+		*args.Get(1).(*string) = secret
+	}))
+	logger, _ := zap.NewDevelopment()
+	uc := NewUserController(db.New(mockDB), newTestRedis(t), logger, true)
+
+	// someone watching the code go in at login used it first
+	step := totp.Step(time.Now())
+	used, err := uc.useTOTPStep(t.Context(), 1, step)
+	require.NoError(t, err)
+	require.True(t, used)
+	code, err := totp.Code(secret, step)
+	require.NoError(t, err)
+
+	body, _ := json.Marshal(MFACodeRequest{Code: code})
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("POST", "/users/me/mfa/totp/confirm", bytes.NewBuffer(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Set(auth.ClaimsKey, &auth.Claims{Username: "tester", StandardClaims: jwt.StandardClaims{Subject: "1"}})
+
+	uc.ConfirmTOTP(c)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "invalid code")
+	mockDB.AssertNotCalled(t, "Exec", mock.Anything, isQuery("ConfirmUserTOTP"), mock.Anything)
+}
+
+func TestNormalizeRecoveryCode(t *testing.T) {
+	assert.Equal(t, "abcdefgh", normalizeRecoveryCode(" ABCD-efgh "))
+}
@@ -0,0 +1,212 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"main/db"
+	"main/dto"
+	"net/http"
+	"net/url"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"go.uber.org/zap"
+)
+
+type CreateOAuthClientRequest struct {
+	Name string `json:"name" binding:"required,max=255"`
+	// RedirectURIs are where users may be sent back to with a code, they are matched exactly
+	RedirectURIs []string `json:"redirect_uris"`
+	// GrantTypes are authorization_code and client_credentials
+	GrantTypes []string `json:"grant_types" binding:"required,min=1"`
+	// Scopes are openid, profile, email and permission names such as users:read
+	Scopes []string `json:"scopes"`
+	// Public apps, such as single page or mobile apps, cannot keep a secret and get none
+	Public bool `json:"public"`
+	// ServiceUserID is the service account client_credentials tokens act as
+	ServiceUserID *int32 `json:"service_user_id"`
+}
+
+// CreateOAuthClient godoc
+// @Summary Register an app
+// @Description Register an app that logs its users in through this service. The client secret is only shown in this response.
+// @Tags oauth
+// @Accept json
+// @Produce json
+// @Param client body CreateOAuthClientRequest true "App"
+// @Success 201 {object} gin.H "Client Secret and the App"
+// @Failure 400 {object} gin.H "Bad Request"
+// @Failure 401 {object} gin.H "Unauthorized"
+// @Failure 403 {object} gin.H "Forbidden"
+// @Failure 500 {object} gin.H "Internal Server Error"
+// @Router /admin/oauth/clients [post]
+func (uc *UserController) CreateOAuthClient(c *gin.Context) {
+	userRequests.WithLabelValues("POST", "/admin/oauth/clients").Inc()
+	var req CreateOAuthClientRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	for _, grantType := range req.GrantTypes {
+		if grantType != grantAuthorizationCode && grantType != grantClientCredentials {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported grant type " + grantType})
+			return
+		}
+	}
+	if slices.Contains(req.GrantTypes, grantAuthorizationCode) && len(req.RedirectURIs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "the authorization_code grant needs at least one redirect uri"})
+		return
+	}
+	for _, redirectURI := range req.RedirectURIs {
+		parsed, err := url.Parse(redirectURI)
+		if err != nil || parsed.Scheme == "" || parsed.Host == "" || parsed.Fragment != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "redirect uris must be absolute and without a fragment", "redirect_uri": redirectURI})
+			return
+		}
+	}
+
+	known, err := uc.Queries.GetPermissionNames(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	known = append(known, identityScopes...)
+	for _, scope := range req.Scopes {
+		if !slices.Contains(known, scope) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unknown scope " + scope, "scopes": known})
+			return
+		}
+	}
+
+	var serviceUserID pgtype.Int4
+	if slices.Contains(req.GrantTypes, grantClientCredentials) {
+		if req.Public || req.ServiceUserID == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "the client_credentials grant needs a confidential app with a service account"})
+			return
+		}
+		roles, err := uc.Queries.GetUserRoles(c.Request.Context(), *req.ServiceUserID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if !slices.Contains(roles, roleServiceAccount) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "service_user_id must be a user with the service_account role"})
+			return
+		}
+		serviceUserID = pgtype.Int4{Int32: *req.ServiceUserID, Valid: true}
+	}
+
+	clientID, err := randomToken(16)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	var secret string
+	var secretHash pgtype.Text
+	if !req.Public {
+		secret, err = randomToken(32)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		secretHash = pgtype.Text{String: hashToken(secret), Valid: true}
+	}
+
+	client, err := uc.Queries.CreateOAuthClient(c.Request.Context(), db.CreateOAuthClientParams{
+		ID:            clientID,
+		Name:          req.Name,
+		SecretHash:    secretHash,
+		RedirectUris:  dedupe(req.RedirectURIs),
+		GrantTypes:    dedupe(req.GrantTypes),
+		Scopes:        dedupe(req.Scopes),
+		ServiceUserID: serviceUserID,
+	})
+	if err != nil {
+		uc.Logger.Error("Failed to register OAuth client", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to register the app"})
+		return
+	}
+
+	response := gin.H{"client": dto.NewOAuthClient(client)}
+	if secret != "" {
+		response["message"] = "Store the client secret now, it cannot be shown again"
+		response["client_secret"] = secret
+	}
+	c.JSON(http.StatusCreated, response)
+}
+
+// ListOAuthClients godoc
+// @Summary List apps
+// @Description List the apps that log their users in through this service
+// @Tags oauth
+// @Produce json
+// @Success 200 {array} dto.OAuthClient "Apps"
+// @Failure 401 {object} gin.H "Unauthorized"
+// @Failure 403 {object} gin.H "Forbidden"
+// @Failure 500 {object} gin.H "Internal Server Error"
+// @Router /admin/oauth/clients [get]
+func (uc *UserController) ListOAuthClients(c *gin.Context) {
+	userRequests.WithLabelValues("GET", "/admin/oauth/clients").Inc()
+	clients, err := uc.Queries.ListOAuthClients(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.NewOAuthClients(clients))
+}
+
+// DeleteOAuthClient godoc
+// @Summary Remove an app
+// @Description Remove an app. Its users' consents go with it and it cannot get new tokens.
+// @Tags oauth
+// @Produce json
+// @Param clientId path string true "Client ID"
+// @Success 200 {object} gin.H "Message"
+// @Failure 401 {object} gin.H "Unauthorized"
+// @Failure 403 {object} gin.H "Forbidden"
+// @Failure 404 {object} gin.H "Not Found"
+// @Failure 500 {object} gin.H "Internal Server Error"
+// @Router /admin/oauth/clients/{clientId} [delete]
+func (uc *UserController) DeleteOAuthClient(c *gin.Context) {
+	userRequests.WithLabelValues("DELETE", "/admin/oauth/clients/:clientId").Inc()
+	rows, err := uc.Queries.DeleteOAuthClient(c.Request.Context(), c.Param("clientId"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if rows == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "app not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "App removed"})
+}
+
+// oauthClient returns the registered app with the id, or nil when there is none.
+func (uc *UserController) oauthClient(ctx context.Context, clientID string) (*db.OauthClient, error) {
+	if clientID == "" {
+		return nil, nil
+	}
+	client, err := uc.Queries.GetOAuthClient(ctx, clientID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &client, nil
+}
+
+func dedupe(values []string) []string {
+	unique := make([]string, 0, len(values))
+	for _, value := range values {
+		if !slices.Contains(unique, value) {
+			unique = append(unique, value)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,210 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"main/auth"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+)
+
+// inactiveToken is the whole answer about a token that is unknown, expired,
+// revoked or of a restricted account, introspection does not tell which.
+var inactiveToken = gin.H{"active": false}
+
+// introspectAPIKey describes an API key the way AuthMiddleware would accept it.
+func (uc *UserController) introspectAPIKey(ctx context.Context, key string) (gin.H, error) {
+	token, err := uc.Queries.GetActiveAPIToken(ctx, auth.HashAPIKey(key))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return inactiveToken, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if auth.Restricted(auth.CurrentStatus(token.Status, token.StatusExpiresAt.Time, token.EmailVerifiedAt.Valid)) {
+		return inactiveToken, nil
+	}
+
+	roles, err := uc.Queries.GetUserRoles(ctx, token.UserID)
+	if err != nil {
+		return nil, err
+	}
+	return gin.H{
+		"active":         true,
+		"token_type":     "api_key",
+		"sub":            strconv.Itoa(int(token.UserID)),
+		"username":       token.Username,
+		"roles":          roles,
+		"scope":          strings.Join(token.Scopes, " "),
+		"email_verified": token.EmailVerifiedAt.Valid,
+	}, nil
+}
+
+// introspectJWT describes an access token the way AuthMiddleware would accept it,
+// along with the login session it belongs to.
+func (uc *UserController) introspectJWT(c *gin.Context, tokenString string) (gin.H, error) {
+	ctx := c.Request.Context()
+	claims, err := auth.ParseJWT(tokenString)
+	if err != nil || claims.Purpose != "" || claims.Audience != "" {
+		return inactiveToken, nil
+	}
+	revoked, err := auth.IsRevoked(ctx, uc.RedisClient, claims)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return inactiveToken, nil
+	}
+	// Tokens of deleted and restricted users are no longer active, even where revoking
+	// them failed. The user is loaded anyway, so their status is read from the database
+	userID, ok := claims.UserID()
+	if !ok {
+		return inactiveToken, nil
+	}
+	user, err := uc.Queries.GetUser(ctx, userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return inactiveToken, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if auth.Restricted(auth.CurrentStatus(user.Status, user.StatusExpiresAt.Time, user.EmailVerifiedAt.Valid)) {
+		return inactiveToken, nil
+	}
+
+	info := gin.H{
+		"active":         true,
+		"token_type":     "access_token",
+		"sub":            claims.Subject,
+		"username":       claims.Username,
+		"roles":          claims.Roles,
+		"email_verified": claims.EmailVerified,
+		"exp":            claims.ExpiresAt,
+		"iat":            claims.IssuedAt,
+		"jti":            claims.Id,
+	}
+	if issuer, ok := oauthIssuer(); ok {
+		info["iss"] = issuer
+	}
+	if claims.Actor != nil {
+		info["act"] = claims.Actor
+	}
+	// Tokens of the service's own logins may do everything their user may, only app tokens carry a scope
+	if claims.ClientID != "" {
+		info["client_id"] = claims.ClientID
+		info["scope"] = claims.Scope
+	}
+
+	if claims.SessionID != "" {
+		session, err := uc.Queries.GetSession(ctx, claims.SessionID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return inactiveToken, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if session.RevokedAt.Valid || session.ExpiresAt.Time.Before(time.Now()) {
+			return inactiveToken, nil
+		}
+		info["sid"] = session.ID
+		info["auth_time"] = session.CreatedAt.Time.Unix()
+		info["session_last_seen_at"] = session.LastSeenAt.Time.Unix()
+		info["session_expires_at"] = session.ExpiresAt.Time.Unix()
+	}
+	return info, nil
+}
+
+// OAuthIntrospect godoc
+// @Summary Introspect a token
+// @Description Tell a confidential app whether an access token or API key is active, and who it acts as with which scopes, so services receiving them do not have to validate tokens themselves. Logged out tokens and tokens of ended sessions are inactive.
+// @Tags oauth
+// @Accept x-www-form-urlencoded
+// @Produce json
+// @Param token formData string true "Access token or API key"
+// @Param token_type_hint formData string false "access_token or api_key"
+// @Success 200 {object} gin.H "Token information"
+// @Failure 400 {object} gin.H "Bad Request"
+// @Failure 401 {object} gin.H "Unauthorized"
+// @Failure 429 {object} gin.H "Too Many Requests"
+// @Failure 503 {object} gin.H "Service Unavailable"
+// @Router /oauth/introspect [post]
+func (uc *UserController) OAuthIntrospect(c *gin.Context) {
+	userRequests.WithLabelValues("POST", "/oauth/introspect").Inc()
+	c.Header("Cache-Control", "no-store")
+
+	client, ok := uc.authenticateOAuthClient(c)
+	if !ok {
+		return
+	}
+	if !client.SecretHash.Valid {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_client", "error_description": "public apps cannot introspect tokens"})
+		return
+	}
+
+	token := c.PostForm("token")
+	if token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request", "error_description": "token is required"})
+		return
+	}
+
+	var info gin.H
+	var err error
+	if auth.IsAPIKey(token) {
+		info, err = uc.introspectAPIKey(c.Request.Context(), token)
+	} else {
+		info, err = uc.introspectJWT(c, token)
+	}
+	if err != nil {
+		uc.Logger.Error("Failed to introspect token", zap.Error(err))
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "temporarily_unavailable"})
+		return
+	}
+	c.JSON(http.StatusOK, info)
+}
+
+// OAuthRevoke godoc
+// @Summary Revoke a token
+// @Description Revoke an access token the app was issued, for example when its user logs out of the app. Unknown tokens and tokens of other apps are left alone, and the answer is the same for them.
+// @Tags oauth
+// @Accept x-www-form-urlencoded
+// @Produce json
+// @Param token formData string true "Access token"
+// @Param token_type_hint formData string false "access_token"
+// @Success 200 "Revoked"
+// @Failure 400 {object} gin.H "Bad Request"
+// @Failure 401 {object} gin.H "Unauthorized"
+// @Failure 429 {object} gin.H "Too Many Requests"
+// @Failure 503 {object} gin.H "Service Unavailable"
+// @Router /oauth/revoke [post]
+func (uc *UserController) OAuthRevoke(c *gin.Context) {
+	userRequests.WithLabelValues("POST", "/oauth/revoke").Inc()
+	client, ok := uc.authenticateOAuthClient(c)
+	if !ok {
+		return
+	}
+
+	token := c.PostForm("token")
+	if token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request", "error_description": "token is required"})
+		return
+	}
+
+	// Apps can only give up their own tokens, the user's sessions and API keys are not theirs to end
+	claims, err := auth.ParseJWT(token)
+	if err != nil || claims.ClientID != client.ID || claims.Purpose != "" || claims.Audience != "" {
+		c.Status(http.StatusOK)
+		return
+	}
+	if err := auth.RevokeToken(c.Request.Context(), uc.RedisClient, claims); err != nil {
+		uc.Logger.Error("Failed to revoke app token", zap.String("client_id", client.ID), zap.Error(err))
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "temporarily_unavailable"})
+		return
+	}
+	c.Status(http.StatusOK)
+}
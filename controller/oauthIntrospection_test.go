@@ -0,0 +1,123 @@
+package controller
+
+import (
+	"main/auth"
+	"main/config"
+	"main/db"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestOAuthIntrospectAndRevoke(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	previous := config.AppConfig.JWT
+	t.Cleanup(func() { config.AppConfig.JWT = previous })
+	config.AppConfig.JWT.Algorithm = "HS256"
+	config.AppConfig.JWT.Key = "test-key"
+
+	loggedIn := time.Now().Add(-time.Hour)
+	mockDB := new(MockDBTX)
+	mockDB.On("QueryRow", mock.Anything, isQuery("GetOAuthClient"), mock.Anything).Return(oauthClientRow())
+	mockDB.On("QueryRow", mock.Anything, isQuery("GetSession"), []interface{}{"s1"}).Return(scanRow(10, nil, func(args mock.Arguments) {
+		*args.Get(0).(*string) = "s1"
+		*args.Get(1).(*int32) = 1
+		*args.Get(6).(*pgtype.Timestamp) = pgtype.Timestamp{Time: loggedIn, Valid: true}
+		*args.Get(7).(*pgtype.Timestamp) = pgtype.Timestamp{Time: time.Now(), Valid: true}
+		*args.Get(8).(*pgtype.Timestamp) = pgtype.Timestamp{Time: time.Now().Add(time.Hour), Valid: true}
+	}))
+	mockDB.On("QueryRow", mock.Anything, isQuery("GetUser"), []interface{}{int32(1)}).Return(userRow(true))
+	mockDB.On("QueryRow", mock.Anything, isQuery("GetUser"), []interface{}{int32(2)}).Return(scanRow(userColumnCount, pgx.ErrNoRows, nil))
+	mockDB.On("QueryRow", mock.Anything, isQuery("GetUser"), []interface{}{int32(5)}).Return(statusRow(auth.StatusLocked, true, time.Time{}))
+	mockAPITokenLookup(mockDB, auth.APIKeyPrefix+"key", []string{"users:read"})
+	mockDB.On("QueryRow", mock.Anything, isQuery("GetActiveAPIToken"), mock.Anything).Return(mockNoRows())
+	mockDB.On("Query", mock.Anything, isQuery("GetUserRoles"), mock.Anything).Return(NewMockRows(nil), nil)
+
+	logger, _ := zap.NewDevelopment()
+	redisClient := newTestRedis(t)
+	uc := NewUserController(db.New(mockDB), redisClient, logger, true)
+	router := gin.New()
+	router.POST("/oauth/introspect", uc.OAuthIntrospect)
+	router.POST("/oauth/revoke", uc.OAuthRevoke)
+
+	post := func(path string, token string, secret string) (int, map[string]interface{}) {
+		req := tokenRequest(url.Values{"token": {token}}, secret)
+		req.URL.Path = path
+		return oauthRequest(router, req)
+	}
+
+	sessionClaims := &auth.Claims{Username: "tester", Roles: []string{"admin"}, SessionID: "s1", StandardClaims: jwt.StandardClaims{Subject: "1"}}
+	sessionToken, err := auth.GenerateJWT(sessionClaims)
+	require.NoError(t, err)
+	appToken, err := uc.appTokens(t.Context(), db.User{ID: 1, Username: "tester"}, "app", []string{"users:read"})
+	require.NoError(t, err)
+
+	code, response := post("/oauth/introspect", sessionToken, "wrong")
+	assert.Equal(t, http.StatusUnauthorized, code)
+	assert.Equal(t, "invalid_client", response["error"])
+
+	code, response = post("/oauth/introspect", sessionToken, "secret")
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, true, response["active"])
+	assert.Equal(t, "1", response["sub"])
+	assert.Equal(t, "s1", response["sid"])
+	assert.Equal(t, float64(loggedIn.Unix()), response["auth_time"])
+	assert.Equal(t, []interface{}{"admin"}, response["roles"])
+	assert.Nil(t, response["scope"])
+
+	code, response = post("/oauth/introspect", appToken["access_token"].(string), "secret")
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, true, response["active"])
+	assert.Equal(t, "app", response["client_id"])
+	assert.Equal(t, "users:read", response["scope"])
+
+	code, response = post("/oauth/introspect", auth.APIKeyPrefix+"key", "secret")
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "api_key", response["token_type"])
+	assert.Equal(t, "users:read", response["scope"])
+
+	for _, token := range []string{"garbage", auth.APIKeyPrefix + "revoked"} {
+		code, response = post("/oauth/introspect", token, "secret")
+		require.Equal(t, http.StatusOK, code)
+		assert.Equal(t, map[string]interface{}{"active": false}, response)
+	}
+
+	// the user was deleted since the token was issued
+	deletedToken, err := auth.GenerateJWT(&auth.Claims{Username: "gone", StandardClaims: jwt.StandardClaims{Subject: "2"}})
+	require.NoError(t, err)
+	_, response = post("/oauth/introspect", deletedToken, "secret")
+	assert.Equal(t, map[string]interface{}{"active": false}, response)
+
+	// the account was locked, whether or not Redis still remembers it
+	lockedToken, err := auth.GenerateJWT(&auth.Claims{Username: "locked", StandardClaims: jwt.StandardClaims{Subject: "5"}})
+	require.NoError(t, err)
+	_, response = post("/oauth/introspect", lockedToken, "secret")
+	assert.Equal(t, map[string]interface{}{"active": false}, response)
+
+	// the app can give up its own token but cannot end the user's session
+	code, _ = post("/oauth/revoke", sessionToken, "secret")
+	assert.Equal(t, http.StatusOK, code)
+	_, response = post("/oauth/introspect", sessionToken, "secret")
+	assert.Equal(t, true, response["active"])
+
+	code, _ = post("/oauth/revoke", appToken["access_token"].(string), "secret")
+	assert.Equal(t, http.StatusOK, code)
+	_, response = post("/oauth/introspect", appToken["access_token"].(string), "secret")
+	assert.Equal(t, false, response["active"])
+
+	// logging out is respected
+	require.NoError(t, auth.RevokeSession(t.Context(), redisClient, "s1"))
+	_, response = post("/oauth/introspect", sessionToken, "secret")
+	assert.Equal(t, false, response["active"])
+}
@@ -0,0 +1,624 @@
+package controller
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"main/auth"
+	"main/config"
+	"main/db"
+	"main/oidc"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+const (
+	grantAuthorizationCode = "authorization_code"
+	grantClientCredentials = "client_credentials"
+
+	scopeOpenID  = "openid"
+	scopeProfile = "profile"
+	scopeEmail   = "email"
+
+	defaultOAuthCodeTTL = time.Minute
+	defaultIDTokenTTL   = time.Hour
+
+	oauthCodeKeyPrefix = "oauth_code:"
+)
+
+// identityScopes ask for who the user is rather than for access to the API
+var identityScopes = []string{scopeOpenID, scopeProfile, scopeEmail}
+
+// AuthorizeRequest is the authorization request an app sent the user with.
+// The consent page passes it on as it is.
+type AuthorizeRequest struct {
+	ResponseType        string `form:"response_type" json:"response_type"`
+	ClientID            string `form:"client_id" json:"client_id"`
+	RedirectURI         string `form:"redirect_uri" json:"redirect_uri"`
+	Scope               string `form:"scope" json:"scope"`
+	State               string `form:"state" json:"state"`
+	Nonce               string `form:"nonce" json:"nonce"`
+	CodeChallenge       string `form:"code_challenge" json:"code_challenge"`
+	CodeChallengeMethod string `form:"code_challenge_method" json:"code_challenge_method"`
+}
+
+// AuthorizeDecision is the user's answer on the consent page.
+type AuthorizeDecision struct {
+	AuthorizeRequest
+	Approve bool `json:"approve"`
+}
+
+// authorizationCode is what a code stands for until the app trades it for tokens.
+type authorizationCode struct {
+	ClientID      string   `json:"client_id"`
+	RedirectURI   string   `json:"redirect_uri"`
+	UserID        int32    `json:"user_id"`
+	Scopes        []string `json:"scopes"`
+	Nonce         string   `json:"nonce"`
+	CodeChallenge string   `json:"code_challenge"`
+}
+
+// authorization is an authorization request that checked out.
+type authorization struct {
+	client      *db.OauthClient
+	redirectURI string
+	scopes      []string
+}
+
+// oauthIssuer is the issuer of id tokens, the public URL of the service. It only
+// comes from the configuration, the request could name any host.
+func oauthIssuer() (string, bool) {
+	issuer := strings.TrimSuffix(config.AppConfig.OAuth.Issuer, "/")
+	return issuer, issuer != ""
+}
+
+// redirectWith adds the parameters to the query of an app's redirect uri.
+func redirectWith(redirectURI string, params url.Values) string {
+	target, _ := url.Parse(redirectURI)
+	query := target.Query()
+	for name, values := range params {
+		for _, value := range values {
+			if value != "" {
+				query.Add(name, value)
+			}
+		}
+	}
+	target.RawQuery = query.Encode()
+	return target.String()
+}
+
+// checkAuthorizeRequest answers the request itself when the authorization request
+// is not valid. Errors about the app or its redirect uri are never sent to the
+// redirect uri, the others carry where to send the user back to with the error.
+func (uc *UserController) checkAuthorizeRequest(c *gin.Context, req AuthorizeRequest) (authorization, bool) {
+	client, err := uc.oauthClient(c.Request.Context(), req.ClientID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return authorization{}, false
+	}
+	if client == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_client", "error_description": "unknown app"})
+		return authorization{}, false
+	}
+
+	redirectURI := req.RedirectURI
+	if redirectURI == "" && len(client.RedirectUris) == 1 {
+		redirectURI = client.RedirectUris[0]
+	}
+	if !slices.Contains(client.RedirectUris, redirectURI) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request", "error_description": "redirect_uri is not registered for the app"})
+		return authorization{}, false
+	}
+
+	fail := func(code string, description string) (authorization, bool) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":             code,
+			"error_description": description,
+			"redirect_to":       redirectWith(redirectURI, url.Values{"error": {code}, "error_description": {description}, "state": {req.State}}),
+		})
+		return authorization{}, false
+	}
+
+	if req.ResponseType != "code" {
+		return fail("unsupported_response_type", "only the code response type is supported")
+	}
+	if !slices.Contains(client.GrantTypes, grantAuthorizationCode) {
+		return fail("unauthorized_client", "the app may not use the authorization code grant")
+	}
+	scopes := dedupe(strings.Fields(req.Scope))
+	if len(scopes) == 0 {
+		return fail("invalid_scope", "scope is required")
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(client.Scopes, scope) {
+			return fail("invalid_scope", "the app may not ask for "+scope)
+		}
+	}
+	if req.CodeChallenge == "" || req.CodeChallengeMethod != "S256" {
+		return fail("invalid_request", "PKCE with the S256 method is required")
+	}
+
+	return authorization{client: client, redirectURI: redirectURI, scopes: scopes}, true
+}
+
+// issueAuthorizationCode returns where to send the user back to with a new code.
+func (uc *UserController) issueAuthorizationCode(ctx context.Context, userID int32, req AuthorizeRequest, authz authorization) (string, error) {
+	code, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	value, err := json.Marshal(authorizationCode{
+		ClientID:      authz.client.ID,
+		RedirectURI:   authz.redirectURI,
+		UserID:        userID,
+		Scopes:        authz.scopes,
+		Nonce:         req.Nonce,
+		CodeChallenge: req.CodeChallenge,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	ttl := durationOr(config.AppConfig.OAuth.CodeTTL, defaultOAuthCodeTTL)
+	if err := uc.RedisClient.Set(ctx, oauthCodeKeyPrefix+hashToken(code), value, ttl).Err(); err != nil {
+		return "", err
+	}
+	return redirectWith(authz.redirectURI, url.Values{"code": {code}, "state": {req.State}}), nil
+}
+
+// takeAuthorizationCode returns what the code stands for and forgets it, codes
+// are only good once. It returns nil for unknown codes.
+func (uc *UserController) takeAuthorizationCode(ctx context.Context, code string) (*authorizationCode, error) {
+	if code == "" {
+		return nil, nil
+	}
+	value, err := uc.RedisClient.GetDel(ctx, oauthCodeKeyPrefix+hashToken(code)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var granted authorizationCode
+	if err := json.Unmarshal(value, &granted); err != nil {
+		return nil, err
+	}
+	return &granted, nil
+}
+
+// consentedScopes returns the scopes the user already allowed the app to use.
+func (uc *UserController) consentedScopes(ctx context.Context, userID int32, clientID string) ([]string, error) {
+	scopes, err := uc.Queries.GetOAuthConsent(ctx, db.GetOAuthConsentParams{UserID: userID, ClientID: clientID})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	return scopes, err
+}
+
+// OAuthAuthorize godoc
+// @Summary Check an authorization request
+// @Description Called by the consent page with the logged in user's token and the app's authorization request. When the user already allowed everything the app asks for, the answer says where to send them back to with a code. Otherwise it describes what to ask the user.
+// @Tags oauth
+// @Produce json
+// @Param client_id query string true "Client ID"
+// @Param redirect_uri query string false "Redirect URI"
+// @Param response_type query string true "code"
+// @Param scope query string true "Scopes"
+// @Param state query string false "State"
+// @Param nonce query string false "Nonce"
+// @Param code_challenge query string true "PKCE challenge"
+// @Param code_challenge_method query string true "S256"
+// @Success 200 {object} gin.H "Consent or where to send the user"
+// @Failure 400 {object} gin.H "Bad Request"
+// @Failure 401 {object} gin.H "Unauthorized"
+// @Failure 500 {object} gin.H "Internal Server Error"
+// @Router /oauth/authorize [get]
+func (uc *UserController) OAuthAuthorize(c *gin.Context) {
+	userRequests.WithLabelValues("GET", "/oauth/authorize").Inc()
+	userID, ok := currentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var req AuthorizeRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	authz, ok := uc.checkAuthorizeRequest(c, req)
+	if !ok {
+		return
+	}
+
+	consented, err := uc.consentedScopes(c.Request.Context(), userID, authz.client.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	for _, scope := range authz.scopes {
+		if !slices.Contains(consented, scope) {
+			c.JSON(http.StatusOK, gin.H{
+				"consent_required": true,
+				"client":           gin.H{"client_id": authz.client.ID, "name": authz.client.Name},
+				"scopes":           authz.scopes,
+			})
+			return
+		}
+	}
+
+	redirectTo, err := uc.issueAuthorizationCode(c.Request.Context(), userID, req, authz)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"consent_required": false, "redirect_to": redirectTo})
+}
+
+// OAuthConsent godoc
+// @Summary Answer an authorization request
+// @Description Called by the consent page with the user's answer. The answer says where to send the user back to, with a code when they approved and an access_denied error when they did not.
+// @Tags oauth
+// @Accept json
+// @Produce json
+// @Param decision body AuthorizeDecision true "Authorization request and the answer"
+// @Success 200 {object} gin.H "Where to send the user"
+// @Failure 400 {object} gin.H "Bad Request"
+// @Failure 401 {object} gin.H "Unauthorized"
+// @Failure 500 {object} gin.H "Internal Server Error"
+// @Router /oauth/authorize [post]
+func (uc *UserController) OAuthConsent(c *gin.Context) {
+	userRequests.WithLabelValues("POST", "/oauth/authorize").Inc()
+	userID, ok := currentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var decision AuthorizeDecision
+	if err := c.ShouldBindJSON(&decision); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	authz, ok := uc.checkAuthorizeRequest(c, decision.AuthorizeRequest)
+	if !ok {
+		return
+	}
+
+	if !decision.Approve {
+		c.JSON(http.StatusOK, gin.H{"redirect_to": redirectWith(authz.redirectURI, url.Values{
+			"error":             {"access_denied"},
+			"error_description": {"the user did not allow the app"},
+			"state":             {decision.State},
+		})})
+		return
+	}
+
+	consented, err := uc.consentedScopes(c.Request.Context(), userID, authz.client.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	err = uc.Queries.SaveOAuthConsent(c.Request.Context(), db.SaveOAuthConsentParams{
+		UserID:   userID,
+		ClientID: authz.client.ID,
+		Scopes:   dedupe(append(consented, authz.scopes...)),
+	})
+	if err != nil {
+		uc.Logger.Error("Failed to save consent", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save consent"})
+		return
+	}
+
+	redirectTo, err := uc.issueAuthorizationCode(c.Request.Context(), userID, decision.AuthorizeRequest, authz)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"redirect_to": redirectTo})
+}
+
+// authenticateOAuthClient returns the app calling the token endpoint. Apps with
+// a secret send it with HTTP basic authentication or in the form, public apps
+// only send their id.
+func (uc *UserController) authenticateOAuthClient(c *gin.Context) (*db.OauthClient, bool) {
+	clientID, secret, basic := c.Request.BasicAuth()
+	if basic {
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID, secret = c.PostForm("client_id"), c.PostForm("client_secret")
+	}
+
+	client, err := uc.oauthClient(c.Request.Context(), clientID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return nil, false
+	}
+
+	valid := client != nil
+	if valid && client.SecretHash.Valid {
+		valid = subtle.ConstantTimeCompare([]byte(hashToken(secret)), []byte(client.SecretHash.String)) == 1
+	} else if valid {
+		valid = secret == ""
+	}
+	if !valid {
+		if basic {
+			c.Header("WWW-Authenticate", `Basic realm="oauth"`)
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_client", "error_description": "unknown app or wrong secret"})
+		return nil, false
+	}
+	return client, true
+}
+
+// OAuthToken godoc
+// @Summary Issue tokens to an app
+// @Description Trade an authorization code and its PKCE verifier for an access token, and an id token when the openid scope was granted. Confidential apps with a service account can also get an access token for it with the client_credentials grant.
+// @Tags oauth
+// @Accept x-www-form-urlencoded
+// @Produce json
+// @Param grant_type formData string true "authorization_code or client_credentials"
+// @Param code formData string false "Authorization code"
+// @Param redirect_uri formData string false "Redirect URI the code was sent to"
+// @Param code_verifier formData string false "PKCE verifier"
+// @Param scope formData string false "Scopes for client_credentials"
+// @Success 200 {object} gin.H "Tokens"
+// @Failure 400 {object} gin.H "Bad Request"
+// @Failure 401 {object} gin.H "Unauthorized"
+// @Failure 500 {object} gin.H "Internal Server Error"
+// @Router /oauth/token [post]
+func (uc *UserController) OAuthToken(c *gin.Context) {
+	userRequests.WithLabelValues("POST", "/oauth/token").Inc()
+	c.Header("Cache-Control", "no-store")
+	c.Header("Pragma", "no-cache")
+
+	client, ok := uc.authenticateOAuthClient(c)
+	if !ok {
+		return
+	}
+
+	grantType := c.PostForm("grant_type")
+	if grantType != grantAuthorizationCode && grantType != grantClientCredentials {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported_grant_type"})
+		return
+	}
+	if !slices.Contains(client.GrantTypes, grantType) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unauthorized_client", "error_description": "the app may not use the " + grantType + " grant"})
+		return
+	}
+
+	if grantType == grantClientCredentials {
+		uc.clientCredentialsGrant(c, client)
+		return
+	}
+
+	granted, err := uc.takeAuthorizationCode(c.Request.Context(), c.PostForm("code"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
+	}
+	if granted == nil || granted.ClientID != client.ID || granted.RedirectURI != c.PostForm("redirect_uri") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_grant", "error_description": "code is invalid, expired or was issued to another app"})
+		return
+	}
+	challenge := oidc.CodeChallenge(c.PostForm("code_verifier"))
+	if subtle.ConstantTimeCompare([]byte(challenge), []byte(granted.CodeChallenge)) != 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_grant", "error_description": "code_verifier does not match the code challenge"})
+		return
+	}
+
+	user, err := uc.Queries.GetUser(c.Request.Context(), granted.UserID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_grant", "error_description": "the user no longer exists"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
+	}
+	if status := accountStatus(user); auth.Restricted(status) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_grant", "error_description": "the user's account is " + status})
+		return
+	}
+
+	response, err := uc.appTokens(c.Request.Context(), user, client.ID, granted.Scopes)
+	if err != nil {
+		uc.Logger.Error("Failed to issue app tokens", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
+	}
+
+	if slices.Contains(granted.Scopes, scopeOpenID) {
+		issuer, ok := oauthIssuer()
+		if !ok {
+			uc.Logger.Error("Cannot issue id tokens, oauth.issuer is not set")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+			return
+		}
+		verified := user.EmailVerifiedAt.Valid
+		idToken := &auth.IDToken{
+			Nonce: granted.Nonce,
+			StandardClaims: jwt.StandardClaims{
+				Issuer:   issuer,
+				Subject:  strconv.Itoa(int(user.ID)),
+				Audience: client.ID,
+			},
+		}
+		if slices.Contains(granted.Scopes, scopeEmail) {
+			idToken.Email = user.Email
+			idToken.EmailVerified = &verified
+		}
+		if slices.Contains(granted.Scopes, scopeProfile) {
+			idToken.PreferredUsername = user.Username
+		}
+		signed, err := auth.GenerateIDToken(idToken, durationOr(config.AppConfig.OAuth.IDTokenTTL, defaultIDTokenTTL))
+		if err != nil {
+			uc.Logger.Error("Failed to sign id token", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+			return
+		}
+		response["id_token"] = signed
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// clientCredentialsGrant issues an access token for the app's service account.
+func (uc *UserController) clientCredentialsGrant(c *gin.Context, client *db.OauthClient) {
+	if !client.SecretHash.Valid || !client.ServiceUserID.Valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unauthorized_client", "error_description": "the app has no service account"})
+		return
+	}
+
+	scopes := dedupe(strings.Fields(c.PostForm("scope")))
+	if len(scopes) == 0 {
+		for _, scope := range client.Scopes {
+			if !slices.Contains(identityScopes, scope) {
+				scopes = append(scopes, scope)
+			}
+		}
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(client.Scopes, scope) || slices.Contains(identityScopes, scope) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_scope", "error_description": "the app may not ask for " + scope})
+			return
+		}
+	}
+
+	user, err := uc.Queries.GetUser(c.Request.Context(), client.ServiceUserID.Int32)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
+	}
+	if status := accountStatus(user); auth.Restricted(status) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unauthorized_client", "error_description": "the app's service account is " + status})
+		return
+	}
+
+	response, err := uc.appTokens(c.Request.Context(), user, client.ID, scopes)
+	if err != nil {
+		uc.Logger.Error("Failed to issue app tokens", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// appTokens issues an access token that acts as the user on behalf of an app,
+// limited to the scopes.
+func (uc *UserController) appTokens(ctx context.Context, user db.User, clientID string, scopes []string) (gin.H, error) {
+	claims, err := uc.tokenClaims(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	claims.ClientID = clientID
+	claims.Scope = strings.Join(scopes, " ")
+
+	token, err := auth.GenerateJWT(claims)
+	if err != nil {
+		return nil, err
+	}
+	return gin.H{
+		"access_token": token,
+		"token_type":   "Bearer",
+		"expires_in":   int(auth.AccessTokenTTL().Seconds()),
+		"scope":        claims.Scope,
+	}, nil
+}
+
+// OAuthUserInfo godoc
+// @Summary Who the token is for
+// @Description Describe the user an app's access token acts as, limited to the profile and email scopes it was granted.
+// @Tags oauth
+// @Produce json
+// @Success 200 {object} gin.H "User claims"
+// @Failure 401 {object} gin.H "Unauthorized"
+// @Failure 403 {object} gin.H "Forbidden"
+// @Failure 500 {object} gin.H "Internal Server Error"
+// @Router /oauth/userinfo [get]
+func (uc *UserController) OAuthUserInfo(c *gin.Context) {
+	userRequests.WithLabelValues("GET", "/oauth/userinfo").Inc()
+	userID, ok := currentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	user, err := uc.Queries.GetUser(c.Request.Context(), userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	info := gin.H{"sub": strconv.Itoa(int(user.ID))}
+	if auth.HasScope(c, scopeProfile) {
+		info["preferred_username"] = user.Username
+	}
+	if auth.HasScope(c, scopeEmail) {
+		info["email"] = user.Email
+		info["email_verified"] = user.EmailVerifiedAt.Valid
+	}
+	c.JSON(http.StatusOK, info)
+}
+
+// OpenIDConfiguration godoc
+// @Summary OpenID Connect discovery
+// @Description Describe this service as an OpenID Connect provider
+// @Tags oauth
+// @Produce json
+// @Success 200 {object} gin.H "Provider configuration"
+// @Failure 503 {object} gin.H "Service Unavailable"
+// @Router /.well-known/openid-configuration [get]
+func (uc *UserController) OpenIDConfiguration(c *gin.Context) {
+	userRequests.WithLabelValues("GET", "/.well-known/openid-configuration").Inc()
+	issuer, ok := oauthIssuer()
+	if !ok {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "the OAuth provider is not configured"})
+		return
+	}
+	authorizationEndpoint := config.AppConfig.OAuth.AuthorizationURL
+	if authorizationEndpoint == "" {
+		authorizationEndpoint = issuer + "/oauth/authorize"
+	}
+	algorithm := auth.AlgorithmHS256
+	if key := auth.Keys.Current(); key != nil {
+		algorithm = key.Algorithm
+	}
+
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, gin.H{
+		"issuer":                                issuer,
+		"authorization_endpoint":                authorizationEndpoint,
+		"token_endpoint":                        issuer + "/oauth/token",
+		"userinfo_endpoint":                     issuer + "/oauth/userinfo",
+		"introspection_endpoint":                issuer + "/oauth/introspect",
+		"revocation_endpoint":                   issuer + "/oauth/revoke",
+		"jwks_uri":                              issuer + "/.well-known/jwks.json",
+		"response_types_supported":              []string{"code"},
+		"grant_types_supported":                 []string{grantAuthorizationCode, grantClientCredentials},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{algorithm},
+		"scopes_supported":                      identityScopes,
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post", "none"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"claims_supported":                      []string{"sub", "iss", "aud", "exp", "iat", "nonce", "email", "email_verified", "preferred_username"},
+	})
+}
@@ -0,0 +1,326 @@
+package controller
+
+import (
+	"encoding/json"
+	"main/auth"
+	"main/config"
+	"main/db"
+	middleware "main/middlewares"
+	"main/oidc"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+const testRedirectURI = "https://app.example.com/callback"
+
+// oauthClientRow answers GetOAuthClient with a confidential app whose secret is "secret"
+func oauthClientRow() *MockRow {
+	return scanRow(8, nil, func(args mock.Arguments) {
+		*args.Get(0).(*string) = "app"
+		*args.Get(1).(*string) = "App"
+		*args.Get(2).(*pgtype.Text) = pgtype.Text{String: hashToken("secret"), Valid: true}
+		*args.Get(3).(*[]string) = []string{testRedirectURI}
+		*args.Get(4).(*[]string) = []string{grantAuthorizationCode, grantClientCredentials}
+		*args.Get(5).(*[]string) = []string{scopeOpenID, scopeProfile, scopeEmail, "users:read"}
+		*args.Get(6).(*pgtype.Int4) = pgtype.Int4{Int32: 1, Valid: true}
+	})
+}
+
+func newOAuthRouter(uc *UserController) *gin.Engine {
+	authMiddleware := middleware.AuthMiddleware(uc.RedisClient, uc.Queries)
+	router := gin.New()
+	router.GET("/oauth/authorize", authMiddleware, middleware.RequireSession(), uc.OAuthAuthorize)
+	router.POST("/oauth/authorize", authMiddleware, middleware.RequireSession(), uc.OAuthConsent)
+	router.POST("/oauth/token", uc.OAuthToken)
+	router.GET("/oauth/userinfo", authMiddleware, middleware.RequireScope(scopeOpenID), uc.OAuthUserInfo)
+	return router
+}
+
+func oauthRequest(router *gin.Engine, req *http.Request) (int, map[string]interface{}) {
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var response map[string]interface{}
+	_ = json.Unmarshal(w.Body.Bytes(), &response)
+	return w.Code, response
+}
+
+func tokenRequest(form url.Values, secret string) *http.Request {
+	req, _ := http.NewRequest("POST", "/oauth/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("app", secret)
+	return req
+}
+
+func TestOAuthAuthorizationCode(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	previousJWT, previousOAuth := config.AppConfig.JWT, config.AppConfig.OAuth
+	t.Cleanup(func() {
+		config.AppConfig.JWT = previousJWT
+		config.AppConfig.OAuth = previousOAuth
+	})
+	config.AppConfig.JWT.Algorithm = "HS256"
+	config.AppConfig.JWT.Key = "test-key"
+	config.AppConfig.OAuth.Issuer = "https://id.example.com"
+
+	mockDB := new(MockDBTX)
+	mockSessionWrites(mockDB)
+	mockDB.On("QueryRow", mock.Anything, isQuery("GetOAuthClient"), []interface{}{"app"}).Return(oauthClientRow())
+	mockDB.On("QueryRow", mock.Anything, isQuery("GetOAuthClient"), mock.Anything).Return(scanRow(8, pgx.ErrNoRows, nil))
+	mockDB.On("QueryRow", mock.Anything, isQuery("GetOAuthConsent"), mock.Anything).Return(scanRow(1, pgx.ErrNoRows, nil)).Once()
+	mockDB.On("QueryRow", mock.Anything, isQuery("GetOAuthConsent"), mock.Anything).Return(scanRow(1, pgx.ErrNoRows, nil)).Once()
+	mockDB.On("QueryRow", mock.Anything, isQuery("GetOAuthConsent"), mock.Anything).Return(scanRow(1, nil, func(args mock.Arguments) {
+		*args.Get(0).(*[]string) = []string{scopeOpenID, scopeEmail}
+	}))
+	mockDB.On("Exec", mock.Anything, isQuery("SaveOAuthConsent"), mock.Anything).Return(pgconn.NewCommandTag("INSERT 0 1"), nil)
+	mockDB.On("QueryRow", mock.Anything, isQuery("GetUser"), mock.Anything).Return(userRow(true))
+	mockDB.On("Query", mock.Anything, isQuery("GetUserRoles"), mock.Anything).Return(NewMockRows(nil), nil)
+
+	logger, _ := zap.NewDevelopment()
+	uc := NewUserController(db.New(mockDB), newTestRedis(t), logger, true)
+	router := newOAuthRouter(uc)
+
+	sessionToken, err := auth.GenerateJWT(&auth.Claims{Username: "tester", SessionID: "s1", StandardClaims: jwt.StandardClaims{Subject: "1"}})
+	require.NoError(t, err)
+
+	verifier, err := oidc.NewCodeVerifier()
+	require.NoError(t, err)
+	authorize := AuthorizeRequest{
+		ResponseType:        "code",
+		ClientID:            "app",
+		RedirectURI:         testRedirectURI,
+		Scope:               "openid email",
+		State:               "xyz",
+		Nonce:               "n-1",
+		CodeChallenge:       oidc.CodeChallenge(verifier),
+		CodeChallengeMethod: "S256",
+	}
+	authorizeGet := func(req AuthorizeRequest, token string) (int, map[string]interface{}) {
+		query := url.Values{
+			"response_type": {req.ResponseType}, "client_id": {req.ClientID}, "redirect_uri": {req.RedirectURI},
+			"scope": {req.Scope}, "state": {req.State}, "nonce": {req.Nonce},
+			"code_challenge": {req.CodeChallenge}, "code_challenge_method": {req.CodeChallengeMethod},
+		}
+		httpReq, _ := http.NewRequest("GET", "/oauth/authorize?"+query.Encode(), nil)
+		httpReq.Header.Set("Authorization", "Bearer "+token)
+		return oauthRequest(router, httpReq)
+	}
+	consent := func(decision AuthorizeDecision) (int, map[string]interface{}) {
+		body, _ := json.Marshal(decision)
+		httpReq, _ := http.NewRequest("POST", "/oauth/authorize", strings.NewReader(string(body)))
+		httpReq.Header.Set("Authorization", "Bearer "+sessionToken)
+		httpReq.Header.Set("Content-Type", "application/json")
+		return oauthRequest(router, httpReq)
+	}
+	codeFrom := func(t *testing.T, response map[string]interface{}) string {
+		redirectTo, err := url.Parse(response["redirect_to"].(string))
+		require.NoError(t, err)
+		assert.Equal(t, "xyz", redirectTo.Query().Get("state"))
+		require.NotEmpty(t, redirectTo.Query().Get("code"))
+		return redirectTo.Query().Get("code")
+	}
+	exchange := func(code string, verifier string) (int, map[string]interface{}) {
+		return oauthRequest(router, tokenRequest(url.Values{
+			"grant_type":    {grantAuthorizationCode},
+			"code":          {code},
+			"redirect_uri":  {testRedirectURI},
+			"code_verifier": {verifier},
+		}, "secret"))
+	}
+
+	// requests the app may not make are refused before the user is asked
+	unknownApp := authorize
+	unknownApp.ClientID = "other"
+	code, response := authorizeGet(unknownApp, sessionToken)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "invalid_client", response["error"])
+
+	otherRedirect := authorize
+	otherRedirect.RedirectURI = "https://evil.example.com/callback"
+	code, response = authorizeGet(otherRedirect, sessionToken)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Nil(t, response["redirect_to"])
+
+	noPKCE := authorize
+	noPKCE.CodeChallenge = ""
+	code, response = authorizeGet(noPKCE, sessionToken)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "invalid_request", response["error"])
+	assert.Contains(t, response["redirect_to"], testRedirectURI)
+
+	adminScope := authorize
+	adminScope.Scope = "openid users:delete"
+	code, response = authorizeGet(adminScope, sessionToken)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "invalid_scope", response["error"])
+
+	// the user has not allowed the app yet
+	code, response = authorizeGet(authorize, sessionToken)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, true, response["consent_required"])
+	assert.Equal(t, []interface{}{"openid", "email"}, response["scopes"])
+
+	code, response = consent(AuthorizeDecision{AuthorizeRequest: authorize, Approve: false})
+	require.Equal(t, http.StatusOK, code)
+	assert.Contains(t, response["redirect_to"], "error=access_denied")
+
+	code, response = consent(AuthorizeDecision{AuthorizeRequest: authorize, Approve: true})
+	require.Equal(t, http.StatusOK, code)
+	mockDB.AssertCalled(t, "Exec", mock.Anything, isQuery("SaveOAuthConsent"), []interface{}{int32(1), "app", []string{"openid", "email"}})
+	authCode := codeFrom(t, response)
+
+	code, response = exchange(authCode, verifier)
+	require.Equal(t, http.StatusOK, code, response)
+	assert.Equal(t, "Bearer", response["token_type"])
+	assert.Equal(t, "openid email", response["scope"])
+
+	var idToken auth.IDToken
+	require.NoError(t, auth.Parse(response["id_token"].(string), &idToken))
+	assert.Equal(t, "https://id.example.com", idToken.Issuer)
+	assert.Equal(t, "app", idToken.Audience)
+	assert.Equal(t, "1", idToken.Subject)
+	assert.Equal(t, "n-1", idToken.Nonce)
+	assert.Equal(t, "alice@example.com", idToken.Email)
+	assert.Empty(t, idToken.PreferredUsername)
+
+	// the access token acts as the user within the granted scopes
+	userInfo, _ := http.NewRequest("GET", "/oauth/userinfo", nil)
+	userInfo.Header.Set("Authorization", "Bearer "+response["access_token"].(string))
+	code, response = oauthRequest(router, userInfo)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, map[string]interface{}{"sub": "1", "email": "alice@example.com", "email_verified": true}, response)
+
+	// codes are only good once
+	code, response = exchange(authCode, verifier)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "invalid_grant", response["error"])
+
+	code, response = oauthRequest(router, tokenRequest(url.Values{}, "secret"))
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "unsupported_grant_type", response["error"])
+
+	// the user already allowed the app, so the next login skips the consent screen
+	code, response = authorizeGet(authorize, sessionToken)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, false, response["consent_required"])
+	secondCode := codeFrom(t, response)
+
+	code, response = exchange(secondCode, "wrong-verifier")
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "invalid_grant", response["error"])
+
+	code, response = oauthRequest(router, tokenRequest(url.Values{"grant_type": {grantAuthorizationCode}}, "wrong"))
+	assert.Equal(t, http.StatusUnauthorized, code)
+	assert.Equal(t, "invalid_client", response["error"])
+}
+
+func TestOAuthTokens(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	previous := config.AppConfig.JWT
+	t.Cleanup(func() { config.AppConfig.JWT = previous })
+	config.AppConfig.JWT.Algorithm = "HS256"
+	config.AppConfig.JWT.Key = "test-key"
+
+	mockDB := new(MockDBTX)
+	mockSessionWrites(mockDB)
+	mockDB.On("QueryRow", mock.Anything, isQuery("GetOAuthClient"), mock.Anything).Return(oauthClientRow())
+	mockDB.On("QueryRow", mock.Anything, isQuery("GetUser"), mock.Anything).Return(userRow(true))
+	mockDB.On("Query", mock.Anything, isQuery("GetUserRoles"), mock.Anything).Return(NewMockRows(nil), nil)
+
+	logger, _ := zap.NewDevelopment()
+	uc := NewUserController(db.New(mockDB), newTestRedis(t), logger, true)
+	router := newOAuthRouter(uc)
+
+	bearer := func(method string, path string, token string) (int, map[string]interface{}) {
+		req, _ := http.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		return oauthRequest(router, req)
+	}
+
+	// the app acts as its service account, within its API scopes
+	code, response := oauthRequest(router, tokenRequest(url.Values{"grant_type": {grantClientCredentials}}, "secret"))
+	require.Equal(t, http.StatusOK, code, response)
+	assert.Equal(t, "users:read", response["scope"])
+	assert.Nil(t, response["id_token"])
+
+	claims, err := auth.ParseJWT(response["access_token"].(string))
+	require.NoError(t, err)
+	assert.Equal(t, "app", claims.ClientID)
+	assert.Equal(t, "1", claims.Subject)
+
+	// app tokens cannot stand in for the user on the consent screen, nor ask who the user is without openid
+	code, _ = bearer("GET", "/oauth/authorize", response["access_token"].(string))
+	assert.Equal(t, http.StatusForbidden, code)
+	code, _ = bearer("GET", "/oauth/userinfo", response["access_token"].(string))
+	assert.Equal(t, http.StatusForbidden, code)
+
+	code, response = oauthRequest(router, tokenRequest(url.Values{"grant_type": {grantClientCredentials}, "scope": {"openid"}}, "secret"))
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "invalid_scope", response["error"])
+
+	// userinfo only tells what the scopes allow
+	appToken, err := uc.appTokens(t.Context(), db.User{ID: 1, Username: "tester", Email: "alice@example.com"}, "app", []string{scopeOpenID, scopeProfile})
+	require.NoError(t, err)
+	code, response = bearer("GET", "/oauth/userinfo", appToken["access_token"].(string))
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, map[string]interface{}{"sub": "1", "preferred_username": "tester"}, response)
+
+	// id tokens are for the app, they are not access tokens
+	idToken, err := auth.GenerateIDToken(&auth.IDToken{StandardClaims: jwt.StandardClaims{Subject: "1", Audience: "app"}}, defaultIDTokenTTL)
+	require.NoError(t, err)
+	code, _ = bearer("GET", "/oauth/userinfo", idToken)
+	assert.Equal(t, http.StatusUnauthorized, code)
+}
+
+func TestOpenIDConfiguration(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	previousJWT, previousOAuth := config.AppConfig.JWT, config.AppConfig.OAuth
+	t.Cleanup(func() {
+		config.AppConfig.JWT = previousJWT
+		config.AppConfig.OAuth = previousOAuth
+	})
+	config.AppConfig.JWT.Algorithm = "HS256"
+	config.AppConfig.OAuth.Issuer = "https://id.example.com/"
+	config.AppConfig.OAuth.AuthorizationURL = "https://id.example.com/consent"
+
+	uc := &UserController{}
+	discover := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request, _ = http.NewRequest("GET", "/.well-known/openid-configuration", nil)
+		c.Request.Host = "evil.example"
+		uc.OpenIDConfiguration(c)
+		return w
+	}
+
+	// the issuer is the configured one, never the host the request names
+	w := discover()
+	require.Equal(t, http.StatusOK, w.Code)
+	var discovery map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &discovery))
+	assert.Equal(t, "https://id.example.com", discovery["issuer"])
+	assert.Equal(t, "https://id.example.com/consent", discovery["authorization_endpoint"])
+	assert.Equal(t, "https://id.example.com/oauth/token", discovery["token_endpoint"])
+	assert.Equal(t, "https://id.example.com/.well-known/jwks.json", discovery["jwks_uri"])
+	assert.Equal(t, []interface{}{"S256"}, discovery["code_challenge_methods_supported"])
+
+	config.AppConfig.OAuth.Issuer = ""
+	w = discover()
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
@@ -0,0 +1,369 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"main/auth"
+	"main/config"
+	"main/db"
+	"main/dto"
+	"main/oidc"
+	"main/passwords"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultOIDCStateTTL = 10 * time.Minute
+
+	oidcStateKeyPrefix = "oidc_state:"
+
+	maxUsernameLength = 32
+)
+
+var (
+	errOIDCNoEmail    = errors.New("the identity provider did not share an email address")
+	errOIDCDeleted    = errors.New("the account linked to this login was deleted")
+	errOIDCEmailTaken = errors.New("an account with this email address already exists, log in with its password and verify the address to log in with this provider")
+
+	usernameDisallowed = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+)
+
+// oidcLoginState is what is remembered between sending the user to the
+// provider and the provider sending them back.
+type oidcLoginState struct {
+	Provider string `json:"provider"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+}
+
+// OIDCLogin godoc
+// @Summary Log in with an identity provider
+// @Description Redirect to the OpenID Connect provider to log in there. The provider sends the user back to the callback.
+// @Tags users
+// @Param provider path string true "Provider name"
+// @Success 302 "Redirect to the provider"
+// @Failure 404 {object} gin.H "Not Found"
+// @Failure 502 {object} gin.H "Bad Gateway"
+// @Router /users/oidc/{provider}/login [get]
+func (uc *UserController) OIDCLogin(c *gin.Context) {
+	userRequests.WithLabelValues("GET", "/users/oidc/:provider/login").Inc()
+	provider, ok := uc.oidcProvider(c)
+	if !ok {
+		return
+	}
+
+	login := oidcLoginState{Provider: provider.Name}
+	state, err := oidc.RandomString(32)
+	if err == nil {
+		login.Nonce, err = oidc.RandomString(32)
+	}
+	if err == nil {
+		login.Verifier, err = oidc.NewCodeVerifier()
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	authURL, err := provider.AuthCodeURL(state, login.Nonce, login.Verifier)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	value, _ := json.Marshal(login)
+	ttl := durationOr(config.AppConfig.OIDC.StateTTL, defaultOIDCStateTTL)
+	if err := uc.RedisClient.Set(c.Request.Context(), oidcStateKeyPrefix+state, value, ttl).Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// OIDCCallback godoc
+// @Summary Finish logging in with an identity provider
+// @Description The provider sends the user back here. Known identities log in to their account. A new identity is linked to the account with the same email address when both the provider and the account have verified it, otherwise a new account is created. Accounts with two-factor authentication get an mfa_token like at /users/login.
+// @Tags users
+// @Produce json
+// @Param provider path string true "Provider name"
+// @Param code query string true "Authorization code"
+// @Param state query string true "State"
+// @Success 200 {object} gin.H "Token Pair"
+// @Success 201 {object} gin.H "Token Pair and the new User"
+// @Failure 400 {object} gin.H "Bad Request"
+// @Failure 401 {object} gin.H "Unauthorized"
+// @Failure 403 {object} gin.H "Forbidden"
+// @Failure 404 {object} gin.H "Not Found"
+// @Failure 409 {object} gin.H "Conflict"
+// @Failure 500 {object} gin.H "Internal Server Error"
+// @Failure 502 {object} gin.H "Bad Gateway"
+// @Router /users/oidc/{provider}/callback [get]
+func (uc *UserController) OIDCCallback(c *gin.Context) {
+	userRequests.WithLabelValues("GET", "/users/oidc/:provider/callback").Inc()
+	provider, ok := uc.oidcProvider(c)
+	if !ok {
+		return
+	}
+
+	// The state is used up even when the provider reports an error
+	login, err := uc.takeOIDCState(c.Request.Context(), c.Query("state"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if login == nil || login.Provider != provider.Name {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "login was not started here or took too long, start again"})
+		return
+	}
+	if providerErr := c.Query("error"); providerErr != "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": providerErr, "error_description": c.Query("error_description")})
+		return
+	}
+	if c.Query("code") == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "code is required"})
+		return
+	}
+
+	idToken, err := provider.Exchange(c.Request.Context(), c.Query("code"), login.Verifier, login.Nonce)
+	if err != nil {
+		uc.Logger.Warn("OIDC login failed", zap.String("provider", provider.Name), zap.Error(err))
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "the identity provider did not confirm the login"})
+		return
+	}
+
+	user, created, err := uc.oidcUser(c.Request.Context(), provider.Name, idToken)
+	if errors.Is(err, errOIDCNoEmail) || errors.Is(err, errOIDCDeleted) {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, errOIDCEmailTaken) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		uc.Logger.Error("Failed to find the user of an OIDC login", zap.String("provider", provider.Name), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log in"})
+		return
+	}
+
+	if refuseRestricted(c, user) {
+		return
+	}
+	if !user.EmailVerifiedAt.Valid && auth.EmailPolicy() == auth.EmailPolicyLogin {
+		c.JSON(http.StatusForbidden, gin.H{"error": "email address is not verified"})
+		return
+	}
+
+	mfaEnabled, err := uc.mfaEnabled(c.Request.Context(), user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if mfaEnabled {
+		challenge, err := uc.mfaChallenge(c.Request.Context(), user)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, challenge)
+		return
+	}
+
+	tokens, err := uc.issueTokens(c.Request.Context(), user, deviceOf(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if created {
+		tokens["message"] = "User created successfully"
+		tokens["user"] = dto.NewSelfUser(user)
+		uc.respondWithTokens(c, http.StatusCreated, tokens)
+		return
+	}
+	uc.respondWithTokens(c, http.StatusOK, tokens)
+}
+
+// oidcProvider answers the request itself when the provider of the path cannot be used.
+func (uc *UserController) oidcProvider(c *gin.Context) (*oidc.Provider, bool) {
+	if uc.OIDC == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown identity provider"})
+		return nil, false
+	}
+	provider, err := uc.OIDC.Provider(c.Request.Context(), c.Param("provider"))
+	if errors.Is(err, oidc.ErrUnknownProvider) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown identity provider"})
+		return nil, false
+	}
+	if err != nil {
+		uc.Logger.Error("Failed to discover OIDC provider", zap.String("provider", c.Param("provider")), zap.Error(err))
+		c.JSON(http.StatusBadGateway, gin.H{"error": "identity provider is unavailable"})
+		return nil, false
+	}
+	return provider, true
+}
+
+// takeOIDCState returns the login the state was handed out for and forgets it,
+// so a callback cannot be replayed. It returns nil for unknown states.
+func (uc *UserController) takeOIDCState(ctx context.Context, state string) (*oidcLoginState, error) {
+	if state == "" {
+		return nil, nil
+	}
+	value, err := uc.RedisClient.GetDel(ctx, oidcStateKeyPrefix+state).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var login oidcLoginState
+	if err := json.Unmarshal(value, &login); err != nil {
+		return nil, err
+	}
+	return &login, nil
+}
+
+// oidcUser returns the account an identity logs in to. Unknown identities are
+// linked to the account with their email address, or get a new account when
+// there is none. created reports whether the account is new.
+//
+// Linking needs both sides to have verified the address. Otherwise whoever
+// signed up with someone else's address first would share their account.
+func (uc *UserController) oidcUser(ctx context.Context, provider string, idToken *oidc.IDToken) (db.User, bool, error) {
+	identity, err := uc.Queries.GetUserIdentity(ctx, db.GetUserIdentityParams{Provider: provider, Subject: idToken.Subject})
+	if err == nil {
+		user, err := uc.Queries.GetUser(ctx, identity.UserID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return db.User{}, false, errOIDCDeleted
+		}
+		if err != nil {
+			return db.User{}, false, err
+		}
+		if err := uc.Queries.TouchUserIdentity(ctx, db.TouchUserIdentityParams{ID: identity.ID, Email: idToken.Email}); err != nil {
+			uc.Logger.Warn("Failed to record OIDC login", zap.Error(err))
+		}
+		return user, false, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return db.User{}, false, err
+	}
+
+	if idToken.Email == "" {
+		return db.User{}, false, errOIDCNoEmail
+	}
+
+	user, err := uc.Queries.GetUserByEmail(ctx, idToken.Email)
+	switch {
+	case err == nil:
+		if !idToken.EmailVerified || !user.EmailVerifiedAt.Valid {
+			return db.User{}, false, errOIDCEmailTaken
+		}
+		if err := uc.linkIdentity(ctx, user.ID, provider, idToken); err != nil {
+			return db.User{}, false, err
+		}
+		return user, false, nil
+	case !errors.Is(err, pgx.ErrNoRows):
+		return db.User{}, false, err
+	}
+
+	user, err = uc.createOIDCUser(ctx, idToken)
+	if err != nil {
+		return db.User{}, false, err
+	}
+	if err := uc.linkIdentity(ctx, user.ID, provider, idToken); err != nil {
+		return db.User{}, false, err
+	}
+	return user, true, nil
+}
+
+func (uc *UserController) linkIdentity(ctx context.Context, userID int32, provider string, idToken *oidc.IDToken) error {
+	_, err := uc.Queries.CreateUserIdentity(ctx, db.CreateUserIdentityParams{
+		UserID:   userID,
+		Provider: provider,
+		Subject:  idToken.Subject,
+		Email:    idToken.Email,
+	})
+	return err
+}
+
+// createOIDCUser creates the account of someone who first logs in through a
+// provider. It gets a random password, one can be set with a password reset.
+func (uc *UserController) createOIDCUser(ctx context.Context, idToken *oidc.IDToken) (db.User, error) {
+	username, err := uc.freeUsername(ctx, idToken)
+	if err != nil {
+		return db.User{}, err
+	}
+
+	password, err := randomToken(32)
+	if err != nil {
+		return db.User{}, err
+	}
+	hashedPassword, err := passwords.Hash(password)
+	if err != nil {
+		return db.User{}, err
+	}
+
+	created, err := uc.Queries.CreateUser(ctx, db.CreateUserParams{Username: username, Email: idToken.Email, Password: hashedPassword})
+	// Someone signed up with the address since it was looked up
+	if index, taken := uniqueViolation(err); taken && index == "users_email_live_key" {
+		return db.User{}, errOIDCEmailTaken
+	}
+	if err != nil {
+		return db.User{}, err
+	}
+	user := db.User{ID: created.ID, Username: created.Username, Email: created.Email, Age: created.Age}
+
+	if idToken.EmailVerified {
+		if _, err := uc.Queries.VerifyUserEmail(ctx, db.VerifyUserEmailParams{ID: user.ID, Email: user.Email}); err != nil {
+			return db.User{}, err
+		}
+		user.EmailVerifiedAt = pgtype.Timestamp{Time: time.Now(), Valid: true}
+	}
+	return user, nil
+}
+
+// freeUsername picks a username for a new account from the identity, adding a
+// random suffix while the name is taken.
+func (uc *UserController) freeUsername(ctx context.Context, idToken *oidc.IDToken) (string, error) {
+	base := idToken.PreferredUsername
+	if base == "" {
+		base, _, _ = strings.Cut(idToken.Email, "@")
+	}
+	base = strings.Trim(usernameDisallowed.ReplaceAllString(base, ""), "._-")
+	if len(base) > maxUsernameLength-5 {
+		base = base[:maxUsernameLength-5]
+	}
+	if base == "" {
+		base = "user"
+	}
+
+	username := base
+	for attempt := 0; attempt < 5; attempt++ {
+		_, err := uc.Queries.GetUserByUsername(ctx, username)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return username, nil
+		}
+		if err != nil {
+			return "", err
+		}
+
+		suffix, err := randomToken(3)
+		if err != nil {
+			return "", err
+		}
+		username = base + "-" + suffix
+	}
+	return "", errors.New("could not find a free username")
+}
@@ -0,0 +1,213 @@
+package controller
+
+import (
+	"encoding/json"
+	"main/config"
+	"main/db"
+	"main/oidc"
+	"main/oidc/oidctest"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// scanRow answers a Scan of columns values with err, filling them in with fill
+func scanRow(columns int, err error, fill func(args mock.Arguments)) *MockRow {
+	mockRow := new(MockRow)
+	call := mockRow.On("Scan", anyColumns(columns)...).Return(err)
+	if fill != nil {
+		call.Run(fill)
+	}
+	return mockRow
+}
+
+func userRow(verified bool) *MockRow {
+	return scanRow(userColumnCount, nil, func(args mock.Arguments) {
+		*args.Get(0).(*int32) = 1
+		*args.Get(1).(*string) = "tester"
+		*args.Get(2).(*string) = "alice@example.com"
+		*args.Get(7).(*pgtype.Timestamp) = pgtype.Timestamp{Time: time.Now(), Valid: verified}
+	})
+}
+
+func identityRow() *MockRow {
+	return scanRow(7, nil, func(args mock.Arguments) {
+		*args.Get(0).(*int32) = 3
+		*args.Get(1).(*int32) = 1
+	})
+}
+
+// followOIDCLogin starts a login at the router, lets the provider log the user
+// in and returns where the provider sent the browser back to.
+func followOIDCLogin(t *testing.T, router *gin.Engine) string {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/users/oidc/test/login", nil)
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusFound, w.Code)
+
+	browser := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := browser.Get(w.Header().Get("Location"))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	require.NoError(t, err)
+	return callback.RequestURI()
+}
+
+func callOIDCCallback(router *gin.Engine, callback string) (int, map[string]interface{}) {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", callback, nil)
+	router.ServeHTTP(w, req)
+
+	var response map[string]interface{}
+	_ = json.Unmarshal(w.Body.Bytes(), &response)
+	return w.Code, response
+}
+
+func TestOIDCLogin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	previousJWT, previousPasswords := config.AppConfig.JWT, config.AppConfig.Passwords
+	t.Cleanup(func() {
+		config.AppConfig.JWT = previousJWT
+		config.AppConfig.Passwords = previousPasswords
+	})
+	config.AppConfig.JWT.Algorithm = "HS256"
+	config.AppConfig.JWT.Key = "test-key"
+	config.AppConfig.Passwords.Argon2id.Memory = 1024
+	config.AppConfig.Passwords.Argon2id.Iterations = 1
+
+	server := oidctest.NewServer("client", "secret")
+	defer server.Close()
+	server.SetUser(oidctest.User{Subject: "alice-1", Email: "alice@example.com", EmailVerified: true, PreferredUsername: "alice"})
+
+	noRows := func(columns int) *MockRow { return scanRow(columns, pgx.ErrNoRows, nil) }
+
+	tests := []struct {
+		name         string
+		mockBehavior func(mockDB *MockDBTX)
+		expectedCode int
+		check        func(t *testing.T, mockDB *MockDBTX, response map[string]interface{})
+	}{
+		{
+			name: "known identity",
+			mockBehavior: func(mockDB *MockDBTX) {
+				mockDB.On("QueryRow", mock.Anything, isQuery("GetUserIdentity"), mock.Anything).Return(identityRow())
+				mockDB.On("QueryRow", mock.Anything, isQuery("GetUser"), mock.Anything).Return(userRow(true))
+				mockDB.On("Exec", mock.Anything, isQuery("TouchUserIdentity"), mock.Anything).Return(pgconn.NewCommandTag("UPDATE 1"), nil)
+			},
+			expectedCode: http.StatusOK,
+			check: func(t *testing.T, mockDB *MockDBTX, response map[string]interface{}) {
+				mockDB.AssertCalled(t, "Exec", mock.Anything, isQuery("TouchUserIdentity"), []interface{}{int32(3), "alice@example.com"})
+			},
+		},
+		{
+			name: "links the account with the verified email",
+			mockBehavior: func(mockDB *MockDBTX) {
+				mockDB.On("QueryRow", mock.Anything, isQuery("GetUserIdentity"), mock.Anything).Return(noRows(7))
+				mockDB.On("QueryRow", mock.Anything, isQuery("GetUserByEmail"), mock.Anything).Return(userRow(true))
+				mockDB.On("QueryRow", mock.Anything, isQuery("CreateUserIdentity"), mock.Anything).Return(identityRow())
+			},
+			expectedCode: http.StatusOK,
+			check: func(t *testing.T, mockDB *MockDBTX, response map[string]interface{}) {
+				mockDB.AssertCalled(t, "QueryRow", mock.Anything, isQuery("CreateUserIdentity"), []interface{}{int32(1), "test", "alice-1", "alice@example.com"})
+			},
+		},
+		{
+			name: "does not link an account with an unverified email",
+			mockBehavior: func(mockDB *MockDBTX) {
+				mockDB.On("QueryRow", mock.Anything, isQuery("GetUserIdentity"), mock.Anything).Return(noRows(7))
+				mockDB.On("QueryRow", mock.Anything, isQuery("GetUserByEmail"), mock.Anything).Return(userRow(false))
+			},
+			expectedCode: http.StatusConflict,
+			check: func(t *testing.T, mockDB *MockDBTX, response map[string]interface{}) {
+				mockDB.AssertNotCalled(t, "QueryRow", mock.Anything, isQuery("CreateUserIdentity"), mock.Anything)
+				assert.Nil(t, response["token"])
+			},
+		},
+		{
+			name: "creates a new account",
+			mockBehavior: func(mockDB *MockDBTX) {
+				mockDB.On("QueryRow", mock.Anything, isQuery("GetUserIdentity"), mock.Anything).Return(noRows(7))
+				mockDB.On("QueryRow", mock.Anything, isQuery("GetUserByEmail"), mock.Anything).Return(noRows(userColumnCount))
+				mockDB.On("QueryRow", mock.Anything, isQuery("GetUserByUsername"), mock.Anything).Return(noRows(userColumnCount))
+				mockDB.On("QueryRow", mock.Anything, isQuery("CreateUser"), mock.Anything).Return(scanRow(4, nil, func(args mock.Arguments) {
+					*args.Get(0).(*int32) = 5
+					*args.Get(1).(*string) = "alice"
+					*args.Get(2).(*string) = "alice@example.com"
+				}))
+				mockDB.On("Exec", mock.Anything, isQuery("VerifyUserEmail"), mock.Anything).Return(pgconn.NewCommandTag("UPDATE 1"), nil)
+				mockDB.On("QueryRow", mock.Anything, isQuery("CreateUserIdentity"), mock.Anything).Return(identityRow())
+			},
+			expectedCode: http.StatusCreated,
+			check: func(t *testing.T, mockDB *MockDBTX, response map[string]interface{}) {
+				mockDB.AssertCalled(t, "Exec", mock.Anything, isQuery("VerifyUserEmail"), []interface{}{int32(5), "alice@example.com"})
+				mockDB.AssertCalled(t, "QueryRow", mock.Anything, isQuery("CreateUserIdentity"), []interface{}{int32(5), "test", "alice-1", "alice@example.com"})
+				user, _ := response["user"].(map[string]interface{})
+				assert.Equal(t, "alice", user["username"])
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB := new(MockDBTX)
+			mockTOTP(mockDB, "")
+			mockSessionWrites(mockDB)
+			tt.mockBehavior(mockDB)
+			mockDB.On("Query", mock.Anything, mock.Anything, mock.Anything).Return(NewMockRows(nil), nil)
+
+			logger, _ := zap.NewDevelopment()
+			uc := NewUserController(db.New(mockDB), newTestRedis(t), logger, true)
+			uc.OIDC = oidc.NewRegistry(map[string]config.OIDCProvider{
+				"test": {Issuer: server.Issuer(), ClientID: "client", ClientSecret: "secret", RedirectURL: "http://localhost/api/users/oidc/test/callback"},
+			}, nil)
+
+			router := gin.New()
+			router.GET("/api/users/oidc/:provider/login", uc.OIDCLogin)
+			router.GET("/api/users/oidc/:provider/callback", uc.OIDCCallback)
+
+			callback := followOIDCLogin(t, router)
+			code, response := callOIDCCallback(router, callback)
+			assert.Equal(t, tt.expectedCode, code, response)
+			if tt.expectedCode == http.StatusOK || tt.expectedCode == http.StatusCreated {
+				assert.NotEmpty(t, response["token"])
+				assert.NotEmpty(t, response["refresh_token"])
+			}
+			tt.check(t, mockDB, response)
+
+			// the state is only good for one callback
+			code, _ = callOIDCCallback(router, callback)
+			assert.Equal(t, http.StatusBadRequest, code)
+		})
+	}
+}
+
+func TestOIDCUnknownProvider(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	logger, _ := zap.NewDevelopment()
+	uc := NewUserController(db.New(new(MockDBTX)), newTestRedis(t), logger, true)
+	uc.OIDC = oidc.NewRegistry(nil, nil)
+
+	router := gin.New()
+	router.GET("/api/users/oidc/:provider/login", uc.OIDCLogin)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/users/oidc/nowhere/login", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,69 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"main/db"
+	"main/passwords"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// checkPasswordPolicy answers with every rule the password breaks and returns
+// false when it does not meet the configured policy.
+func (uc *UserController) checkPasswordPolicy(c *gin.Context, password string, subject passwords.Subject) bool {
+	err := passwords.ConfiguredPolicy().Check(password, subject)
+	if err == nil {
+		return true
+	}
+
+	var policyErr *passwords.PolicyError
+	if errors.As(err, &policyErr) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "password does not meet the policy", "violations": policyErr.Violations})
+		return false
+	}
+	uc.Logger.Error("Failed to check password policy", zap.Error(err))
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check password"})
+	return false
+}
+
+// passwordSubject returns what a new password of the user is checked against,
+// including as many of their previous password hashes as the policy looks at.
+func (uc *UserController) passwordSubject(ctx context.Context, user db.User) (passwords.Subject, error) {
+	subject := passwords.Subject{Username: user.Username, Email: user.Email}
+
+	limit := passwords.ConfiguredPolicy().History
+	if limit <= 0 {
+		return subject, nil
+	}
+	history, err := uc.Queries.GetPasswordHistory(ctx, db.GetPasswordHistoryParams{UserID: user.ID, Limit: int32(limit)})
+	if err != nil {
+		return subject, err
+	}
+
+	// Passwords set before the history was kept are only in the users table
+	if len(history) == 0 || history[0] != user.Password {
+		history = append([]string{user.Password}, history...)
+	}
+	subject.History = history
+	return subject, nil
+}
+
+// rememberPassword adds a new password hash to the user's history and drops the
+// entries the policy no longer looks at.
+func (uc *UserController) rememberPassword(ctx context.Context, userID int32, hash string) {
+	limit := passwords.ConfiguredPolicy().History
+	if limit <= 0 {
+		return
+	}
+
+	if err := uc.Queries.CreatePasswordHistory(ctx, db.CreatePasswordHistoryParams{UserID: userID, PasswordHash: hash}); err != nil {
+		uc.Logger.Warn("Failed to add password to history", zap.Error(err))
+		return
+	}
+	if err := uc.Queries.PrunePasswordHistory(ctx, db.PrunePasswordHistoryParams{UserID: userID, Limit: int32(limit)}); err != nil {
+		uc.Logger.Warn("Failed to prune password history", zap.Error(err))
+	}
+}
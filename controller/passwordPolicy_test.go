@@ -0,0 +1,90 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"main/config"
+	"main/db"
+	"main/passwords"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func changePassword(uc *UserController, oldPassword string, newPassword string) (int, map[string]interface{}) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Set("username", "tester")
+
+	jsonValue, _ := json.Marshal(ChangePasswordRequest{OldUserPassword: oldPassword, NewUserPassword: newPassword})
+	c.Request, _ = http.NewRequest("PUT", "/users/change-password", bytes.NewBuffer(jsonValue))
+	c.Request.Header.Set("Content-Type", "application/json")
+
+	uc.ChangePassword(c)
+
+	var response map[string]interface{}
+	_ = json.Unmarshal(w.Body.Bytes(), &response)
+	return w.Code, response
+}
+
+func violationRules(response map[string]interface{}) []string {
+	var rules []string
+	violations, _ := response["violations"].([]interface{})
+	for _, violation := range violations {
+		rules = append(rules, violation.(map[string]interface{})["rule"].(string))
+	}
+	return rules
+}
+
+func TestChangePasswordPolicy(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	previous := config.AppConfig.Passwords
+	t.Cleanup(func() { config.AppConfig.Passwords = previous })
+	config.AppConfig.Passwords.Argon2id.Memory = 1024
+	config.AppConfig.Passwords.Argon2id.Iterations = 1
+	config.AppConfig.Passwords.Policy.MinLength = 10
+	config.AppConfig.Passwords.Policy.MinStrength = 3
+	config.AppConfig.Passwords.Policy.RejectUserInputs = true
+	config.AppConfig.Passwords.Policy.History = 3
+
+	earlier, err := passwords.Hash("an earlier sentence of words")
+	require.NoError(t, err)
+
+	mockDB := new(MockDBTX)
+	// every change looks the history up again
+	for i := 0; i < 4; i++ {
+		mockDB.On("Query", mock.Anything, mock.Anything, mock.Anything).Return(NewMockRows([][]interface{}{{earlier}}), nil).Once()
+	}
+	mockUserWithPassword(mockDB, "current sentence of words")
+	mockDB.On("Exec", mock.Anything, mock.Anything, mock.Anything).Return(pgconn.NewCommandTag("INSERT 0 1"), nil)
+
+	logger, _ := zap.NewDevelopment()
+	uc := NewUserController(db.New(mockDB), newTestRedis(t), logger, true)
+
+	code, response := changePassword(uc, "current sentence of words", "tester1")
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, []string{passwords.RuleMinLength, passwords.RuleStrength, passwords.RuleUserInput}, violationRules(response))
+
+	code, response = changePassword(uc, "current sentence of words", "an earlier sentence of words")
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, []string{passwords.RuleHistory}, violationRules(response))
+
+	code, response = changePassword(uc, "current sentence of words", "current sentence of words")
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, []string{passwords.RuleHistory}, violationRules(response))
+
+	code, _ = changePassword(uc, "current sentence of words", "plum violin staircase")
+	assert.Equal(t, http.StatusOK, code)
+	mockDB.AssertCalled(t, "Exec", mock.Anything, mock.MatchedBy(func(sql string) bool {
+		return strings.Contains(sql, "INSERT INTO password_history")
+	}), mock.Anything)
+}
@@ -0,0 +1,262 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"main/config"
+	"main/db"
+	"main/mailer"
+	"main/passwords"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultPasswordResetTTL = time.Hour
+
+	passwordResetKeyPrefix     = "password_reset:"
+	passwordResetUserKeyPrefix = "password_reset_user:"
+	passwordResetSentKeyPrefix = "password_reset_sent:"
+)
+
+var ErrPasswordResetInvalid = errors.New("reset token is invalid or expired")
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required"`
+}
+
+type ResetPasswordRequest struct {
+	Token       string `json:"token" binding:"required"`
+	NewPassword string `json:"new_password" binding:"required"`
+}
+
+// newPasswordResetToken stores the hash of a new reset token for the user. Only the
+// latest token of a user is valid.
+func (uc *UserController) newPasswordResetToken(ctx context.Context, userID int32) (string, error) {
+	token, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	ttl := durationOr(config.AppConfig.PasswordReset.TokenTTL, defaultPasswordResetTTL)
+	userKey := passwordResetUserKeyPrefix + strconv.Itoa(int(userID))
+	tokenHash := hashToken(token)
+
+	previous, err := uc.RedisClient.Get(ctx, userKey).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return "", err
+	}
+
+	_, err = uc.RedisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		if previous != "" {
+			pipe.Del(ctx, passwordResetKeyPrefix+previous)
+		}
+		pipe.Set(ctx, passwordResetKeyPrefix+tokenHash, userID, ttl)
+		pipe.Set(ctx, userKey, tokenHash, ttl)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// passwordResetUser returns the user a reset token was issued to without using it up.
+func (uc *UserController) passwordResetUser(ctx context.Context, token string) (int32, error) {
+	userID, err := uc.RedisClient.Get(ctx, passwordResetKeyPrefix+hashToken(token)).Int()
+	if errors.Is(err, redis.Nil) {
+		return 0, ErrPasswordResetInvalid
+	}
+	if err != nil {
+		return 0, err
+	}
+	return int32(userID), nil
+}
+
+// consumePasswordResetToken returns the user a reset token was issued to and makes
+// sure it cannot be used again.
+func (uc *UserController) consumePasswordResetToken(ctx context.Context, token string) (int32, error) {
+	userID, err := uc.RedisClient.GetDel(ctx, passwordResetKeyPrefix+hashToken(token)).Int()
+	if errors.Is(err, redis.Nil) {
+		return 0, ErrPasswordResetInvalid
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if err := uc.RedisClient.Del(ctx, passwordResetUserKeyPrefix+strconv.Itoa(userID)).Err(); err != nil {
+		return 0, err
+	}
+	return int32(userID), nil
+}
+
+func (uc *UserController) sendPasswordResetEmail(ctx context.Context, user db.User, token string) error {
+	link := token
+	if resetURL := config.AppConfig.PasswordReset.ResetURL; resetURL != "" {
+		link = resetURL + "?token=" + url.QueryEscape(token)
+	}
+
+	ttl := durationOr(config.AppConfig.PasswordReset.TokenTTL, defaultPasswordResetTTL)
+	return uc.Mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\r\n\r\nYou can choose a new password by opening the link below. It works once and expires in %s.\r\n\r\n%s\r\n\r\n"+
+			"If you did not ask for this, you can ignore this email and your password stays the same.\r\n", user.Username, ttl, link),
+	})
+}
+
+// ForgotPassword godoc
+// @Summary Request a password reset
+// @Description Email a single-use password reset link. The answer is the same whether or not the address belongs to an account.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param email body ForgotPasswordRequest true "Email Address"
+// @Success 202 {object} gin.H "Message"
+// @Failure 400 {object} gin.H "Bad Request"
+// @Router /users/password/forgot [post]
+func (uc *UserController) ForgotPassword(c *gin.Context) {
+	userRequests.WithLabelValues("POST", "/users/password/forgot").Inc()
+	var req ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Failures past this point are only logged, so the answer never tells whether the address is registered
+	c.JSON(http.StatusAccepted, gin.H{"message": "If the address belongs to an account, a password reset email is on its way"})
+	uc.inBackground(func(ctx context.Context) { uc.mailPasswordReset(ctx, req.Email) })
+}
+
+// mailPasswordReset sends a password reset email to the address, if it belongs to an account.
+func (uc *UserController) mailPasswordReset(ctx context.Context, email string) {
+	interval := durationOr(config.AppConfig.PasswordReset.ResendInterval, defaultResendInterval)
+	wait, err := uc.throttleMail(ctx, passwordResetSentKeyPrefix, email, interval)
+	if err != nil {
+		uc.Logger.Error("Failed to throttle password reset email", zap.Error(err))
+		return
+	}
+	if wait > 0 {
+		return
+	}
+
+	user, err := uc.Queries.GetUserByEmail(ctx, email)
+	if err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			uc.Logger.Error("Failed to look up user for password reset", zap.Error(err))
+		}
+		return
+	}
+
+	token, err := uc.newPasswordResetToken(ctx, user.ID)
+	if err != nil {
+		uc.Logger.Error("Failed to create password reset token", zap.Error(err))
+		return
+	}
+
+	if err := uc.sendPasswordResetEmail(ctx, user, token); err != nil {
+		uc.Logger.Error("Failed to send password reset email", zap.Error(err))
+	}
+}
+
+// ResetPassword godoc
+// @Summary Reset a password
+// @Description Set a new password with the token from the reset email. The new password has to meet the password policy, a 400 lists every rule it breaks. Every session of the user is logged out.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param reset body ResetPasswordRequest true "Reset Token and New Password"
+// @Success 200 {object} gin.H "Message"
+// @Failure 400 {object} gin.H "Bad Request"
+// @Failure 500 {object} gin.H "Internal Server Error"
+// @Router /users/password/reset [post]
+func (uc *UserController) ResetPassword(c *gin.Context) {
+	userRequests.WithLabelValues("POST", "/users/password/reset").Inc()
+	var req ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// The token is only used up once the new password is accepted, so a rejected one can be retried
+	userID, err := uc.passwordResetUser(c.Request.Context(), req.Token)
+	if err != nil {
+		if errors.Is(err, ErrPasswordResetInvalid) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	current, err := uc.Queries.GetUser(c.Request.Context(), userID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": ErrPasswordResetInvalid.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	subject, err := uc.passwordSubject(c.Request.Context(), current)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not retrieve password history"})
+		return
+	}
+	if !uc.checkPasswordPolicy(c, req.NewPassword, subject) {
+		return
+	}
+
+	if _, err := uc.consumePasswordResetToken(c.Request.Context(), req.Token); err != nil {
+		if errors.Is(err, ErrPasswordResetInvalid) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	hashedPassword, err := passwords.Hash(req.NewPassword)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process new password"})
+		return
+	}
+
+	user, err := uc.Queries.UpdateUserPassword(c.Request.Context(), db.UpdateUserPasswordParams{
+		ID:       userID,
+		Password: hashedPassword,
+	})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": ErrPasswordResetInvalid.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update password"})
+		return
+	}
+	uc.rememberPassword(c.Request.Context(), user.ID, hashedPassword)
+
+	if err := uc.revokeAllTokens(c.Request.Context(), user.ID); err != nil {
+		uc.Logger.Error("Failed to revoke tokens after password reset", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke existing sessions"})
+		return
+	}
+	if err := uc.resetLoginFailures(c.Request.Context(), user.ID); err != nil {
+		uc.Logger.Warn("Failed to reset login failures", zap.Error(err))
+	}
+
+	// The reset link reached the user's inbox, which proves they own the address
+	if _, err := uc.Queries.VerifyUserEmail(c.Request.Context(), db.VerifyUserEmailParams{ID: user.ID, Email: user.Email}); err != nil {
+		uc.Logger.Warn("Failed to mark email as verified", zap.Error(err))
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password has been reset, log in with the new password"})
+}
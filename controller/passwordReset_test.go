@@ -0,0 +1,129 @@
+package controller
+
+import (
+	"context"
+	"main/auth"
+	"main/config"
+	"main/db"
+	"main/mailer"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestPasswordReset(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	previous := config.AppConfig.PasswordReset
+	t.Cleanup(func() { config.AppConfig.PasswordReset = previous })
+	config.AppConfig.PasswordReset.ResetURL = "http://localhost/reset-password"
+
+	mockDB := new(MockDBTX)
+	mockRow := new(MockRow)
+	mockRow.On("Scan", anyColumns(userColumnCount)...).Return(nil).Run(func(args mock.Arguments) {
+		*args.Get(0).(*int32) = 1
+		*args.Get(1).(*string) = "tester"
+		*args.Get(2).(*string) = "testuser@test.com"
+	})
+	mockDB.On("QueryRow", mock.Anything, mock.Anything, mock.Anything).Return(mockRow)
+	mockDB.On("Exec", mock.Anything, mock.Anything, mock.Anything).Return(pgconn.NewCommandTag("UPDATE 1"), nil)
+
+	logger, _ := zap.NewDevelopment()
+	redisClient := newTestRedis(t)
+	uc := NewUserController(db.New(mockDB), redisClient, logger, true)
+	mail := &recordingMailer{}
+	uc.Mailer = mail
+
+	code, _ := postJSON(uc.ForgotPassword, "/users/password/forgot", ForgotPasswordRequest{Email: "testuser@test.com"})
+	assert.Equal(t, http.StatusAccepted, code)
+	uc.background.Wait()
+	require.Len(t, mail.sent, 1)
+	assert.Equal(t, "testuser@test.com", mail.sent[0].To)
+
+	var token string
+	for _, field := range strings.Fields(mail.sent[0].Body) {
+		if link, err := url.Parse(field); err == nil && link.Query().Get("token") != "" {
+			token = link.Query().Get("token")
+		}
+	}
+	require.NotEmpty(t, token)
+
+	code, response := postJSON(uc.ResetPassword, "/users/password/reset", ResetPasswordRequest{Token: token, NewPassword: "new password"})
+	assert.Equal(t, http.StatusOK, code)
+	assert.NotEmpty(t, response["message"])
+
+	// existing access tokens were issued for generation 0 and are revoked now
+	generation, err := auth.TokenGeneration(context.Background(), redisClient, "1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), generation)
+
+	code, response = postJSON(uc.ResetPassword, "/users/password/reset", ResetPasswordRequest{Token: token, NewPassword: "another password"})
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, ErrPasswordResetInvalid.Error(), response["error"])
+}
+
+func TestForgotPasswordUnknownEmail(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockDB := new(MockDBTX)
+	mockRow := new(MockRow)
+	mockRow.On("Scan", anyColumns(userColumnCount)...).Return(pgx.ErrNoRows)
+	mockDB.On("QueryRow", mock.Anything, mock.Anything, mock.Anything).Return(mockRow)
+
+	logger, _ := zap.NewDevelopment()
+	uc := NewUserController(db.New(mockDB), newTestRedis(t), logger, true)
+	mail := &recordingMailer{}
+	uc.Mailer = mail
+
+	code, _ := postJSON(uc.ForgotPassword, "/users/password/forgot", ForgotPasswordRequest{Email: "nobody@test.com"})
+	assert.Equal(t, http.StatusAccepted, code)
+	uc.background.Wait()
+	assert.Empty(t, mail.sent)
+}
+
+// blockingMailer holds every message until it is released
+type blockingMailer struct {
+	release chan struct{}
+	sent    chan mailer.Message
+}
+
+func (m *blockingMailer) Send(ctx context.Context, msg mailer.Message) error {
+	<-m.release
+	m.sent <- msg
+	return nil
+}
+
+func TestForgotPasswordAnswersBeforeMailing(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockDB := new(MockDBTX)
+	mockDB.On("QueryRow", mock.Anything, isQuery("GetUserByEmail"), mock.Anything).Return(userRow(true))
+	logger, _ := zap.NewDevelopment()
+	uc := NewUserController(db.New(mockDB), newTestRedis(t), logger, true)
+	mail := &blockingMailer{release: make(chan struct{}), sent: make(chan mailer.Message, 1)}
+	uc.Mailer = mail
+
+	// the answer does not wait for the account to be looked up and mailed
+	code, _ := postJSON(uc.ForgotPassword, "/users/password/forgot", ForgotPasswordRequest{Email: "testuser@test.com"})
+	assert.Equal(t, http.StatusAccepted, code)
+	assert.Empty(t, mail.sent)
+
+	// shutting down waits for the mail, as long as the deadline allows
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, uc.WaitForBackground(ctx), context.DeadlineExceeded)
+
+	close(mail.release)
+	require.NoError(t, uc.WaitForBackground(context.Background()))
+	assert.Len(t, mail.sent, 1)
+}
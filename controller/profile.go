@@ -0,0 +1,207 @@
+package controller
+
+import (
+	"errors"
+	"main/auth"
+	"main/db"
+	"main/dto"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	// Time zones are checked against the embedded database, hosts may not have one
+	_ "time/tzdata"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"go.uber.org/zap"
+	"golang.org/x/text/language"
+)
+
+const (
+	maxDisplayNameLength = 64
+	maxBioLength         = 500
+	maxLocaleLength      = 35
+	maxPronounsLength    = 40
+	maxProfileLinks      = 5
+	maxLinkLength        = 255
+)
+
+// ProfileViolation is one profile field that is invalid.
+type ProfileViolation struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+type UpdateProfileRequest struct {
+	// DisplayName is shown instead of the username, at most 64 characters
+	DisplayName string `json:"display_name"`
+	// Bio is at most 500 characters and may span lines
+	Bio string `json:"bio"`
+	// Locale is a BCP 47 language tag such as en-US
+	Locale string `json:"locale"`
+	// Timezone is an IANA time zone name such as Europe/Berlin
+	Timezone string `json:"timezone"`
+	// Pronouns are at most 40 characters
+	Pronouns string `json:"pronouns"`
+	// Links are at most 5 http or https URLs
+	Links []string `json:"links"`
+}
+
+// hasControl reports whether the text holds control characters, other than the ones allowed.
+func hasControl(text string, allowed ...rune) bool {
+	return strings.IndexFunc(text, func(r rune) bool {
+		return unicode.IsControl(r) && !strings.ContainsRune(string(allowed), r)
+	}) >= 0
+}
+
+// profileText checks a free text field, empty fields are cleared.
+func profileText(field string, value string, maxLength int, allowed ...rune) (pgtype.Text, *ProfileViolation) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return pgtype.Text{}, nil
+	}
+	if !utf8.ValidString(value) || hasControl(value, allowed...) {
+		return pgtype.Text{}, &ProfileViolation{Field: field, Message: field + " must not contain control characters"}
+	}
+	if utf8.RuneCountInString(value) > maxLength {
+		return pgtype.Text{}, &ProfileViolation{Field: field, Message: field + " must be at most " + strconv.Itoa(maxLength) + " characters"}
+	}
+	return pgtype.Text{String: value, Valid: true}, nil
+}
+
+func profileLocale(value string) (pgtype.Text, *ProfileViolation) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return pgtype.Text{}, nil
+	}
+	tag, err := language.Parse(value)
+	if err != nil || tag == language.Und || len(tag.String()) > maxLocaleLength {
+		return pgtype.Text{}, &ProfileViolation{Field: "locale", Message: "locale must be a language tag such as en-US"}
+	}
+	return pgtype.Text{String: tag.String(), Valid: true}, nil
+}
+
+func profileTimezone(value string) (pgtype.Text, *ProfileViolation) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return pgtype.Text{}, nil
+	}
+	// Local is wherever the server happens to run
+	if _, err := time.LoadLocation(value); err != nil || value == "Local" {
+		return pgtype.Text{}, &ProfileViolation{Field: "timezone", Message: "timezone must be a time zone name such as Europe/Berlin"}
+	}
+	return pgtype.Text{String: value, Valid: true}, nil
+}
+
+// profileLinks checks the links, dropping blank and repeated ones.
+func profileLinks(values []string) ([]string, []ProfileViolation) {
+	links := []string{}
+	var violations []ProfileViolation
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" || slices.Contains(links, value) {
+			continue
+		}
+		link, err := url.Parse(value)
+		switch {
+		case len(value) > maxLinkLength:
+			violations = append(violations, ProfileViolation{Field: "links", Message: "links must be at most " + strconv.Itoa(maxLinkLength) + " bytes"})
+		case err != nil || (link.Scheme != "http" && link.Scheme != "https") || link.Host == "":
+			violations = append(violations, ProfileViolation{Field: "links", Message: value + " is not an http or https URL"})
+		// Credentials in links make them look like they go somewhere else
+		case link.User != nil:
+			violations = append(violations, ProfileViolation{Field: "links", Message: value + " must not contain credentials"})
+		default:
+			links = append(links, value)
+		}
+	}
+	if len(links) > maxProfileLinks {
+		violations = append(violations, ProfileViolation{Field: "links", Message: "at most " + strconv.Itoa(maxProfileLinks) + " links are allowed"})
+	}
+	return links, violations
+}
+
+// profileParams checks every field of the profile and returns what to store,
+// or every field that is invalid.
+func profileParams(req UpdateProfileRequest) (db.UpdateUserProfileParams, []ProfileViolation) {
+	var params db.UpdateUserProfileParams
+	var violations []ProfileViolation
+	check := func(value pgtype.Text, violation *ProfileViolation) pgtype.Text {
+		if violation != nil {
+			violations = append(violations, *violation)
+		}
+		return value
+	}
+
+	params.DisplayName = check(profileText("display_name", req.DisplayName, maxDisplayNameLength))
+	params.Bio = check(profileText("bio", strings.ReplaceAll(req.Bio, "\r\n", "\n"), maxBioLength, '\n'))
+	params.Locale = check(profileLocale(req.Locale))
+	params.Timezone = check(profileTimezone(req.Timezone))
+	params.Pronouns = check(profileText("pronouns", req.Pronouns, maxPronounsLength))
+
+	links, linkViolations := profileLinks(req.Links)
+	params.Links = links
+	violations = append(violations, linkViolations...)
+	return params, violations
+}
+
+// UpdateProfile godoc
+// @Summary Update your profile
+// @Description Replace the display name, bio, locale, timezone, pronouns and links of the logged in user. Fields left out are cleared. A 400 lists every field that is invalid.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param profile body UpdateProfileRequest true "Profile"
+// @Success 200 {object} dto.SelfUser "Updated User"
+// @Failure 400 {object} gin.H "Bad Request"
+// @Failure 401 {object} gin.H "Unauthorized"
+// @Failure 404 {object} gin.H "Not Found"
+// @Failure 500 {object} gin.H "Internal Server Error"
+// @Router /users/me/profile [put]
+func (uc *UserController) UpdateProfile(c *gin.Context) {
+	userRequests.WithLabelValues("PUT", "/users/me/profile").Inc()
+	claims, ok := auth.FromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	userID, ok := claims.UserID()
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var req UpdateProfileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	params, violations := profileParams(req)
+	if len(violations) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "profile is invalid", "violations": violations})
+		return
+	}
+	params.ID = userID
+
+	user, err := uc.Queries.UpdateUserProfile(c.Request.Context(), params)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := uc.forgetCachedUser(c.Request.Context(), user.ID); err != nil {
+		uc.Logger.Warn("Failed to drop cached user", zap.Error(err))
+	}
+
+	c.JSON(http.StatusOK, dto.NewSelfUser(user))
+}
@@ -0,0 +1,451 @@
+package controller
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"main/auth"
+	"main/config"
+	"main/db"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultRefreshTokenTTL = 30 * 24 * time.Hour
+
+	refreshTokenKeyPrefix    = "refresh_token:"
+	refreshFamilyKeyPrefix   = "refresh_family:"
+	refreshFamiliesKeyPrefix = "refresh_families:"
+)
+
+var (
+	ErrRefreshTokenInvalid = errors.New("refresh token is invalid or expired")
+	ErrRefreshTokenReused  = errors.New("refresh token has already been used")
+)
+
+// rotateRefreshTokenScript swaps the current token of a family for a new one.
+// It returns "ok:<session>:<user id>" on success, "reused" when the presented
+// token is not the family's current one (the family is deleted in that case)
+// and "invalid" when the token or its family no longer exists.
+var rotateRefreshTokenScript = redis.NewScript(`
+local family = redis.call("GET", KEYS[1])
+if not family then
+	return "invalid"
+end
+local familyKey = ARGV[4] .. family
+local user = redis.call("HGET", familyKey, "user_id")
+if not user then
+	return "invalid"
+end
+if redis.call("HGET", familyKey, "current") ~= ARGV[1] then
+	redis.call("DEL", familyKey)
+	redis.call("SREM", ARGV[5] .. user, family)
+	return "reused"
+end
+redis.call("HSET", familyKey, "current", ARGV[2])
+redis.call("PEXPIRE", familyKey, ARGV[3])
+redis.call("SET", KEYS[2], family, "PX", ARGV[3])
+local session = redis.call("HGET", familyKey, "session") or ""
+return "ok:" .. session .. ":" .. user
+`)
+
+func refreshTokenTTL() time.Duration {
+	if ttl := config.AppConfig.JWT.RefreshTokenTTL; ttl > 0 {
+		return ttl
+	}
+	return defaultRefreshTokenTTL
+}
+
+func randomToken(size int) (string, error) {
+	buf := make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// newRefreshToken starts a new token family for the session and returns its
+// first token and the family id. Families belong to the user id, usernames can
+// change hands.
+func (uc *UserController) newRefreshToken(ctx context.Context, userID int32, sessionID string) (string, string, error) {
+	familyID, err := randomToken(16)
+	if err != nil {
+		return "", "", err
+	}
+	token, err := randomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+
+	ttl := refreshTokenTTL()
+	tokenHash := hashToken(token)
+	familyKey := refreshFamilyKeyPrefix + familyID
+	familiesKey := refreshFamiliesKeyPrefix + strconv.Itoa(int(userID))
+
+	_, err = uc.RedisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, familyKey, "user_id", userID, "current", tokenHash, "session", sessionID)
+		pipe.PExpire(ctx, familyKey, ttl)
+		pipe.Set(ctx, refreshTokenKeyPrefix+tokenHash, familyID, ttl)
+		pipe.SAdd(ctx, familiesKey, familyID)
+		pipe.PExpire(ctx, familiesKey, ttl)
+		return nil
+	})
+	if err != nil {
+		return "", "", err
+	}
+	return token, familyID, nil
+}
+
+// refreshTokenOwner returns the id of the user whose family the refresh token
+// belongs to, without using the token up.
+func (uc *UserController) refreshTokenOwner(ctx context.Context, token string) (int32, error) {
+	familyID, err := uc.RedisClient.Get(ctx, refreshTokenKeyPrefix+hashToken(token)).Result()
+	if errors.Is(err, redis.Nil) {
+		return 0, ErrRefreshTokenInvalid
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	owner, err := uc.RedisClient.HGet(ctx, refreshFamilyKeyPrefix+familyID, "user_id").Result()
+	if errors.Is(err, redis.Nil) {
+		return 0, ErrRefreshTokenInvalid
+	}
+	if err != nil {
+		return 0, err
+	}
+	userID, err := strconv.ParseInt(owner, 10, 32)
+	if err != nil {
+		return 0, ErrRefreshTokenInvalid
+	}
+	return int32(userID), nil
+}
+
+// rotatedRefreshToken is a new refresh token and what its family belongs to.
+type rotatedRefreshToken struct {
+	Token     string
+	UserID    int32
+	SessionID string
+}
+
+// rotateRefreshToken exchanges a refresh token for a new one in the same family.
+func (uc *UserController) rotateRefreshToken(ctx context.Context, token string) (rotatedRefreshToken, error) {
+	rotated := rotatedRefreshToken{}
+	newToken, err := randomToken(32)
+	if err != nil {
+		return rotated, err
+	}
+
+	oldHash := hashToken(token)
+	newHash := hashToken(newToken)
+
+	result, err := rotateRefreshTokenScript.Run(ctx, uc.RedisClient,
+		[]string{refreshTokenKeyPrefix + oldHash, refreshTokenKeyPrefix + newHash},
+		oldHash, newHash, refreshTokenTTL().Milliseconds(), refreshFamilyKeyPrefix, refreshFamiliesKeyPrefix,
+	).Text()
+	if err != nil {
+		return rotated, err
+	}
+
+	switch result {
+	case "invalid":
+		return rotated, ErrRefreshTokenInvalid
+	case "reused":
+		return rotated, ErrRefreshTokenReused
+	}
+
+	sessionID, user, ok := strings.Cut(strings.TrimPrefix(result, "ok:"), ":")
+	if !ok || !strings.HasPrefix(result, "ok:") {
+		return rotated, ErrRefreshTokenInvalid
+	}
+	userID, err := strconv.ParseInt(user, 10, 32)
+	if err != nil {
+		return rotated, ErrRefreshTokenInvalid
+	}
+	rotated.Token, rotated.UserID, rotated.SessionID = newToken, int32(userID), sessionID
+	return rotated, nil
+}
+
+// dropRefreshFamily deletes a refresh token family of the user.
+func (uc *UserController) dropRefreshFamily(ctx context.Context, userID int32, familyID string) error {
+	_, err := uc.RedisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, refreshFamilyKeyPrefix+familyID)
+		pipe.SRem(ctx, refreshFamiliesKeyPrefix+strconv.Itoa(int(userID)), familyID)
+		return nil
+	})
+	return err
+}
+
+// revokeRefreshToken drops the family the refresh token belongs to, if it is the user's.
+func (uc *UserController) revokeRefreshToken(ctx context.Context, userID int32, token string) error {
+	familyID, err := uc.RedisClient.Get(ctx, refreshTokenKeyPrefix+hashToken(token)).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	owner, err := uc.RedisClient.HGet(ctx, refreshFamilyKeyPrefix+familyID, "user_id").Result()
+	if errors.Is(err, redis.Nil) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if owner != strconv.Itoa(int(userID)) {
+		return nil
+	}
+	return uc.dropRefreshFamily(ctx, userID, familyID)
+}
+
+// revokeAllRefreshTokens drops every refresh token family of the user.
+func (uc *UserController) revokeAllRefreshTokens(ctx context.Context, userID int32) error {
+	familiesKey := refreshFamiliesKeyPrefix + strconv.Itoa(int(userID))
+	families, err := uc.RedisClient.SMembers(ctx, familiesKey).Result()
+	if err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(families)+1)
+	for _, familyID := range families {
+		keys = append(keys, refreshFamilyKeyPrefix+familyID)
+	}
+	keys = append(keys, familiesKey)
+	return uc.RedisClient.Del(ctx, keys...).Err()
+}
+
+// tokenClaims describes the user in an access token, with their current roles
+// and token generation.
+func (uc *UserController) tokenClaims(ctx context.Context, user db.User) (*auth.Claims, error) {
+	generation, err := auth.TokenGeneration(ctx, uc.RedisClient, strconv.Itoa(int(user.ID)))
+	if err != nil {
+		return nil, err
+	}
+
+	roles, err := uc.Queries.GetUserRoles(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &auth.Claims{
+		Username:       user.Username,
+		Roles:          roles,
+		Generation:     generation,
+		EmailVerified:  user.EmailVerifiedAt.Valid,
+		StandardClaims: jwt.StandardClaims{Subject: strconv.Itoa(int(user.ID))},
+	}, nil
+}
+
+// accessToken issues an access token of the session. It returns the token and its jti.
+func (uc *UserController) accessToken(ctx context.Context, user db.User, sessionID string) (string, string, error) {
+	claims, err := uc.tokenClaims(ctx, user)
+	if err != nil {
+		return "", "", err
+	}
+
+	claims.SessionID = sessionID
+	token, err := auth.GenerateJWT(claims)
+	return token, claims.Id, err
+}
+
+// device describes where a login comes from.
+type device struct {
+	UserAgent string
+	IP        string
+}
+
+func deviceOf(c *gin.Context) device {
+	return device{UserAgent: c.Request.UserAgent(), IP: c.ClientIP()}
+}
+
+// issueTokens starts a new session for the user with an access token and a new
+// refresh token family.
+func (uc *UserController) issueTokens(ctx context.Context, user db.User, from device) (gin.H, error) {
+	sessionID, err := randomToken(16)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, familyID, err := uc.newRefreshToken(ctx, user.ID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	token, tokenID, err := uc.accessToken(ctx, user, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = uc.Queries.CreateSession(ctx, db.CreateSessionParams{
+		ID:            sessionID,
+		UserID:        user.ID,
+		TokenID:       tokenID,
+		RefreshFamily: familyID,
+		UserAgent:     from.UserAgent,
+		Ip:            from.IP,
+		ExpiresAt:     pgtype.Timestamp{Time: time.Now().Add(refreshTokenTTL()).UTC(), Valid: true},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return gin.H{
+		"token":         token,
+		"refresh_token": refreshToken,
+		"expires_in":    int(auth.AccessTokenTTL().Seconds()),
+	}, nil
+}
+
+// respondWithTokens answers a login with the tokens of its new session. When session
+// cookies are enabled the browser gets them as cookies too, along with a CSRF token.
+func (uc *UserController) respondWithTokens(c *gin.Context, status int, tokens gin.H) {
+	if auth.SessionCookiesEnabled() {
+		token, _ := tokens["token"].(string)
+		refreshToken, _ := tokens["refresh_token"].(string)
+		csrfToken, err := auth.SetSessionCookies(c, token, refreshToken, refreshTokenTTL())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		tokens["csrf_token"] = csrfToken
+	}
+	c.JSON(status, tokens)
+}
+
+type RefreshRequest struct {
+	// RefreshToken may be left out by browsers that have it in the session cookie
+	RefreshToken string `json:"refresh_token"`
+}
+
+// Refresh godoc
+// @Summary Refresh an access token
+// @Description Exchange a refresh token for a new access token and refresh token pair. Browsers with session cookies may leave the body out and send the CSRF token in the X-CSRF-Token header instead.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param token body RefreshRequest false "Refresh Token"
+// @Success 200 {object} gin.H "Token Pair"
+// @Failure 400 {object} gin.H "Bad Request"
+// @Failure 401 {object} gin.H "Unauthorized"
+// @Failure 403 {object} gin.H "Forbidden"
+// @Failure 500 {object} gin.H "Internal Server Error"
+// @Router /users/refresh [post]
+func (uc *UserController) Refresh(c *gin.Context) {
+	userRequests.WithLabelValues("POST", "/refresh").Inc()
+	var req RefreshRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if req.RefreshToken == "" && auth.SessionCookiesEnabled() {
+		if cookie, err := c.Cookie(auth.RefreshTokenCookie); err == nil && cookie != "" {
+			// The cookie comes along with requests other sites make, the CSRF token does not
+			if !auth.ValidCSRF(c) {
+				c.JSON(http.StatusForbidden, gin.H{"error": "missing or invalid CSRF token"})
+				return
+			}
+			req.RefreshToken = cookie
+		}
+	}
+	if req.RefreshToken == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "refresh_token is required"})
+		return
+	}
+
+	// The user is checked before the token is rotated, so a refusal or a failed lookup
+	// leaves the client with the token it presented
+	userID, err := uc.refreshTokenOwner(c.Request.Context(), req.RefreshToken)
+	if errors.Is(err, ErrRefreshTokenInvalid) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		uc.Logger.Error("Failed to look up refresh token", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := uc.Queries.GetUser(c.Request.Context(), userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": ErrRefreshTokenInvalid.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if refuseRestricted(c, user) {
+		return
+	}
+
+	rotated, err := uc.rotateRefreshToken(c.Request.Context(), req.RefreshToken)
+	if err != nil {
+		if errors.Is(err, ErrRefreshTokenReused) {
+			uc.Logger.Warn("Refresh token reuse detected, token family revoked")
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, ErrRefreshTokenInvalid) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		uc.Logger.Error("Failed to rotate refresh token", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if rotated.UserID != user.ID {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": ErrRefreshTokenInvalid.Error()})
+		return
+	}
+
+	token, tokenID, err := uc.accessToken(c.Request.Context(), user, rotated.SessionID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Families started before sessions were kept have no session to update
+	if rotated.SessionID != "" {
+		rows, err := uc.Queries.RefreshSession(c.Request.Context(), db.RefreshSessionParams{
+			ID:        rotated.SessionID,
+			TokenID:   tokenID,
+			Ip:        c.ClientIP(),
+			ExpiresAt: pgtype.Timestamp{Time: time.Now().Add(refreshTokenTTL()).UTC(), Valid: true},
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if rows == 0 {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": ErrRefreshTokenInvalid.Error()})
+			return
+		}
+	}
+
+	uc.respondWithTokens(c, http.StatusOK, gin.H{
+		"token":         token,
+		"refresh_token": rotated.Token,
+		"expires_in":    int(auth.AccessTokenTTL().Seconds()),
+	})
+}
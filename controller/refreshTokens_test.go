@@ -0,0 +1,209 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"main/auth"
+	"main/config"
+	"main/db"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func refresh(uc *UserController, refreshToken string) (int, map[string]interface{}) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+
+	jsonValue, _ := json.Marshal(RefreshRequest{RefreshToken: refreshToken})
+	c.Request, _ = http.NewRequest("POST", "/users/refresh", bytes.NewBuffer(jsonValue))
+	c.Request.Header.Set("Content-Type", "application/json")
+
+	uc.Refresh(c)
+
+	var response map[string]interface{}
+	_ = json.Unmarshal(w.Body.Bytes(), &response)
+	return w.Code, response
+}
+
+// mockUserLookup answers user lookups with the given user and GetUserRoles with no roles
+func mockUserLookup(mockDB *MockDBTX, id int32, username string) {
+	mockRow := new(MockRow)
+	mockRow.On("Scan", anyColumns(userColumnCount)...).Return(nil).Run(func(args mock.Arguments) {
+		*args.Get(0).(*int32) = id
+		*args.Get(1).(*string) = username
+	})
+	mockDB.On("QueryRow", mock.Anything, mock.Anything, mock.Anything).Return(mockRow)
+	mockDB.On("Query", mock.Anything, mock.Anything, mock.Anything).Return(NewMockRows(nil), nil)
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockDB := new(MockDBTX)
+	mockUserLookup(mockDB, 1, "tester")
+	mockSessionWrites(mockDB)
+	logger, _ := zap.NewDevelopment()
+	uc := NewUserController(db.New(mockDB), newTestRedis(t), logger, true)
+
+	first, _, err := uc.newRefreshToken(context.Background(), 1, "session-id")
+	require.NoError(t, err)
+
+	code, response := refresh(uc, first)
+	assert.Equal(t, http.StatusOK, code)
+	assert.NotEmpty(t, response["token"])
+	second, _ := response["refresh_token"].(string)
+	assert.NotEmpty(t, second)
+	assert.NotEqual(t, first, second)
+
+	// presenting the first token again is a reuse and revokes the whole family
+	code, response = refresh(uc, first)
+	assert.Equal(t, http.StatusUnauthorized, code)
+	assert.Equal(t, ErrRefreshTokenReused.Error(), response["error"])
+
+	code, _ = refresh(uc, second)
+	assert.Equal(t, http.StatusUnauthorized, code)
+}
+
+func TestRefreshFollowsUserID(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	previous := config.AppConfig.JWT
+	t.Cleanup(func() { config.AppConfig.JWT = previous })
+	config.AppConfig.JWT.Algorithm = "HS256"
+	config.AppConfig.JWT.Key = "test-key"
+
+	// the user was renamed since logging in, someone else may have taken the old name
+	mockDB := new(MockDBTX)
+	mockDB.On("QueryRow", mock.Anything, isQuery("GetUser"), []interface{}{int32(1)}).Return(scanRow(userColumnCount, nil, func(args mock.Arguments) {
+		*args.Get(0).(*int32) = 1
+		*args.Get(1).(*string) = "renamed"
+	}))
+	mockDB.On("Query", mock.Anything, isQuery("GetUserRoles"), mock.Anything).Return(NewMockRows(nil), nil)
+	mockSessionWrites(mockDB)
+	logger, _ := zap.NewDevelopment()
+	uc := NewUserController(db.New(mockDB), newTestRedis(t), logger, true)
+
+	refreshToken, _, err := uc.newRefreshToken(context.Background(), 1, "session-id")
+	require.NoError(t, err)
+
+	code, response := refresh(uc, refreshToken)
+	require.Equal(t, http.StatusOK, code)
+	claims, err := auth.ParseJWT(response["token"].(string))
+	require.NoError(t, err)
+	assert.Equal(t, "1", claims.Subject)
+	assert.Equal(t, "renamed", claims.Username)
+	mockDB.AssertNotCalled(t, "QueryRow", mock.Anything, isQuery("GetUserByUsername"), mock.Anything)
+
+	// logging everyone out of the account reaches its families whatever it is called now
+	require.NoError(t, uc.revokeAllTokens(context.Background(), 1))
+	code, _ = refresh(uc, response["refresh_token"].(string))
+	assert.Equal(t, http.StatusUnauthorized, code)
+}
+
+func TestRefreshChecksUserBeforeRotating(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	previous := config.AppConfig.JWT
+	t.Cleanup(func() { config.AppConfig.JWT = previous })
+	config.AppConfig.JWT.Algorithm = "HS256"
+	config.AppConfig.JWT.Key = "test-key"
+
+	mockDB := new(MockDBTX)
+	mockDB.On("QueryRow", mock.Anything, isQuery("GetUser"), []interface{}{int32(1)}).Return(statusRow(auth.StatusSuspended, true, time.Now().Add(time.Hour))).Once()
+	mockDB.On("QueryRow", mock.Anything, isQuery("GetUser"), []interface{}{int32(1)}).Return(scanRow(userColumnCount, errors.New("connection refused"), nil)).Once()
+	mockDB.On("QueryRow", mock.Anything, isQuery("GetUser"), []interface{}{int32(1)}).Return(userRow(true))
+	mockDB.On("Query", mock.Anything, isQuery("GetUserRoles"), mock.Anything).Return(NewMockRows(nil), nil)
+	mockSessionWrites(mockDB)
+	logger, _ := zap.NewDevelopment()
+	uc := NewUserController(db.New(mockDB), newTestRedis(t), logger, true)
+
+	refreshToken, _, err := uc.newRefreshToken(context.Background(), 1, "session-id")
+	require.NoError(t, err)
+
+	// neither a refusal nor a failed lookup uses the token up
+	code, response := refresh(uc, refreshToken)
+	assert.Equal(t, http.StatusForbidden, code)
+	assert.Equal(t, auth.StatusSuspended, response["status"])
+	code, _ = refresh(uc, refreshToken)
+	assert.Equal(t, http.StatusInternalServerError, code)
+
+	code, response = refresh(uc, refreshToken)
+	assert.Equal(t, http.StatusOK, code)
+	assert.NotEmpty(t, response["refresh_token"])
+	mockDB.AssertExpectations(t)
+}
+
+func TestRefreshUnknownToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	logger, _ := zap.NewDevelopment()
+	uc := NewUserController(db.New(new(MockDBTX)), newTestRedis(t), logger, true)
+
+	code, response := refresh(uc, "not-a-real-token")
+	assert.Equal(t, http.StatusUnauthorized, code)
+	assert.Equal(t, ErrRefreshTokenInvalid.Error(), response["error"])
+}
+
+func TestRefreshFromSessionCookie(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	enabled := config.AppConfig.SessionCookie.Enabled
+	config.AppConfig.SessionCookie.Enabled = true
+	t.Cleanup(func() { config.AppConfig.SessionCookie.Enabled = enabled })
+
+	mockDB := new(MockDBTX)
+	mockUserLookup(mockDB, 1, "tester")
+	mockSessionWrites(mockDB)
+	logger, _ := zap.NewDevelopment()
+	uc := NewUserController(db.New(mockDB), newTestRedis(t), logger, true)
+
+	refreshToken, _, err := uc.newRefreshToken(context.Background(), 1, "session-id")
+	require.NoError(t, err)
+
+	refreshWithCookie := func(csrfHeader string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request, _ = http.NewRequest("POST", "/users/refresh", nil)
+		c.Request.AddCookie(&http.Cookie{Name: auth.RefreshTokenCookie, Value: refreshToken})
+		c.Request.AddCookie(&http.Cookie{Name: auth.CSRFCookie, Value: "csrf-token"})
+		if csrfHeader != "" {
+			c.Request.Header.Set(auth.CSRFHeader, csrfHeader)
+		}
+		uc.Refresh(c)
+		return w
+	}
+
+	// another site can make the browser send the cookie, but not the CSRF token
+	w := refreshWithCookie("")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = refreshWithCookie("csrf-token")
+	require.Equal(t, http.StatusOK, w.Code)
+	var response map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.NotEmpty(t, response["csrf_token"])
+
+	cookies := map[string]*http.Cookie{}
+	for _, cookie := range w.Result().Cookies() {
+		cookies[cookie.Name] = cookie
+	}
+	require.Contains(t, cookies, auth.AccessTokenCookie)
+	assert.Equal(t, response["token"], cookies[auth.AccessTokenCookie].Value)
+	assert.True(t, cookies[auth.AccessTokenCookie].HttpOnly)
+	assert.True(t, cookies[auth.AccessTokenCookie].Secure)
+	require.Contains(t, cookies, auth.RefreshTokenCookie)
+	assert.Equal(t, response["refresh_token"], cookies[auth.RefreshTokenCookie].Value)
+	assert.NotEqual(t, refreshToken, cookies[auth.RefreshTokenCookie].Value)
+	require.Contains(t, cookies, auth.CSRFCookie)
+	assert.Equal(t, response["csrf_token"], cookies[auth.CSRFCookie].Value)
+	assert.False(t, cookies[auth.CSRFCookie].HttpOnly)
+}
@@ -0,0 +1,103 @@
+package controller
+
+import (
+	"main/db"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+type RoleRequest struct {
+	Role string `json:"role" binding:"required"`
+}
+
+// AddUserRole godoc
+// @Summary Grant a role to a user
+// @Description Grant a role such as admin or moderator to a user. The role shows up in the user's next token
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Param role body RoleRequest true "Role"
+// @Success 200 {object} gin.H "Message"
+// @Failure 400 {object} gin.H "Bad Request"
+// @Failure 404 {object} gin.H "Not Found"
+// @Failure 500 {object} gin.H "Internal Server Error"
+// @Router /admin/users/{id}/roles [post]
+func (uc *UserController) AddUserRole(c *gin.Context) {
+	userRequests.WithLabelValues("POST", "/admin/users/:id/roles").Inc()
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var req RoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if _, err := uc.Queries.GetUser(c.Request.Context(), int32(id)); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+
+	if _, err := uc.Queries.AddUserRole(c.Request.Context(), db.AddUserRoleParams{UserID: int32(id), Role: req.Role}); err != nil {
+		uc.Logger.Error("Failed to add role", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	roles, err := uc.Queries.GetUserRoles(c.Request.Context(), int32(id))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !slices.Contains(roles, req.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown role", "role": req.Role})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Role added", "roles": roles})
+}
+
+// RemoveUserRole godoc
+// @Summary Revoke a role from a user
+// @Description Revoke a role from a user. Tokens issued before keep the role until they are refreshed
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Param role path string true "Role"
+// @Success 200 {object} gin.H "Message"
+// @Failure 400 {object} gin.H "Bad Request"
+// @Failure 404 {object} gin.H "Not Found"
+// @Failure 500 {object} gin.H "Internal Server Error"
+// @Router /admin/users/{id}/roles/{role} [delete]
+func (uc *UserController) RemoveUserRole(c *gin.Context) {
+	userRequests.WithLabelValues("DELETE", "/admin/users/:id/roles/:role").Inc()
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	removed, err := uc.Queries.RemoveUserRole(c.Request.Context(), db.RemoveUserRoleParams{UserID: int32(id), Role: c.Param("role")})
+	if err != nil {
+		uc.Logger.Error("Failed to remove role", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if removed == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user does not have this role"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Role removed"})
+}
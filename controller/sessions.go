@@ -0,0 +1,99 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"main/auth"
+	"main/db"
+	"main/dto"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+)
+
+// endSession revokes a session of the user: its refresh tokens stop working
+// and its access tokens are refused from now on.
+func (uc *UserController) endSession(ctx context.Context, userID int32, sessionID string) error {
+	session, err := uc.Queries.RevokeSession(ctx, db.RevokeSessionParams{ID: sessionID, UserID: userID})
+	if err != nil {
+		return err
+	}
+
+	if err := uc.dropRefreshFamily(ctx, userID, session.RefreshFamily); err != nil {
+		return err
+	}
+	return auth.RevokeSession(ctx, uc.RedisClient, session.ID)
+}
+
+// ListSessions godoc
+// @Summary List active sessions
+// @Description List the devices the user is logged in on, most recently seen first. The session of the calling token is marked as current.
+// @Tags sessions
+// @Accept json
+// @Produce json
+// @Success 200 {array} dto.Session "Sessions"
+// @Failure 401 {object} gin.H "Unauthorized"
+// @Failure 500 {object} gin.H "Internal Server Error"
+// @Router /users/me/sessions [get]
+func (uc *UserController) ListSessions(c *gin.Context) {
+	userRequests.WithLabelValues("GET", "/users/me/sessions").Inc()
+	claims, ok := auth.FromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	userID, ok := claims.UserID()
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	sessions, err := uc.Queries.ListSessions(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.NewSessions(sessions, claims.SessionID))
+}
+
+// RevokeSession godoc
+// @Summary Sign out a session
+// @Description Sign out one of the user's devices. Its refresh token stops working and its access tokens are refused right away.
+// @Tags sessions
+// @Accept json
+// @Produce json
+// @Param id path string true "Session ID"
+// @Success 200 {object} gin.H "Message"
+// @Failure 401 {object} gin.H "Unauthorized"
+// @Failure 404 {object} gin.H "Not Found"
+// @Failure 500 {object} gin.H "Internal Server Error"
+// @Router /users/me/sessions/{id} [delete]
+func (uc *UserController) RevokeSession(c *gin.Context) {
+	userRequests.WithLabelValues("DELETE", "/users/me/sessions/:id").Inc()
+	claims, ok := auth.FromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	userID, ok := claims.UserID()
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	err := uc.endSession(c.Request.Context(), userID, c.Param("id"))
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+		return
+	}
+	if err != nil {
+		uc.Logger.Error("Failed to revoke session", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke session"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Session signed out"})
+}
@@ -0,0 +1,136 @@
+package controller
+
+import (
+	"main/auth"
+	"main/config"
+	"main/db"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func isSessionWrite(sql string) bool {
+	return strings.Contains(sql, "sessions")
+}
+
+// mockSessionWrites accepts the writes that keep track of sessions. It has to be
+// set up before any catch-all Exec expectation.
+func mockSessionWrites(mockDB *MockDBTX) {
+	mockDB.On("Exec", mock.Anything, mock.MatchedBy(isSessionWrite), mock.Anything).Return(pgconn.NewCommandTag("UPDATE 1"), nil)
+}
+
+func TestSessions(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	previous := config.AppConfig.JWT
+	t.Cleanup(func() { config.AppConfig.JWT = previous })
+	config.AppConfig.JWT.Algorithm = "HS256"
+	config.AppConfig.JWT.Key = "test-key"
+
+	mockDB := new(MockDBTX)
+	mockTOTP(mockDB, "")
+	mockSessionWrites(mockDB)
+
+	// RevokeSession finds the session the login creates below
+	var created db.CreateSessionParams
+	revokedRow := new(MockRow)
+	revokedRow.On("Scan",
+		mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything,
+		mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything,
+	).Return(nil).Run(func(args mock.Arguments) {
+		*args.Get(0).(*string) = created.ID
+		*args.Get(1).(*int32) = 1
+		*args.Get(3).(*string) = created.RefreshFamily
+		*args.Get(9).(*pgtype.Timestamp) = pgtype.Timestamp{Time: time.Now(), Valid: true}
+	})
+	mockDB.On("QueryRow", mock.Anything, isQuery("RevokeSession"), mock.MatchedBy(func(args []interface{}) bool {
+		return args[0] == created.ID
+	})).Return(revokedRow)
+
+	unknownRow := new(MockRow)
+	unknownRow.On("Scan",
+		mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything,
+		mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything,
+	).Return(pgx.ErrNoRows)
+	mockDB.On("QueryRow", mock.Anything, isQuery("RevokeSession"), mock.Anything).Return(unknownRow)
+
+	mockUserWithPassword(mockDB, "password123")
+
+	logger, _ := zap.NewDevelopment()
+	redisClient := newTestRedis(t)
+	uc := NewUserController(db.New(mockDB), redisClient, logger, true)
+
+	w, response := login(uc, "tester", "password123")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// the login started a session that its tokens carry
+	for _, call := range mockDB.Calls {
+		if call.Method == "Exec" && strings.Contains(call.Arguments.String(1), "CreateSession") {
+			params := call.Arguments.Get(2).([]interface{})
+			created.ID, created.TokenID, created.RefreshFamily = params[0].(string), params[2].(string), params[3].(string)
+			created.Ip = params[5].(string)
+		}
+	}
+	require.NotEmpty(t, created.ID)
+	assert.Equal(t, "10.0.0.1", created.Ip)
+
+	claims, err := auth.ParseJWT(response["token"].(string))
+	require.NoError(t, err)
+	assert.Equal(t, created.ID, claims.SessionID)
+	assert.Equal(t, created.TokenID, claims.Id)
+
+	revoked, err := auth.IsRevoked(t.Context(), redisClient, claims)
+	require.NoError(t, err)
+	assert.False(t, revoked)
+
+	// signing the session out from elsewhere
+	revokeSession := func(sessionID string) int {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Set(auth.ClaimsKey, &auth.Claims{Username: "tester", SessionID: "other", StandardClaims: jwt.StandardClaims{Subject: "1"}})
+		c.Params = gin.Params{{Key: "id", Value: sessionID}}
+		c.Request, _ = http.NewRequest("DELETE", "/users/me/sessions/"+sessionID, nil)
+		uc.RevokeSession(c)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusNotFound, revokeSession("unknown"))
+	assert.Equal(t, http.StatusOK, revokeSession(created.ID))
+
+	revoked, err = auth.IsRevoked(t.Context(), redisClient, claims)
+	require.NoError(t, err)
+	assert.True(t, revoked)
+
+	code, _ := refresh(uc, response["refresh_token"].(string))
+	assert.Equal(t, http.StatusUnauthorized, code)
+}
+
+func TestRefreshRevokedSession(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockDB := new(MockDBTX)
+	mockUserLookup(mockDB, 1, "tester")
+	// the session row was revoked, but its refresh family is still around
+	mockDB.On("Exec", mock.Anything, isQuery("RefreshSession"), mock.Anything).Return(pgconn.NewCommandTag("UPDATE 0"), nil)
+	logger, _ := zap.NewDevelopment()
+	uc := NewUserController(db.New(mockDB), newTestRedis(t), logger, true)
+
+	token, _, err := uc.newRefreshToken(t.Context(), 1, "session-id")
+	require.NoError(t, err)
+
+	code, response := refresh(uc, token)
+	assert.Equal(t, http.StatusUnauthorized, code)
+	assert.Equal(t, ErrRefreshTokenInvalid.Error(), response["error"])
+}
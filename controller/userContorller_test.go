@@ -4,12 +4,16 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"main/db"
+	"main/passwords"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/alicebob/miniredis/v2"
 	"github.com/gin-gonic/gin"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
@@ -17,10 +21,23 @@ import (
 	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// userColumnCount is how many columns a row of the users table scans into
+const userColumnCount = 21
+
+// anyColumns matches a Scan of that many columns
+func anyColumns(count int) []interface{} {
+	matchers := make([]interface{}, count)
+	for i := range matchers {
+		matchers[i] = mock.Anything
+	}
+	return matchers
+}
+
 // MockRow implements pgx.Row interface
 type MockRow struct {
 	mock.Mock
@@ -34,6 +51,58 @@ func (m *MockRow) Scan(dest ...interface{}) error {
 	return nil
 }
 
+// MockRows implements pgx.Rows over a fixed set of rows
+type MockRows struct {
+	rows  [][]interface{}
+	index int
+}
+
+func NewMockRows(rows [][]interface{}) *MockRows {
+	return &MockRows{rows: rows, index: -1}
+}
+
+func (m *MockRows) Next() bool {
+	m.index++
+	return m.index < len(m.rows)
+}
+
+func (m *MockRows) Scan(dest ...interface{}) error {
+	if m.index < 0 || m.index >= len(m.rows) {
+		return pgx.ErrNoRows
+	}
+	for i, val := range m.rows[m.index] {
+		if val == nil {
+			continue
+		}
+		switch d := dest[i].(type) {
+		case *int32:
+			*d = val.(int32)
+		case *string:
+			*d = val.(string)
+		case *float32:
+			*d = val.(float32)
+		case *bool:
+			*d = val.(bool)
+		default:
+			return errors.New("unsupported type")
+		}
+	}
+	return nil
+}
+
+func (m *MockRows) Err() error                                   { return nil }
+func (m *MockRows) Close()                                       {}
+func (m *MockRows) CommandTag() pgconn.CommandTag                { return pgconn.NewCommandTag("SELECT 1") }
+func (m *MockRows) FieldDescriptions() []pgconn.FieldDescription { return nil }
+func (m *MockRows) RawValues() [][]byte                          { return nil }
+func (m *MockRows) Conn() *pgx.Conn                              { return nil }
+func (m *MockRows) Values() ([]interface{}, error) {
+	if m.index < 0 || m.index >= len(m.rows) {
+		return nil, pgx.ErrNoRows
+	}
+	return m.rows[m.index], nil
+}
+
 type MockDBTX struct {
 	mock.Mock
 }
@@ -52,6 +121,13 @@ func (m *MockDBTX) QueryRow(ctx context.Context, sql string, args ...interface{}
 	return m.Called(ctx, sql, args).Get(0).(pgx.Row)
 }
 
+// newTestRedis starts an in-memory redis server that lives as long as the test
+func newTestRedis(t *testing.T) *redis.Client {
+	t.Helper()
+	server := miniredis.RunT(t)
+	return redis.NewClient(&redis.Options{Addr: server.Addr()})
+}
+
 func TestSignUP(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 
@@ -76,10 +152,12 @@ func TestSignUP(t *testing.T) {
 					mock.Anything,
 					mock.Anything,
 					mock.Anything,
+					mock.Anything,
 				).Run(func(args mock.Arguments) {
-					*args.Get(0).(*string) = "tester"
-					*args.Get(1).(*string) = "testuser@test.com"
-					*args.Get(2).(*pgtype.Int4) = pgtype.Int4{Int32: 25, Valid: true}
+					*args.Get(0).(*int32) = 1
+					*args.Get(1).(*string) = "tester"
+					*args.Get(2).(*string) = "testuser@test.com"
+					*args.Get(3).(*pgtype.Int4) = pgtype.Int4{Int32: 25, Valid: true}
 				}).Return(nil)
 
 				mockDB.On("QueryRow",
@@ -87,10 +165,26 @@ func TestSignUP(t *testing.T) {
 					mock.Anything,
 					mock.Anything,
 				).Return(mockRow)
+				mockDB.On("Query", mock.Anything, mock.Anything, mock.Anything).Return(NewMockRows(nil), nil)
 			},
 			expectedCode: http.StatusCreated,
 			expectedErr:  false,
 		},
+		{
+			name: "username or email taken",
+			input: db.CreateUserParams{
+				Username: "tester",
+				Password: "password",
+				Email:    "testuser@test.com",
+			},
+			mockBehavior: func(mockDB *MockDBTX) {
+				taken := &pgconn.PgError{Code: uniqueViolationCode, ConstraintName: "users_email_live_key"}
+				mockDB.On("QueryRow", mock.Anything, isQuery("CreateUser"), mock.Anything).Return(scanRow(4, taken, nil))
+				mockDB.On("Query", mock.Anything, mock.Anything, mock.Anything).Return(NewMockRows(nil), nil)
+			},
+			expectedCode: http.StatusConflict,
+			expectedErr:  true,
+		},
 		{
 			name: "Invalid Email",
 			input: db.CreateUserParams{
@@ -108,8 +202,9 @@ func TestSignUP(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			mockDB := new(MockDBTX)
+			mockSessionWrites(mockDB)
 			queries := db.New(mockDB)
-			mockRedisClient := redis.NewClient(&redis.Options{})
+			mockRedisClient := newTestRedis(t)
 			logger, _ := zap.NewDevelopment()
 			uc := NewUserController(queries, mockRedisClient, logger, true)
 
@@ -132,6 +227,7 @@ func TestSignUP(t *testing.T) {
 
 			if !tt.expectedErr {
 				assert.Contains(t, response, "token")
+				assert.Contains(t, response, "refresh_token")
 				assert.Contains(t, response, "user")
 				assert.Contains(t, response, "message")
 				assert.Equal(t, "User created successfully", response["message"])
@@ -162,9 +258,7 @@ func TestLogin(t *testing.T) {
 			},
 			mockBehavior: func(mockDB *MockDBTX) {
 				mockRows := new(MockRow)
-				mockRows.On("Scan",
-					mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything,
-				).Return(nil).Run(func(args mock.Arguments) {
+				mockRows.On("Scan", anyColumns(userColumnCount)...).Return(nil).Run(func(args mock.Arguments) {
 					*args.Get(0).(*int32) = 1
 					*args.Get(1).(*string) = "tester"
 					*args.Get(2).(*string) = "testuser@test.com"
@@ -173,7 +267,9 @@ func TestLogin(t *testing.T) {
 					*args.Get(5).(*pgtype.Int4) = pgtype.Int4{Int32: 1, Valid: true}
 					*args.Get(6).(*pgtype.Timestamp) = pgtype.Timestamp{Time: time.Now(), Valid: true}
 				})
+				mockTOTP(mockDB, "")
 				mockDB.On("QueryRow", mock.Anything, mock.Anything, mock.Anything).Return(mockRows)
+				mockDB.On("Query", mock.Anything, mock.Anything, mock.Anything).Return(NewMockRows([][]interface{}{{"admin"}}), nil)
 			},
 			expectedCode: http.StatusOK,
 			expectedErr:  false,
@@ -186,9 +282,7 @@ func TestLogin(t *testing.T) {
 			},
 			mockBehavior: func(mockDB *MockDBTX) {
 				mockRows := new(MockRow)
-				mockRows.On("Scan",
-					mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything,
-				).Return(nil).Run(func(args mock.Arguments) {
+				mockRows.On("Scan", anyColumns(userColumnCount)...).Return(nil).Run(func(args mock.Arguments) {
 					*args.Get(0).(*int32) = 1
 					*args.Get(1).(*string) = "tester"
 					*args.Get(2).(*string) = "testuser@test.com"
@@ -210,11 +304,11 @@ func TestLogin(t *testing.T) {
 			},
 			mockBehavior: func(mockDB *MockDBTX) {
 				mockRow := new(MockRow)
-				mockRow.On("Scan", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+				mockRow.On("Scan", anyColumns(userColumnCount)...).
 					Return(pgx.ErrNoRows)
 				mockDB.On("QueryRow", mock.Anything, mock.Anything, mock.Anything).Return(mockRow)
 			},
-			expectedCode: http.StatusNotFound,
+			expectedCode: http.StatusUnauthorized,
 			expectedErr:  true,
 		},
 	}
@@ -222,8 +316,9 @@ func TestLogin(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			mockDB := new(MockDBTX)
+			mockSessionWrites(mockDB)
 			queries := db.New(mockDB)
-			mockRedisClient := redis.NewClient(&redis.Options{})
+			mockRedisClient := newTestRedis(t)
 			logger, _ := zap.NewDevelopment()
 			uc := NewUserController(queries, mockRedisClient, logger, true)
 
@@ -247,6 +342,7 @@ func TestLogin(t *testing.T) {
 			if !tt.expectedErr {
 				assert.Contains(t, response, "token")
 				assert.NotEmpty(t, response["token"])
+				assert.NotEmpty(t, response["refresh_token"])
 			} else {
 				assert.Contains(t, response, "error")
 				assert.NotEmpty(t, response["error"])
@@ -254,3 +350,33 @@ func TestLogin(t *testing.T) {
 		})
 	}
 }
+
+func TestLoginRehashesPassword(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockDB := new(MockDBTX)
+	mockSessionWrites(mockDB)
+	mockTOTP(mockDB, "")
+	// the stored hash is bcrypt, the configured algorithm is argon2id
+	mockUserWithPassword(mockDB, "password123")
+
+	logger, _ := zap.NewDevelopment()
+	uc := NewUserController(db.New(mockDB), newTestRedis(t), logger, true)
+
+	w, _ := login(uc, "tester", "password123")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var rehashed string
+	for _, call := range mockDB.Calls {
+		if call.Method != "QueryRow" {
+			continue
+		}
+		for _, arg := range call.Arguments.Get(2).([]interface{}) {
+			if hash, ok := arg.(string); ok && strings.HasPrefix(hash, "$argon2id$") {
+				rehashed = hash
+			}
+		}
+	}
+	require.NotEmpty(t, rehashed)
+	assert.NoError(t, passwords.Verify("password123", rehashed))
+}
@@ -1,31 +1,42 @@
 package controller
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
 	"github.com/jackc/pgx/v5"
+	"main/auth"
 	"main/config"
 	"main/db"
+	"main/dto"
+	"main/mailer"
+	"main/oidc"
+	"main/passwords"
+	"math"
 	"net/http"
 	"regexp"
 	"strconv"
-	"time"
+	"sync"
 
 	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/redis/go-redis/v9"
 	"go.uber.org/zap"
 
 	"github.com/prometheus/client_golang/prometheus"
-
-	"github.com/dgrijalva/jwt-go"
-	"golang.org/x/crypto/bcrypt"
 )
 
 type UserController struct {
 	Queries     *db.Queries
 	RedisClient *redis.Client
 	Logger      *zap.Logger
+	Mailer      mailer.Mailer
+	OIDC        *oidc.Registry
+	// Rooms disconnects users whose account gets restricted, when set
+	Rooms Disconnector
+
+	// background tracks work that goes on after the response, see inBackground
+	background sync.WaitGroup
 }
 
 var (
@@ -43,37 +54,25 @@ func NewUserController(queries *db.Queries, redisClient *redis.Client, logger *z
 		prometheus.MustRegister(userRequests)
 		prometheusRegistered = true
 	}
-	return &UserController{Queries: queries, RedisClient: redisClient, Logger: logger}
-}
-
-type Claims struct {
-	Username string `json:"username"`
-	jwt.StandardClaims
-}
-
-func GenerateJWT(username string) (string, error) {
-	expirationTime := time.Now().Add(15 * time.Minute)
-
-	claims := &Claims{
-		Username: username,
-		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: expirationTime.Unix(),
-		},
+	return &UserController{
+		Queries:     queries,
+		RedisClient: redisClient,
+		Logger:      logger,
+		Mailer:      mailer.New(logger),
+		OIDC:        oidc.NewRegistry(config.AppConfig.OIDC.Providers, nil),
 	}
-
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(config.AppConfig.JWT.Key))
 }
 
 // SignUp godoc
 // @Summary Sign up a user
-// @Description Register a new user with their username, email, and password
+// @Description Register a new user with their username, email, and password. The password has to meet the password policy, a 400 lists every rule it breaks. A verification link is mailed to the address.
 // @Tags users
 // @Accept json
 // @Produce json
 // @Param user body db.CreateUserParams true "User Data"
-// @Success 200 {object} db.User "Registered User"
+// @Success 201 {object} dto.SelfUser "Registered User"
 // @Failure 400 {object} gin.H "Bad Request"
+// @Failure 409 {object} gin.H "Conflict"
 // @Failure 500 {object} gin.H "Internal Server Error"
 // @Router /users/signup [post]
 func (uc *UserController) SignUp(c *gin.Context) {
@@ -102,30 +101,50 @@ func (uc *UserController) SignUp(c *gin.Context) {
 		return
 	}
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(params.Password), bcrypt.DefaultCost)
+	if !uc.checkPasswordPolicy(c, params.Password, passwords.Subject{Username: params.Username, Email: params.Email}) {
+		return
+	}
+
+	hashedPassword, err := passwords.Hash(params.Password)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "message": "this is to hash password"})
 		return
 	}
-	params.Password = string(hashedPassword)
+	params.Password = hashedPassword
 
 	user, err := uc.Queries.CreateUser(c.Request.Context(), params)
+	if _, taken := uniqueViolation(err); taken {
+		c.JSON(http.StatusConflict, gin.H{"error": "username or email address is already taken"})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "message": "this is to work with db"})
 		return
 	}
+	uc.rememberPassword(c.Request.Context(), user.ID, hashedPassword)
+
+	// The user can ask for another email if this one does not arrive
+	if _, err := uc.throttleVerificationEmail(c.Request.Context(), user.Email); err != nil {
+		uc.Logger.Warn("Failed to throttle verification email", zap.Error(err))
+	}
+	if err := uc.sendVerificationEmail(c.Request.Context(), user.ID, user.Username, user.Email); err != nil {
+		uc.Logger.Error("Failed to send verification email", zap.Error(err))
+	}
+
+	if auth.EmailPolicy() == auth.EmailPolicyLogin {
+		c.JSON(http.StatusCreated, gin.H{"message": "User created successfully, verify your email address to log in", "user": dto.NewCreatedUser(user)})
+		return
+	}
 
-	token, err := GenerateJWT(user.Username)
+	tokens, err := uc.issueTokens(c.Request.Context(), db.User{ID: user.ID, Username: user.Username}, deviceOf(c))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "message": "this is to generate the token"})
 		return
 	}
 
-	c.JSON(http.StatusCreated, gin.H{
-		"message": "User created successfully",
-		"user":    user,
-		"token":   token,
-	})
+	tokens["message"] = "User created successfully"
+	tokens["user"] = dto.NewCreatedUser(user)
+	uc.respondWithTokens(c, http.StatusCreated, tokens)
 
 }
 
@@ -136,14 +155,16 @@ type LoginRequest struct {
 
 // Login godoc
 // @Summary Login a user
-// @Description Authenticate a user with their username and password
+// @Description Authenticate a user with their username and password. Accounts with two-factor authentication get an mfa_token to finish the login with at /users/login/mfa. With session cookies enabled the tokens are also set as HttpOnly cookies, and the answer carries the csrf_token to send in the X-CSRF-Token header of state-changing requests.
 // @Tags users
 // @Accept json
 // @Produce json
 // @Param user body LoginRequest true "User Data"
-// @Success 200 {object} gin.H "Token"
+// @Success 200 {object} gin.H "Token Pair"
 // @Failure 400 {object} gin.H "Bad Request"
 // @Failure 401 {object} gin.H "Unauthorized"
+// @Failure 403 {object} gin.H "Forbidden"
+// @Failure 429 {object} gin.H "Too Many Requests"
 // @Failure 500 {object} gin.H "Internal Server Error"
 // @Router /users/login [post]
 func (uc *UserController) Login(c *gin.Context) {
@@ -155,49 +176,213 @@ func (uc *UserController) Login(c *gin.Context) {
 	}
 
 	user, err := uc.Queries.GetUserByUsername(c.Request.Context(), params.Username)
+	known := err == nil
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	account := unknownLoginAccount(params.Username)
+	if known {
+		account = loginAccount(user.ID)
+	}
+
+	lockout, err := uc.loginLockout(c.Request.Context(), account, c.ClientIP())
 	if err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if lockout > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(lockout.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many failed login attempts, try again later"})
+		return
+	}
+
+	if !known {
+		compareDummyPassword(params.Password)
+		uc.rejectLogin(c, account)
+		return
+	}
+
+	if err := passwords.Verify(params.Password, user.Password); err != nil {
+		if !errors.Is(err, passwords.ErrMismatch) {
+			uc.Logger.Error("Failed to verify password", zap.Error(err))
+		}
+		uc.rejectLogin(c, account)
+		return
+	}
+	uc.upgradePasswordHash(c.Request.Context(), user, params.Password)
+
+	if refuseRestricted(c, user) {
+		return
+	}
+
+	if !user.EmailVerifiedAt.Valid && auth.EmailPolicy() == auth.EmailPolicyLogin {
+		c.JSON(http.StatusForbidden, gin.H{"error": "email address is not verified"})
+		return
+	}
+
+	mfaEnabled, err := uc.mfaEnabled(c.Request.Context(), user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if mfaEnabled {
+		// Failed attempts are only cleared once the second factor is checked as well
+		challenge, err := uc.mfaChallenge(c.Request.Context(), user)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
+		c.JSON(http.StatusOK, challenge)
+		return
+	}
+
+	if err := uc.resetLoginFailures(c.Request.Context(), user.ID); err != nil {
+		uc.Logger.Warn("Failed to reset login failures", zap.Error(err))
+	}
+
+	tokens, err := uc.issueTokens(c.Request.Context(), user, deviceOf(c))
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(params.Password)); err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+	uc.respondWithTokens(c, http.StatusOK, tokens)
+
+}
+
+// upgradePasswordHash rehashes the password of a user who just logged in when the
+// stored hash uses an older algorithm or weaker parameters than configured.
+// Failing to do so is not fatal, the next login tries again.
+func (uc *UserController) upgradePasswordHash(ctx context.Context, user db.User, password string) {
+	if !passwords.NeedsRehash(user.Password) {
 		return
 	}
 
-	token, err := GenerateJWT(user.Username)
+	hashedPassword, err := passwords.Hash(password)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		uc.Logger.Warn("Failed to rehash password", zap.Error(err))
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"token": token})
+	_, err = uc.Queries.UpdateUserPassword(ctx, db.UpdateUserPasswordParams{ID: user.ID, Password: hashedPassword})
+	if err != nil {
+		uc.Logger.Warn("Failed to store rehashed password", zap.Error(err))
+		return
+	}
+	uc.Logger.Info("Upgraded password hash", zap.Int32("user_id", user.ID))
+}
 
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token"`
 }
 
 // Logout godoc
 // @Summary Logout a user
-// @Description Invalidate the user's token
+// @Description Revoke the user's access token and end its session, which also revokes the refresh token it came with. Session cookies are cleared.
 // @Tags users
 // @Accept json
 // @Produce json
+// @Param token body LogoutRequest false "Refresh Token"
 // @Success 200 {object} gin.H "Message"
 // @Failure 400 {object} gin.H "Bad Request"
+// @Failure 401 {object} gin.H "Unauthorized"
+// @Failure 500 {object} gin.H "Internal Server Error"
 // @Router /users/logout [post]
 func (uc *UserController) Logout(c *gin.Context) {
 	userRequests.WithLabelValues("POST", "/logout").Inc()
-	token := c.GetHeader("Authorization")
-	if token == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "No token provided"})
+	claims, ok := auth.FromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var req LogoutRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	if err := auth.RevokeToken(c.Request.Context(), uc.RedisClient, claims); err != nil {
+		uc.Logger.Error("Failed to revoke token", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke token"})
 		return
 	}
+
+	userID, ok := claims.UserID()
+	if ok && req.RefreshToken != "" {
+		if err := uc.revokeRefreshToken(c.Request.Context(), userID, req.RefreshToken); err != nil {
+			uc.Logger.Error("Failed to revoke refresh token", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke refresh token"})
+			return
+		}
+	}
+
+	if ok && claims.SessionID != "" {
+		err := uc.endSession(c.Request.Context(), userID, claims.SessionID)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			uc.Logger.Error("Failed to end session", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to end session"})
+			return
+		}
+	}
+
+	if auth.SessionCookiesEnabled() {
+		auth.ClearSessionCookies(c)
+	}
 	c.JSON(http.StatusOK, gin.H{"message": "Successfully logged out"})
 }
 
+// LogoutAll godoc
+// @Summary Logout a user everywhere
+// @Description Revoke every access token and refresh token issued to the user
+// @Tags users
+// @Accept json
+// @Produce json
+// @Success 200 {object} gin.H "Message"
+// @Failure 401 {object} gin.H "Unauthorized"
+// @Failure 500 {object} gin.H "Internal Server Error"
+// @Router /users/logout-all [post]
+func (uc *UserController) LogoutAll(c *gin.Context) {
+	userRequests.WithLabelValues("POST", "/logout-all").Inc()
+	claims, ok := auth.FromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	userID, ok := claims.UserID()
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	if err := uc.revokeAllTokens(c.Request.Context(), userID); err != nil {
+		uc.Logger.Error("Failed to revoke tokens", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke tokens"})
+		return
+	}
+
+	if auth.SessionCookiesEnabled() {
+		auth.ClearSessionCookies(c)
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Successfully logged out from all devices"})
+}
+
+// revokeAllTokens bumps the user's token generation, drops all of their refresh
+// token families and ends all of their sessions.
+func (uc *UserController) revokeAllTokens(ctx context.Context, userID int32) error {
+	if _, err := auth.BumpTokenGeneration(ctx, uc.RedisClient, strconv.Itoa(int(userID))); err != nil {
+		return err
+	}
+	if err := uc.revokeAllRefreshTokens(ctx, userID); err != nil {
+		return err
+	}
+	return uc.Queries.RevokeUserSessions(ctx, userID)
+}
+
 type ChangePasswordRequest struct {
 	OldUserPassword string `json:"old_password"`
 	NewUserPassword string `json:"new_password"`
@@ -205,7 +390,7 @@ type ChangePasswordRequest struct {
 
 // ChangePassword godoc
 // @Summary Change a user's password
-// @Description Update the user's password
+// @Description Update the user's password. The new password has to meet the password policy, a 400 lists every rule it breaks.
 // @Tags users
 // @Accept json
 // @Produce json
@@ -242,17 +427,21 @@ func (uc *UserController) ChangePassword(c *gin.Context) {
 		return
 	}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.OldUserPassword)); err != nil {
+	if err := passwords.Verify(req.OldUserPassword, user.Password); err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Incorrect old password"})
 		return
 	}
 
-	if req.NewUserPassword == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Password cannot be empty"})
+	subject, err := uc.passwordSubject(c.Request.Context(), user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not retrieve password history"})
+		return
+	}
+	if !uc.checkPasswordPolicy(c, req.NewUserPassword, subject) {
 		return
 	}
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.NewUserPassword), bcrypt.DefaultCost)
+	hashedPassword, err := passwords.Hash(req.NewUserPassword)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process new password"})
 		return
@@ -260,26 +449,28 @@ func (uc *UserController) ChangePassword(c *gin.Context) {
 
 	updateParams := db.UpdateUserPasswordParams{
 		ID:       user.ID,
-		Password: string(hashedPassword),
+		Password: hashedPassword,
 	}
 
 	if _, err := uc.Queries.UpdateUserPassword(c.Request.Context(), updateParams); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update password"})
 		return
 	}
+	uc.rememberPassword(c.Request.Context(), user.ID, hashedPassword)
 
 	c.JSON(http.StatusOK, gin.H{"message": "Password changed successfully"})
 }
 
 // DeleteUser godoc
 // @Summary Delete a user
-// @Description Delete a user by their ID
+// @Description Delete a user by their ID. They are logged out everywhere and disconnected from their rooms. An admin can restore them until they are purged after the retention period.
 // @Tags users
 // @Accept json
 // @Produce json
 // @Param id path int true "User ID"
-// @Success 200 {object} db.User "Deleted User"
+// @Success 200 {object} dto.SelfUser "Deleted User"
 // @Failure 400 {object} gin.H "Bad Request"
+// @Failure 404 {object} gin.H "Not Found"
 // @Failure 500 {object} gin.H "Internal Server Error"
 // @Router /users/{id} [delete]
 func (uc *UserController) DeleteUser(c *gin.Context) {
@@ -293,11 +484,27 @@ func (uc *UserController) DeleteUser(c *gin.Context) {
 
 	user, err := uc.Queries.DeleteUser(c.Request.Context(), int32(id))
 	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, user)
+	if err := uc.forgetCachedUser(c.Request.Context(), user.ID); err != nil {
+		uc.Logger.Warn("Failed to drop cached user", zap.Error(err))
+	}
+	if err := uc.audit(c, auditUserDelete, actorOf(c), user, "", ""); err != nil {
+		uc.Logger.Error("Failed to write deletion to the audit log", zap.Error(err))
+	}
+	if err := uc.logOutEverywhere(c.Request.Context(), user.ID); err != nil {
+		uc.Logger.Error("Failed to log deleted user out", zap.Int32("user_id", user.ID), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "the user was deleted but could not be logged out everywhere"})
+		return
+	}
+
+	c.JSON(http.StatusOK, userView(c, dto.NewAdminUser(user)))
 }
 
 // GetUser godoc
@@ -307,7 +514,7 @@ func (uc *UserController) DeleteUser(c *gin.Context) {
 // @Accept json
 // @Produce json
 // @Param id path int true "User ID"
-// @Success 200 {object} db.User "User Information"
+// @Success 200 {object} dto.PublicUser "User Information, the self or admin view where the caller may see it"
 // @Failure 400 {object} gin.H "Bad Request"
 // @Failure 500 {object} gin.H "Internal Server Error"
 // @Router /users/{id} [get]
@@ -320,107 +527,31 @@ func (uc *UserController) GetUser(c *gin.Context) {
 		return
 	}
 
-	// user, message, err := uc.CheckCache(c, id)
-	// if err != nil {
-	// 	uc.Logger.Error(err.Error())
-	// 	c.JSON(http.StatusBadRequest, gin.H{"message": message})
-	// }
-
-	// userJson, err := json.Marshal(struct {
-	// 	ID        int32  `json:"id"`
-	// 	Username  string `json:"username"`
-	// 	Email     string `json:"email"`
-	// 	Password  string `json:"password"`
-	// 	Age       int    `json:"age"`
-	// 	CreatedAt string `json:"created_at"`
-	// }{
-	// 	ID:        user.ID,
-	// 	Username:  user.Username,
-	// 	Email:     user.Email,
-	// 	Password:  user.Password,
-	// 	Age:       int(user.Age.Int32),
-	// 	CreatedAt: user.CreatedAt.Time.Format(time.RFC3339),
-	// })
-
-	// if err != nil {
-	// 	uc.Logger.Warn("Failed To Marshal User", zap.Error(err))
-	// }
-
-	// err = uc.RedisClient.Set(c.Request.Context(), strconv.Itoa(id), userJson, 10*time.Minute).Err()
-	// if err != nil {
-	// 	uc.Logger.Warn("Failed To Store User In Redis", zap.Error(err))
-	// }
-
-	// c.JSON(http.StatusOK, gin.H{"message": message, "user": user})
-
-	cachedUser, err := uc.RedisClient.Get(c.Request.Context(), strconv.Itoa(id)).Result()
-	if err != nil {
-		uc.Logger.Info("There is nothing in the redis yet or there is problem fetching data")
-	}
-	type CachedUser struct {
-		ID        int       `json:"id"`
-		Username  string    `json:"username"`
-		Email     string    `json:"email"`
-		Password  string    `json:"password"`
-		Age       int       `json:"age"`
-		CreatedAt time.Time `json:"created_at"`
-	}
-
-	var cached CachedUser
-	if err := json.Unmarshal([]byte(cachedUser), &cached); err == nil {
-		user := db.User{
-			ID:        int32(cached.ID),
-			Username:  cached.Username,
-			Email:     cached.Email,
-			Password:  cached.Password,
-			Age:       pgtype.Int4{Int32: int32(cached.Age), Valid: cached.Age != 0},
-			CreatedAt: pgtype.Timestamp{Time: cached.CreatedAt, Valid: true},
-		}
-
-		uc.Logger.Info("Returning user from cache", zap.Any("cachedUser", user))
-		c.JSON(http.StatusOK, gin.H{"source": "cache", "user": user})
-		return
-	} else if cachedUser == "" {
-		uc.Logger.Info("Cached user is empty so there is nothing to marshal", zap.Any("cached user", cachedUser))
-	} else {
-		uc.Logger.Error("There is a problem in unmarshalling", zap.Error(err))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	cached, ok, err := uc.cachedUser(c.Request.Context(), int32(id))
+	if err != nil && !errors.Is(err, redis.Nil) {
+		uc.Logger.Warn("Failed to read cached user", zap.Error(err))
+	}
+	if ok {
+		c.JSON(http.StatusOK, gin.H{"source": "cache", "user": userView(c, cached)})
 		return
 	}
 
 	user, err := uc.Queries.GetUser(c.Request.Context(), int32(id))
 	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	userJson, err := json.Marshal(struct {
-		ID        int32  `json:"id"`
-		Username  string `json:"username"`
-		Email     string `json:"email"`
-		Password  string `json:"password"`
-		Age       int    `json:"age"`
-		CreatedAt string `json:"created_at"`
-	}{
-		ID:        user.ID,
-		Username:  user.Username,
-		Email:     user.Email,
-		Password:  user.Password,
-		Age:       int(user.Age.Int32),
-		CreatedAt: user.CreatedAt.Time.Format(time.RFC3339),
-	})
-
-	if err != nil {
-		uc.Logger.Warn("Failed to marshal user", zap.Error(err))
-		return
-	}
-
-	err = uc.RedisClient.Set(c.Request.Context(), strconv.Itoa(id), userJson, 10*time.Minute).Err()
-	if err != nil {
+	view := dto.NewAdminUser(user)
+	if err := uc.cacheUser(c.Request.Context(), view); err != nil {
 		uc.Logger.Warn("Failed to cache user", zap.Error(err))
 	}
 
-	c.JSON(http.StatusOK, gin.H{"source": "database", "user": user})
+	c.JSON(http.StatusOK, gin.H{"source": "database", "user": userView(c, view)})
 }
 
 type UpdateUserRequest struct {
@@ -431,14 +562,15 @@ type UpdateUserRequest struct {
 
 // UpdateUser godoc
 // @Summary Update a user's information
-// @Description Update the user's details such as username, email, and age
+// @Description Update the user's details such as username, email, and age. A new email address has to be verified again, a verification email is sent to it.
 // @Tags users
 // @Accept json
 // @Produce json
 // @Param id path int true "User ID"
 // @Param user body UpdateUserRequest true "Updated User Data"
-// @Success 200 {object} db.User "Updated User"
+// @Success 200 {object} dto.SelfUser "Updated User"
 // @Failure 400 {object} gin.H "Bad Request"
+// @Failure 409 {object} gin.H "Conflict"
 // @Failure 500 {object} gin.H "Internal Server Error"
 // @Router /users/{id} [put]
 func (uc *UserController) UpdateUser(c *gin.Context) {
@@ -468,39 +600,102 @@ func (uc *UserController) UpdateUser(c *gin.Context) {
 	}
 
 	user, err := uc.Queries.UpdateUser(c.Request.Context(), updateParams)
+	if _, taken := uniqueViolation(err); taken {
+		c.JSON(http.StatusConflict, gin.H{"error": "username or email address is already taken"})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	userWithNoPassword := db.User{
-		ID:       user.ID,
-		Username: user.Username,
-		Email:    user.Email,
-		Age:      user.Age,
+	if err := uc.forgetCachedUser(c.Request.Context(), user.ID); err != nil {
+		uc.Logger.Warn("Failed to drop cached user", zap.Error(err))
 	}
 
-	c.JSON(http.StatusOK, userWithNoPassword)
+	// The new address lost its verification, the user has to prove they own it
+	if user.Email != existingUser.Email {
+		if _, err := uc.throttleVerificationEmail(c.Request.Context(), user.Email); err != nil {
+			uc.Logger.Warn("Failed to throttle verification email", zap.Error(err))
+		}
+		if err := uc.sendVerificationEmail(c.Request.Context(), user.ID, user.Username, user.Email); err != nil {
+			uc.Logger.Error("Failed to send verification email", zap.Error(err))
+		}
+	}
+
+	c.JSON(http.StatusOK, userView(c, dto.NewAdminUser(user)))
 }
 
 // GetUsers godoc
-// @Summary Get all users
-// @Description Retrieve all users from the database
+// @Summary List users
+// @Description List users a page at a time. Pages are continued with the next_cursor of the previous one, which is null on the last page. Users can be filtered and sorted by username, created_at, age or id, ties are broken by id. Users without an age sort as younger than everyone.
 // @Tags users
 // @Accept json
 // @Produce json
-// @Success 200 {array} db.User "List of Users"
+// @Param limit query int false "Users per page, 50 by default and 200 at most"
+// @Param cursor query string false "next_cursor of the previous page"
+// @Param sort query string false "username, created_at, age or id, username by default"
+// @Param order query string false "asc or desc, asc by default"
+// @Param min_age query int false "Only users at least this old"
+// @Param max_age query int false "Only users at most this old"
+// @Param created_after query string false "Only users created at or after this RFC 3339 time"
+// @Param created_before query string false "Only users created before this RFC 3339 time"
+// @Param room_id query int false "Only users in this room"
+// @Param email_domain query string false "Only users with an email address at this domain"
+// @Param status query string false "Only users with this stored account status, administrators only"
+// @Param include_total query bool false "Also count all users matching the filters"
+// @Success 200 {object} gin.H "Page of users, in the admin view for administrators"
+// @Failure 400 {object} gin.H "Bad Request"
 // @Failure 500 {object} gin.H "Internal Server Error"
 // @Router /users [get]
 func (uc *UserController) GetUsers(c *gin.Context) {
 	userRequests.WithLabelValues("GET", "/users").Inc()
-	users, err := uc.Queries.GetUsers(c.Request.Context())
+	params, err := userListParams(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	claims, _ := auth.FromContext(c)
+	isAdmin := claims != nil && claims.HasRole(roleAdmin)
+	// Only administrators get to see who is restricted
+	if params.Status != "" && !isAdmin {
+		c.JSON(http.StatusForbidden, gin.H{"error": "only administrators can filter by status"})
+		return
+	}
+
+	users, nextCursor, err := uc.listUsers(c, params)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, users)
+	var total *int64
+	if includeTotal, _ := strconv.ParseBool(c.Query("include_total")); includeTotal {
+		count, err := uc.Queries.CountUsers(c.Request.Context(), params.UserFilter)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		total = &count
+	}
+
+	if isAdmin {
+		c.JSON(http.StatusOK, usersPage(dto.NewAdminUsers(users), nextCursor, total))
+		return
+	}
+	c.JSON(http.StatusOK, usersPage(dto.NewPublicUsers(users), nextCursor, total))
+}
+
+// uniqueViolationCode is the SQLSTATE of writes refused by a unique index
+const uniqueViolationCode = "23505"
+
+// uniqueViolation returns the unique index a write was refused by, if it was.
+func uniqueViolation(err error) (string, bool) {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolationCode {
+		return pgErr.ConstraintName, true
+	}
+	return "", false
 }
 
 func ifNotNil[T any](value *T, defaultValue T) T {
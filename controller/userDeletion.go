@@ -0,0 +1,146 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"main/auth"
+	"main/config"
+	"main/db"
+	"main/dto"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"go.uber.org/zap"
+)
+
+const (
+	auditUserDelete  = "user.delete"
+	auditUserRestore = "user.restore"
+
+	defaultUserRetention     = 30 * 24 * time.Hour
+	defaultUserPurgeInterval = time.Hour
+	defaultUserPurgeBatch    = 100
+)
+
+// actorOf names the user the request is made by in the audit log.
+func actorOf(c *gin.Context) *auth.Actor {
+	claims, ok := auth.FromContext(c)
+	if !ok {
+		return nil
+	}
+	return &auth.Actor{Subject: claims.Subject, Username: claims.Username}
+}
+
+// RestoreUser godoc
+// @Summary Restore a deleted user
+// @Description Undo the deletion of a user that has not been purged yet. Their API keys work again where they have not expired, they have to log in again everywhere else.
+// @Tags admin
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {object} dto.AdminUser "Restored User"
+// @Failure 400 {object} gin.H "Bad Request"
+// @Failure 401 {object} gin.H "Unauthorized"
+// @Failure 403 {object} gin.H "Forbidden"
+// @Failure 404 {object} gin.H "Not Found"
+// @Failure 409 {object} gin.H "Conflict"
+// @Failure 500 {object} gin.H "Internal Server Error"
+// @Router /admin/users/{id}/restore [post]
+func (uc *UserController) RestoreUser(c *gin.Context) {
+	userRequests.WithLabelValues("POST", "/admin/users/:id/restore").Inc()
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := uc.Queries.RestoreUser(c.Request.Context(), int32(id))
+	if _, taken := uniqueViolation(err); taken {
+		c.JSON(http.StatusConflict, gin.H{"error": "the username or email address was taken by another user since the deletion"})
+		return
+	}
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "no deleted user with this id, or they were already purged"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := uc.forgetCachedUser(c.Request.Context(), user.ID); err != nil {
+		uc.Logger.Warn("Failed to drop cached user", zap.Error(err))
+	}
+	if err := uc.audit(c, auditUserRestore, actorOf(c), user, "", ""); err != nil {
+		uc.Logger.Error("Failed to write restore to the audit log", zap.Error(err))
+	}
+
+	c.JSON(http.StatusOK, dto.NewAdminUser(user))
+}
+
+// PurgeDeletedUsers removes the users deleted longer ago than the retention period
+// for good, or anonymizes them when configured to. Either way they leave their room
+// and their cache entry is dropped. It returns how many it purged.
+func (uc *UserController) PurgeDeletedUsers(ctx context.Context) (int, error) {
+	cfg := config.AppConfig.UserPurge
+	retention := pgtype.Interval{Microseconds: durationOr(cfg.Retention, defaultUserRetention).Microseconds(), Valid: true}
+	batch := intOr(cfg.BatchSize, defaultUserPurgeBatch)
+
+	purged := 0
+	for {
+		ids, err := uc.Queries.ListPurgeableUsers(ctx, db.ListPurgeableUsersParams{Retention: retention, MaxUsers: int32(batch)})
+		if err != nil {
+			return purged, err
+		}
+
+		for _, id := range ids {
+			var rows int64
+			if cfg.Anonymize {
+				rows, err = uc.Queries.AnonymizeUser(ctx, db.AnonymizeUserParams{ID: id, Retention: retention})
+			} else {
+				rows, err = uc.Queries.PurgeUser(ctx, db.PurgeUserParams{ID: id, Retention: retention})
+			}
+			if err != nil {
+				return purged, err
+			}
+			// The user was restored meanwhile
+			if rows == 0 {
+				continue
+			}
+			purged++
+
+			if err := uc.forgetCachedUser(ctx, id); err != nil {
+				uc.Logger.Warn("Failed to drop cached user", zap.Int32("user_id", id), zap.Error(err))
+			}
+		}
+
+		if len(ids) < batch {
+			return purged, nil
+		}
+	}
+}
+
+// RunUserPurge purges deleted users every interval until ctx is done.
+func (uc *UserController) RunUserPurge(ctx context.Context) {
+	ticker := time.NewTicker(durationOr(config.AppConfig.UserPurge.Interval, defaultUserPurgeInterval))
+	defer ticker.Stop()
+
+	for {
+		purged, err := uc.PurgeDeletedUsers(ctx)
+		if err != nil && ctx.Err() == nil {
+			uc.Logger.Error("Failed to purge deleted users", zap.Error(err))
+		}
+		if purged > 0 {
+			uc.Logger.Info("Purged deleted users", zap.Int("users", purged), zap.Bool("anonymized", config.AppConfig.UserPurge.Anonymize))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"main/auth"
+	"main/config"
+	"main/db"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestRestoreUser(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockDB := new(MockDBTX)
+	mockDB.On("QueryRow", mock.Anything, isQuery("RestoreUser"), []interface{}{int32(1)}).Return(userRow(true)).Once()
+	mockDB.On("QueryRow", mock.Anything, isQuery("RestoreUser"), []interface{}{int32(2)}).Return(scanRow(userColumnCount, pgx.ErrNoRows, nil)).Once()
+	taken := &pgconn.PgError{Code: uniqueViolationCode, ConstraintName: "users_username_live_key"}
+	mockDB.On("QueryRow", mock.Anything, isQuery("RestoreUser"), []interface{}{int32(4)}).Return(scanRow(userColumnCount, taken, nil)).Once()
+	mockDB.On("Exec", mock.Anything, isQuery("CreateAuditLogEntry"), mock.Anything).Return(pgconn.NewCommandTag("INSERT 0 1"), nil)
+	logger, _ := zap.NewDevelopment()
+	redisClient := newTestRedis(t)
+	uc := NewUserController(db.New(mockDB), redisClient, logger, true)
+	admin := &auth.Claims{Username: "root", Roles: []string{"admin"}, StandardClaims: jwt.StandardClaims{Subject: "3"}}
+
+	restore := func(id string) (int, map[string]interface{}) {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request, _ = http.NewRequest("POST", "/admin/users/"+id+"/restore", nil)
+		c.Params = []gin.Param{{Key: "id", Value: id}}
+		c.Set(auth.ClaimsKey, admin)
+
+		uc.RestoreUser(c)
+
+		var response map[string]interface{}
+		_ = json.Unmarshal(w.Body.Bytes(), &response)
+		return w.Code, response
+	}
+
+	// the cached view from before the restore still shows them deleted
+	require.NoError(t, redisClient.Set(context.Background(), userCacheKey(1), "{}", 0).Err())
+	code, response := restore("1")
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "tester", response["username"])
+	exists, err := redisClient.Exists(context.Background(), userCacheKey(1)).Result()
+	require.NoError(t, err)
+	assert.Zero(t, exists)
+	mockDB.AssertCalled(t, "Exec", mock.Anything, isQuery("CreateAuditLogEntry"), mock.MatchedBy(func(args []interface{}) bool {
+		return args[0] == auditUserRestore && args[2] == "root" && args[4] == "tester"
+	}))
+
+	// never deleted, already purged or restored
+	code, _ = restore("2")
+	assert.Equal(t, http.StatusNotFound, code)
+
+	// someone signed up with their username while they were deleted
+	code, _ = restore("4")
+	assert.Equal(t, http.StatusConflict, code)
+
+	code, _ = restore("me")
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+func TestDeleteUserLogsOut(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockDB := new(MockDBTX)
+	mockDB.On("QueryRow", mock.Anything, isQuery("DeleteUser"), []interface{}{int32(1)}).Return(userRow(true)).Once()
+	mockDB.On("Exec", mock.Anything, isQuery("CreateAuditLogEntry"), mock.Anything).Return(pgconn.NewCommandTag("INSERT 0 1"), nil)
+	mockDB.On("Exec", mock.Anything, isQuery("RevokeUserSessions"), []interface{}{int32(1)}).Return(pgconn.NewCommandTag("UPDATE 1"), nil).Once()
+	logger, _ := zap.NewDevelopment()
+	redisClient := newTestRedis(t)
+	uc := NewUserController(db.New(mockDB), redisClient, logger, true)
+	rooms := &recordingDisconnector{}
+	uc.Rooms = rooms
+
+	refreshToken, _, err := uc.newRefreshToken(context.Background(), 1, "session-id")
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("DELETE", "/users/1", nil)
+	c.Params = []gin.Param{{Key: "id", Value: "1"}}
+	c.Set(auth.ClaimsKey, &auth.Claims{Username: "root", Roles: []string{"admin"}, StandardClaims: jwt.StandardClaims{Subject: "3"}})
+
+	uc.DeleteUser(c)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []int32{1}, rooms.disconnected)
+	generation, err := auth.TokenGeneration(context.Background(), redisClient, "1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), generation, "access tokens issued so far are refused")
+	_, err = uc.rotateRefreshToken(context.Background(), refreshToken)
+	assert.ErrorIs(t, err, ErrRefreshTokenInvalid)
+	mockDB.AssertExpectations(t)
+}
+
+func TestPurgeDeletedUsers(t *testing.T) {
+	purge := config.AppConfig.UserPurge
+	t.Cleanup(func() { config.AppConfig.UserPurge = purge })
+	config.AppConfig.UserPurge.BatchSize = 2
+
+	for _, anonymize := range []bool{false, true} {
+		config.AppConfig.UserPurge.Anonymize = anonymize
+		purgeQuery := "PurgeUser"
+		if anonymize {
+			purgeQuery = "AnonymizeUser"
+		}
+
+		mockDB := new(MockDBTX)
+		// a full batch is followed by another look
+		mockDB.On("Query", mock.Anything, isQuery("ListPurgeableUsers"), mock.Anything).Return(NewMockRows([][]interface{}{{int32(1)}, {int32(2)}}), nil).Once()
+		mockDB.On("Query", mock.Anything, isQuery("ListPurgeableUsers"), mock.Anything).Return(NewMockRows([][]interface{}{{int32(3)}}), nil).Once()
+		mockDB.On("Exec", mock.Anything, isQuery(purgeQuery), mock.MatchedBy(func(args []interface{}) bool { return args[0] != int32(2) })).
+			Return(pgconn.NewCommandTag("UPDATE 1"), nil)
+		// user 2 was restored after they were listed
+		mockDB.On("Exec", mock.Anything, isQuery(purgeQuery), mock.MatchedBy(func(args []interface{}) bool { return args[0] == int32(2) })).
+			Return(pgconn.NewCommandTag("UPDATE 0"), nil)
+
+		redisClient := newTestRedis(t)
+		for _, id := range []int32{1, 2, 3} {
+			require.NoError(t, redisClient.Set(context.Background(), userCacheKey(id), "{}", 0).Err())
+		}
+		logger, _ := zap.NewDevelopment()
+		uc := NewUserController(db.New(mockDB), redisClient, logger, true)
+
+		purged, err := uc.PurgeDeletedUsers(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, 2, purged)
+		mockDB.AssertExpectations(t)
+
+		for id, cached := range map[int32]int64{1: 0, 2: 1, 3: 0} {
+			exists, err := redisClient.Exists(context.Background(), userCacheKey(id)).Result()
+			require.NoError(t, err)
+			assert.Equal(t, cached, exists, "cache entry of user %d", id)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package controller
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"main/db"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const (
+	defaultUserListLimit = 50
+	maxUserListLimit     = 200
+)
+
+var ErrInvalidCursor = errors.New("cursor is invalid or belongs to another sort order")
+
+// userCursor points behind the last user of a page. It is handed out base64 encoded
+// and names the sort it was made for, as its value means nothing in any other.
+type userCursor struct {
+	Sort       db.UserSort     `json:"sort"`
+	Descending bool            `json:"desc,omitempty"`
+	Value      json.RawMessage `json:"value"`
+	ID         int32           `json:"id"`
+}
+
+func encodeUserCursor(params db.ListUsersParams, last db.User) (string, error) {
+	value, err := json.Marshal(params.Sort.Value(last))
+	if err != nil {
+		return "", err
+	}
+	cursor, err := json.Marshal(userCursor{Sort: params.Sort, Descending: params.Descending, Value: value, ID: last.ID})
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(cursor), nil
+}
+
+// decodeUserCursor continues params behind the user the cursor points to.
+func decodeUserCursor(encoded string, params *db.ListUsersParams) error {
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return ErrInvalidCursor
+	}
+	var cursor userCursor
+	if err := json.Unmarshal(raw, &cursor); err != nil {
+		return ErrInvalidCursor
+	}
+	if cursor.Sort != params.Sort || cursor.Descending != params.Descending {
+		return ErrInvalidCursor
+	}
+
+	switch cursor.Sort {
+	case db.UserSortUsername:
+		var value string
+		err = json.Unmarshal(cursor.Value, &value)
+		params.AfterValue = value
+	case db.UserSortCreatedAt:
+		var value time.Time
+		err = json.Unmarshal(cursor.Value, &value)
+		params.AfterValue = value
+	default:
+		var value int32
+		err = json.Unmarshal(cursor.Value, &value)
+		params.AfterValue = value
+	}
+	if err != nil {
+		return ErrInvalidCursor
+	}
+	params.AfterID = pgtype.Int4{Int32: cursor.ID, Valid: true}
+	return nil
+}
+
+func queryInt(c *gin.Context, name string) (pgtype.Int4, error) {
+	value := c.Query(name)
+	if value == "" {
+		return pgtype.Int4{}, nil
+	}
+	number, err := strconv.ParseInt(value, 10, 32)
+	if err != nil {
+		return pgtype.Int4{}, errors.New(name + " must be a number")
+	}
+	return pgtype.Int4{Int32: int32(number), Valid: true}, nil
+}
+
+func queryTime(c *gin.Context, name string) (pgtype.Timestamp, error) {
+	value := c.Query(name)
+	if value == "" {
+		return pgtype.Timestamp{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return pgtype.Timestamp{}, errors.New(name + " must be an RFC 3339 time")
+	}
+	return pgtype.Timestamp{Time: t.UTC(), Valid: true}, nil
+}
+
+// userListParams reads the filters, sort and page of a user listing from the query string.
+func userListParams(c *gin.Context) (db.ListUsersParams, error) {
+	params := db.ListUsersParams{Sort: db.UserSortUsername, Limit: defaultUserListLimit}
+	var err error
+
+	if sort := c.Query("sort"); sort != "" {
+		params.Sort = db.UserSort(sort)
+		if !params.Sort.Valid() {
+			return params, errors.New("sort must be one of username, created_at, age and id")
+		}
+	}
+	switch strings.ToLower(c.DefaultQuery("order", "asc")) {
+	case "asc":
+	case "desc":
+		params.Descending = true
+	default:
+		return params, errors.New("order must be asc or desc")
+	}
+	if limit := c.Query("limit"); limit != "" {
+		value, err := strconv.Atoi(limit)
+		if err != nil || value < 1 || value > maxUserListLimit {
+			return params, errors.New("limit must be between 1 and " + strconv.Itoa(maxUserListLimit))
+		}
+		params.Limit = int32(value)
+	}
+
+	if params.MinAge, err = queryInt(c, "min_age"); err != nil {
+		return params, err
+	}
+	if params.MaxAge, err = queryInt(c, "max_age"); err != nil {
+		return params, err
+	}
+	if params.RoomID, err = queryInt(c, "room_id"); err != nil {
+		return params, err
+	}
+	if params.CreatedAfter, err = queryTime(c, "created_after"); err != nil {
+		return params, err
+	}
+	if params.CreatedBefore, err = queryTime(c, "created_before"); err != nil {
+		return params, err
+	}
+	params.EmailDomain = strings.TrimPrefix(c.Query("email_domain"), "@")
+	if params.Status = c.Query("status"); params.Status != "" {
+		if _, ok := statusTransitions[params.Status]; !ok {
+			return params, errors.New("status must be one of active, pending_verification, suspended, locked and deactivated")
+		}
+	}
+
+	if cursor := c.Query("cursor"); cursor != "" {
+		if err := decodeUserCursor(cursor, &params); err != nil {
+			return params, err
+		}
+	}
+	return params, nil
+}
+
+// listUsers returns a page of users and the cursor of the next one, which is empty on the last page.
+func (uc *UserController) listUsers(c *gin.Context, params db.ListUsersParams) ([]db.User, string, error) {
+	// One user more than asked for tells whether there is a next page
+	limit := params.Limit
+	params.Limit++
+	users, err := uc.Queries.ListUsers(c.Request.Context(), params)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(users) <= int(limit) {
+		return users, "", nil
+	}
+
+	users = users[:limit]
+	cursor, err := encodeUserCursor(params, users[len(users)-1])
+	if err != nil {
+		return nil, "", err
+	}
+	return users, cursor, nil
+}
+
+// usersPage is the answer to a user listing.
+func usersPage(users interface{}, nextCursor string, total *int64) gin.H {
+	page := gin.H{"users": users, "next_cursor": nil}
+	if nextCursor != "" {
+		page["next_cursor"] = nextCursor
+	}
+	if total != nil {
+		page["total"] = *total
+	}
+	return page
+}
@@ -0,0 +1,114 @@
+package controller
+
+import (
+	"encoding/json"
+	"main/db"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func getUsers(uc *UserController, query url.Values) (int, map[string]interface{}) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("GET", "/users?"+query.Encode(), nil)
+
+	uc.GetUsers(c)
+
+	var response map[string]interface{}
+	_ = json.Unmarshal(w.Body.Bytes(), &response)
+	return w.Code, response
+}
+
+func isSQL(fragment string) interface{} {
+	return mock.MatchedBy(func(sql string) bool {
+		return strings.Contains(sql, fragment)
+	})
+}
+
+func TestGetUsersPagination(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockDB := new(MockDBTX)
+	logger, _ := zap.NewDevelopment()
+	uc := NewUserController(db.New(mockDB), newTestRedis(t), logger, true)
+
+	// a page of two asks for a third user to tell whether there is another page
+	mockDB.On("Query", mock.Anything, isSQL("FROM users WHERE deleted_at IS NULL ORDER BY id DESC, id DESC LIMIT $1"), []interface{}{int32(3)}).Return(NewMockRows([][]interface{}{
+		{int32(9), "carol"}, {int32(8), "bob"}, {int32(7), "alice"},
+	}), nil).Once()
+
+	code, response := getUsers(uc, url.Values{"limit": {"2"}, "sort": {"id"}, "order": {"desc"}})
+	require.Equal(t, http.StatusOK, code)
+	users, _ := response["users"].([]interface{})
+	require.Len(t, users, 2)
+	assert.Equal(t, "bob", users[1].(map[string]interface{})["username"])
+	cursor, _ := response["next_cursor"].(string)
+	require.NotEmpty(t, cursor)
+	assert.NotContains(t, response, "total")
+
+	mockDB.On("Query", mock.Anything, isSQL("WHERE deleted_at IS NULL AND (id, id) < ($1, $2) ORDER BY id DESC, id DESC LIMIT $3"),
+		[]interface{}{int32(8), pgtype.Int4{Int32: 8, Valid: true}, int32(3)},
+	).Return(NewMockRows([][]interface{}{{int32(7), "alice"}}), nil).Once()
+
+	code, response = getUsers(uc, url.Values{"limit": {"2"}, "sort": {"id"}, "order": {"desc"}, "cursor": {cursor}})
+	require.Equal(t, http.StatusOK, code)
+	assert.Len(t, response["users"], 1)
+	assert.Nil(t, response["next_cursor"])
+
+	// the cursor's position means nothing in another sort order
+	code, response = getUsers(uc, url.Values{"limit": {"2"}, "sort": {"username"}, "cursor": {cursor}})
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, ErrInvalidCursor.Error(), response["error"])
+
+	mockDB.AssertExpectations(t)
+}
+
+func TestGetUsersFilters(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockDB := new(MockDBTX)
+	logger, _ := zap.NewDevelopment()
+	uc := NewUserController(db.New(mockDB), newTestRedis(t), logger, true)
+
+	where := "WHERE deleted_at IS NULL AND age >= $1 AND room_id = $2 AND lower(split_part(email, '@', 2)) = lower($3)"
+	filterArgs := []interface{}{pgtype.Int4{Int32: 18, Valid: true}, pgtype.Int4{Int32: 4, Valid: true}, "Example.com"}
+	mockDB.On("Query", mock.Anything, isSQL(where+" ORDER BY username ASC, id ASC LIMIT $4"), append(filterArgs, int32(defaultUserListLimit+1))).
+		Return(NewMockRows([][]interface{}{{int32(1), "alice"}}), nil).Once()
+	mockDB.On("QueryRow", mock.Anything, "SELECT count(*) FROM users "+where, filterArgs).Return(scanRow(1, nil, func(args mock.Arguments) {
+		*args.Get(0).(*int64) = 42
+	})).Once()
+
+	code, response := getUsers(uc, url.Values{"min_age": {"18"}, "room_id": {"4"}, "email_domain": {"@Example.com"}, "include_total": {"true"}})
+	require.Equal(t, http.StatusOK, code)
+	assert.Len(t, response["users"], 1)
+	assert.Nil(t, response["next_cursor"])
+	assert.EqualValues(t, 42, response["total"])
+	mockDB.AssertExpectations(t)
+
+	for _, query := range []url.Values{
+		{"sort": {"password"}},
+		{"order": {"sideways"}},
+		{"limit": {"1000"}},
+		{"min_age": {"old"}},
+		{"created_after": {"yesterday"}},
+		{"cursor": {"not-a-cursor"}},
+		{"status": {"banned"}},
+	} {
+		code, _ := getUsers(uc, query)
+		assert.Equal(t, http.StatusBadRequest, code, query.Encode())
+	}
+
+	// who is restricted is only for administrators to know
+	code, _ = getUsers(uc, url.Values{"status": {"suspended"}})
+	assert.Equal(t, http.StatusForbidden, code)
+}
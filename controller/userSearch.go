@@ -0,0 +1,162 @@
+package controller
+
+import (
+	"main/db"
+	"main/dto"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultUserSearchLimit = 20
+	maxUserSearchLimit     = 100
+	maxUserSearchQuery     = 100
+
+	// minTrigramQuery is the shortest query trigram similarity finds anything useful for,
+	// shorter ones only match prefixes
+	minTrigramQuery = 3
+)
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// highlight splits text into the parts that match the query and the rest. Every
+// word of the query is marked where it occurs. When none does, as with fuzzy
+// matches, the longest stretch the text shares with the query is marked instead.
+func highlight(text string, query string) []dto.Highlight {
+	runes := []rune(text)
+	lower := make([]rune, len(runes))
+	for i, r := range runes {
+		lower[i] = unicode.ToLower(r)
+	}
+	marked := make([]bool, len(runes))
+	mark := func(start int, length int) {
+		for i := start; i < start+length; i++ {
+			marked[i] = true
+		}
+	}
+
+	found := false
+	for _, word := range strings.Fields(strings.ToLower(query)) {
+		term := []rune(word)
+		for i := 0; i+len(term) <= len(lower); i++ {
+			if string(lower[i:i+len(term)]) == word {
+				mark(i, len(term))
+				found = true
+			}
+		}
+	}
+	if !found {
+		if start, length := longestCommonRun(lower, []rune(strings.ToLower(query))); length >= minTrigramQuery {
+			mark(start, length)
+		}
+	}
+
+	var highlights []dto.Highlight
+	for i := 0; i < len(runes); {
+		j := i
+		for j < len(runes) && marked[j] == marked[i] {
+			j++
+		}
+		highlights = append(highlights, dto.Highlight{Text: string(runes[i:j]), Match: marked[i]})
+		i = j
+	}
+	return highlights
+}
+
+// longestCommonRun finds the longest run of text that also occurs in query.
+func longestCommonRun(text []rune, query []rune) (start int, length int) {
+	previous := make([]int, len(query)+1)
+	for i := range text {
+		current := make([]int, len(query)+1)
+		for j := range query {
+			if text[i] == query[j] {
+				current[j+1] = previous[j] + 1
+				if current[j+1] > length {
+					start, length = i-current[j+1]+1, current[j+1]
+				}
+			}
+		}
+		previous = current
+	}
+	return start, length
+}
+
+func userSearchResult(user db.User, score float32, prefixMatch bool, query string) dto.UserSearchResult {
+	return dto.UserSearchResult{
+		User:        dto.NewAdminUser(user),
+		Score:       score,
+		PrefixMatch: prefixMatch,
+		Highlights: dto.UserHighlights{
+			Username: highlight(user.Username, query),
+			Email:    highlight(user.Email, query),
+		},
+	}
+}
+
+// SearchUsers godoc
+// @Summary Search users
+// @Description Find users by part of their username or email address, for support staff. Users whose username or email address starts with the query come first, then the ones most similar to it. Queries shorter than three characters only match prefixes. The parts of the username and email address that match are highlighted.
+// @Tags users
+// @Produce json
+// @Param q query string true "Part of a username or email address"
+// @Param limit query int false "Results to return, 20 by default and 100 at most"
+// @Success 200 {object} gin.H "Query and the results, best first"
+// @Failure 400 {object} gin.H "Bad Request"
+// @Failure 401 {object} gin.H "Unauthorized"
+// @Failure 403 {object} gin.H "Forbidden"
+// @Failure 500 {object} gin.H "Internal Server Error"
+// @Router /users/search [get]
+func (uc *UserController) SearchUsers(c *gin.Context) {
+	userRequests.WithLabelValues("GET", "/users/search").Inc()
+	query := strings.ToLower(strings.TrimSpace(c.Query("q")))
+	if query == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "q is required"})
+		return
+	}
+	if utf8.RuneCountInString(query) > maxUserSearchQuery {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "q must be at most " + strconv.Itoa(maxUserSearchQuery) + " characters"})
+		return
+	}
+	limit := int32(defaultUserSearchLimit)
+	if value := c.Query("limit"); value != "" {
+		number, err := strconv.Atoi(value)
+		if err != nil || number < 1 || number > maxUserSearchLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and " + strconv.Itoa(maxUserSearchLimit)})
+			return
+		}
+		limit = int32(number)
+	}
+
+	ctx := c.Request.Context()
+	prefix := likeEscaper.Replace(query) + "%"
+	prefixMatches, err := uc.Queries.SearchUsersByPrefix(ctx, db.SearchUsersByPrefixParams{Query: query, Prefix: prefix, Limit: limit})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	results := make([]dto.UserSearchResult, 0, limit)
+	// Prefix matches rank first, so a page full of them needs no trigram search
+	if len(prefixMatches) == int(limit) || utf8.RuneCountInString(query) < minTrigramQuery {
+		for _, match := range prefixMatches {
+			results = append(results, userSearchResult(match.User, match.Score, true, query))
+		}
+		c.JSON(http.StatusOK, gin.H{"query": query, "results": results})
+		return
+	}
+
+	matches, err := uc.Queries.SearchUsers(ctx, db.SearchUsersParams{Prefix: prefix, Query: query, Limit: limit})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	for _, match := range matches {
+		results = append(results, userSearchResult(match.User, match.Score, match.PrefixMatch, query))
+	}
+	c.JSON(http.StatusOK, gin.H{"query": query, "results": results})
+}
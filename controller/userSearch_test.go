@@ -0,0 +1,98 @@
+package controller
+
+import (
+	"encoding/json"
+	"main/db"
+	"main/dto"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestHighlight(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		query    string
+		expected []dto.Highlight
+	}{
+		{name: "prefix", text: "alice", query: "ali", expected: []dto.Highlight{{Text: "ali", Match: true}, {Text: "ce"}}},
+		{name: "keeps the case of the text", text: "Bob.Smith@Example.com", query: "smith", expected: []dto.Highlight{
+			{Text: "Bob."}, {Text: "Smith", Match: true}, {Text: "@Example.com"},
+		}},
+		{name: "every word and occurrence", text: "anna-anderson", query: "an son", expected: []dto.Highlight{
+			{Text: "an", Match: true}, {Text: "na-"}, {Text: "an", Match: true}, {Text: "der"}, {Text: "son", Match: true},
+		}},
+		{name: "fuzzy match", text: "jonathan", query: "jonatan", expected: []dto.Highlight{{Text: "jonat", Match: true}, {Text: "han"}}},
+		{name: "no match", text: "carol", query: "xyz", expected: []dto.Highlight{{Text: "carol"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, highlight(tt.text, tt.query))
+		})
+	}
+}
+
+func searchUsers(uc *UserController, query url.Values) (int, map[string]interface{}) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("GET", "/users/search?"+query.Encode(), nil)
+
+	uc.SearchUsers(c)
+
+	var response map[string]interface{}
+	_ = json.Unmarshal(w.Body.Bytes(), &response)
+	return w.Code, response
+}
+
+func TestSearchUsers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockDB := new(MockDBTX)
+	logger, _ := zap.NewDevelopment()
+	uc := NewUserController(db.New(mockDB), newTestRedis(t), logger, true)
+
+	// short queries only match prefixes, escaped so their wildcards match literally
+	mockDB.On("Query", mock.Anything, isQuery("SearchUsersByPrefix"), []interface{}{"a_", `a\_%`, int32(defaultUserSearchLimit)}).
+		Return(NewMockRows([][]interface{}{{int32(1), "a_lice", "a_lice@example.com", nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, float32(0.4)}}), nil).Once()
+
+	code, response := searchUsers(uc, url.Values{"q": {" A_ "}})
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "a_", response["query"])
+	results, _ := response["results"].([]interface{})
+	require.Len(t, results, 1)
+	result := results[0].(map[string]interface{})
+	assert.Equal(t, true, result["prefix_match"])
+	assert.InDelta(t, 0.4, result["score"], 0.001)
+	assert.Equal(t, "a_lice@example.com", result["user"].(map[string]interface{})["email"])
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"text": "a_", "match": true},
+		map[string]interface{}{"text": "lice", "match": false},
+	}, result["highlights"].(map[string]interface{})["username"])
+
+	// longer queries fill the page up with similar users
+	mockDB.On("Query", mock.Anything, isQuery("SearchUsersByPrefix"), []interface{}{"alcie", "alcie%", int32(2)}).
+		Return(NewMockRows(nil), nil).Once()
+	mockDB.On("Query", mock.Anything, isQuery("SearchUsers"), []interface{}{"alcie%", "alcie", int32(2)}).
+		Return(NewMockRows([][]interface{}{{int32(1), "alice", "alice@example.com", nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, false, float32(0.3)}}), nil).Once()
+
+	code, response = searchUsers(uc, url.Values{"q": {"alcie"}, "limit": {"2"}})
+	require.Equal(t, http.StatusOK, code)
+	results, _ = response["results"].([]interface{})
+	require.Len(t, results, 1)
+	assert.Equal(t, false, results[0].(map[string]interface{})["prefix_match"])
+	mockDB.AssertExpectations(t)
+
+	for _, query := range []url.Values{{}, {"q": {"  "}}, {"q": {"alice"}, "limit": {"0"}}} {
+		code, _ := searchUsers(uc, query)
+		assert.Equal(t, http.StatusBadRequest, code, query.Encode())
+	}
+}
@@ -0,0 +1,65 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"main/auth"
+	"main/dto"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	roleAdmin = "admin"
+
+	userCacheKeyPrefix = "user_cache:"
+	userCacheTTL       = 10 * time.Minute
+)
+
+// userView picks the view of user the caller is allowed to see: administrators get
+// the admin view, users their own account's self view and everyone else the public one.
+func userView(c *gin.Context, user dto.AdminUser) interface{} {
+	claims, ok := auth.FromContext(c)
+	if !ok {
+		return user.PublicUser
+	}
+	if claims.HasRole(roleAdmin) {
+		return user
+	}
+	if id, ok := claims.UserID(); ok && id == user.ID {
+		return user.SelfUser
+	}
+	return user.PublicUser
+}
+
+func userCacheKey(id int32) string {
+	return userCacheKeyPrefix + strconv.Itoa(int(id))
+}
+
+// cachedUser returns the cached admin view of a user. The cache only ever holds
+// dto views, never db rows, so no credentials end up in Redis.
+func (uc *UserController) cachedUser(ctx context.Context, id int32) (dto.AdminUser, bool, error) {
+	var user dto.AdminUser
+	cached, err := uc.RedisClient.Get(ctx, userCacheKey(id)).Bytes()
+	if err != nil {
+		return user, false, err
+	}
+	if err := json.Unmarshal(cached, &user); err != nil {
+		return user, false, err
+	}
+	return user, true, nil
+}
+
+func (uc *UserController) cacheUser(ctx context.Context, user dto.AdminUser) error {
+	userJson, err := json.Marshal(user)
+	if err != nil {
+		return err
+	}
+	return uc.RedisClient.Set(ctx, userCacheKey(user.ID), userJson, userCacheTTL).Err()
+}
+
+func (uc *UserController) forgetCachedUser(ctx context.Context, id int32) error {
+	return uc.RedisClient.Del(ctx, userCacheKey(id)).Err()
+}
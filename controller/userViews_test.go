@@ -0,0 +1,149 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"main/auth"
+	"main/db"
+	"main/dto"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// assertNoPasswordKey fails when a "password" key shows up anywhere in the JSON document
+func assertNoPasswordKey(t *testing.T, body []byte) {
+	t.Helper()
+
+	var document interface{}
+	require.NoError(t, json.Unmarshal(body, &document))
+
+	var walk func(value interface{}, path string)
+	walk = func(value interface{}, path string) {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			for key, child := range v {
+				assert.NotEqual(t, "password", key, "response leaks a password at %s", path)
+				walk(child, path+"."+key)
+			}
+		case []interface{}:
+			for _, child := range v {
+				walk(child, path+"[]")
+			}
+		}
+	}
+	walk(document, "$")
+}
+
+func TestUserResponsesHaveNoPassword(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	hashedPassword := "$2a$10$7EqJtq98hPqEX7fNZaFWoOhi5BWX4Z0p0hxI5VZjc.3ZAfzrkEtSe"
+	mockDB := new(MockDBTX)
+	mockRow := new(MockRow)
+	mockRow.On("Scan", anyColumns(userColumnCount)...).Return(nil).Run(func(args mock.Arguments) {
+		*args.Get(0).(*int32) = 1
+		*args.Get(1).(*string) = "tester"
+		*args.Get(2).(*string) = "testuser@test.com"
+		*args.Get(3).(*string) = hashedPassword
+		*args.Get(6).(*pgtype.Timestamp) = pgtype.Timestamp{Time: time.Now(), Valid: true}
+	})
+	mockDB.On("QueryRow", mock.Anything, mock.Anything, mock.Anything).Return(mockRow)
+	mockSessionWrites(mockDB)
+	mockDB.On("Exec", mock.Anything, isQuery("CreateAuditLogEntry"), mock.Anything).Return(pgconn.NewCommandTag("INSERT 0 1"), nil)
+	mockDB.On("Query", mock.Anything, mock.Anything, mock.Anything).Return(NewMockRows([][]interface{}{
+		{int32(1), "tester", "testuser@test.com", hashedPassword},
+		{int32(2), "other", "other@test.com", hashedPassword},
+	}), nil)
+
+	logger, _ := zap.NewDevelopment()
+	redisClient := newTestRedis(t)
+	uc := NewUserController(db.New(mockDB), redisClient, logger, true)
+
+	// the admin view is the most detailed one
+	admin := &auth.Claims{Username: "root", Roles: []string{"admin"}, StandardClaims: jwt.StandardClaims{Subject: "3"}}
+
+	tests := []struct {
+		name    string
+		method  string
+		body    interface{}
+		handler gin.HandlerFunc
+	}{
+		{name: "get user from the database", method: "GET", handler: uc.GetUser},
+		{name: "get user from the cache", method: "GET", handler: uc.GetUser},
+		{name: "get users", method: "GET", handler: uc.GetUsers},
+		{name: "update user", method: "PUT", body: UpdateUserRequest{}, handler: uc.UpdateUser},
+		{name: "delete user", method: "DELETE", handler: uc.DeleteUser},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+
+			jsonValue, _ := json.Marshal(tt.body)
+			c.Request, _ = http.NewRequest(tt.method, "/users/1", bytes.NewBuffer(jsonValue))
+			c.Request.Header.Set("Content-Type", "application/json")
+			c.Params = []gin.Param{{Key: "id", Value: "1"}}
+			c.Set(auth.ClaimsKey, admin)
+
+			tt.handler(c)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.NotContains(t, w.Body.String(), hashedPassword)
+			assertNoPasswordKey(t, w.Body.Bytes())
+		})
+	}
+
+	keys, err := redisClient.Keys(t.Context(), userCacheKeyPrefix+"*").Result()
+	require.NoError(t, err)
+	for _, key := range keys {
+		cached, err := redisClient.Get(t.Context(), key).Bytes()
+		require.NoError(t, err)
+		assert.NotContains(t, string(cached), hashedPassword)
+		assertNoPasswordKey(t, cached)
+	}
+}
+
+func TestUserView(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	user := db.User{ID: 1, Username: "tester", Email: "testuser@test.com", Password: "hash"}
+
+	tests := []struct {
+		name          string
+		claims        *auth.Claims
+		expectedEmail bool
+	}{
+		{name: "someone else", claims: &auth.Claims{Username: "other", StandardClaims: jwt.StandardClaims{Subject: "2"}}},
+		{name: "the user", claims: &auth.Claims{Username: "tester", StandardClaims: jwt.StandardClaims{Subject: "1"}}, expectedEmail: true},
+		{name: "admin", claims: &auth.Claims{Username: "root", Roles: []string{"admin"}, StandardClaims: jwt.StandardClaims{Subject: "3"}}, expectedEmail: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Set(auth.ClaimsKey, tt.claims)
+
+			body, err := json.Marshal(userView(c, dto.NewAdminUser(user)))
+			require.NoError(t, err)
+
+			var view map[string]interface{}
+			require.NoError(t, json.Unmarshal(body, &view))
+			assert.Equal(t, "tester", view["username"])
+			_, hasEmail := view["email"]
+			assert.Equal(t, tt.expectedEmail, hasEmail)
+			assertNoPasswordKey(t, body)
+		})
+	}
+}
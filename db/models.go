@@ -8,17 +8,141 @@ import (
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
-type Room struct {
+type ApiToken struct {
+	ID          int32            `json:"id"`
+	UserID      int32            `json:"user_id"`
+	Name        string           `json:"name"`
+	TokenPrefix string           `json:"token_prefix"`
+	TokenHash   string           `json:"token_hash"`
+	Scopes      []string         `json:"scopes"`
+	ExpiresAt   pgtype.Timestamp `json:"expires_at"`
+	LastUsedAt  pgtype.Timestamp `json:"last_used_at"`
+	LastUsedIp  pgtype.Text      `json:"last_used_ip"`
+	CreatedAt   pgtype.Timestamp `json:"created_at"`
+}
+
+type AuditLog struct {
+	ID             int32            `json:"id"`
+	Action         string           `json:"action"`
+	ActorID        pgtype.Int4      `json:"actor_id"`
+	ActorUsername  string           `json:"actor_username"`
+	TargetUserID   pgtype.Int4      `json:"target_user_id"`
+	TargetUsername string           `json:"target_username"`
+	TokenID        string           `json:"token_id"`
+	Reason         string           `json:"reason"`
+	Ip             string           `json:"ip"`
+	UserAgent      string           `json:"user_agent"`
+	CreatedAt      pgtype.Timestamp `json:"created_at"`
+}
+
+type OauthClient struct {
+	ID            string           `json:"id"`
+	Name          string           `json:"name"`
+	SecretHash    pgtype.Text      `json:"secret_hash"`
+	RedirectUris  []string         `json:"redirect_uris"`
+	GrantTypes    []string         `json:"grant_types"`
+	Scopes        []string         `json:"scopes"`
+	ServiceUserID pgtype.Int4      `json:"service_user_id"`
+	CreatedAt     pgtype.Timestamp `json:"created_at"`
+}
+
+type OauthConsent struct {
+	UserID    int32            `json:"user_id"`
+	ClientID  string           `json:"client_id"`
+	Scopes    []string         `json:"scopes"`
+	GrantedAt pgtype.Timestamp `json:"granted_at"`
+}
+
+type PasswordHistory struct {
+	ID           int32            `json:"id"`
+	UserID       int32            `json:"user_id"`
+	PasswordHash string           `json:"password_hash"`
+	CreatedAt    pgtype.Timestamp `json:"created_at"`
+}
+
+type Permission struct {
+	ID   int32  `json:"id"`
+	Name string `json:"name"`
+}
+
+type RecoveryCode struct {
+	ID       int32            `json:"id"`
+	UserID   int32            `json:"user_id"`
+	CodeHash string           `json:"code_hash"`
+	UsedAt   pgtype.Timestamp `json:"used_at"`
+}
+
+type Role struct {
 	ID   int32  `json:"id"`
 	Name string `json:"name"`
 }
 
+type RolePermission struct {
+	RoleID       int32 `json:"role_id"`
+	PermissionID int32 `json:"permission_id"`
+}
+
+type Room struct {
+	ID      int32       `json:"id"`
+	Name    string      `json:"name"`
+	OwnerID pgtype.Int4 `json:"owner_id"`
+}
+
+type Session struct {
+	ID            string           `json:"id"`
+	UserID        int32            `json:"user_id"`
+	TokenID       string           `json:"token_id"`
+	RefreshFamily string           `json:"refresh_family"`
+	UserAgent     string           `json:"user_agent"`
+	Ip            string           `json:"ip"`
+	CreatedAt     pgtype.Timestamp `json:"created_at"`
+	LastSeenAt    pgtype.Timestamp `json:"last_seen_at"`
+	ExpiresAt     pgtype.Timestamp `json:"expires_at"`
+	RevokedAt     pgtype.Timestamp `json:"revoked_at"`
+}
+
 type User struct {
-	ID        int32            `json:"id"`
-	Username  string           `json:"username"`
-	Email     string           `json:"email"`
-	Password  string           `json:"password"`
-	Age       pgtype.Int4      `json:"age"`
-	RoomID    pgtype.Int4      `json:"room_id"`
-	CreatedAt pgtype.Timestamp `json:"created_at"`
+	ID              int32            `json:"id"`
+	Username        string           `json:"username"`
+	Email           string           `json:"email"`
+	Password        string           `json:"password"`
+	Age             pgtype.Int4      `json:"age"`
+	RoomID          pgtype.Int4      `json:"room_id"`
+	CreatedAt       pgtype.Timestamp `json:"created_at"`
+	EmailVerifiedAt pgtype.Timestamp `json:"email_verified_at"`
+	DeletedAt       pgtype.Timestamp `json:"deleted_at"`
+	PurgedAt        pgtype.Timestamp `json:"purged_at"`
+	Status          string           `json:"status"`
+	StatusReason    pgtype.Text      `json:"status_reason"`
+	StatusChangedBy pgtype.Int4      `json:"status_changed_by"`
+	StatusChangedAt pgtype.Timestamp `json:"status_changed_at"`
+	StatusExpiresAt pgtype.Timestamp `json:"status_expires_at"`
+	DisplayName     pgtype.Text      `json:"display_name"`
+	Bio             pgtype.Text      `json:"bio"`
+	Locale          pgtype.Text      `json:"locale"`
+	Timezone        pgtype.Text      `json:"timezone"`
+	Pronouns        pgtype.Text      `json:"pronouns"`
+	Links           []string         `json:"links"`
+}
+
+type UserIdentity struct {
+	ID          int32            `json:"id"`
+	UserID      int32            `json:"user_id"`
+	Provider    string           `json:"provider"`
+	Subject     string           `json:"subject"`
+	Email       string           `json:"email"`
+	CreatedAt   pgtype.Timestamp `json:"created_at"`
+	LastLoginAt pgtype.Timestamp `json:"last_login_at"`
+}
+
+type UserRole struct {
+	UserID int32 `json:"user_id"`
+	RoleID int32 `json:"role_id"`
+}
+
+type UserTotp struct {
+	UserID      int32            `json:"user_id"`
+	Secret      string           `json:"secret"`
+	ConfirmedAt pgtype.Timestamp `json:"confirmed_at"`
+	CreatedAt   pgtype.Timestamp `json:"created_at"`
 }
@@ -11,8 +11,27 @@ import (
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
+const addUserRole = `-- name: AddUserRole :execrows
+INSERT INTO user_roles (user_id, role_id)
+SELECT $1, r.id FROM roles r WHERE r.name = $2
+ON CONFLICT DO NOTHING
+`
+
+type AddUserRoleParams struct {
+	UserID int32  `json:"user_id"`
+	Role   string `json:"role"`
+}
+
+func (q *Queries) AddUserRole(ctx context.Context, arg AddUserRoleParams) (int64, error) {
+	result, err := q.db.Exec(ctx, addUserRole, arg.UserID, arg.Role)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
 const addUserToRoom = `-- name: AddUserToRoom :one
-UPDATE users SET room_id = $2 WHERE username = $1 RETURNING id, username, email, password, age, room_id, created_at
+UPDATE users SET room_id = $2 WHERE username = $1 AND deleted_at IS NULL RETURNING id, username, email, password, age, room_id, created_at, email_verified_at, deleted_at, purged_at, status, status_reason, status_changed_by, status_changed_at, status_expires_at, display_name, bio, locale, timezone, pronouns, links
 `
 
 type AddUserToRoomParams struct {
@@ -31,23 +50,258 @@ func (q *Queries) AddUserToRoom(ctx context.Context, arg AddUserToRoomParams) (U
 		&i.Age,
 		&i.RoomID,
 		&i.CreatedAt,
+		&i.EmailVerifiedAt,
+		&i.DeletedAt,
+		&i.PurgedAt,
+		&i.Status,
+		&i.StatusReason,
+		&i.StatusChangedBy,
+		&i.StatusChangedAt,
+		&i.StatusExpiresAt,
+		&i.DisplayName,
+		&i.Bio,
+		&i.Locale,
+		&i.Timezone,
+		&i.Pronouns,
+		&i.Links,
+	)
+	return i, err
+}
+
+const anonymizeUser = `-- name: AnonymizeUser :execrows
+WITH target AS (
+    SELECT u.id FROM users u WHERE u.id = $1 AND u.deleted_at < NOW() - $2::interval AND u.purged_at IS NULL FOR UPDATE
+), roles AS (
+    DELETE FROM user_roles WHERE user_id IN (SELECT id FROM target)
+), totp AS (
+    DELETE FROM user_totp WHERE user_id IN (SELECT id FROM target)
+), codes AS (
+    DELETE FROM recovery_codes WHERE user_id IN (SELECT id FROM target)
+), history AS (
+    DELETE FROM password_history WHERE user_id IN (SELECT id FROM target)
+), tokens AS (
+    DELETE FROM api_tokens WHERE user_id IN (SELECT id FROM target)
+), user_sessions AS (
+    DELETE FROM sessions WHERE user_id IN (SELECT id FROM target)
+), identities AS (
+    DELETE FROM user_identities WHERE user_id IN (SELECT id FROM target)
+), consents AS (
+    DELETE FROM oauth_consents WHERE user_id IN (SELECT id FROM target)
+)
+UPDATE users SET username = 'deleted-' || users.id, email = 'deleted-' || users.id || '@invalid', password = '',
+    age = NULL, room_id = NULL, email_verified_at = NULL, status_reason = NULL, display_name = NULL, bio = NULL,
+    locale = NULL, timezone = NULL, pronouns = NULL, links = '{}', purged_at = NOW()
+WHERE users.id IN (SELECT id FROM target)
+`
+
+type AnonymizeUserParams struct {
+	ID        int32           `json:"id"`
+	Retention pgtype.Interval `json:"retention"`
+}
+
+func (q *Queries) AnonymizeUser(ctx context.Context, arg AnonymizeUserParams) (int64, error) {
+	result, err := q.db.Exec(ctx, anonymizeUser, arg.ID, arg.Retention)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const confirmUserTOTP = `-- name: ConfirmUserTOTP :exec
+UPDATE user_totp SET confirmed_at = NOW() WHERE user_id = $1
+`
+
+func (q *Queries) ConfirmUserTOTP(ctx context.Context, userID int32) error {
+	_, err := q.db.Exec(ctx, confirmUserTOTP, userID)
+	return err
+}
+
+const createAPIToken = `-- name: CreateAPIToken :one
+INSERT INTO api_tokens (user_id, name, token_prefix, token_hash, scopes, expires_at)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING id, user_id, name, token_prefix, token_hash, scopes, expires_at, last_used_at, last_used_ip, created_at
+`
+
+type CreateAPITokenParams struct {
+	UserID      int32            `json:"user_id"`
+	Name        string           `json:"name"`
+	TokenPrefix string           `json:"token_prefix"`
+	TokenHash   string           `json:"token_hash"`
+	Scopes      []string         `json:"scopes"`
+	ExpiresAt   pgtype.Timestamp `json:"expires_at"`
+}
+
+func (q *Queries) CreateAPIToken(ctx context.Context, arg CreateAPITokenParams) (ApiToken, error) {
+	row := q.db.QueryRow(ctx, createAPIToken,
+		arg.UserID,
+		arg.Name,
+		arg.TokenPrefix,
+		arg.TokenHash,
+		arg.Scopes,
+		arg.ExpiresAt,
+	)
+	var i ApiToken
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Name,
+		&i.TokenPrefix,
+		&i.TokenHash,
+		&i.Scopes,
+		&i.ExpiresAt,
+		&i.LastUsedAt,
+		&i.LastUsedIp,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const createAuditLogEntry = `-- name: CreateAuditLogEntry :exec
+INSERT INTO audit_log (action, actor_id, actor_username, target_user_id, target_username, token_id, reason, ip, user_agent)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+`
+
+type CreateAuditLogEntryParams struct {
+	Action         string      `json:"action"`
+	ActorID        pgtype.Int4 `json:"actor_id"`
+	ActorUsername  string      `json:"actor_username"`
+	TargetUserID   pgtype.Int4 `json:"target_user_id"`
+	TargetUsername string      `json:"target_username"`
+	TokenID        string      `json:"token_id"`
+	Reason         string      `json:"reason"`
+	Ip             string      `json:"ip"`
+	UserAgent      string      `json:"user_agent"`
+}
+
+func (q *Queries) CreateAuditLogEntry(ctx context.Context, arg CreateAuditLogEntryParams) error {
+	_, err := q.db.Exec(ctx, createAuditLogEntry,
+		arg.Action,
+		arg.ActorID,
+		arg.ActorUsername,
+		arg.TargetUserID,
+		arg.TargetUsername,
+		arg.TokenID,
+		arg.Reason,
+		arg.Ip,
+		arg.UserAgent,
+	)
+	return err
+}
+
+const createOAuthClient = `-- name: CreateOAuthClient :one
+INSERT INTO oauth_clients (id, name, secret_hash, redirect_uris, grant_types, scopes, service_user_id)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+RETURNING id, name, secret_hash, redirect_uris, grant_types, scopes, service_user_id, created_at
+`
+
+type CreateOAuthClientParams struct {
+	ID            string      `json:"id"`
+	Name          string      `json:"name"`
+	SecretHash    pgtype.Text `json:"secret_hash"`
+	RedirectUris  []string    `json:"redirect_uris"`
+	GrantTypes    []string    `json:"grant_types"`
+	Scopes        []string    `json:"scopes"`
+	ServiceUserID pgtype.Int4 `json:"service_user_id"`
+}
+
+func (q *Queries) CreateOAuthClient(ctx context.Context, arg CreateOAuthClientParams) (OauthClient, error) {
+	row := q.db.QueryRow(ctx, createOAuthClient,
+		arg.ID,
+		arg.Name,
+		arg.SecretHash,
+		arg.RedirectUris,
+		arg.GrantTypes,
+		arg.Scopes,
+		arg.ServiceUserID,
+	)
+	var i OauthClient
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.SecretHash,
+		&i.RedirectUris,
+		&i.GrantTypes,
+		&i.Scopes,
+		&i.ServiceUserID,
+		&i.CreatedAt,
 	)
 	return i, err
 }
 
+const createPasswordHistory = `-- name: CreatePasswordHistory :exec
+INSERT INTO password_history (user_id, password_hash) VALUES ($1, $2)
+`
+
+type CreatePasswordHistoryParams struct {
+	UserID       int32  `json:"user_id"`
+	PasswordHash string `json:"password_hash"`
+}
+
+func (q *Queries) CreatePasswordHistory(ctx context.Context, arg CreatePasswordHistoryParams) error {
+	_, err := q.db.Exec(ctx, createPasswordHistory, arg.UserID, arg.PasswordHash)
+	return err
+}
+
+const createRecoveryCode = `-- name: CreateRecoveryCode :exec
+INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2)
+`
+
+type CreateRecoveryCodeParams struct {
+	UserID   int32  `json:"user_id"`
+	CodeHash string `json:"code_hash"`
+}
+
+func (q *Queries) CreateRecoveryCode(ctx context.Context, arg CreateRecoveryCodeParams) error {
+	_, err := q.db.Exec(ctx, createRecoveryCode, arg.UserID, arg.CodeHash)
+	return err
+}
+
 const createRoom = `-- name: CreateRoom :one
-INSERT INTO rooms (name) VALUES ($1) RETURNING id, name
+INSERT INTO rooms (name, owner_id) VALUES ($1, $2) RETURNING id, name, owner_id
 `
 
-func (q *Queries) CreateRoom(ctx context.Context, name string) (Room, error) {
-	row := q.db.QueryRow(ctx, createRoom, name)
+type CreateRoomParams struct {
+	Name    string      `json:"name"`
+	OwnerID pgtype.Int4 `json:"owner_id"`
+}
+
+func (q *Queries) CreateRoom(ctx context.Context, arg CreateRoomParams) (Room, error) {
+	row := q.db.QueryRow(ctx, createRoom, arg.Name, arg.OwnerID)
 	var i Room
-	err := row.Scan(&i.ID, &i.Name)
+	err := row.Scan(&i.ID, &i.Name, &i.OwnerID)
 	return i, err
 }
 
+const createSession = `-- name: CreateSession :exec
+INSERT INTO sessions (id, user_id, token_id, refresh_family, user_agent, ip, expires_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+`
+
+type CreateSessionParams struct {
+	ID            string           `json:"id"`
+	UserID        int32            `json:"user_id"`
+	TokenID       string           `json:"token_id"`
+	RefreshFamily string           `json:"refresh_family"`
+	UserAgent     string           `json:"user_agent"`
+	Ip            string           `json:"ip"`
+	ExpiresAt     pgtype.Timestamp `json:"expires_at"`
+}
+
+func (q *Queries) CreateSession(ctx context.Context, arg CreateSessionParams) error {
+	_, err := q.db.Exec(ctx, createSession,
+		arg.ID,
+		arg.UserID,
+		arg.TokenID,
+		arg.RefreshFamily,
+		arg.UserAgent,
+		arg.Ip,
+		arg.ExpiresAt,
+	)
+	return err
+}
+
 const createUser = `-- name: CreateUser :one
-INSERT INTO users (username, email, password, age) VALUES ($1, $2, $3, $4) RETURNING username, email, age
+INSERT INTO users (username, email, password, age) VALUES ($1, $2, $3, $4) RETURNING id, username, email, age
 `
 
 type CreateUserParams struct {
@@ -58,6 +312,7 @@ type CreateUserParams struct {
 }
 
 type CreateUserRow struct {
+	ID       int32       `json:"id"`
 	Username string      `json:"username"`
 	Email    string      `json:"email"`
 	Age      pgtype.Int4 `json:"age"`
@@ -71,23 +326,99 @@ func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (CreateU
 		arg.Age,
 	)
 	var i CreateUserRow
-	err := row.Scan(&i.Username, &i.Email, &i.Age)
+	err := row.Scan(
+		&i.ID,
+		&i.Username,
+		&i.Email,
+		&i.Age,
+	)
+	return i, err
+}
+
+const createUserIdentity = `-- name: CreateUserIdentity :one
+INSERT INTO user_identities (user_id, provider, subject, email, last_login_at)
+VALUES ($1, $2, $3, $4, NOW())
+RETURNING id, user_id, provider, subject, email, created_at, last_login_at
+`
+
+type CreateUserIdentityParams struct {
+	UserID   int32  `json:"user_id"`
+	Provider string `json:"provider"`
+	Subject  string `json:"subject"`
+	Email    string `json:"email"`
+}
+
+func (q *Queries) CreateUserIdentity(ctx context.Context, arg CreateUserIdentityParams) (UserIdentity, error) {
+	row := q.db.QueryRow(ctx, createUserIdentity,
+		arg.UserID,
+		arg.Provider,
+		arg.Subject,
+		arg.Email,
+	)
+	var i UserIdentity
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Provider,
+		&i.Subject,
+		&i.Email,
+		&i.CreatedAt,
+		&i.LastLoginAt,
+	)
 	return i, err
 }
 
+const deleteAPIToken = `-- name: DeleteAPIToken :execrows
+DELETE FROM api_tokens WHERE id = $1 AND user_id = $2
+`
+
+type DeleteAPITokenParams struct {
+	ID     int32 `json:"id"`
+	UserID int32 `json:"user_id"`
+}
+
+func (q *Queries) DeleteAPIToken(ctx context.Context, arg DeleteAPITokenParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteAPIToken, arg.ID, arg.UserID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteOAuthClient = `-- name: DeleteOAuthClient :execrows
+DELETE FROM oauth_clients WHERE id = $1
+`
+
+func (q *Queries) DeleteOAuthClient(ctx context.Context, id string) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteOAuthClient, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteRecoveryCodes = `-- name: DeleteRecoveryCodes :exec
+DELETE FROM recovery_codes WHERE user_id = $1
+`
+
+func (q *Queries) DeleteRecoveryCodes(ctx context.Context, userID int32) error {
+	_, err := q.db.Exec(ctx, deleteRecoveryCodes, userID)
+	return err
+}
+
 const deleteRoom = `-- name: DeleteRoom :one
-DELETE FROM rooms WHERE id = $1 RETURNING id, name
+DELETE FROM rooms WHERE id = $1 RETURNING id, name, owner_id
 `
 
 func (q *Queries) DeleteRoom(ctx context.Context, id int32) (Room, error) {
 	row := q.db.QueryRow(ctx, deleteRoom, id)
 	var i Room
-	err := row.Scan(&i.ID, &i.Name)
+	err := row.Scan(&i.ID, &i.Name, &i.OwnerID)
 	return i, err
 }
 
 const deleteUser = `-- name: DeleteUser :one
-DELETE FROM users WHERE id = $1 RETURNING id, username, email, password, age, room_id, created_at
+UPDATE users SET deleted_at = NOW() WHERE id = $1 AND deleted_at IS NULL RETURNING id, username, email, password, age, room_id, created_at, email_verified_at, deleted_at, purged_at, status, status_reason, status_changed_by, status_changed_at, status_expires_at, display_name, bio, locale, timezone, pronouns, links
 `
 
 func (q *Queries) DeleteUser(ctx context.Context, id int32) (User, error) {
@@ -101,23 +432,195 @@ func (q *Queries) DeleteUser(ctx context.Context, id int32) (User, error) {
 		&i.Age,
 		&i.RoomID,
 		&i.CreatedAt,
+		&i.EmailVerifiedAt,
+		&i.DeletedAt,
+		&i.PurgedAt,
+		&i.Status,
+		&i.StatusReason,
+		&i.StatusChangedBy,
+		&i.StatusChangedAt,
+		&i.StatusExpiresAt,
+		&i.DisplayName,
+		&i.Bio,
+		&i.Locale,
+		&i.Timezone,
+		&i.Pronouns,
+		&i.Links,
+	)
+	return i, err
+}
+
+const deleteUserTOTP = `-- name: DeleteUserTOTP :exec
+DELETE FROM user_totp WHERE user_id = $1
+`
+
+func (q *Queries) DeleteUserTOTP(ctx context.Context, userID int32) error {
+	_, err := q.db.Exec(ctx, deleteUserTOTP, userID)
+	return err
+}
+
+const getActiveAPIToken = `-- name: GetActiveAPIToken :one
+SELECT t.id, t.user_id, t.scopes, u.username, u.email_verified_at, u.status, u.status_expires_at
+FROM api_tokens t JOIN users u ON u.id = t.user_id
+WHERE t.token_hash = $1 AND (t.expires_at IS NULL OR t.expires_at > NOW()) AND u.deleted_at IS NULL
+LIMIT 1
+`
+
+type GetActiveAPITokenRow struct {
+	ID              int32            `json:"id"`
+	UserID          int32            `json:"user_id"`
+	Scopes          []string         `json:"scopes"`
+	Username        string           `json:"username"`
+	EmailVerifiedAt pgtype.Timestamp `json:"email_verified_at"`
+	Status          string           `json:"status"`
+	StatusExpiresAt pgtype.Timestamp `json:"status_expires_at"`
+}
+
+func (q *Queries) GetActiveAPIToken(ctx context.Context, tokenHash string) (GetActiveAPITokenRow, error) {
+	row := q.db.QueryRow(ctx, getActiveAPIToken, tokenHash)
+	var i GetActiveAPITokenRow
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Scopes,
+		&i.Username,
+		&i.EmailVerifiedAt,
+		&i.Status,
+		&i.StatusExpiresAt,
+	)
+	return i, err
+}
+
+const getOAuthClient = `-- name: GetOAuthClient :one
+SELECT id, name, secret_hash, redirect_uris, grant_types, scopes, service_user_id, created_at FROM oauth_clients WHERE id = $1 LIMIT 1
+`
+
+func (q *Queries) GetOAuthClient(ctx context.Context, id string) (OauthClient, error) {
+	row := q.db.QueryRow(ctx, getOAuthClient, id)
+	var i OauthClient
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.SecretHash,
+		&i.RedirectUris,
+		&i.GrantTypes,
+		&i.Scopes,
+		&i.ServiceUserID,
+		&i.CreatedAt,
 	)
 	return i, err
 }
 
+const getOAuthConsent = `-- name: GetOAuthConsent :one
+SELECT scopes FROM oauth_consents WHERE user_id = $1 AND client_id = $2 LIMIT 1
+`
+
+type GetOAuthConsentParams struct {
+	UserID   int32  `json:"user_id"`
+	ClientID string `json:"client_id"`
+}
+
+func (q *Queries) GetOAuthConsent(ctx context.Context, arg GetOAuthConsentParams) ([]string, error) {
+	row := q.db.QueryRow(ctx, getOAuthConsent, arg.UserID, arg.ClientID)
+	var scopes []string
+	err := row.Scan(&scopes)
+	return scopes, err
+}
+
+const getPasswordHistory = `-- name: GetPasswordHistory :many
+SELECT password_hash FROM password_history WHERE user_id = $1 ORDER BY id DESC LIMIT $2
+`
+
+type GetPasswordHistoryParams struct {
+	UserID int32 `json:"user_id"`
+	Limit  int32 `json:"limit"`
+}
+
+func (q *Queries) GetPasswordHistory(ctx context.Context, arg GetPasswordHistoryParams) ([]string, error) {
+	rows, err := q.db.Query(ctx, getPasswordHistory, arg.UserID, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var password_hash string
+		if err := rows.Scan(&password_hash); err != nil {
+			return nil, err
+		}
+		items = append(items, password_hash)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getPermissionNames = `-- name: GetPermissionNames :many
+SELECT name FROM permissions ORDER BY name ASC
+`
+
+func (q *Queries) GetPermissionNames(ctx context.Context) ([]string, error) {
+	rows, err := q.db.Query(ctx, getPermissionNames)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		items = append(items, name)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getPermissionsByRoles = `-- name: GetPermissionsByRoles :many
+SELECT DISTINCT p.name FROM permissions p
+JOIN role_permissions rp ON rp.permission_id = p.id
+JOIN roles r ON r.id = rp.role_id
+WHERE r.name = ANY($1::text[])
+ORDER BY p.name ASC
+`
+
+func (q *Queries) GetPermissionsByRoles(ctx context.Context, roles []string) ([]string, error) {
+	rows, err := q.db.Query(ctx, getPermissionsByRoles, roles)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		items = append(items, name)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const getRoomById = `-- name: GetRoomById :one
-SELECT id, name FROM rooms WHERE id = $1 LIMIT 1
+SELECT id, name, owner_id FROM rooms WHERE id = $1 LIMIT 1
 `
 
 func (q *Queries) GetRoomById(ctx context.Context, id int32) (Room, error) {
 	row := q.db.QueryRow(ctx, getRoomById, id)
 	var i Room
-	err := row.Scan(&i.ID, &i.Name)
+	err := row.Scan(&i.ID, &i.Name, &i.OwnerID)
 	return i, err
 }
 
 const getRooms = `-- name: GetRooms :many
-SELECT id, name FROM rooms ORDER BY name ASC
+SELECT id, name, owner_id FROM rooms ORDER BY name ASC
 `
 
 func (q *Queries) GetRooms(ctx context.Context) ([]Room, error) {
@@ -129,7 +632,7 @@ func (q *Queries) GetRooms(ctx context.Context) ([]Room, error) {
 	var items []Room
 	for rows.Next() {
 		var i Room
-		if err := rows.Scan(&i.ID, &i.Name); err != nil {
+		if err := rows.Scan(&i.ID, &i.Name, &i.OwnerID); err != nil {
 			return nil, err
 		}
 		items = append(items, i)
@@ -140,8 +643,30 @@ func (q *Queries) GetRooms(ctx context.Context) ([]Room, error) {
 	return items, nil
 }
 
+const getSession = `-- name: GetSession :one
+SELECT id, user_id, token_id, refresh_family, user_agent, ip, created_at, last_seen_at, expires_at, revoked_at FROM sessions WHERE id = $1 LIMIT 1
+`
+
+func (q *Queries) GetSession(ctx context.Context, id string) (Session, error) {
+	row := q.db.QueryRow(ctx, getSession, id)
+	var i Session
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.TokenID,
+		&i.RefreshFamily,
+		&i.UserAgent,
+		&i.Ip,
+		&i.CreatedAt,
+		&i.LastSeenAt,
+		&i.ExpiresAt,
+		&i.RevokedAt,
+	)
+	return i, err
+}
+
 const getUser = `-- name: GetUser :one
-SELECT id, username, email, password, age, room_id, created_at FROM users WHERE id = $1 LIMIT 1
+SELECT id, username, email, password, age, room_id, created_at, email_verified_at, deleted_at, purged_at, status, status_reason, status_changed_by, status_changed_at, status_expires_at, display_name, bio, locale, timezone, pronouns, links FROM users WHERE id = $1 AND deleted_at IS NULL LIMIT 1
 `
 
 func (q *Queries) GetUser(ctx context.Context, id int32) (User, error) {
@@ -155,12 +680,59 @@ func (q *Queries) GetUser(ctx context.Context, id int32) (User, error) {
 		&i.Age,
 		&i.RoomID,
 		&i.CreatedAt,
+		&i.EmailVerifiedAt,
+		&i.DeletedAt,
+		&i.PurgedAt,
+		&i.Status,
+		&i.StatusReason,
+		&i.StatusChangedBy,
+		&i.StatusChangedAt,
+		&i.StatusExpiresAt,
+		&i.DisplayName,
+		&i.Bio,
+		&i.Locale,
+		&i.Timezone,
+		&i.Pronouns,
+		&i.Links,
+	)
+	return i, err
+}
+
+const getUserByEmail = `-- name: GetUserByEmail :one
+SELECT id, username, email, password, age, room_id, created_at, email_verified_at, deleted_at, purged_at, status, status_reason, status_changed_by, status_changed_at, status_expires_at, display_name, bio, locale, timezone, pronouns, links FROM users WHERE email = $1 AND deleted_at IS NULL ORDER BY id ASC LIMIT 1
+`
+
+func (q *Queries) GetUserByEmail(ctx context.Context, email string) (User, error) {
+	row := q.db.QueryRow(ctx, getUserByEmail, email)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Username,
+		&i.Email,
+		&i.Password,
+		&i.Age,
+		&i.RoomID,
+		&i.CreatedAt,
+		&i.EmailVerifiedAt,
+		&i.DeletedAt,
+		&i.PurgedAt,
+		&i.Status,
+		&i.StatusReason,
+		&i.StatusChangedBy,
+		&i.StatusChangedAt,
+		&i.StatusExpiresAt,
+		&i.DisplayName,
+		&i.Bio,
+		&i.Locale,
+		&i.Timezone,
+		&i.Pronouns,
+		&i.Links,
 	)
 	return i, err
 }
 
 const getUserByUsername = `-- name: GetUserByUsername :one
-SELECT id, username, email, password, age, room_id, created_at FROM users WHERE username = $1 LIMIT 1
+SELECT id, username, email, password, age, room_id, created_at, email_verified_at, deleted_at, purged_at, status, status_reason, status_changed_by, status_changed_at, status_expires_at, display_name, bio, locale, timezone, pronouns, links FROM users WHERE username = $1 AND deleted_at IS NULL LIMIT 1
 `
 
 func (q *Queries) GetUserByUsername(ctx context.Context, username string) (User, error) {
@@ -174,12 +746,90 @@ func (q *Queries) GetUserByUsername(ctx context.Context, username string) (User,
 		&i.Age,
 		&i.RoomID,
 		&i.CreatedAt,
+		&i.EmailVerifiedAt,
+		&i.DeletedAt,
+		&i.PurgedAt,
+		&i.Status,
+		&i.StatusReason,
+		&i.StatusChangedBy,
+		&i.StatusChangedAt,
+		&i.StatusExpiresAt,
+		&i.DisplayName,
+		&i.Bio,
+		&i.Locale,
+		&i.Timezone,
+		&i.Pronouns,
+		&i.Links,
+	)
+	return i, err
+}
+
+const getUserIdentity = `-- name: GetUserIdentity :one
+SELECT id, user_id, provider, subject, email, created_at, last_login_at FROM user_identities WHERE provider = $1 AND subject = $2 LIMIT 1
+`
+
+type GetUserIdentityParams struct {
+	Provider string `json:"provider"`
+	Subject  string `json:"subject"`
+}
+
+func (q *Queries) GetUserIdentity(ctx context.Context, arg GetUserIdentityParams) (UserIdentity, error) {
+	row := q.db.QueryRow(ctx, getUserIdentity, arg.Provider, arg.Subject)
+	var i UserIdentity
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Provider,
+		&i.Subject,
+		&i.Email,
+		&i.CreatedAt,
+		&i.LastLoginAt,
+	)
+	return i, err
+}
+
+const getUserRoles = `-- name: GetUserRoles :many
+SELECT r.name FROM roles r JOIN user_roles ur ON ur.role_id = r.id WHERE ur.user_id = $1 ORDER BY r.name ASC
+`
+
+func (q *Queries) GetUserRoles(ctx context.Context, userID int32) ([]string, error) {
+	rows, err := q.db.Query(ctx, getUserRoles, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		items = append(items, name)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getUserTOTP = `-- name: GetUserTOTP :one
+SELECT user_id, secret, confirmed_at, created_at FROM user_totp WHERE user_id = $1 LIMIT 1
+`
+
+func (q *Queries) GetUserTOTP(ctx context.Context, userID int32) (UserTotp, error) {
+	row := q.db.QueryRow(ctx, getUserTOTP, userID)
+	var i UserTotp
+	err := row.Scan(
+		&i.UserID,
+		&i.Secret,
+		&i.ConfirmedAt,
+		&i.CreatedAt,
 	)
 	return i, err
 }
 
 const getUsers = `-- name: GetUsers :many
-SELECT id, username, email, password, age, room_id, created_at FROM users ORDER BY username ASC
+SELECT id, username, email, password, age, room_id, created_at, email_verified_at, deleted_at, purged_at, status, status_reason, status_changed_by, status_changed_at, status_expires_at, display_name, bio, locale, timezone, pronouns, links FROM users WHERE deleted_at IS NULL ORDER BY username ASC
 `
 
 func (q *Queries) GetUsers(ctx context.Context) ([]User, error) {
@@ -199,6 +849,20 @@ func (q *Queries) GetUsers(ctx context.Context) ([]User, error) {
 			&i.Age,
 			&i.RoomID,
 			&i.CreatedAt,
+			&i.EmailVerifiedAt,
+			&i.DeletedAt,
+			&i.PurgedAt,
+			&i.Status,
+			&i.StatusReason,
+			&i.StatusChangedBy,
+			&i.StatusChangedAt,
+			&i.StatusExpiresAt,
+			&i.DisplayName,
+			&i.Bio,
+			&i.Locale,
+			&i.Timezone,
+			&i.Pronouns,
+			&i.Links,
 		); err != nil {
 			return nil, err
 		}
@@ -211,7 +875,7 @@ func (q *Queries) GetUsers(ctx context.Context) ([]User, error) {
 }
 
 const getUsersByRoomID = `-- name: GetUsersByRoomID :many
-SELECT id, username, email, password, age, room_id, created_at FROM users WHERE room_id = $1 ORDER BY id ASC
+SELECT id, username, email, password, age, room_id, created_at, email_verified_at, deleted_at, purged_at, status, status_reason, status_changed_by, status_changed_at, status_expires_at, display_name, bio, locale, timezone, pronouns, links FROM users WHERE room_id = $1 AND deleted_at IS NULL ORDER BY id ASC
 `
 
 func (q *Queries) GetUsersByRoomID(ctx context.Context, roomID pgtype.Int4) ([]User, error) {
@@ -231,6 +895,20 @@ func (q *Queries) GetUsersByRoomID(ctx context.Context, roomID pgtype.Int4) ([]U
 			&i.Age,
 			&i.RoomID,
 			&i.CreatedAt,
+			&i.EmailVerifiedAt,
+			&i.DeletedAt,
+			&i.PurgedAt,
+			&i.Status,
+			&i.StatusReason,
+			&i.StatusChangedBy,
+			&i.StatusChangedAt,
+			&i.StatusExpiresAt,
+			&i.DisplayName,
+			&i.Bio,
+			&i.Locale,
+			&i.Timezone,
+			&i.Pronouns,
+			&i.Links,
 		); err != nil {
 			return nil, err
 		}
@@ -242,11 +920,249 @@ func (q *Queries) GetUsersByRoomID(ctx context.Context, roomID pgtype.Int4) ([]U
 	return items, nil
 }
 
-const removeUserFromARoom = `-- name: RemoveUserFromARoom :one
-UPDATE users SET room_id = NULL WHERE id = $1 RETURNING id, username, email, password, age, room_id, created_at
+const listAPITokens = `-- name: ListAPITokens :many
+SELECT id, user_id, name, token_prefix, token_hash, scopes, expires_at, last_used_at, last_used_ip, created_at FROM api_tokens WHERE user_id = $1 ORDER BY id ASC
 `
 
-func (q *Queries) RemoveUserFromARoom(ctx context.Context, id int32) (User, error) {
+func (q *Queries) ListAPITokens(ctx context.Context, userID int32) ([]ApiToken, error) {
+	rows, err := q.db.Query(ctx, listAPITokens, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ApiToken
+	for rows.Next() {
+		var i ApiToken
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.Name,
+			&i.TokenPrefix,
+			&i.TokenHash,
+			&i.Scopes,
+			&i.ExpiresAt,
+			&i.LastUsedAt,
+			&i.LastUsedIp,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listAuditLog = `-- name: ListAuditLog :many
+SELECT id, action, actor_id, actor_username, target_user_id, target_username, token_id, reason, ip, user_agent, created_at FROM audit_log
+WHERE $1::int IS NULL OR actor_id = $1 OR target_user_id = $1
+ORDER BY id DESC
+LIMIT $3 OFFSET $2
+`
+
+type ListAuditLogParams struct {
+	UserID pgtype.Int4 `json:"user_id"`
+	Offset int32       `json:"offset"`
+	Limit  int32       `json:"limit"`
+}
+
+func (q *Queries) ListAuditLog(ctx context.Context, arg ListAuditLogParams) ([]AuditLog, error) {
+	rows, err := q.db.Query(ctx, listAuditLog, arg.UserID, arg.Offset, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []AuditLog
+	for rows.Next() {
+		var i AuditLog
+		if err := rows.Scan(
+			&i.ID,
+			&i.Action,
+			&i.ActorID,
+			&i.ActorUsername,
+			&i.TargetUserID,
+			&i.TargetUsername,
+			&i.TokenID,
+			&i.Reason,
+			&i.Ip,
+			&i.UserAgent,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listOAuthClients = `-- name: ListOAuthClients :many
+SELECT id, name, secret_hash, redirect_uris, grant_types, scopes, service_user_id, created_at FROM oauth_clients ORDER BY created_at ASC
+`
+
+func (q *Queries) ListOAuthClients(ctx context.Context) ([]OauthClient, error) {
+	rows, err := q.db.Query(ctx, listOAuthClients)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []OauthClient
+	for rows.Next() {
+		var i OauthClient
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.SecretHash,
+			&i.RedirectUris,
+			&i.GrantTypes,
+			&i.Scopes,
+			&i.ServiceUserID,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listPurgeableUsers = `-- name: ListPurgeableUsers :many
+SELECT id FROM users WHERE deleted_at < NOW() - $1::interval AND purged_at IS NULL ORDER BY deleted_at ASC LIMIT $2
+`
+
+type ListPurgeableUsersParams struct {
+	Retention pgtype.Interval `json:"retention"`
+	MaxUsers  int32           `json:"max_users"`
+}
+
+func (q *Queries) ListPurgeableUsers(ctx context.Context, arg ListPurgeableUsersParams) ([]int32, error) {
+	rows, err := q.db.Query(ctx, listPurgeableUsers, arg.Retention, arg.MaxUsers)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []int32
+	for rows.Next() {
+		var id int32
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		items = append(items, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listSessions = `-- name: ListSessions :many
+SELECT id, user_id, token_id, refresh_family, user_agent, ip, created_at, last_seen_at, expires_at, revoked_at FROM sessions
+WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+ORDER BY last_seen_at DESC
+`
+
+func (q *Queries) ListSessions(ctx context.Context, userID int32) ([]Session, error) {
+	rows, err := q.db.Query(ctx, listSessions, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Session
+	for rows.Next() {
+		var i Session
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.TokenID,
+			&i.RefreshFamily,
+			&i.UserAgent,
+			&i.Ip,
+			&i.CreatedAt,
+			&i.LastSeenAt,
+			&i.ExpiresAt,
+			&i.RevokedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const prunePasswordHistory = `-- name: PrunePasswordHistory :exec
+DELETE FROM password_history ph
+WHERE ph.user_id = $1 AND ph.id NOT IN (
+    SELECT recent.id FROM password_history recent WHERE recent.user_id = $1 ORDER BY recent.id DESC LIMIT $2
+)
+`
+
+type PrunePasswordHistoryParams struct {
+	UserID int32 `json:"user_id"`
+	Limit  int32 `json:"limit"`
+}
+
+func (q *Queries) PrunePasswordHistory(ctx context.Context, arg PrunePasswordHistoryParams) error {
+	_, err := q.db.Exec(ctx, prunePasswordHistory, arg.UserID, arg.Limit)
+	return err
+}
+
+const purgeUser = `-- name: PurgeUser :execrows
+DELETE FROM users WHERE id = $1 AND deleted_at < NOW() - $2::interval AND purged_at IS NULL
+`
+
+type PurgeUserParams struct {
+	ID        int32           `json:"id"`
+	Retention pgtype.Interval `json:"retention"`
+}
+
+func (q *Queries) PurgeUser(ctx context.Context, arg PurgeUserParams) (int64, error) {
+	result, err := q.db.Exec(ctx, purgeUser, arg.ID, arg.Retention)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const refreshSession = `-- name: RefreshSession :execrows
+UPDATE sessions SET token_id = $2, ip = $3, last_seen_at = NOW(), expires_at = $4
+WHERE id = $1 AND revoked_at IS NULL
+`
+
+type RefreshSessionParams struct {
+	ID        string           `json:"id"`
+	TokenID   string           `json:"token_id"`
+	Ip        string           `json:"ip"`
+	ExpiresAt pgtype.Timestamp `json:"expires_at"`
+}
+
+func (q *Queries) RefreshSession(ctx context.Context, arg RefreshSessionParams) (int64, error) {
+	result, err := q.db.Exec(ctx, refreshSession,
+		arg.ID,
+		arg.TokenID,
+		arg.Ip,
+		arg.ExpiresAt,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const removeUserFromARoom = `-- name: RemoveUserFromARoom :one
+UPDATE users SET room_id = NULL WHERE id = $1 AND deleted_at IS NULL RETURNING id, username, email, password, age, room_id, created_at, email_verified_at, deleted_at, purged_at, status, status_reason, status_changed_by, status_changed_at, status_expires_at, display_name, bio, locale, timezone, pronouns, links
+`
+
+func (q *Queries) RemoveUserFromARoom(ctx context.Context, id int32) (User, error) {
 	row := q.db.QueryRow(ctx, removeUserFromARoom, id)
 	var i User
 	err := row.Scan(
@@ -257,12 +1173,359 @@ func (q *Queries) RemoveUserFromARoom(ctx context.Context, id int32) (User, erro
 		&i.Age,
 		&i.RoomID,
 		&i.CreatedAt,
+		&i.EmailVerifiedAt,
+		&i.DeletedAt,
+		&i.PurgedAt,
+		&i.Status,
+		&i.StatusReason,
+		&i.StatusChangedBy,
+		&i.StatusChangedAt,
+		&i.StatusExpiresAt,
+		&i.DisplayName,
+		&i.Bio,
+		&i.Locale,
+		&i.Timezone,
+		&i.Pronouns,
+		&i.Links,
 	)
 	return i, err
 }
 
+const removeUserRole = `-- name: RemoveUserRole :execrows
+DELETE FROM user_roles ur USING roles r WHERE ur.role_id = r.id AND ur.user_id = $1 AND r.name = $2
+`
+
+type RemoveUserRoleParams struct {
+	UserID int32  `json:"user_id"`
+	Role   string `json:"role"`
+}
+
+func (q *Queries) RemoveUserRole(ctx context.Context, arg RemoveUserRoleParams) (int64, error) {
+	result, err := q.db.Exec(ctx, removeUserRole, arg.UserID, arg.Role)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const restoreUser = `-- name: RestoreUser :one
+UPDATE users SET deleted_at = NULL WHERE id = $1 AND deleted_at IS NOT NULL AND purged_at IS NULL RETURNING id, username, email, password, age, room_id, created_at, email_verified_at, deleted_at, purged_at, status, status_reason, status_changed_by, status_changed_at, status_expires_at, display_name, bio, locale, timezone, pronouns, links
+`
+
+func (q *Queries) RestoreUser(ctx context.Context, id int32) (User, error) {
+	row := q.db.QueryRow(ctx, restoreUser, id)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Username,
+		&i.Email,
+		&i.Password,
+		&i.Age,
+		&i.RoomID,
+		&i.CreatedAt,
+		&i.EmailVerifiedAt,
+		&i.DeletedAt,
+		&i.PurgedAt,
+		&i.Status,
+		&i.StatusReason,
+		&i.StatusChangedBy,
+		&i.StatusChangedAt,
+		&i.StatusExpiresAt,
+		&i.DisplayName,
+		&i.Bio,
+		&i.Locale,
+		&i.Timezone,
+		&i.Pronouns,
+		&i.Links,
+	)
+	return i, err
+}
+
+const revokeSession = `-- name: RevokeSession :one
+UPDATE sessions SET revoked_at = NOW()
+WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+RETURNING id, user_id, token_id, refresh_family, user_agent, ip, created_at, last_seen_at, expires_at, revoked_at
+`
+
+type RevokeSessionParams struct {
+	ID     string `json:"id"`
+	UserID int32  `json:"user_id"`
+}
+
+func (q *Queries) RevokeSession(ctx context.Context, arg RevokeSessionParams) (Session, error) {
+	row := q.db.QueryRow(ctx, revokeSession, arg.ID, arg.UserID)
+	var i Session
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.TokenID,
+		&i.RefreshFamily,
+		&i.UserAgent,
+		&i.Ip,
+		&i.CreatedAt,
+		&i.LastSeenAt,
+		&i.ExpiresAt,
+		&i.RevokedAt,
+	)
+	return i, err
+}
+
+const revokeUserSessions = `-- name: RevokeUserSessions :exec
+UPDATE sessions SET revoked_at = NOW()
+WHERE user_id = $1 AND revoked_at IS NULL
+`
+
+func (q *Queries) RevokeUserSessions(ctx context.Context, userID int32) error {
+	_, err := q.db.Exec(ctx, revokeUserSessions, userID)
+	return err
+}
+
+const saveOAuthConsent = `-- name: SaveOAuthConsent :exec
+INSERT INTO oauth_consents (user_id, client_id, scopes) VALUES ($1, $2, $3)
+ON CONFLICT (user_id, client_id) DO UPDATE SET scopes = EXCLUDED.scopes, granted_at = NOW()
+`
+
+type SaveOAuthConsentParams struct {
+	UserID   int32    `json:"user_id"`
+	ClientID string   `json:"client_id"`
+	Scopes   []string `json:"scopes"`
+}
+
+func (q *Queries) SaveOAuthConsent(ctx context.Context, arg SaveOAuthConsentParams) error {
+	_, err := q.db.Exec(ctx, saveOAuthConsent, arg.UserID, arg.ClientID, arg.Scopes)
+	return err
+}
+
+const searchUsers = `-- name: SearchUsers :many
+SELECT users.id, users.username, users.email, users.password, users.age, users.room_id, users.created_at, users.email_verified_at, users.deleted_at, users.purged_at, users.status, users.status_reason, users.status_changed_by, users.status_changed_at, users.status_expires_at, users.display_name, users.bio, users.locale, users.timezone, users.pronouns, users.links,
+    (lower(username) LIKE $1::text OR lower(email) LIKE $1::text)::boolean AS prefix_match,
+    GREATEST(similarity(lower(username), $2::text), similarity(lower(email), $2::text))::real AS score
+FROM users
+WHERE deleted_at IS NULL AND (
+    lower(username) LIKE $1::text OR lower(email) LIKE $1::text
+    OR lower(username) % $2::text OR lower(email) % $2::text
+)
+ORDER BY prefix_match DESC, score DESC, username ASC, id ASC
+LIMIT $3
+`
+
+type SearchUsersParams struct {
+	Prefix string `json:"prefix"`
+	Query  string `json:"query"`
+	Limit  int32  `json:"limit"`
+}
+
+type SearchUsersRow struct {
+	User        User    `json:"user"`
+	PrefixMatch bool    `json:"prefix_match"`
+	Score       float32 `json:"score"`
+}
+
+func (q *Queries) SearchUsers(ctx context.Context, arg SearchUsersParams) ([]SearchUsersRow, error) {
+	rows, err := q.db.Query(ctx, searchUsers, arg.Prefix, arg.Query, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []SearchUsersRow
+	for rows.Next() {
+		var i SearchUsersRow
+		if err := rows.Scan(
+			&i.User.ID,
+			&i.User.Username,
+			&i.User.Email,
+			&i.User.Password,
+			&i.User.Age,
+			&i.User.RoomID,
+			&i.User.CreatedAt,
+			&i.User.EmailVerifiedAt,
+			&i.User.DeletedAt,
+			&i.User.PurgedAt,
+			&i.User.Status,
+			&i.User.StatusReason,
+			&i.User.StatusChangedBy,
+			&i.User.StatusChangedAt,
+			&i.User.StatusExpiresAt,
+			&i.User.DisplayName,
+			&i.User.Bio,
+			&i.User.Locale,
+			&i.User.Timezone,
+			&i.User.Pronouns,
+			&i.User.Links,
+			&i.PrefixMatch,
+			&i.Score,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const searchUsersByPrefix = `-- name: SearchUsersByPrefix :many
+SELECT users.id, users.username, users.email, users.password, users.age, users.room_id, users.created_at, users.email_verified_at, users.deleted_at, users.purged_at, users.status, users.status_reason, users.status_changed_by, users.status_changed_at, users.status_expires_at, users.display_name, users.bio, users.locale, users.timezone, users.pronouns, users.links, GREATEST(similarity(lower(username), $1::text), similarity(lower(email), $1::text))::real AS score
+FROM users
+WHERE deleted_at IS NULL AND (lower(username) LIKE $2::text OR lower(email) LIKE $2::text)
+ORDER BY score DESC, username ASC, id ASC
+LIMIT $3
+`
+
+type SearchUsersByPrefixParams struct {
+	Query  string `json:"query"`
+	Prefix string `json:"prefix"`
+	Limit  int32  `json:"limit"`
+}
+
+type SearchUsersByPrefixRow struct {
+	User  User    `json:"user"`
+	Score float32 `json:"score"`
+}
+
+func (q *Queries) SearchUsersByPrefix(ctx context.Context, arg SearchUsersByPrefixParams) ([]SearchUsersByPrefixRow, error) {
+	rows, err := q.db.Query(ctx, searchUsersByPrefix, arg.Query, arg.Prefix, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []SearchUsersByPrefixRow
+	for rows.Next() {
+		var i SearchUsersByPrefixRow
+		if err := rows.Scan(
+			&i.User.ID,
+			&i.User.Username,
+			&i.User.Email,
+			&i.User.Password,
+			&i.User.Age,
+			&i.User.RoomID,
+			&i.User.CreatedAt,
+			&i.User.EmailVerifiedAt,
+			&i.User.DeletedAt,
+			&i.User.PurgedAt,
+			&i.User.Status,
+			&i.User.StatusReason,
+			&i.User.StatusChangedBy,
+			&i.User.StatusChangedAt,
+			&i.User.StatusExpiresAt,
+			&i.User.DisplayName,
+			&i.User.Bio,
+			&i.User.Locale,
+			&i.User.Timezone,
+			&i.User.Pronouns,
+			&i.User.Links,
+			&i.Score,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const setUserStatus = `-- name: SetUserStatus :one
+UPDATE users SET status = $1, status_reason = $2, status_changed_by = $3,
+    status_changed_at = NOW(), status_expires_at = $4
+WHERE id = $5 AND deleted_at IS NULL RETURNING id, username, email, password, age, room_id, created_at, email_verified_at, deleted_at, purged_at, status, status_reason, status_changed_by, status_changed_at, status_expires_at, display_name, bio, locale, timezone, pronouns, links
+`
+
+type SetUserStatusParams struct {
+	Status    string           `json:"status"`
+	Reason    pgtype.Text      `json:"reason"`
+	ChangedBy pgtype.Int4      `json:"changed_by"`
+	ExpiresAt pgtype.Timestamp `json:"expires_at"`
+	ID        int32            `json:"id"`
+}
+
+func (q *Queries) SetUserStatus(ctx context.Context, arg SetUserStatusParams) (User, error) {
+	row := q.db.QueryRow(ctx, setUserStatus,
+		arg.Status,
+		arg.Reason,
+		arg.ChangedBy,
+		arg.ExpiresAt,
+		arg.ID,
+	)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Username,
+		&i.Email,
+		&i.Password,
+		&i.Age,
+		&i.RoomID,
+		&i.CreatedAt,
+		&i.EmailVerifiedAt,
+		&i.DeletedAt,
+		&i.PurgedAt,
+		&i.Status,
+		&i.StatusReason,
+		&i.StatusChangedBy,
+		&i.StatusChangedAt,
+		&i.StatusExpiresAt,
+		&i.DisplayName,
+		&i.Bio,
+		&i.Locale,
+		&i.Timezone,
+		&i.Pronouns,
+		&i.Links,
+	)
+	return i, err
+}
+
+const touchAPIToken = `-- name: TouchAPIToken :exec
+UPDATE api_tokens SET last_used_at = NOW(), last_used_ip = $1::varchar
+WHERE id = $2 AND (
+    last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute' OR last_used_ip IS DISTINCT FROM $1::varchar
+)
+`
+
+type TouchAPITokenParams struct {
+	Ip string `json:"ip"`
+	ID int32  `json:"id"`
+}
+
+func (q *Queries) TouchAPIToken(ctx context.Context, arg TouchAPITokenParams) error {
+	_, err := q.db.Exec(ctx, touchAPIToken, arg.Ip, arg.ID)
+	return err
+}
+
+const touchSession = `-- name: TouchSession :exec
+UPDATE sessions SET last_seen_at = NOW(), ip = $2 WHERE id = $1 AND revoked_at IS NULL
+`
+
+type TouchSessionParams struct {
+	ID string `json:"id"`
+	Ip string `json:"ip"`
+}
+
+func (q *Queries) TouchSession(ctx context.Context, arg TouchSessionParams) error {
+	_, err := q.db.Exec(ctx, touchSession, arg.ID, arg.Ip)
+	return err
+}
+
+const touchUserIdentity = `-- name: TouchUserIdentity :exec
+UPDATE user_identities SET email = $2, last_login_at = NOW() WHERE id = $1
+`
+
+type TouchUserIdentityParams struct {
+	ID    int32  `json:"id"`
+	Email string `json:"email"`
+}
+
+func (q *Queries) TouchUserIdentity(ctx context.Context, arg TouchUserIdentityParams) error {
+	_, err := q.db.Exec(ctx, touchUserIdentity, arg.ID, arg.Email)
+	return err
+}
+
 const updateUser = `-- name: UpdateUser :one
-UPDATE users SET username = $2, email = $3, age = $4 WHERE id = $1 RETURNING id, username, email, password, age, room_id, created_at
+UPDATE users SET username = $2, email = $3, age = $4,
+    email_verified_at = CASE WHEN email = $3 THEN email_verified_at END,
+    status = CASE WHEN email <> $3 AND status = 'active' THEN 'pending_verification' ELSE status END
+WHERE id = $1 AND deleted_at IS NULL RETURNING id, username, email, password, age, room_id, created_at, email_verified_at, deleted_at, purged_at, status, status_reason, status_changed_by, status_changed_at, status_expires_at, display_name, bio, locale, timezone, pronouns, links
 `
 
 type UpdateUserParams struct {
@@ -288,12 +1551,26 @@ func (q *Queries) UpdateUser(ctx context.Context, arg UpdateUserParams) (User, e
 		&i.Age,
 		&i.RoomID,
 		&i.CreatedAt,
+		&i.EmailVerifiedAt,
+		&i.DeletedAt,
+		&i.PurgedAt,
+		&i.Status,
+		&i.StatusReason,
+		&i.StatusChangedBy,
+		&i.StatusChangedAt,
+		&i.StatusExpiresAt,
+		&i.DisplayName,
+		&i.Bio,
+		&i.Locale,
+		&i.Timezone,
+		&i.Pronouns,
+		&i.Links,
 	)
 	return i, err
 }
 
 const updateUserPassword = `-- name: UpdateUserPassword :one
-UPDATE users SET password = $2 WHERE id = $1 RETURNING id, username, email, password, age, room_id, created_at
+UPDATE users SET password = $2 WHERE id = $1 AND deleted_at IS NULL RETURNING id, username, email, password, age, room_id, created_at, email_verified_at, deleted_at, purged_at, status, status_reason, status_changed_by, status_changed_at, status_expires_at, display_name, bio, locale, timezone, pronouns, links
 `
 
 type UpdateUserPasswordParams struct {
@@ -312,6 +1589,124 @@ func (q *Queries) UpdateUserPassword(ctx context.Context, arg UpdateUserPassword
 		&i.Age,
 		&i.RoomID,
 		&i.CreatedAt,
+		&i.EmailVerifiedAt,
+		&i.DeletedAt,
+		&i.PurgedAt,
+		&i.Status,
+		&i.StatusReason,
+		&i.StatusChangedBy,
+		&i.StatusChangedAt,
+		&i.StatusExpiresAt,
+		&i.DisplayName,
+		&i.Bio,
+		&i.Locale,
+		&i.Timezone,
+		&i.Pronouns,
+		&i.Links,
 	)
 	return i, err
 }
+
+const updateUserProfile = `-- name: UpdateUserProfile :one
+UPDATE users SET display_name = $1, bio = $2, locale = $3, timezone = $4,
+    pronouns = $5, links = $6::text[]
+WHERE id = $7 AND deleted_at IS NULL RETURNING id, username, email, password, age, room_id, created_at, email_verified_at, deleted_at, purged_at, status, status_reason, status_changed_by, status_changed_at, status_expires_at, display_name, bio, locale, timezone, pronouns, links
+`
+
+type UpdateUserProfileParams struct {
+	DisplayName pgtype.Text `json:"display_name"`
+	Bio         pgtype.Text `json:"bio"`
+	Locale      pgtype.Text `json:"locale"`
+	Timezone    pgtype.Text `json:"timezone"`
+	Pronouns    pgtype.Text `json:"pronouns"`
+	Links       []string    `json:"links"`
+	ID          int32       `json:"id"`
+}
+
+func (q *Queries) UpdateUserProfile(ctx context.Context, arg UpdateUserProfileParams) (User, error) {
+	row := q.db.QueryRow(ctx, updateUserProfile,
+		arg.DisplayName,
+		arg.Bio,
+		arg.Locale,
+		arg.Timezone,
+		arg.Pronouns,
+		arg.Links,
+		arg.ID,
+	)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Username,
+		&i.Email,
+		&i.Password,
+		&i.Age,
+		&i.RoomID,
+		&i.CreatedAt,
+		&i.EmailVerifiedAt,
+		&i.DeletedAt,
+		&i.PurgedAt,
+		&i.Status,
+		&i.StatusReason,
+		&i.StatusChangedBy,
+		&i.StatusChangedAt,
+		&i.StatusExpiresAt,
+		&i.DisplayName,
+		&i.Bio,
+		&i.Locale,
+		&i.Timezone,
+		&i.Pronouns,
+		&i.Links,
+	)
+	return i, err
+}
+
+const upsertUserTOTP = `-- name: UpsertUserTOTP :exec
+INSERT INTO user_totp (user_id, secret) VALUES ($1, $2)
+ON CONFLICT (user_id) DO UPDATE SET secret = EXCLUDED.secret, confirmed_at = NULL, created_at = NOW()
+`
+
+type UpsertUserTOTPParams struct {
+	UserID int32  `json:"user_id"`
+	Secret string `json:"secret"`
+}
+
+func (q *Queries) UpsertUserTOTP(ctx context.Context, arg UpsertUserTOTPParams) error {
+	_, err := q.db.Exec(ctx, upsertUserTOTP, arg.UserID, arg.Secret)
+	return err
+}
+
+const useRecoveryCode = `-- name: UseRecoveryCode :execrows
+UPDATE recovery_codes SET used_at = NOW() WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+`
+
+type UseRecoveryCodeParams struct {
+	UserID   int32  `json:"user_id"`
+	CodeHash string `json:"code_hash"`
+}
+
+func (q *Queries) UseRecoveryCode(ctx context.Context, arg UseRecoveryCodeParams) (int64, error) {
+	result, err := q.db.Exec(ctx, useRecoveryCode, arg.UserID, arg.CodeHash)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const verifyUserEmail = `-- name: VerifyUserEmail :execrows
+UPDATE users SET email_verified_at = NOW(),
+    status = CASE WHEN status = 'pending_verification' THEN 'active' ELSE status END
+WHERE id = $1 AND email = $2 AND email_verified_at IS NULL AND deleted_at IS NULL
+`
+
+type VerifyUserEmailParams struct {
+	ID    int32  `json:"id"`
+	Email string `json:"email"`
+}
+
+func (q *Queries) VerifyUserEmail(ctx context.Context, arg VerifyUserEmailParams) (int64, error) {
+	result, err := q.db.Exec(ctx, verifyUserEmail, arg.ID, arg.Email)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
@@ -0,0 +1,188 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// UserSort is a column users can be listed by. Ties are broken by id.
+type UserSort string
+
+const (
+	UserSortUsername  UserSort = "username"
+	UserSortCreatedAt UserSort = "created_at"
+	UserSortAge       UserSort = "age"
+	UserSortID        UserSort = "id"
+)
+
+// userSortExpressions match the indexes of the user listing migration. Users
+// without an age sort as if they were -1.
+var userSortExpressions = map[UserSort]string{
+	UserSortUsername:  "username",
+	UserSortCreatedAt: "created_at",
+	UserSortAge:       "COALESCE(age, -1)",
+	UserSortID:        "id",
+}
+
+// Valid reports whether users can be listed by the column.
+func (s UserSort) Valid() bool {
+	_, ok := userSortExpressions[s]
+	return ok
+}
+
+// Value is what the user is sorted by, as AfterValue expects it.
+func (s UserSort) Value(user User) interface{} {
+	switch s {
+	case UserSortUsername:
+		return user.Username
+	case UserSortCreatedAt:
+		return user.CreatedAt.Time
+	case UserSortAge:
+		if !user.Age.Valid {
+			return int32(-1)
+		}
+		return user.Age.Int32
+	default:
+		return user.ID
+	}
+}
+
+// UserFilter narrows a user listing down, unset fields do not filter.
+type UserFilter struct {
+	MinAge        pgtype.Int4
+	MaxAge        pgtype.Int4
+	CreatedAfter  pgtype.Timestamp
+	CreatedBefore pgtype.Timestamp
+	RoomID        pgtype.Int4
+	// EmailDomain is matched against the part of the address after the @, ignoring case
+	EmailDomain string
+	// Status is the stored account status, a suspension that ran out still counts
+	Status string
+}
+
+type ListUsersParams struct {
+	UserFilter
+	Sort       UserSort
+	Descending bool
+	// AfterValue and AfterID continue the listing behind the user they were taken from
+	AfterValue interface{}
+	AfterID    pgtype.Int4
+	Limit      int32
+}
+
+const userColumns = "id, username, email, password, age, room_id, created_at, email_verified_at, deleted_at, purged_at, " +
+	"status, status_reason, status_changed_by, status_changed_at, status_expires_at, " +
+	"display_name, bio, locale, timezone, pronouns, links"
+
+// where returns the filter's conditions along with the arguments they refer to.
+// Deleted users are never listed.
+func (f UserFilter) where() ([]string, []interface{}) {
+	conditions := []string{"deleted_at IS NULL"}
+	var args []interface{}
+	add := func(condition string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if f.MinAge.Valid {
+		add("age >= $%d", f.MinAge)
+	}
+	if f.MaxAge.Valid {
+		add("age <= $%d", f.MaxAge)
+	}
+	if f.CreatedAfter.Valid {
+		add("created_at >= $%d", f.CreatedAfter)
+	}
+	if f.CreatedBefore.Valid {
+		add("created_at < $%d", f.CreatedBefore)
+	}
+	if f.RoomID.Valid {
+		add("room_id = $%d", f.RoomID)
+	}
+	if f.EmailDomain != "" {
+		add("lower(split_part(email, '@', 2)) = lower($%d)", f.EmailDomain)
+	}
+	if f.Status != "" {
+		add("status = $%d", f.Status)
+	}
+	return conditions, args
+}
+
+func whereClause(conditions []string) string {
+	return " WHERE " + strings.Join(conditions, " AND ")
+}
+
+// ListUsers returns a page of the users matching the filter, sorted by the given column.
+// It is written by hand, sqlc cannot generate queries whose filters and ORDER BY
+// depend on the request.
+func (q *Queries) ListUsers(ctx context.Context, arg ListUsersParams) ([]User, error) {
+	sortExpression, ok := userSortExpressions[arg.Sort]
+	if !ok {
+		return nil, fmt.Errorf("cannot sort users by %q", arg.Sort)
+	}
+	direction, comparison := "ASC", ">"
+	if arg.Descending {
+		direction, comparison = "DESC", "<"
+	}
+
+	conditions, args := arg.UserFilter.where()
+	if arg.AfterID.Valid {
+		args = append(args, arg.AfterValue, arg.AfterID)
+		conditions = append(conditions, fmt.Sprintf("(%s, id) %s ($%d, $%d)", sortExpression, comparison, len(args)-1, len(args)))
+	}
+	args = append(args, arg.Limit)
+	query := fmt.Sprintf("SELECT %s FROM users%s ORDER BY %s %s, id %s LIMIT $%d",
+		userColumns, whereClause(conditions), sortExpression, direction, direction, len(args))
+
+	rows, err := q.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []User
+	for rows.Next() {
+		var i User
+		if err := rows.Scan(
+			&i.ID,
+			&i.Username,
+			&i.Email,
+			&i.Password,
+			&i.Age,
+			&i.RoomID,
+			&i.CreatedAt,
+			&i.EmailVerifiedAt,
+			&i.DeletedAt,
+			&i.PurgedAt,
+			&i.Status,
+			&i.StatusReason,
+			&i.StatusChangedBy,
+			&i.StatusChangedAt,
+			&i.StatusExpiresAt,
+			&i.DisplayName,
+			&i.Bio,
+			&i.Locale,
+			&i.Timezone,
+			&i.Pronouns,
+			&i.Links,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// CountUsers counts the users matching the filter.
+func (q *Queries) CountUsers(ctx context.Context, filter UserFilter) (int64, error) {
+	conditions, args := filter.where()
+	row := q.db.QueryRow(ctx, "SELECT count(*) FROM users"+whereClause(conditions), args...)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
@@ -0,0 +1,45 @@
+package dto
+
+import (
+	"main/db"
+	"time"
+)
+
+// APIToken describes an API key without the key itself, which is only shown when it is created.
+type APIToken struct {
+	ID         int32      `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	LastUsedIP *string    `json:"last_used_ip,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+func NewAPIToken(token db.ApiToken) APIToken {
+	view := APIToken{
+		ID:         token.ID,
+		Name:       token.Name,
+		Prefix:     token.TokenPrefix,
+		Scopes:     token.Scopes,
+		ExpiresAt:  timeOrNil(token.ExpiresAt),
+		LastUsedAt: timeOrNil(token.LastUsedAt),
+		CreatedAt:  token.CreatedAt.Time,
+	}
+	if view.Scopes == nil {
+		view.Scopes = []string{}
+	}
+	if token.LastUsedIp.Valid {
+		view.LastUsedIP = &token.LastUsedIp.String
+	}
+	return view
+}
+
+func NewAPITokens(tokens []db.ApiToken) []APIToken {
+	views := make([]APIToken, 0, len(tokens))
+	for _, token := range tokens {
+		views = append(views, NewAPIToken(token))
+	}
+	return views
+}
@@ -0,0 +1,44 @@
+package dto
+
+import (
+	"main/db"
+	"time"
+)
+
+// AuditLogEntry is something done to a user's account, such as an admin impersonating them.
+// The usernames are the ones the users had at the time, the ids are nil once a user is deleted.
+type AuditLogEntry struct {
+	ID             int32     `json:"id"`
+	Action         string    `json:"action"`
+	ActorID        *int32    `json:"actor_id"`
+	ActorUsername  string    `json:"actor_username"`
+	TargetUserID   *int32    `json:"target_user_id"`
+	TargetUsername string    `json:"target_username"`
+	Reason         string    `json:"reason,omitempty"`
+	IP             string    `json:"ip"`
+	UserAgent      string    `json:"user_agent"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+func NewAuditLogEntry(entry db.AuditLog) AuditLogEntry {
+	return AuditLogEntry{
+		ID:             entry.ID,
+		Action:         entry.Action,
+		ActorID:        int4OrNil(entry.ActorID),
+		ActorUsername:  entry.ActorUsername,
+		TargetUserID:   int4OrNil(entry.TargetUserID),
+		TargetUsername: entry.TargetUsername,
+		Reason:         entry.Reason,
+		IP:             entry.Ip,
+		UserAgent:      entry.UserAgent,
+		CreatedAt:      entry.CreatedAt.Time,
+	}
+}
+
+func NewAuditLogEntries(entries []db.AuditLog) []AuditLogEntry {
+	views := make([]AuditLogEntry, 0, len(entries))
+	for _, entry := range entries {
+		views = append(views, NewAuditLogEntry(entry))
+	}
+	return views
+}
@@ -0,0 +1,47 @@
+package dto
+
+import (
+	"main/db"
+	"time"
+)
+
+// OAuthClient describes an app registered to log users in through the service,
+// without its secret, which is only shown when the app is registered.
+type OAuthClient struct {
+	ClientID      string    `json:"client_id"`
+	Name          string    `json:"name"`
+	Confidential  bool      `json:"confidential"`
+	RedirectURIs  []string  `json:"redirect_uris"`
+	GrantTypes    []string  `json:"grant_types"`
+	Scopes        []string  `json:"scopes"`
+	ServiceUserID *int32    `json:"service_user_id,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+func NewOAuthClient(client db.OauthClient) OAuthClient {
+	return OAuthClient{
+		ClientID:      client.ID,
+		Name:          client.Name,
+		Confidential:  client.SecretHash.Valid,
+		RedirectURIs:  nonNil(client.RedirectUris),
+		GrantTypes:    nonNil(client.GrantTypes),
+		Scopes:        nonNil(client.Scopes),
+		ServiceUserID: int4OrNil(client.ServiceUserID),
+		CreatedAt:     client.CreatedAt.Time,
+	}
+}
+
+func NewOAuthClients(clients []db.OauthClient) []OAuthClient {
+	views := make([]OAuthClient, 0, len(clients))
+	for _, client := range clients {
+		views = append(views, NewOAuthClient(client))
+	}
+	return views
+}
+
+func nonNil(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
@@ -0,0 +1,25 @@
+package dto
+
+import "main/db"
+
+type Room struct {
+	ID      int32  `json:"id"`
+	Name    string `json:"name"`
+	OwnerID *int32 `json:"owner_id,omitempty"`
+}
+
+func NewRoom(room db.Room) Room {
+	return Room{
+		ID:      room.ID,
+		Name:    room.Name,
+		OwnerID: int4OrNil(room.OwnerID),
+	}
+}
+
+func NewRooms(rooms []db.Room) []Room {
+	views := make([]Room, 0, len(rooms))
+	for _, room := range rooms {
+		views = append(views, NewRoom(room))
+	}
+	return views
+}
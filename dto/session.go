@@ -0,0 +1,38 @@
+package dto
+
+import (
+	"main/db"
+	"time"
+)
+
+// Session is a device the user is logged in on.
+type Session struct {
+	ID         string    `json:"id"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	// Current marks the session of the token the list was asked for with
+	Current bool `json:"current"`
+}
+
+func NewSession(session db.Session, currentID string) Session {
+	return Session{
+		ID:         session.ID,
+		UserAgent:  session.UserAgent,
+		IP:         session.Ip,
+		CreatedAt:  session.CreatedAt.Time,
+		LastSeenAt: session.LastSeenAt.Time,
+		ExpiresAt:  session.ExpiresAt.Time,
+		Current:    currentID != "" && session.ID == currentID,
+	}
+}
+
+func NewSessions(sessions []db.Session, currentID string) []Session {
+	views := make([]Session, 0, len(sessions))
+	for _, session := range sessions {
+		views = append(views, NewSession(session, currentID))
+	}
+	return views
+}
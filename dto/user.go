@@ -0,0 +1,124 @@
+// Package dto holds the shapes the API answers with. Handlers never write db
+// models directly, so columns such as password hashes cannot leak by accident.
+package dto
+
+import (
+	"main/auth"
+	"main/db"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// PublicUser is what any authenticated user may see about an account.
+type PublicUser struct {
+	ID          int32      `json:"id"`
+	Username    string     `json:"username"`
+	DisplayName *string    `json:"display_name,omitempty"`
+	Bio         *string    `json:"bio,omitempty"`
+	Pronouns    *string    `json:"pronouns,omitempty"`
+	Links       []string   `json:"links,omitempty"`
+	CreatedAt   *time.Time `json:"created_at,omitempty"`
+}
+
+// SelfUser is the account owner's own view.
+type SelfUser struct {
+	PublicUser
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Age           *int32 `json:"age,omitempty"`
+	RoomID        *int32 `json:"room_id,omitempty"`
+	// Locale and Timezone are kept to the owner, as they tell where they are
+	Locale   *string `json:"locale,omitempty"`
+	Timezone *string `json:"timezone,omitempty"`
+}
+
+// AdminUser is the view for administrators.
+type AdminUser struct {
+	SelfUser
+	EmailVerifiedAt *time.Time `json:"email_verified_at,omitempty"`
+	// Status is the account status now, a suspension that ran out is over
+	Status          string     `json:"status"`
+	StatusReason    *string    `json:"status_reason,omitempty"`
+	StatusChangedBy *int32     `json:"status_changed_by,omitempty"`
+	StatusChangedAt *time.Time `json:"status_changed_at,omitempty"`
+	StatusExpiresAt *time.Time `json:"status_expires_at,omitempty"`
+}
+
+func NewPublicUser(user db.User) PublicUser {
+	return PublicUser{
+		ID:          user.ID,
+		Username:    user.Username,
+		DisplayName: textOrNil(user.DisplayName),
+		Bio:         textOrNil(user.Bio),
+		Pronouns:    textOrNil(user.Pronouns),
+		Links:       user.Links,
+		CreatedAt:   timeOrNil(user.CreatedAt),
+	}
+}
+
+func NewSelfUser(user db.User) SelfUser {
+	return SelfUser{
+		PublicUser:    NewPublicUser(user),
+		Email:         user.Email,
+		EmailVerified: user.EmailVerifiedAt.Valid,
+		Age:           int4OrNil(user.Age),
+		RoomID:        int4OrNil(user.RoomID),
+		Locale:        textOrNil(user.Locale),
+		Timezone:      textOrNil(user.Timezone),
+	}
+}
+
+func NewAdminUser(user db.User) AdminUser {
+	return AdminUser{
+		SelfUser:        NewSelfUser(user),
+		EmailVerifiedAt: timeOrNil(user.EmailVerifiedAt),
+		Status:          auth.CurrentStatus(user.Status, user.StatusExpiresAt.Time, user.EmailVerifiedAt.Valid),
+		StatusReason:    textOrNil(user.StatusReason),
+		StatusChangedBy: int4OrNil(user.StatusChangedBy),
+		StatusChangedAt: timeOrNil(user.StatusChangedAt),
+		StatusExpiresAt: timeOrNil(user.StatusExpiresAt),
+	}
+}
+
+// NewCreatedUser is the self view of a user that was just signed up.
+func NewCreatedUser(user db.CreateUserRow) SelfUser {
+	return NewSelfUser(db.User{ID: user.ID, Username: user.Username, Email: user.Email, Age: user.Age})
+}
+
+func NewPublicUsers(users []db.User) []PublicUser {
+	views := make([]PublicUser, 0, len(users))
+	for _, user := range users {
+		views = append(views, NewPublicUser(user))
+	}
+	return views
+}
+
+func NewAdminUsers(users []db.User) []AdminUser {
+	views := make([]AdminUser, 0, len(users))
+	for _, user := range users {
+		views = append(views, NewAdminUser(user))
+	}
+	return views
+}
+
+func timeOrNil(t pgtype.Timestamp) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+func int4OrNil(i pgtype.Int4) *int32 {
+	if !i.Valid {
+		return nil
+	}
+	return &i.Int32
+}
+
+func textOrNil(t pgtype.Text) *string {
+	if !t.Valid {
+		return nil
+	}
+	return &t.String
+}
@@ -0,0 +1,22 @@
+package dto
+
+// Highlight is a piece of a searched field, Match tells whether it matched the query.
+// Joined together the pieces give the whole field.
+type Highlight struct {
+	Text  string `json:"text"`
+	Match bool   `json:"match"`
+}
+
+type UserHighlights struct {
+	Username []Highlight `json:"username"`
+	Email    []Highlight `json:"email"`
+}
+
+// UserSearchResult is a user found by a search. Score is the trigram similarity of
+// the closer of their username and email address to the query, from 0 to 1.
+type UserSearchResult struct {
+	User        AdminUser      `json:"user"`
+	Score       float32        `json:"score"`
+	PrefixMatch bool           `json:"prefix_match"`
+	Highlights  UserHighlights `json:"highlights"`
+}
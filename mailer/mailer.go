@@ -0,0 +1,52 @@
+// Package mailer sends the service's outgoing mail.
+package mailer
+
+import (
+	"context"
+	"main/config"
+
+	"go.uber.org/zap"
+)
+
+const (
+	DriverSMTP = "smtp"
+	DriverFile = "file"
+	DriverLog  = "log"
+
+	defaultFrom = "no-reply@usermanagement.local"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// New returns the mailer selected by the mail section of the config. Mail is
+// only logged when no driver is configured.
+func New(logger *zap.Logger) Mailer {
+	settings := config.AppConfig.Mail
+	from := settings.From
+	if from == "" {
+		from = defaultFrom
+	}
+
+	switch settings.Driver {
+	case DriverSMTP:
+		return &SMTPMailer{
+			Host:     settings.SMTP.Host,
+			Port:     settings.SMTP.Port,
+			Username: settings.SMTP.Username,
+			Password: settings.SMTP.Password,
+			From:     from,
+		}
+	case DriverFile:
+		return &FileMailer{Dir: settings.Dir, From: from}
+	default:
+		return &LogMailer{Logger: logger, From: from}
+	}
+}
@@ -3,10 +3,12 @@ package main
 import (
 	"context"
 	"fmt"
+	"main/auth"
 	"main/config"
 	"main/connection"
 	"main/controller"
 	"main/db"
+	middleware "main/middlewares"
 	"main/routes"
 	"main/utility"
 	"main/ws"
@@ -35,6 +37,9 @@ func main() {
 
 	// Load configuration
 	config.LoadConfig()
+	if err := config.AppConfig.Validate(); err != nil {
+		logger.Fatal("Invalid configuration", zap.Error(err))
+	}
 
 	// Load database connection
 	connection.InitDatabase()
@@ -44,6 +49,14 @@ func main() {
 	connection.InitRedis()
 	defer connection.CloseRedis()
 
+	// Load signing keys
+	if err := auth.InitKeys(); err != nil {
+		logger.Fatal("Failed to load signing keys", zap.Error(err))
+	}
+	rotationCtx, stopRotation := context.WithCancel(context.Background())
+	defer stopRotation()
+	go auth.RunKeyRotation(rotationCtx)
+
 	logger.Info("Application started")
 
 	// Map database
@@ -53,10 +66,15 @@ func main() {
 	// Load user controller
 	uc := controller.NewUserController(queries, redisClient, logger, false)
 
+	purgeCtx, stopPurge := context.WithCancel(context.Background())
+	defer stopPurge()
+	go uc.RunUserPurge(purgeCtx)
+
 	// Load wsc controller
 	h := ws.NewHub()
 	wsc := ws.NewWsController(queries, h, logger)
 	go h.Run()
+	uc.Rooms = h
 
 	// Load router
 	gin.SetMode(gin.ReleaseMode)
@@ -66,11 +84,15 @@ func main() {
 	router.GET("/metrics", gin.WrapH(promhttp.Handler()))
 
 	api := router.Group("/api")
+	api.Use(middleware.RateLimit(redisClient, "api"))
 	docs.SwaggerInfo.BasePath = "/api"
 	router.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 
 	// Register Routes
 	routes.RegisterUserRoutes(api, uc, wsc)
+	routes.RegisterAdminRoutes(api, uc)
+	routes.RegisterWellKnownRoutes(router.Group("/.well-known"), uc)
+	routes.RegisterOAuthRoutes(router.Group("/oauth"), uc)
 
 	// start server
 	serverAddress := fmt.Sprintf("%s:%d", config.AppConfig.Server.Host, config.AppConfig.Server.Port)
@@ -104,5 +126,10 @@ func main() {
 	}
 
 	wg.Wait()
+
+	// Mails already answered with 202 still have to go out, before the database and Redis close
+	if err := uc.WaitForBackground(ctx); err != nil {
+		logger.Warn("Stopped before all background work finished", zap.Error(err))
+	}
 	logger.Info("Server gracefully stopped")
 }
@@ -1,18 +1,35 @@
 package middleware
 
 import (
-	"fmt"
-	"main/config"
+	"context"
+	"errors"
+	"main/auth"
+	"main/db"
+	"main/utility"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
 )
 
-func AuthMiddleware() gin.HandlerFunc {
+// AuthMiddleware accepts access tokens and API keys as bearer tokens. When session
+// cookies are enabled, requests without an Authorization header may carry the
+// access token in its cookie instead.
+func AuthMiddleware(redisClient *redis.Client, queries *db.Queries) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		tokenString := c.GetHeader("Authorization")
+		if tokenString == "" && auth.SessionCookiesEnabled() {
+			if cookie, err := c.Cookie(auth.AccessTokenCookie); err == nil && cookie != "" {
+				tokenString = "Bearer " + cookie
+				c.Set(auth.CookieAuthKey, true)
+			}
+		}
 
 		// Token must be in "Bearer <token>" format
 		if tokenString == "" || !strings.HasPrefix(tokenString, "Bearer ") {
@@ -22,33 +39,164 @@ func AuthMiddleware() gin.HandlerFunc {
 		}
 
 		tokenString = strings.TrimPrefix(tokenString, "Bearer ")
-		// Parse and validate JWT token
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			// Check signing method
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method")
-			}
+		if auth.IsAPIKey(tokenString) && !c.GetBool(auth.CookieAuthKey) {
+			authenticateAPIKey(c, queries, tokenString)
+			return
+		}
 
-			// Ensure the key is not empty before returning it
-			if config.AppConfig.JWT.Key == "" {
-				return nil, fmt.Errorf("JWT secret key is empty")
-			}
+		// Parse and validate JWT token
+		claims, err := auth.ParseJWT(tokenString)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token", "error explanation": err.Error()})
+			c.Abort()
+			return
+		}
+		// Tokens issued for a single purpose, such as finishing a login, are not access tokens,
+		// and neither are id tokens, which are meant for the app in their audience
+		if claims.Purpose != "" || claims.Audience != "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token", "error explanation": "token cannot be used for this request"})
+			c.Abort()
+			return
+		}
 
-			return []byte(config.AppConfig.JWT.Key), nil
-		})
+		// Reject tokens that were logged out
+		revoked, err := auth.IsRevoked(c.Request.Context(), redisClient, claims)
+		if err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Could not verify token"})
+			c.Abort()
+			return
+		}
+		if revoked {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Token has been revoked"})
+			c.Abort()
+			return
+		}
 
-		if err != nil || !token.Valid {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token", "error explanation": err, "token": token})
+		// Suspended, locked and deactivated accounts are kept out even with tokens issued before
+		restriction, err := accountRestriction(c.Request.Context(), redisClient, queries, claims)
+		if errors.Is(err, pgx.ErrNoRows) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token", "error explanation": "user no longer exists"})
+			c.Abort()
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Could not verify token"})
 			c.Abort()
 			return
 		}
+		if restriction != "" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "account is " + restriction, "status": restriction})
+			c.Abort()
+			return
+		}
+
+		if claims.SessionID != "" {
+			touchSession(c, redisClient, queries, claims.SessionID)
+		}
 
-		// Extract user info from token claims (optional)
-		if claims, ok := token.Claims.(jwt.MapClaims); ok {
-			c.Set("username", claims["username"])
+		// Extract user info from token claims
+		c.Set("username", claims.Username)
+		c.Set(auth.ClaimsKey, claims)
+		// Tokens issued to other apps are limited to the scopes the user consented to
+		if claims.ClientID != "" {
+			c.Set(auth.ScopesKey, strings.Fields(claims.Scope))
 		}
 
 		// Proceed to next handler
 		c.Next()
 	}
 }
+
+// accountRestriction returns the status the user's account is restricted by, or an
+// empty string. Redis normally knows, the database is asked when it does not, so
+// restrictions hold even after Redis lost its data. Unknown users are pgx.ErrNoRows.
+func accountRestriction(ctx context.Context, redisClient *redis.Client, queries *db.Queries, claims *auth.Claims) (string, error) {
+	restriction, known, err := auth.AccountRestriction(ctx, redisClient, claims.Subject)
+	if err != nil || known {
+		return restriction, err
+	}
+
+	userID, ok := claims.UserID()
+	if !ok {
+		return "", pgx.ErrNoRows
+	}
+	user, err := queries.GetUser(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	if status := auth.CurrentStatus(user.Status, user.StatusExpiresAt.Time, user.EmailVerifiedAt.Valid); auth.Restricted(status) {
+		return status, nil
+	}
+	// Remembering the answer only saves the next lookups, it must not fail the request
+	if err := auth.MarkUnrestricted(ctx, redisClient, claims.Subject); err != nil {
+		utility.AppLogger.Logger.Warn("Failed to remember account status", zap.String("subject", claims.Subject), zap.Error(err))
+	}
+	return "", nil
+}
+
+// sessionSeenInterval is how often a session's last-seen time is written at most
+const sessionSeenInterval = time.Minute
+
+// touchSession records that the session was just seen, at most once per
+// sessionSeenInterval so busy clients do not write on every request.
+func touchSession(c *gin.Context, redisClient *redis.Client, queries *db.Queries, sessionID string) {
+	ctx := c.Request.Context()
+	due, err := redisClient.SetNX(ctx, "session_seen:"+sessionID, 1, sessionSeenInterval).Result()
+	if err == nil && due {
+		err = queries.TouchSession(ctx, db.TouchSessionParams{ID: sessionID, Ip: c.ClientIP()})
+	}
+	// Keeping track of usage must not fail the request
+	if err != nil {
+		utility.AppLogger.Logger.Warn("Failed to record session activity", zap.String("session_id", sessionID), zap.Error(err))
+	}
+}
+
+// authenticateAPIKey stands in for the JWT checks when the bearer token is an API key.
+// The key acts as its user, with the user's current roles, limited to the key's scopes.
+func authenticateAPIKey(c *gin.Context, queries *db.Queries, key string) {
+	token, err := queries.GetActiveAPIToken(c.Request.Context(), auth.HashAPIKey(key))
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token", "error explanation": "API key is unknown, revoked or expired"})
+		c.Abort()
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Could not verify token"})
+		c.Abort()
+		return
+	}
+
+	if status := auth.CurrentStatus(token.Status, token.StatusExpiresAt.Time, token.EmailVerifiedAt.Valid); auth.Restricted(status) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "account is " + status, "status": status})
+		c.Abort()
+		return
+	}
+
+	roles, err := queries.GetUserRoles(c.Request.Context(), token.UserID)
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Could not verify token"})
+		c.Abort()
+		return
+	}
+
+	// Keeping track of usage must not fail the request
+	if err := queries.TouchAPIToken(c.Request.Context(), db.TouchAPITokenParams{ID: token.ID, Ip: c.ClientIP()}); err != nil {
+		utility.AppLogger.Logger.Warn("Failed to record API key usage", zap.Int32("token_id", token.ID), zap.Error(err))
+	}
+
+	claims := &auth.Claims{
+		Username:       token.Username,
+		Roles:          roles,
+		EmailVerified:  token.EmailVerifiedAt.Valid,
+		StandardClaims: jwt.StandardClaims{Subject: strconv.Itoa(int(token.UserID))},
+	}
+	scopes := token.Scopes
+	if scopes == nil {
+		scopes = []string{}
+	}
+
+	c.Set("username", claims.Username)
+	c.Set(auth.ClaimsKey, claims)
+	c.Set(auth.ScopesKey, scopes)
+	c.Next()
+}
@@ -0,0 +1,208 @@
+package middleware
+
+import (
+	"errors"
+	"main/auth"
+	"main/db"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// RequireRole lets the request through when the token carries any of the roles.
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, ok := auth.FromContext(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			c.Abort()
+			return
+		}
+
+		if !claims.HasRole(roles...) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RequireScope lets API keys through only when they carry the scope. Requests
+// authenticated with a JWT always pass.
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !auth.HasScope(c, scope) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "API key is missing the " + scope + " scope"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RequireSession refuses API keys and tokens of other apps, for requests that
+// only make sense for a logged in user such as logging out or changing the password.
+func RequireSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, scoped := auth.ScopesFromContext(c); scoped {
+			c.JSON(http.StatusForbidden, gin.H{"error": "API keys and app tokens cannot be used for this request"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// DenyImpersonation refuses tokens an admin got to act as the user, for requests
+// that change how the account is secured or that only its owner should make.
+func DenyImpersonation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if claims, ok := auth.FromContext(c); ok && claims.Actor != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": "not allowed while impersonating a user"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RequirePermission lets the request through when one of the token's roles grants the permission.
+func RequirePermission(queries *db.Queries, permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, ok := auth.FromContext(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			c.Abort()
+			return
+		}
+
+		if len(claims.Roles) == 0 {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+			c.Abort()
+			return
+		}
+
+		permissions, err := queries.GetPermissionsByRoles(c.Request.Context(), claims.Roles)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not load permissions"})
+			c.Abort()
+			return
+		}
+
+		// An API key needs the permission as a scope as well
+		if !slices.Contains(permissions, permission) || !auth.HasScope(c, permission) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RequireSelfOrRole lets the request through when the :id path parameter is the
+// caller's own user id or the token carries any of the roles.
+func RequireSelfOrRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, ok := auth.FromContext(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			c.Abort()
+			return
+		}
+
+		if claims.HasRole(roles...) {
+			c.Next()
+			return
+		}
+
+		userID, ok := claims.UserID()
+		if !ok || c.Param("id") != strconv.Itoa(int(userID)) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RequireRoomOwnerOrRole lets the request through when the caller owns the room
+// in the :roomId path parameter or the token carries any of the roles.
+func RequireRoomOwnerOrRole(queries *db.Queries, roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, ok := auth.FromContext(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			c.Abort()
+			return
+		}
+
+		if claims.HasRole(roles...) {
+			c.Next()
+			return
+		}
+
+		roomID, err := strconv.Atoi(c.Param("roomId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid room ID"})
+			c.Abort()
+			return
+		}
+
+		room, err := queries.GetRoomById(c.Request.Context(), int32(roomID))
+		if errors.Is(err, pgx.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Room not found"})
+			c.Abort()
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			c.Abort()
+			return
+		}
+
+		userID, ok := claims.UserID()
+		if !ok || !room.OwnerID.Valid || room.OwnerID.Int32 != userID {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RequireVerifiedEmail refuses users who have not verified their email address,
+// unless the email verification policy lets unverified accounts through.
+func RequireVerifiedEmail() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if auth.EmailPolicy() == auth.EmailPolicyNone {
+			c.Next()
+			return
+		}
+
+		claims, ok := auth.FromContext(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			c.Abort()
+			return
+		}
+
+		if !claims.EmailVerified {
+			c.JSON(http.StatusForbidden, gin.H{"error": "email address is not verified"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
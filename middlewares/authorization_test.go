@@ -0,0 +1,158 @@
+package middleware
+
+import (
+	"main/auth"
+	"main/config"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequireSelfOrRole(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name         string
+		claims       *auth.Claims
+		path         string
+		expectedCode int
+	}{
+		{
+			name:         "own account",
+			claims:       &auth.Claims{Username: "tester", StandardClaims: jwt.StandardClaims{Subject: "1"}},
+			path:         "/users/1",
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "someone else's account",
+			claims:       &auth.Claims{Username: "tester", StandardClaims: jwt.StandardClaims{Subject: "1"}},
+			path:         "/users/2",
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:         "admin",
+			claims:       &auth.Claims{Username: "root", Roles: []string{"admin"}, StandardClaims: jwt.StandardClaims{Subject: "3"}},
+			path:         "/users/2",
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "unauthenticated",
+			path:         "/users/1",
+			expectedCode: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.PUT("/users/:id", func(c *gin.Context) {
+				if tt.claims != nil {
+					c.Set(auth.ClaimsKey, tt.claims)
+				}
+			}, RequireSelfOrRole("admin"), func(c *gin.Context) {
+				c.Status(http.StatusOK)
+			})
+
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("PUT", tt.path, nil)
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedCode, w.Code)
+		})
+	}
+}
+
+func TestRequireRole(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.GET("/admin", func(c *gin.Context) {
+		c.Set(auth.ClaimsKey, &auth.Claims{Username: "tester", Roles: []string{"moderator"}})
+	}, RequireRole("admin"), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/admin", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestRequireVerifiedEmail(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	previous := config.AppConfig.EmailVerification
+	t.Cleanup(func() { config.AppConfig.EmailVerification = previous })
+
+	tests := []struct {
+		name         string
+		policy       string
+		claims       *auth.Claims
+		expectedCode int
+	}{
+		{
+			name:         "verified",
+			policy:       "restricted",
+			claims:       &auth.Claims{Username: "tester", EmailVerified: true},
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "unverified",
+			policy:       "restricted",
+			claims:       &auth.Claims{Username: "tester"},
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:         "unverified without a policy",
+			policy:       "none",
+			claims:       &auth.Claims{Username: "tester"},
+			expectedCode: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.AppConfig.EmailVerification.Policy = tt.policy
+
+			router := gin.New()
+			router.GET("/ws/getRooms", func(c *gin.Context) {
+				c.Set(auth.ClaimsKey, tt.claims)
+			}, RequireVerifiedEmail(), func(c *gin.Context) {
+				c.Status(http.StatusOK)
+			})
+
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/ws/getRooms", nil)
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedCode, w.Code)
+		})
+	}
+}
+
+func TestDenyImpersonation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	for claims, expectedCode := range map[*auth.Claims]int{
+		{Username: "tester"}: http.StatusOK,
+		{Username: "tester", Actor: &auth.Actor{Subject: "9", Username: "support"}}: http.StatusForbidden,
+	} {
+		router := gin.New()
+		router.PUT("/users/change-password", func(c *gin.Context) {
+			c.Set(auth.ClaimsKey, claims)
+		}, DenyImpersonation(), func(c *gin.Context) {
+			c.Status(http.StatusOK)
+		})
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("PUT", "/users/change-password", nil)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, expectedCode, w.Code)
+	}
+}
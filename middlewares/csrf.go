@@ -0,0 +1,30 @@
+package middleware
+
+import (
+	"main/auth"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CSRFProtection refuses state-changing requests authenticated with the session
+// cookie unless they echo the CSRF cookie in the CSRF header. Browsers attach the
+// cookie to requests other sites trigger, but those sites cannot read it.
+// It has to come after AuthMiddleware.
+func CSRFProtection() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			c.Next()
+			return
+		}
+
+		if c.GetBool(auth.CookieAuthKey) && !auth.ValidCSRF(c) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "missing or invalid CSRF token"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"main/auth"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCSRFProtection(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name         string
+		method       string
+		cookieAuth   bool
+		header       string
+		expectedCode int
+	}{
+		{name: "header authentication needs no token", method: "POST", expectedCode: http.StatusOK},
+		{name: "cookie authentication reads freely", method: "GET", cookieAuth: true, expectedCode: http.StatusOK},
+		{name: "cookie authentication without token", method: "POST", cookieAuth: true, expectedCode: http.StatusForbidden},
+		{name: "cookie authentication with wrong token", method: "DELETE", cookieAuth: true, header: "guess", expectedCode: http.StatusForbidden},
+		{name: "cookie authentication with token", method: "POST", cookieAuth: true, header: "csrf-token", expectedCode: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.Handle(tt.method, "/users/logout", func(c *gin.Context) {
+				c.Set(auth.CookieAuthKey, tt.cookieAuth)
+			}, CSRFProtection(), func(c *gin.Context) {
+				c.Status(http.StatusOK)
+			})
+
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(tt.method, "/users/logout", nil)
+			req.AddCookie(&http.Cookie{Name: auth.CSRFCookie, Value: "csrf-token"})
+			if tt.header != "" {
+				req.Header.Set(auth.CSRFHeader, tt.header)
+			}
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedCode, w.Code)
+		})
+	}
+}
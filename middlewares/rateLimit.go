@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"main/auth"
+	"main/config"
+	"main/ratelimit"
+	"main/utility"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+const (
+	rateLimitKeyIP    = "ip"
+	rateLimitKeyUser  = "user"
+	rateLimitKeyRoute = "route"
+)
+
+// RateLimit applies the rule with the given name from the rate_limit section of the config.
+// Requests pass through when rate limiting is disabled or the rule is not configured.
+func RateLimit(redisClient *redis.Client, rule string) gin.HandlerFunc {
+	settings := config.AppConfig.RateLimit
+	limit, ok := settings.Rules[rule]
+	if !settings.Enabled || !ok || limit.Requests <= 0 || limit.Window <= 0 {
+		return func(c *gin.Context) {
+			c.Next()
+		}
+	}
+
+	limiter := ratelimit.NewLimiter(redisClient)
+	return func(c *gin.Context) {
+		key := rule + ":" + rateLimitKey(c, limit.Key)
+		result, err := limiter.Allow(c.Request.Context(), key, ratelimit.Limit{Requests: limit.Requests, Window: limit.Window})
+		if err != nil {
+			// Redis being unavailable should not take the whole API down with it
+			utility.AppLogger.Logger.Warn("Rate limiter unavailable", zap.String("rule", rule), zap.Error(err))
+			c.Next()
+			return
+		}
+
+		reset := ceilSeconds(result.ResetAfter)
+		c.Header("RateLimit-Limit", strconv.Itoa(result.Limit))
+		c.Header("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		c.Header("RateLimit-Reset", strconv.Itoa(reset))
+
+		if !result.Allowed {
+			c.Header("Retry-After", strconv.Itoa(reset))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Rate limit exceeded", "retry_after": reset})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// rateLimitKey identifies the caller. User keys are the user id, which stays the same
+// across renames, and fall back to the client IP on routes reached without a token.
+func rateLimitKey(c *gin.Context, kind string) string {
+	switch kind {
+	case rateLimitKeyRoute:
+		return rateLimitKeyRoute
+	case rateLimitKeyUser:
+		if claims, ok := auth.FromContext(c); ok {
+			return "user:" + claims.Subject
+		}
+	}
+	return "ip:" + c.ClientIP()
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,111 @@
+package middleware
+
+import (
+	"main/auth"
+	"main/config"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	previous := config.AppConfig.RateLimit
+	t.Cleanup(func() { config.AppConfig.RateLimit = previous })
+	config.AppConfig.RateLimit.Enabled = true
+	config.AppConfig.RateLimit.Rules = map[string]config.RateLimitRule{
+		"login": {Requests: 2, Window: time.Minute, Key: "ip"},
+	}
+
+	mr := miniredis.RunT(t)
+	redisClient := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+
+	router := gin.New()
+	router.POST("/login", RateLimit(redisClient, "login"), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	router.POST("/signup", RateLimit(redisClient, "signup"), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	send := func(path string, remoteAddr string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", path, nil)
+		req.RemoteAddr = remoteAddr
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := send("/login", "10.0.0.1:1234")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "2", w.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "1", w.Header().Get("RateLimit-Remaining"))
+
+	w = send("/login", "10.0.0.1:1234")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "0", w.Header().Get("RateLimit-Remaining"))
+
+	w = send("/login", "10.0.0.1:1234")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+	assert.Contains(t, w.Body.String(), "retry_after")
+
+	// a different client is counted separately
+	w = send("/login", "10.0.0.2:1234")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// rules that are not configured do not limit anything
+	for i := 0; i < 5; i++ {
+		w = send("/signup", "10.0.0.1:1234")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, w.Header().Get("RateLimit-Limit"))
+	}
+}
+
+func TestRateLimitByUser(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	previous := config.AppConfig.RateLimit
+	t.Cleanup(func() { config.AppConfig.RateLimit = previous })
+	config.AppConfig.RateLimit.Enabled = true
+	config.AppConfig.RateLimit.Rules = map[string]config.RateLimitRule{
+		"users": {Requests: 2, Window: time.Minute, Key: "user"},
+	}
+
+	mr := miniredis.RunT(t)
+	redisClient := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+
+	send := func(claims *auth.Claims) *httptest.ResponseRecorder {
+		router := gin.New()
+		router.GET("/users", func(c *gin.Context) {
+			c.Set(auth.ClaimsKey, claims)
+		}, RateLimit(redisClient, "users"), func(c *gin.Context) {
+			c.Status(http.StatusOK)
+		})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/users", nil)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := send(&auth.Claims{Username: "tester", StandardClaims: jwt.StandardClaims{Subject: "1"}})
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = send(&auth.Claims{Username: "tester", StandardClaims: jwt.StandardClaims{Subject: "1"}})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// renaming the account does not start the count over
+	w = send(&auth.Claims{Username: "renamed", StandardClaims: jwt.StandardClaims{Subject: "1"}})
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+
+	// nor does someone who takes the old name inherit it
+	w = send(&auth.Claims{Username: "tester", StandardClaims: jwt.StandardClaims{Subject: "2"}})
+	assert.Equal(t, http.StatusOK, w.Code)
+}
@@ -0,0 +1,31 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS audit_log (
+    id SERIAL PRIMARY KEY,
+    action VARCHAR(64) NOT NULL,
+    actor_id INT REFERENCES users(id) ON DELETE SET NULL,
+    actor_username VARCHAR(255) NOT NULL DEFAULT '',
+    target_user_id INT REFERENCES users(id) ON DELETE SET NULL,
+    target_username VARCHAR(255) NOT NULL DEFAULT '',
+    token_id VARCHAR(64) NOT NULL DEFAULT '',
+    reason TEXT NOT NULL DEFAULT '',
+    ip VARCHAR(45) NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS audit_log_actor_id_idx ON audit_log (actor_id);
+CREATE INDEX IF NOT EXISTS audit_log_target_user_id_idx ON audit_log (target_user_id);
+
+INSERT INTO permissions (name) VALUES ('users:impersonate'), ('audit:read') ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r JOIN permissions p ON p.name IN ('users:impersonate', 'audit:read') WHERE r.name = 'admin'
+ON CONFLICT DO NOTHING;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DELETE FROM permissions WHERE name IN ('users:impersonate', 'audit:read');
+DROP TABLE IF EXISTS audit_log;
+-- +goose StatementEnd
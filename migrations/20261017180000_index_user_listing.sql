@@ -0,0 +1,22 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Keyset pagination compares (sort column, id) pairs, which NULLs would drop out of
+UPDATE users SET created_at = NOW() WHERE created_at IS NULL;
+ALTER TABLE users ALTER COLUMN created_at SET NOT NULL;
+
+CREATE INDEX IF NOT EXISTS users_username_id_idx ON users (username, id);
+CREATE INDEX IF NOT EXISTS users_created_at_id_idx ON users (created_at, id);
+CREATE INDEX IF NOT EXISTS users_age_id_idx ON users ((COALESCE(age, -1)), id);
+CREATE INDEX IF NOT EXISTS users_room_id_idx ON users (room_id);
+CREATE INDEX IF NOT EXISTS users_email_domain_idx ON users ((lower(split_part(email, '@', 2))));
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS users_email_domain_idx;
+DROP INDEX IF EXISTS users_room_id_idx;
+DROP INDEX IF EXISTS users_age_id_idx;
+DROP INDEX IF EXISTS users_created_at_id_idx;
+DROP INDEX IF EXISTS users_username_id_idx;
+ALTER TABLE users ALTER COLUMN created_at DROP NOT NULL;
+-- +goose StatementEnd
@@ -0,0 +1,32 @@
+-- +goose Up
+-- +goose StatementBegin
+-- New accounts wait for their email address to be verified, existing verified ones are active
+ALTER TABLE users ADD COLUMN IF NOT EXISTS status VARCHAR(32) NOT NULL DEFAULT 'pending_verification'
+    CHECK (status IN ('active', 'pending_verification', 'suspended', 'locked', 'deactivated'));
+UPDATE users SET status = 'active' WHERE email_verified_at IS NOT NULL;
+
+-- Why and by whom the status was last changed, and when a suspension or lock runs out
+ALTER TABLE users ADD COLUMN IF NOT EXISTS status_reason TEXT;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS status_changed_by INT REFERENCES users(id) ON DELETE SET NULL;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS status_changed_at TIMESTAMP;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS status_expires_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS users_status_idx ON users (status, id) WHERE status <> 'active';
+
+INSERT INTO permissions (name) VALUES ('users:moderate') ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r JOIN permissions p ON p.name = 'users:moderate' WHERE r.name = 'admin'
+ON CONFLICT DO NOTHING;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DELETE FROM permissions WHERE name = 'users:moderate';
+DROP INDEX IF EXISTS users_status_idx;
+ALTER TABLE users DROP COLUMN IF EXISTS status_expires_at;
+ALTER TABLE users DROP COLUMN IF EXISTS status_changed_at;
+ALTER TABLE users DROP COLUMN IF EXISTS status_changed_by;
+ALTER TABLE users DROP COLUMN IF EXISTS status_reason;
+ALTER TABLE users DROP COLUMN IF EXISTS status;
+-- +goose StatementEnd
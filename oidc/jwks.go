@@ -0,0 +1,148 @@
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"main/auth"
+	"math/big"
+	"sync"
+	"time"
+)
+
+// jwksRefreshInterval keeps a token with an unknown kid from making us fetch
+// the provider's keys on every request.
+const jwksRefreshInterval = time.Minute
+
+var ErrUnknownKey = errors.New("oidc: id token is signed with an unknown key")
+
+// remoteKeys caches the keys a provider publishes at its jwks_uri.
+type remoteKeys struct {
+	provider *Provider
+
+	mu        sync.Mutex
+	keys      map[string]interface{}
+	fetchedAt time.Time
+}
+
+// lookup returns the key with the given kid. The keys are fetched again when
+// the kid is unknown, providers publish new keys before they use them.
+// An empty kid matches the only key of a provider that publishes one.
+func (rk *remoteKeys) lookup(ctx context.Context, kid string) (interface{}, error) {
+	rk.mu.Lock()
+	defer rk.mu.Unlock()
+
+	if key, ok := rk.find(kid); ok {
+		return key, nil
+	}
+	if time.Since(rk.fetchedAt) < jwksRefreshInterval {
+		return nil, ErrUnknownKey
+	}
+
+	var set auth.JWKSet
+	if err := rk.provider.getJSON(ctx, rk.provider.JWKSURI, &set); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]interface{}, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := publicKey(jwk)
+		if err != nil {
+			// Keys of types we do not know are skipped, the others still work
+			continue
+		}
+		keys[jwk.KeyID] = key
+	}
+	rk.keys = keys
+	rk.fetchedAt = time.Now()
+
+	if key, ok := rk.find(kid); ok {
+		return key, nil
+	}
+	return nil, ErrUnknownKey
+}
+
+func (rk *remoteKeys) find(kid string) (interface{}, bool) {
+	if kid == "" && len(rk.keys) == 1 {
+		for _, key := range rk.keys {
+			return key, true
+		}
+	}
+	key, ok := rk.keys[kid]
+	return key, ok
+}
+
+// publicKey turns a JWK into the key type jwt-go verifies with.
+func publicKey(jwk auth.JWK) (interface{}, error) {
+	switch jwk.KeyType {
+	case "RSA":
+		n, err := decodeInt(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeInt(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() {
+			return nil, errors.New("oidc: RSA exponent is too large")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("oidc: unsupported curve %q", jwk.Curve)
+		}
+		x, err := decodeInt(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeInt(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("oidc: EC key is not on its curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if jwk.Curve != "Ed25519" {
+			return nil, fmt.Errorf("oidc: unsupported curve %q", jwk.Curve)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("oidc: Ed25519 key has the wrong size")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("oidc: unsupported key type %q", jwk.KeyType)
+	}
+}
+
+func decodeInt(value string) (*big.Int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) == 0 {
+		return nil, errors.New("oidc: key parameter is empty")
+	}
+	return new(big.Int).SetBytes(raw), nil
+}
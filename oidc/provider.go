@@ -0,0 +1,206 @@
+// Package oidc logs users in with an external OpenID Connect provider using
+// the authorization code flow with PKCE.
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"main/config"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// clockSkew is how far the provider's clock may be ahead of or behind ours
+const clockSkew = time.Minute
+
+var defaultScopes = []string{"openid", "email", "profile"}
+
+var (
+	ErrInvalidIDToken = errors.New("oidc: id token is invalid")
+	ErrNonceMismatch  = errors.New("oidc: id token nonce does not match")
+)
+
+// Provider is an OpenID Connect provider whose configuration was discovered.
+type Provider struct {
+	Name   string              `json:"-"`
+	Config config.OIDCProvider `json:"-"`
+
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	SigningAlgorithms     []string `json:"id_token_signing_alg_values_supported"`
+	TokenAuthMethods      []string `json:"token_endpoint_auth_methods_supported"`
+
+	client *http.Client
+	keys   *remoteKeys
+}
+
+// Discover reads the configuration the provider publishes under its issuer.
+func Discover(ctx context.Context, client *http.Client, name string, settings config.OIDCProvider) (*Provider, error) {
+	p := &Provider{Name: name, Config: settings, client: client}
+	p.keys = &remoteKeys{provider: p}
+
+	wellKnown := strings.TrimSuffix(settings.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := p.getJSON(ctx, wellKnown, p); err != nil {
+		return nil, err
+	}
+	if p.Issuer != settings.Issuer {
+		return nil, fmt.Errorf("oidc: provider %s claims to be issuer %q", name, p.Issuer)
+	}
+	if p.AuthorizationEndpoint == "" || p.TokenEndpoint == "" || p.JWKSURI == "" {
+		return nil, fmt.Errorf("oidc: provider %s does not publish its endpoints", name)
+	}
+	// Only tokens signed with the provider's published keys are trusted, never "none" or HMAC
+	p.SigningAlgorithms = slices.DeleteFunc(p.SigningAlgorithms, func(alg string) bool {
+		return alg == "none" || strings.HasPrefix(alg, "HS")
+	})
+	if len(p.SigningAlgorithms) == 0 {
+		p.SigningAlgorithms = []string{"RS256"}
+	}
+	return p, nil
+}
+
+// AuthCodeURL returns where to send the user to log in at the provider.
+func (p *Provider) AuthCodeURL(state string, nonce string, verifier string) (string, error) {
+	endpoint, err := url.Parse(p.AuthorizationEndpoint)
+	if err != nil {
+		return "", err
+	}
+
+	scopes := p.Config.Scopes
+	if len(scopes) == 0 {
+		scopes = defaultScopes
+	}
+	if !slices.Contains(scopes, "openid") {
+		scopes = append([]string{"openid"}, scopes...)
+	}
+
+	query := endpoint.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", p.Config.ClientID)
+	query.Set("redirect_uri", p.Config.RedirectURL)
+	query.Set("scope", strings.Join(scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", CodeChallenge(verifier))
+	query.Set("code_challenge_method", "S256")
+	endpoint.RawQuery = query.Encode()
+	return endpoint.String(), nil
+}
+
+type tokenResponse struct {
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// Exchange trades an authorization code for the user's identity. The id token
+// has to be signed by the provider, meant for us and carry our nonce.
+func (p *Provider) Exchange(ctx context.Context, code string, verifier string, nonce string) (*IDToken, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.Config.RedirectURL},
+		"code_verifier": {verifier},
+		"client_id":     {p.Config.ClientID},
+	}
+	// client_secret_basic is the default of the spec, some providers only take the secret in the body
+	secretInBody := slices.Contains(p.TokenAuthMethods, "client_secret_post") && !slices.Contains(p.TokenAuthMethods, "client_secret_basic")
+	if secretInBody && p.Config.ClientSecret != "" {
+		form.Set("client_secret", p.Config.ClientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if !secretInBody && p.Config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.Config.ClientID), url.QueryEscape(p.Config.ClientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var token tokenResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&token); err != nil {
+		return nil, fmt.Errorf("oidc: reading token response: %w", err)
+	}
+	if token.Error != "" {
+		return nil, fmt.Errorf("oidc: token endpoint: %s %s", token.Error, token.ErrorDescription)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("oidc: token endpoint answered %s", resp.Status)
+	}
+	if token.IDToken == "" {
+		return nil, errors.New("oidc: token response has no id token")
+	}
+
+	return p.Verify(ctx, token.IDToken, nonce)
+}
+
+// Verify checks the signature and claims of an id token.
+func (p *Provider) Verify(ctx context.Context, raw string, nonce string) (*IDToken, error) {
+	claims := &IDToken{}
+	parser := &jwt.Parser{ValidMethods: p.SigningAlgorithms}
+	_, err := parser.ParseWithClaims(raw, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.keys.lookup(ctx, kid)
+	})
+	if err != nil {
+		var validationErr *jwt.ValidationError
+		if errors.As(err, &validationErr) && validationErr.Inner != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidIDToken, validationErr.Inner)
+		}
+		return nil, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+
+	if claims.Issuer != p.Issuer {
+		return nil, fmt.Errorf("%w: issued by %q", ErrInvalidIDToken, claims.Issuer)
+	}
+	if !slices.Contains(claims.Audience, p.Config.ClientID) {
+		return nil, fmt.Errorf("%w: not meant for this client", ErrInvalidIDToken)
+	}
+	if len(claims.Audience) > 1 && claims.AuthorizedParty != p.Config.ClientID {
+		return nil, fmt.Errorf("%w: authorized party is %q", ErrInvalidIDToken, claims.AuthorizedParty)
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("%w: no subject", ErrInvalidIDToken)
+	}
+	if claims.Nonce != nonce {
+		return nil, ErrNonceMismatch
+	}
+	return claims, nil
+}
+
+func (p *Provider) getJSON(ctx context.Context, target string, value interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("oidc: GET %s answered %s", target, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(value)
+}
@@ -0,0 +1,115 @@
+package oidc_test
+
+import (
+	"context"
+	"main/config"
+	"main/oidc"
+	"main/oidc/oidctest"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// login runs the browser's part of the flow and returns the code and state the provider redirected back with.
+func login(t *testing.T, authURL string) (string, string) {
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(authURL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	require.NoError(t, err)
+	return callback.Query().Get("code"), callback.Query().Get("state")
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	server := oidctest.NewServer("client", "secret")
+	defer server.Close()
+	server.SetUser(oidctest.User{Subject: "alice-1", Email: "alice@example.com", EmailVerified: true})
+
+	registry := oidc.NewRegistry(map[string]config.OIDCProvider{
+		"test":     {Issuer: server.Issuer(), ClientID: "client", ClientSecret: "secret", RedirectURL: "http://app.local/callback"},
+		"disabled": {Issuer: server.Issuer()},
+	}, nil)
+
+	_, err := registry.Provider(context.Background(), "disabled")
+	assert.ErrorIs(t, err, oidc.ErrUnknownProvider)
+
+	provider, err := registry.Provider(context.Background(), "test")
+	require.NoError(t, err)
+
+	verifier, err := oidc.NewCodeVerifier()
+	require.NoError(t, err)
+	authURL, err := provider.AuthCodeURL("the-state", "the-nonce", verifier)
+	require.NoError(t, err)
+
+	code, state := login(t, authURL)
+	assert.Equal(t, "the-state", state)
+
+	// the provider checks the verifier against the challenge
+	_, err = provider.Exchange(context.Background(), code, "another-verifier-of-enough-length-1234567890", "the-nonce")
+	assert.Error(t, err)
+
+	code, _ = login(t, authURL)
+	_, err = provider.Exchange(context.Background(), code, verifier, "another-nonce")
+	assert.ErrorIs(t, err, oidc.ErrNonceMismatch)
+
+	code, _ = login(t, authURL)
+	idToken, err := provider.Exchange(context.Background(), code, verifier, "the-nonce")
+	require.NoError(t, err)
+	assert.Equal(t, "alice-1", idToken.Subject)
+	assert.Equal(t, "alice@example.com", idToken.Email)
+	assert.True(t, idToken.EmailVerified)
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	server := oidctest.NewServer("client", "secret")
+	defer server.Close()
+
+	provider, err := oidc.Discover(context.Background(), http.DefaultClient, "test", config.OIDCProvider{
+		Issuer: server.Issuer(), ClientID: "client",
+	})
+	require.NoError(t, err)
+
+	now := time.Now()
+	claims := func(changes jwt.MapClaims) jwt.MapClaims {
+		base := jwt.MapClaims{"iss": server.Issuer(), "sub": "alice-1", "aud": []string{"client"}, "exp": now.Add(time.Minute).Unix(), "iat": now.Unix()}
+		for name, value := range changes {
+			base[name] = value
+		}
+		return base
+	}
+
+	_, err = provider.Verify(context.Background(), server.Sign(claims(nil)), "")
+	assert.NoError(t, err)
+
+	for name, changes := range map[string]jwt.MapClaims{
+		"other issuer":         {"iss": "https://evil.example.com"},
+		"other audience":       {"aud": "someone-else"},
+		"several audiences":    {"aud": []string{"client", "someone-else"}},
+		"expired":              {"exp": now.Add(-time.Hour).Unix()},
+		"no expiry":            {"exp": nil},
+		"issued in the future": {"iat": now.Add(time.Hour).Unix()},
+		"no subject":           {"sub": ""},
+	} {
+		_, err := provider.Verify(context.Background(), server.Sign(claims(changes)), "")
+		assert.ErrorIs(t, err, oidc.ErrInvalidIDToken, name)
+	}
+
+	// HMAC tokens signed with something public are never accepted
+	forged, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims(nil)).SignedString([]byte("client"))
+	require.NoError(t, err)
+	_, err = provider.Verify(context.Background(), forged, "")
+	assert.ErrorIs(t, err, oidc.ErrInvalidIDToken)
+}
+
+func TestCodeChallenge(t *testing.T) {
+	// the example of RFC 7636, appendix B
+	assert.Equal(t, "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM", oidc.CodeChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"))
+}
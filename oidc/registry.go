@@ -0,0 +1,58 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"main/config"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var ErrUnknownProvider = errors.New("oidc: unknown provider")
+
+// Registry holds the providers of the oidc section of the config. A provider
+// is discovered the first time it is used, so the service starts even while
+// a provider is down.
+type Registry struct {
+	client   *http.Client
+	settings map[string]config.OIDCProvider
+
+	mu        sync.Mutex
+	providers map[string]*Provider
+}
+
+// NewRegistry returns a registry of the configured providers. Providers without
+// an issuer or client id are left out.
+func NewRegistry(settings map[string]config.OIDCProvider, client *http.Client) *Registry {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	configured := make(map[string]config.OIDCProvider, len(settings))
+	for name, provider := range settings {
+		if provider.Issuer != "" && provider.ClientID != "" {
+			configured[name] = provider
+		}
+	}
+	return &Registry{client: client, settings: configured, providers: make(map[string]*Provider)}
+}
+
+// Provider returns the named provider, discovering it if needed.
+func (r *Registry) Provider(ctx context.Context, name string) (*Provider, error) {
+	settings, ok := r.settings[name]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if provider, ok := r.providers[name]; ok {
+		return provider, nil
+	}
+	provider, err := Discover(ctx, r.client, name, settings)
+	if err != nil {
+		return nil, err
+	}
+	r.providers[name] = provider
+	return provider, nil
+}
@@ -0,0 +1,201 @@
+// Package passwords hashes and verifies user passwords.
+//
+// Hashes are stored in a self-describing format, so the algorithm and its
+// parameters can change without invalidating existing hashes:
+//
+//	$argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>   PHC string format, base64 without padding
+//	$2a$12$<salt and key>                          bcrypt's modular crypt format
+package passwords
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"main/config"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	AlgorithmArgon2id = "argon2id"
+	AlgorithmBcrypt   = "bcrypt"
+
+	defaultArgon2Memory      = 64 * 1024
+	defaultArgon2Iterations  = 3
+	defaultArgon2Parallelism = 2
+	defaultArgon2SaltLength  = 16
+	defaultArgon2KeyLength   = 32
+)
+
+var (
+	ErrUnknownFormat = errors.New("unknown password hash format")
+	ErrMismatch      = errors.New("password does not match")
+)
+
+var b64 = base64.RawStdEncoding
+
+// Params says how new hashes are made.
+type Params struct {
+	Algorithm string
+
+	// Argon2id, memory is in KiB
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+
+	BcryptCost int
+}
+
+// Configured returns the params from the passwords section of the config,
+// with defaults for anything that is not set.
+func Configured() Params {
+	settings := config.AppConfig.Passwords
+	params := Params{
+		Algorithm:   settings.Algorithm,
+		Memory:      settings.Argon2id.Memory,
+		Iterations:  settings.Argon2id.Iterations,
+		Parallelism: settings.Argon2id.Parallelism,
+		SaltLength:  settings.Argon2id.SaltLength,
+		KeyLength:   settings.Argon2id.KeyLength,
+		BcryptCost:  settings.BcryptCost,
+	}
+
+	if params.Algorithm != AlgorithmBcrypt {
+		params.Algorithm = AlgorithmArgon2id
+	}
+	if params.Memory == 0 {
+		params.Memory = defaultArgon2Memory
+	}
+	if params.Iterations == 0 {
+		params.Iterations = defaultArgon2Iterations
+	}
+	if params.Parallelism == 0 {
+		params.Parallelism = defaultArgon2Parallelism
+	}
+	if params.SaltLength == 0 {
+		params.SaltLength = defaultArgon2SaltLength
+	}
+	if params.KeyLength == 0 {
+		params.KeyLength = defaultArgon2KeyLength
+	}
+	if params.BcryptCost == 0 {
+		params.BcryptCost = bcrypt.DefaultCost
+	}
+	return params
+}
+
+// Hash hashes password with the configured algorithm.
+func Hash(password string) (string, error) {
+	return Configured().Hash(password)
+}
+
+// Verify checks password against a hash made by any supported algorithm.
+// It returns ErrMismatch when the password is wrong.
+func Verify(password string, encoded string) error {
+	switch {
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		hash, err := decodeArgon2id(encoded)
+		if err != nil {
+			return err
+		}
+		key := argon2.IDKey([]byte(password), hash.salt, hash.params.Iterations, hash.params.Memory, hash.params.Parallelism, uint32(len(hash.key)))
+		if subtle.ConstantTimeCompare(key, hash.key) != 1 {
+			return ErrMismatch
+		}
+		return nil
+	case isBcrypt(encoded):
+		err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return ErrMismatch
+		}
+		return err
+	}
+	return ErrUnknownFormat
+}
+
+// NeedsRehash reports whether a hash was made with another algorithm or
+// weaker parameters than the configured ones.
+func NeedsRehash(encoded string) bool {
+	return Configured().NeedsRehash(encoded)
+}
+
+func (p Params) Hash(password string) (string, error) {
+	if p.Algorithm == AlgorithmBcrypt {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), p.BcryptCost)
+		return string(hash), err
+	}
+
+	salt := make([]byte, p.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, p.Memory, p.Iterations, p.Parallelism, b64.EncodeToString(salt), b64.EncodeToString(key)), nil
+}
+
+func (p Params) NeedsRehash(encoded string) bool {
+	if p.Algorithm == AlgorithmBcrypt {
+		if !isBcrypt(encoded) {
+			return true
+		}
+		cost, err := bcrypt.Cost([]byte(encoded))
+		return err != nil || cost < p.BcryptCost
+	}
+
+	hash, err := decodeArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+	return hash.params.Memory < p.Memory ||
+		hash.params.Iterations < p.Iterations ||
+		hash.params.Parallelism < p.Parallelism ||
+		uint32(len(hash.salt)) < p.SaltLength ||
+		uint32(len(hash.key)) < p.KeyLength
+}
+
+type argon2idHash struct {
+	params Params
+	salt   []byte
+	key    []byte
+}
+
+func decodeArgon2id(encoded string) (argon2idHash, error) {
+	var hash argon2idHash
+
+	// "", "argon2id", "v=19", "m=...,t=...,p=...", salt, key
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != AlgorithmArgon2id {
+		return hash, ErrUnknownFormat
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return hash, ErrUnknownFormat
+	}
+
+	hash.params.Algorithm = AlgorithmArgon2id
+	_, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &hash.params.Memory, &hash.params.Iterations, &hash.params.Parallelism)
+	if err != nil {
+		return hash, ErrUnknownFormat
+	}
+
+	if hash.salt, err = b64.DecodeString(parts[4]); err != nil {
+		return hash, ErrUnknownFormat
+	}
+	if hash.key, err = b64.DecodeString(parts[5]); err != nil || len(hash.key) == 0 {
+		return hash, ErrUnknownFormat
+	}
+	return hash, nil
+}
+
+func isBcrypt(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
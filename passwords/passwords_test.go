@@ -0,0 +1,88 @@
+package passwords
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testParams keep the tests fast, they are far too weak for real use
+var testParams = Params{
+	Algorithm:   AlgorithmArgon2id,
+	Memory:      1024,
+	Iterations:  1,
+	Parallelism: 1,
+	SaltLength:  16,
+	KeyLength:   32,
+	BcryptCost:  bcrypt.MinCost,
+}
+
+func TestArgon2id(t *testing.T) {
+	hash, err := testParams.Hash("password123")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=1,p=1$"))
+
+	assert.NoError(t, Verify("password123", hash))
+	assert.ErrorIs(t, Verify("wrong password", hash), ErrMismatch)
+
+	// the same password hashes differently every time
+	again, err := testParams.Hash("password123")
+	require.NoError(t, err)
+	assert.NotEqual(t, hash, again)
+}
+
+func TestBcrypt(t *testing.T) {
+	params := testParams
+	params.Algorithm = AlgorithmBcrypt
+
+	hash, err := params.Hash("password123")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(hash, "$2a$"))
+
+	assert.NoError(t, Verify("password123", hash))
+	assert.ErrorIs(t, Verify("wrong password", hash), ErrMismatch)
+}
+
+func TestVerifyUnknownFormat(t *testing.T) {
+	assert.ErrorIs(t, Verify("password123", "password123"), ErrUnknownFormat)
+	assert.ErrorIs(t, Verify("password123", "$argon2id$v=19$m=1024$broken"), ErrUnknownFormat)
+	assert.ErrorIs(t, Verify("password123", "$argon2id$v=16$m=1024,t=1,p=1$c2FsdA$a2V5"), ErrUnknownFormat)
+}
+
+func TestNeedsRehash(t *testing.T) {
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.MinCost)
+	require.NoError(t, err)
+	argon2Hash, err := testParams.Hash("password123")
+	require.NoError(t, err)
+
+	stronger := testParams
+	stronger.Iterations = 2
+	bcryptParams := testParams
+	bcryptParams.Algorithm = AlgorithmBcrypt
+	strongerBcrypt := bcryptParams
+	strongerBcrypt.BcryptCost = bcrypt.MinCost + 1
+
+	tests := []struct {
+		name     string
+		params   Params
+		hash     string
+		expected bool
+	}{
+		{name: "same argon2id params", params: testParams, hash: argon2Hash, expected: false},
+		{name: "more argon2id iterations", params: stronger, hash: argon2Hash, expected: true},
+		{name: "bcrypt to argon2id", params: testParams, hash: string(bcryptHash), expected: true},
+		{name: "same bcrypt cost", params: bcryptParams, hash: string(bcryptHash), expected: false},
+		{name: "higher bcrypt cost", params: strongerBcrypt, hash: string(bcryptHash), expected: true},
+		{name: "argon2id to bcrypt", params: bcryptParams, hash: argon2Hash, expected: true},
+		{name: "unknown format", params: testParams, hash: "plain text", expected: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, tt.params.NeedsRehash(tt.hash))
+		})
+	}
+}
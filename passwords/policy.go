@@ -0,0 +1,176 @@
+package passwords
+
+import (
+	"errors"
+	"fmt"
+	"main/config"
+	"strings"
+	"unicode/utf8"
+)
+
+// Rules a password can break, as reported in a Violation.
+const (
+	RuleMinLength = "min_length"
+	RuleStrength  = "strength"
+	RuleUserInput = "user_input"
+	RuleBreached  = "breached"
+	RuleHistory   = "history"
+)
+
+// Violation is one policy rule a password breaks.
+type Violation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// PolicyError lists every rule a password breaks.
+type PolicyError struct {
+	Violations []Violation `json:"violations"`
+}
+
+func (e *PolicyError) Error() string {
+	messages := make([]string, len(e.Violations))
+	for i, violation := range e.Violations {
+		messages[i] = violation.Message
+	}
+	return "password does not meet the policy: " + strings.Join(messages, "; ")
+}
+
+// Policy says which passwords are accepted. A zero value turns its rule off,
+// only an empty password is always rejected.
+type Policy struct {
+	MinLength   int
+	MinStrength int
+
+	// RejectUserInputs rejects passwords that contain the username or email address
+	RejectUserInputs bool
+
+	// BreachedDir holds SHA-1 range files named after a five character hash
+	// prefix, e.g. 21BD1.txt, with a SUFFIX:COUNT line per breached password.
+	// This is the layout of the Pwned Passwords range API, so the files can be
+	// refreshed offline with its downloader.
+	BreachedDir string
+
+	// History is how many previous passwords may not be used again
+	History int
+}
+
+// Subject is the user a password is checked for.
+type Subject struct {
+	Username string
+	Email    string
+
+	// History holds the user's previous password hashes, newest first
+	History []string
+}
+
+// ConfiguredPolicy returns the policy from the passwords section of the config.
+func ConfiguredPolicy() Policy {
+	settings := config.AppConfig.Passwords.Policy
+	return Policy{
+		MinLength:        settings.MinLength,
+		MinStrength:      settings.MinStrength,
+		RejectUserInputs: settings.RejectUserInputs,
+		BreachedDir:      settings.BreachedDir,
+		History:          settings.History,
+	}
+}
+
+// Check returns a *PolicyError listing every rule password breaks, or nil when
+// it is accepted. Other errors mean the check itself failed.
+func (p Policy) Check(password string, subject Subject) error {
+	var violations []Violation
+
+	minLength := max(p.MinLength, 1)
+	if utf8.RuneCountInString(password) < minLength {
+		violations = append(violations, Violation{
+			Rule:    RuleMinLength,
+			Message: fmt.Sprintf("must be at least %d characters long", minLength),
+		})
+	}
+
+	inputs := subject.inputs()
+	if p.MinStrength > 0 && Strength(password, inputs...) < p.MinStrength {
+		violations = append(violations, Violation{
+			Rule:    RuleStrength,
+			Message: "is too easy to guess, use a longer password or a few unrelated words",
+		})
+	}
+
+	if p.RejectUserInputs && containsAny(password, inputs) {
+		violations = append(violations, Violation{
+			Rule:    RuleUserInput,
+			Message: "must not contain your username or email address",
+		})
+	}
+
+	if p.BreachedDir != "" && password != "" {
+		breached, err := Breached(p.BreachedDir, password)
+		if err != nil {
+			return err
+		}
+		if breached {
+			violations = append(violations, Violation{
+				Rule:    RuleBreached,
+				Message: "appears in a known data breach",
+			})
+		}
+	}
+
+	if p.History > 0 && password != "" {
+		reused, err := reused(password, subject.History[:min(p.History, len(subject.History))])
+		if err != nil {
+			return err
+		}
+		if reused {
+			violations = append(violations, Violation{
+				Rule:    RuleHistory,
+				Message: fmt.Sprintf("must not be one of your last %d passwords", p.History),
+			})
+		}
+	}
+
+	if len(violations) > 0 {
+		return &PolicyError{Violations: violations}
+	}
+	return nil
+}
+
+// inputs returns the parts of the subject a password should not be built from.
+func (s Subject) inputs() []string {
+	var inputs []string
+	if s.Username != "" {
+		inputs = append(inputs, s.Username)
+	}
+	if s.Email != "" {
+		inputs = append(inputs, s.Email)
+		if local, _, found := strings.Cut(s.Email, "@"); found && local != "" {
+			inputs = append(inputs, local)
+		}
+	}
+	return inputs
+}
+
+func containsAny(password string, inputs []string) bool {
+	password = strings.ToLower(password)
+	for _, input := range inputs {
+		if len(input) >= 3 && strings.Contains(password, strings.ToLower(input)) {
+			return true
+		}
+	}
+	return false
+}
+
+func reused(password string, hashes []string) (bool, error) {
+	for _, hash := range hashes {
+		err := Verify(password, hash)
+		if err == nil {
+			return true, nil
+		}
+		// Hashes in a format this build does not know cannot match anyway
+		if !errors.Is(err, ErrMismatch) && !errors.Is(err, ErrUnknownFormat) {
+			return false, err
+		}
+	}
+	return false, nil
+}
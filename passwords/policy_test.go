@@ -0,0 +1,118 @@
+package passwords
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStrength(t *testing.T) {
+	tests := []struct {
+		password string
+		inputs   []string
+		min      int
+		max      int
+	}{
+		{password: "password", min: 0, max: 0},
+		{password: "Password123", min: 0, max: 1},
+		{password: "qwertyuiop", min: 0, max: 1},
+		{password: "aaaaaaaaaaaa", min: 0, max: 1},
+		{password: "abcdef123456", min: 0, max: 1},
+		{password: "tester2024", inputs: []string{"tester"}, min: 0, max: 2},
+		{password: "x7#Kp2!vQ9", min: 4, max: 4},
+		{password: "correct horse battery staple", min: 4, max: 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.password, func(t *testing.T) {
+			score := Strength(tt.password, tt.inputs...)
+			assert.GreaterOrEqual(t, score, tt.min)
+			assert.LessOrEqual(t, score, tt.max)
+		})
+	}
+}
+
+// writeRangeFile lists passwords in the range files of dir like the Pwned Passwords downloader does
+func writeRangeFile(t *testing.T, dir string, passwords ...string) {
+	t.Helper()
+	for _, password := range passwords {
+		sum := sha1.Sum([]byte(password))
+		hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+		file, err := os.OpenFile(filepath.Join(dir, hash[:5]+".txt"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+		require.NoError(t, err)
+		_, err = file.WriteString("0018A45C4D1DEF81644B54AB7F969B88D65:1\r\n" + hash[5:] + ":42\r\n")
+		require.NoError(t, err)
+		require.NoError(t, file.Close())
+	}
+}
+
+func TestBreached(t *testing.T) {
+	dir := t.TempDir()
+	writeRangeFile(t, dir, "hunter2 but longer")
+
+	breached, err := Breached(dir, "hunter2 but longer")
+	require.NoError(t, err)
+	assert.True(t, breached)
+
+	breached, err = Breached(dir, "not in the list")
+	require.NoError(t, err)
+	assert.False(t, breached)
+}
+
+func violatedRules(t *testing.T, err error) []string {
+	t.Helper()
+	if err == nil {
+		return nil
+	}
+	var policyErr *PolicyError
+	require.ErrorAs(t, err, &policyErr)
+
+	rules := make([]string, len(policyErr.Violations))
+	for i, violation := range policyErr.Violations {
+		rules[i] = violation.Rule
+	}
+	return rules
+}
+
+func TestPolicyCheck(t *testing.T) {
+	dir := t.TempDir()
+	writeRangeFile(t, dir, "Tr0ub4dor&3 is breached")
+
+	previous, err := testParams.Hash("my old sentence of words")
+	require.NoError(t, err)
+
+	policy := Policy{MinLength: 10, MinStrength: 3, RejectUserInputs: true, BreachedDir: dir, History: 2}
+	subject := Subject{Username: "tester", Email: "jane.doe@test.com", History: []string{previous}}
+
+	tests := []struct {
+		name     string
+		password string
+		rules    []string
+	}{
+		{name: "accepted", password: "plum violin staircase", rules: nil},
+		{name: "too short and weak", password: "abc123", rules: []string{RuleMinLength, RuleStrength}},
+		{name: "username", password: "tester plum violin", rules: []string{RuleUserInput}},
+		{name: "email local part", password: "Jane.Doe violin staircase", rules: []string{RuleUserInput}},
+		{name: "breached", password: "Tr0ub4dor&3 is breached", rules: []string{RuleBreached}},
+		{name: "previous password", password: "my old sentence of words", rules: []string{RuleHistory}},
+		{name: "every rule at once", password: "tester", rules: []string{RuleMinLength, RuleStrength, RuleUserInput}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.rules, violatedRules(t, policy.Check(tt.password, subject)))
+		})
+	}
+}
+
+func TestZeroPolicy(t *testing.T) {
+	// only an empty password is rejected when every rule is off
+	assert.Nil(t, violatedRules(t, Policy{}.Check("a", Subject{Username: "a"})))
+	assert.Equal(t, []string{RuleMinLength}, violatedRules(t, Policy{}.Check("", Subject{})))
+}
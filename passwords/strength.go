@@ -0,0 +1,210 @@
+package passwords
+
+import (
+	_ "embed"
+	"math"
+	"strings"
+	"unicode"
+)
+
+// common.txt lists frequently used passwords and words, most common first
+//
+//go:embed common.txt
+var commonList string
+
+var commonRanks = func() map[string]int {
+	ranks := make(map[string]int)
+	for i, word := range strings.Fields(commonList) {
+		if _, ok := ranks[word]; !ok {
+			ranks[word] = i + 1
+		}
+	}
+	return ranks
+}()
+
+var keyboardRows = []string{"qwertyuiop", "asdfghjkl", "zxcvbnm", "1qaz2wsx3edc", "qazwsxedc"}
+
+// Strength estimates how hard password is to guess on zxcvbn's scale:
+//
+//	0  fewer than 10^3 guesses
+//	1  fewer than 10^6 guesses
+//	2  fewer than 10^8 guesses
+//	3  fewer than 10^10 guesses
+//	4  more than that
+//
+// Like zxcvbn it splits the password into the cheapest patterns it finds, such
+// as common words, the user inputs, repeats, sequences and keyboard runs, and
+// guesses every other character by brute force.
+func Strength(password string, userInputs ...string) int {
+	guesses := log10Guesses(password, userInputs)
+	switch {
+	case guesses < 3:
+		return 0
+	case guesses < 6:
+		return 1
+	case guesses < 8:
+		return 2
+	case guesses < 10:
+		return 3
+	}
+	return 4
+}
+
+// log10Guesses returns the base 10 logarithm of the estimated number of guesses.
+func log10Guesses(password string, userInputs []string) float64 {
+	ranks := make(map[string]int, len(userInputs))
+	for _, input := range userInputs {
+		// The attacker is assumed to know the user, so their inputs come first
+		ranks[strings.ToLower(input)] = 1
+	}
+
+	original := []rune(password)
+	runes := []rune(strings.ToLower(password))
+	bruteForce := math.Log10(float64(cardinality(original)))
+
+	var total float64
+	for i := 0; i < len(runes); {
+		length, guesses := bestMatch(original, runes, i, ranks)
+		if length == 0 {
+			length, guesses = 1, bruteForce
+		}
+		total += guesses
+		i += length
+	}
+	return total
+}
+
+// bestMatch returns the length and log10 guesses of the longest pattern that
+// starts at i, preferring the cheaper one of equally long patterns.
+func bestMatch(original []rune, runes []rune, i int, userRanks map[string]int) (int, float64) {
+	bestLength, bestGuesses := 0, 0.0
+	consider := func(length int, guesses float64) {
+		if length > bestLength || (length == bestLength && guesses < bestGuesses) {
+			bestLength, bestGuesses = length, guesses
+		}
+	}
+
+	for j := len(runes); j >= i+3; j-- {
+		word := string(runes[i:j])
+		rank, ok := userRanks[word]
+		if !ok {
+			rank, ok = commonRanks[word]
+		}
+		if ok {
+			consider(j-i, math.Log10(float64(rank))+uppercaseVariations(original[i:j]))
+			break
+		}
+	}
+
+	if length := repeatLength(runes, i); length >= 3 {
+		consider(length, math.Log10(float64(cardinality(runes[i:i+1])*length)))
+	}
+	if length := sequenceLength(runes, i); length >= 3 {
+		consider(length, math.Log10(float64(10*length)))
+	}
+	if length := keyboardLength(runes, i); length >= 4 {
+		consider(length, math.Log10(float64(20*length)))
+	}
+	return bestLength, bestGuesses
+}
+
+// uppercaseVariations adds the guesses for capitalizing a word, which is
+// cheap when only the first or every letter is upper case.
+func uppercaseVariations(word []rune) float64 {
+	upper := 0
+	for _, r := range word {
+		if unicode.IsUpper(r) {
+			upper++
+		}
+	}
+	switch {
+	case upper == 0:
+		return 0
+	case upper == len(word) || (upper == 1 && unicode.IsUpper(word[0])):
+		return math.Log10(2)
+	}
+	return float64(upper) * math.Log10(2)
+}
+
+func repeatLength(runes []rune, i int) int {
+	j := i + 1
+	for j < len(runes) && runes[j] == runes[i] {
+		j++
+	}
+	return j - i
+}
+
+// sequenceLength is the length of a run like abcd, 4321 or 2468 starting at i.
+func sequenceLength(runes []rune, i int) int {
+	if i+1 >= len(runes) {
+		return 1
+	}
+	delta := runes[i+1] - runes[i]
+	if delta == 0 || delta > 2 || delta < -2 {
+		return 1
+	}
+	j := i + 1
+	for j < len(runes) && runes[j]-runes[j-1] == delta {
+		j++
+	}
+	return j - i
+}
+
+// keyboardLength is the length of the longest run of adjacent keys starting at i.
+func keyboardLength(runes []rune, i int) int {
+	best := 0
+	for _, row := range keyboardRows {
+		for _, keys := range []string{row, reverse(row)} {
+			start := strings.IndexRune(keys, runes[i])
+			if start < 0 {
+				continue
+			}
+			length := 0
+			for i+length < len(runes) && start+length < len(keys) && rune(keys[start+length]) == runes[i+length] {
+				length++
+			}
+			best = max(best, length)
+		}
+	}
+	return best
+}
+
+func reverse(s string) string {
+	runes := []rune(s)
+	for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+		runes[i], runes[j] = runes[j], runes[i]
+	}
+	return string(runes)
+}
+
+// cardinality is the size of the character classes the runes are drawn from.
+func cardinality(runes []rune) int {
+	var lower, upper, digit, other bool
+	for _, r := range runes {
+		switch {
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= '0' && r <= '9':
+			digit = true
+		default:
+			other = true
+		}
+	}
+
+	size := 0
+	if lower {
+		size += 26
+	}
+	if upper {
+		size += 26
+	}
+	if digit {
+		size += 10
+	}
+	if other {
+		size += 33
+	}
+	return max(size, 1)
+}
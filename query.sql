@@ -1,35 +1,90 @@
 -- name: CreateUser :one
-INSERT INTO users (username, email, password, age) VALUES ($1, $2, $3, $4) RETURNING username, email, age;
+INSERT INTO users (username, email, password, age) VALUES ($1, $2, $3, $4) RETURNING id, username, email, age;
 
 -- name: GetUser :one
-SELECT * FROM users WHERE id = $1 LIMIT 1;
+SELECT * FROM users WHERE id = $1 AND deleted_at IS NULL LIMIT 1;
 
 -- name: GetUsers :many
-SELECT * FROM users ORDER BY username ASC;
+SELECT * FROM users WHERE deleted_at IS NULL ORDER BY username ASC;
 
 -- name: AddUserToRoom :one
-UPDATE users SET room_id = $2 WHERE username = $1 RETURNING *;
+UPDATE users SET room_id = $2 WHERE username = $1 AND deleted_at IS NULL RETURNING *;
 
 -- name: RemoveUserFromARoom :one
-UPDATE users SET room_id = NULL WHERE id = $1 RETURNING *;
+UPDATE users SET room_id = NULL WHERE id = $1 AND deleted_at IS NULL RETURNING *;
 
 -- name: UpdateUser :one
-UPDATE users SET username = $2, email = $3, age = $4 WHERE id = $1 RETURNING *;
+UPDATE users SET username = $2, email = $3, age = $4,
+    email_verified_at = CASE WHEN email = $3 THEN email_verified_at END,
+    status = CASE WHEN email <> $3 AND status = 'active' THEN 'pending_verification' ELSE status END
+WHERE id = $1 AND deleted_at IS NULL RETURNING *;
+
+-- name: UpdateUserProfile :one
+UPDATE users SET display_name = @display_name, bio = @bio, locale = @locale, timezone = @timezone,
+    pronouns = @pronouns, links = @links::text[]
+WHERE id = @id AND deleted_at IS NULL RETURNING *;
 
 -- name: DeleteUser :one
-DELETE FROM users WHERE id = $1 RETURNING *;
+UPDATE users SET deleted_at = NOW() WHERE id = $1 AND deleted_at IS NULL RETURNING *;
+
+-- name: RestoreUser :one
+UPDATE users SET deleted_at = NULL WHERE id = $1 AND deleted_at IS NOT NULL AND purged_at IS NULL RETURNING *;
+
+-- name: SetUserStatus :one
+UPDATE users SET status = @status, status_reason = @reason, status_changed_by = @changed_by,
+    status_changed_at = NOW(), status_expires_at = @expires_at
+WHERE id = @id AND deleted_at IS NULL RETURNING *;
+
+-- name: ListPurgeableUsers :many
+SELECT id FROM users WHERE deleted_at < NOW() - @retention::interval AND purged_at IS NULL ORDER BY deleted_at ASC LIMIT @max_users;
+
+-- name: PurgeUser :execrows
+DELETE FROM users WHERE id = @id AND deleted_at < NOW() - @retention::interval AND purged_at IS NULL;
+
+-- name: AnonymizeUser :execrows
+WITH target AS (
+    SELECT u.id FROM users u WHERE u.id = @id AND u.deleted_at < NOW() - @retention::interval AND u.purged_at IS NULL FOR UPDATE
+), roles AS (
+    DELETE FROM user_roles WHERE user_id IN (SELECT id FROM target)
+), totp AS (
+    DELETE FROM user_totp WHERE user_id IN (SELECT id FROM target)
+), codes AS (
+    DELETE FROM recovery_codes WHERE user_id IN (SELECT id FROM target)
+), history AS (
+    DELETE FROM password_history WHERE user_id IN (SELECT id FROM target)
+), tokens AS (
+    DELETE FROM api_tokens WHERE user_id IN (SELECT id FROM target)
+), user_sessions AS (
+    DELETE FROM sessions WHERE user_id IN (SELECT id FROM target)
+), identities AS (
+    DELETE FROM user_identities WHERE user_id IN (SELECT id FROM target)
+), consents AS (
+    DELETE FROM oauth_consents WHERE user_id IN (SELECT id FROM target)
+)
+UPDATE users SET username = 'deleted-' || users.id, email = 'deleted-' || users.id || '@invalid', password = '',
+    age = NULL, room_id = NULL, email_verified_at = NULL, status_reason = NULL, display_name = NULL, bio = NULL,
+    locale = NULL, timezone = NULL, pronouns = NULL, links = '{}', purged_at = NOW()
+WHERE users.id IN (SELECT id FROM target);
 
 -- name: GetUserByUsername :one
-SELECT * FROM users WHERE username = $1 LIMIT 1;
+SELECT * FROM users WHERE username = $1 AND deleted_at IS NULL LIMIT 1;
+
+-- name: GetUserByEmail :one
+SELECT * FROM users WHERE email = $1 AND deleted_at IS NULL ORDER BY id ASC LIMIT 1;
+
+-- name: VerifyUserEmail :execrows
+UPDATE users SET email_verified_at = NOW(),
+    status = CASE WHEN status = 'pending_verification' THEN 'active' ELSE status END
+WHERE id = $1 AND email = $2 AND email_verified_at IS NULL AND deleted_at IS NULL;
 
 -- name: GetUsersByRoomID :many
-SELECT * FROM users WHERE room_id = $1 ORDER BY id ASC;
+SELECT * FROM users WHERE room_id = $1 AND deleted_at IS NULL ORDER BY id ASC;
 
 -- name: UpdateUserPassword :one
-UPDATE users SET password = $2 WHERE id = $1 RETURNING *;
+UPDATE users SET password = $2 WHERE id = $1 AND deleted_at IS NULL RETURNING *;
 
 -- name: CreateRoom :one
-INSERT INTO rooms (name) VALUES ($1) RETURNING *;
+INSERT INTO rooms (name, owner_id) VALUES ($1, $2) RETURNING *;
 
 -- name: GetRooms :many
 SELECT * FROM rooms ORDER BY name ASC;
@@ -40,3 +95,170 @@ SELECT * FROM rooms WHERE id = $1 LIMIT 1;
 -- name: DeleteRoom :one
 DELETE FROM rooms WHERE id = $1 RETURNING *;
 
+
+-- name: GetUserRoles :many
+SELECT r.name FROM roles r JOIN user_roles ur ON ur.role_id = r.id WHERE ur.user_id = $1 ORDER BY r.name ASC;
+
+-- name: GetPermissionsByRoles :many
+SELECT DISTINCT p.name FROM permissions p
+JOIN role_permissions rp ON rp.permission_id = p.id
+JOIN roles r ON r.id = rp.role_id
+WHERE r.name = ANY(@roles::text[])
+ORDER BY p.name ASC;
+
+-- name: AddUserRole :execrows
+INSERT INTO user_roles (user_id, role_id)
+SELECT $1, r.id FROM roles r WHERE r.name = @role
+ON CONFLICT DO NOTHING;
+
+-- name: RemoveUserRole :execrows
+DELETE FROM user_roles ur USING roles r WHERE ur.role_id = r.id AND ur.user_id = $1 AND r.name = @role;
+
+-- name: GetUserTOTP :one
+SELECT * FROM user_totp WHERE user_id = $1 LIMIT 1;
+
+-- name: UpsertUserTOTP :exec
+INSERT INTO user_totp (user_id, secret) VALUES ($1, $2)
+ON CONFLICT (user_id) DO UPDATE SET secret = EXCLUDED.secret, confirmed_at = NULL, created_at = NOW();
+
+-- name: ConfirmUserTOTP :exec
+UPDATE user_totp SET confirmed_at = NOW() WHERE user_id = $1;
+
+-- name: DeleteUserTOTP :exec
+DELETE FROM user_totp WHERE user_id = $1;
+
+-- name: CreateRecoveryCode :exec
+INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2);
+
+-- name: DeleteRecoveryCodes :exec
+DELETE FROM recovery_codes WHERE user_id = $1;
+
+-- name: UseRecoveryCode :execrows
+UPDATE recovery_codes SET used_at = NOW() WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL;
+
+-- name: CreatePasswordHistory :exec
+INSERT INTO password_history (user_id, password_hash) VALUES ($1, $2);
+
+-- name: GetPasswordHistory :many
+SELECT password_hash FROM password_history WHERE user_id = $1 ORDER BY id DESC LIMIT $2;
+
+-- name: PrunePasswordHistory :exec
+DELETE FROM password_history ph
+WHERE ph.user_id = $1 AND ph.id NOT IN (
+    SELECT recent.id FROM password_history recent WHERE recent.user_id = $1 ORDER BY recent.id DESC LIMIT $2
+);
+
+-- name: GetPermissionNames :many
+SELECT name FROM permissions ORDER BY name ASC;
+
+-- name: CreateAPIToken :one
+INSERT INTO api_tokens (user_id, name, token_prefix, token_hash, scopes, expires_at)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING *;
+
+-- name: ListAPITokens :many
+SELECT * FROM api_tokens WHERE user_id = $1 ORDER BY id ASC;
+
+-- name: GetActiveAPIToken :one
+SELECT t.id, t.user_id, t.scopes, u.username, u.email_verified_at, u.status, u.status_expires_at
+FROM api_tokens t JOIN users u ON u.id = t.user_id
+WHERE t.token_hash = $1 AND (t.expires_at IS NULL OR t.expires_at > NOW()) AND u.deleted_at IS NULL
+LIMIT 1;
+
+-- name: TouchAPIToken :exec
+UPDATE api_tokens SET last_used_at = NOW(), last_used_ip = @ip::varchar
+WHERE id = @id AND (
+    last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute' OR last_used_ip IS DISTINCT FROM @ip::varchar
+);
+
+-- name: DeleteAPIToken :execrows
+DELETE FROM api_tokens WHERE id = $1 AND user_id = $2;
+
+-- name: CreateSession :exec
+INSERT INTO sessions (id, user_id, token_id, refresh_family, user_agent, ip, expires_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7);
+
+-- name: RefreshSession :execrows
+UPDATE sessions SET token_id = $2, ip = $3, last_seen_at = NOW(), expires_at = $4
+WHERE id = $1 AND revoked_at IS NULL;
+
+-- name: TouchSession :exec
+UPDATE sessions SET last_seen_at = NOW(), ip = $2 WHERE id = $1 AND revoked_at IS NULL;
+
+-- name: ListSessions :many
+SELECT * FROM sessions
+WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+ORDER BY last_seen_at DESC;
+
+-- name: GetSession :one
+SELECT * FROM sessions WHERE id = $1 LIMIT 1;
+
+-- name: RevokeSession :one
+UPDATE sessions SET revoked_at = NOW()
+WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+RETURNING *;
+
+-- name: RevokeUserSessions :exec
+UPDATE sessions SET revoked_at = NOW()
+WHERE user_id = $1 AND revoked_at IS NULL;
+
+-- name: GetUserIdentity :one
+SELECT * FROM user_identities WHERE provider = $1 AND subject = $2 LIMIT 1;
+
+-- name: CreateUserIdentity :one
+INSERT INTO user_identities (user_id, provider, subject, email, last_login_at)
+VALUES ($1, $2, $3, $4, NOW())
+RETURNING *;
+
+-- name: TouchUserIdentity :exec
+UPDATE user_identities SET email = $2, last_login_at = NOW() WHERE id = $1;
+
+-- name: CreateOAuthClient :one
+INSERT INTO oauth_clients (id, name, secret_hash, redirect_uris, grant_types, scopes, service_user_id)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+RETURNING *;
+
+-- name: GetOAuthClient :one
+SELECT * FROM oauth_clients WHERE id = $1 LIMIT 1;
+
+-- name: ListOAuthClients :many
+SELECT * FROM oauth_clients ORDER BY created_at ASC;
+
+-- name: DeleteOAuthClient :execrows
+DELETE FROM oauth_clients WHERE id = $1;
+
+-- name: GetOAuthConsent :one
+SELECT scopes FROM oauth_consents WHERE user_id = $1 AND client_id = $2 LIMIT 1;
+
+-- name: SaveOAuthConsent :exec
+INSERT INTO oauth_consents (user_id, client_id, scopes) VALUES ($1, $2, $3)
+ON CONFLICT (user_id, client_id) DO UPDATE SET scopes = EXCLUDED.scopes, granted_at = NOW();
+
+-- name: CreateAuditLogEntry :exec
+INSERT INTO audit_log (action, actor_id, actor_username, target_user_id, target_username, token_id, reason, ip, user_agent)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9);
+
+-- name: ListAuditLog :many
+SELECT * FROM audit_log
+WHERE sqlc.narg('user_id')::int IS NULL OR actor_id = sqlc.narg('user_id') OR target_user_id = sqlc.narg('user_id')
+ORDER BY id DESC
+LIMIT sqlc.arg('limit') OFFSET sqlc.arg('offset');
+
+-- name: SearchUsersByPrefix :many
+SELECT sqlc.embed(users), GREATEST(similarity(lower(username), sqlc.arg('query')::text), similarity(lower(email), sqlc.arg('query')::text))::real AS score
+FROM users
+WHERE deleted_at IS NULL AND (lower(username) LIKE sqlc.arg('prefix')::text OR lower(email) LIKE sqlc.arg('prefix')::text)
+ORDER BY score DESC, username ASC, id ASC
+LIMIT sqlc.arg('limit');
+
+-- name: SearchUsers :many
+SELECT sqlc.embed(users),
+    (lower(username) LIKE sqlc.arg('prefix')::text OR lower(email) LIKE sqlc.arg('prefix')::text)::boolean AS prefix_match,
+    GREATEST(similarity(lower(username), sqlc.arg('query')::text), similarity(lower(email), sqlc.arg('query')::text))::real AS score
+FROM users
+WHERE deleted_at IS NULL AND (
+    lower(username) LIKE sqlc.arg('prefix')::text OR lower(email) LIKE sqlc.arg('prefix')::text
+    OR lower(username) % sqlc.arg('query')::text OR lower(email) % sqlc.arg('query')::text
+)
+ORDER BY prefix_match DESC, score DESC, username ASC, id ASC
+LIMIT sqlc.arg('limit');
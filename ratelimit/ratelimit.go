@@ -0,0 +1,102 @@
+package ratelimit
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const keyPrefix = "rate_limit:"
+
+// slidingWindowScript keeps one sorted set member per request, scored by its time in
+// milliseconds. Members older than the window are dropped before counting, so the limit
+// applies to any window-long span rather than to fixed buckets.
+//
+// It returns {allowed, remaining, reset in ms}, reset being when the oldest counted
+// request leaves the window.
+var slidingWindowScript = redis.NewScript(`
+local key = KEYS[1]
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+local member = ARGV[4]
+
+redis.call('ZREMRANGEBYSCORE', key, '-inf', now - window)
+local count = redis.call('ZCARD', key)
+
+local allowed = 0
+if count < limit then
+	redis.call('ZADD', key, now, member)
+	count = count + 1
+	allowed = 1
+end
+redis.call('PEXPIRE', key, window)
+
+local reset = window
+local oldest = redis.call('ZRANGE', key, 0, 0, 'WITHSCORES')
+if oldest[2] then
+	reset = tonumber(oldest[2]) + window - now
+end
+
+return {allowed, limit - count, reset}
+`)
+
+// Limit allows Requests requests per Window.
+type Limit struct {
+	Requests int
+	Window   time.Duration
+}
+
+// Result describes the state of a key after a call to Allow.
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// ResetAfter is how long until a request slot frees up again.
+	ResetAfter time.Duration
+}
+
+type Limiter struct {
+	rdb *redis.Client
+	now func() time.Time
+}
+
+func NewLimiter(rdb *redis.Client) *Limiter {
+	return &Limiter{rdb: rdb, now: time.Now}
+}
+
+// Allow counts a request against key and reports whether it fits in the limit.
+// Rejected requests are not counted.
+func (l *Limiter) Allow(ctx context.Context, key string, limit Limit) (Result, error) {
+	member, err := requestID()
+	if err != nil {
+		return Result{}, err
+	}
+
+	now := l.now().UnixMilli()
+	values, err := slidingWindowScript.Run(ctx, l.rdb, []string{keyPrefix + key},
+		now, limit.Window.Milliseconds(), limit.Requests, strconv.FormatInt(now, 10)+"-"+member,
+	).Int64Slice()
+	if err != nil {
+		return Result{}, err
+	}
+
+	return Result{
+		Allowed:    values[0] == 1,
+		Limit:      limit.Requests,
+		Remaining:  int(values[1]),
+		ResetAfter: time.Duration(values[2]) * time.Millisecond,
+	}, nil
+}
+
+func requestID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,48 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSlidingWindow(t *testing.T) {
+	mr := miniredis.RunT(t)
+	limiter := NewLimiter(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+
+	now := time.Unix(1700000000, 0)
+	limiter.now = func() time.Time { return now }
+	limit := Limit{Requests: 3, Window: time.Minute}
+	ctx := context.Background()
+
+	for i := 0; i < limit.Requests; i++ {
+		result, err := limiter.Allow(ctx, "ip:10.0.0.1", limit)
+		require.NoError(t, err)
+		assert.True(t, result.Allowed)
+		assert.Equal(t, limit.Requests-i-1, result.Remaining)
+		now = now.Add(10 * time.Second)
+	}
+
+	result, err := limiter.Allow(ctx, "ip:10.0.0.1", limit)
+	require.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 0, result.Remaining)
+	// the first request was 30 seconds ago, so a slot frees up in 30 seconds
+	assert.Equal(t, 30*time.Second, result.ResetAfter)
+
+	// other keys have their own window
+	result, err = limiter.Allow(ctx, "ip:10.0.0.2", limit)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+
+	now = now.Add(30 * time.Second)
+	result, err = limiter.Allow(ctx, "ip:10.0.0.1", limit)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 0, result.Remaining)
+}
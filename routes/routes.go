@@ -8,29 +8,104 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
+const (
+	roleAdmin     = "admin"
+	roleModerator = "moderator"
+)
+
 func RegisterUserRoutes(router *gin.RouterGroup, uc *controller.UserController, ws *ws.WsController) {
+	authMiddleware := middleware.AuthMiddleware(uc.RedisClient, uc.Queries)
+	verifiedEmail := middleware.RequireVerifiedEmail()
+	session := middleware.RequireSession()
+	notImpersonated := middleware.DenyImpersonation()
+	csrf := middleware.CSRFProtection()
 
 	UserRouter := router.Group("/users")
 	{
-		UserRouter.POST("/signup", uc.SignUp)
-		UserRouter.POST("/login", uc.Login)
+		UserRouter.POST("/signup", middleware.RateLimit(uc.RedisClient, "signup"), uc.SignUp)
+		UserRouter.POST("/login", middleware.RateLimit(uc.RedisClient, "login"), uc.Login)
+		UserRouter.POST("/login/mfa", middleware.RateLimit(uc.RedisClient, "login"), uc.LoginMFA)
+		UserRouter.POST("/login/magic-link", middleware.RateLimit(uc.RedisClient, "magic_link"), uc.RequestMagicLink)
+		UserRouter.GET("/login/magic-link/callback", middleware.RateLimit(uc.RedisClient, "login"), uc.MagicLinkCallback)
+		UserRouter.POST("/refresh", uc.Refresh)
+		UserRouter.POST("/verify-email", uc.VerifyEmail)
+		UserRouter.POST("/verify-email/resend", middleware.RateLimit(uc.RedisClient, "signup"), uc.ResendVerificationEmail)
+		UserRouter.POST("/password/forgot", middleware.RateLimit(uc.RedisClient, "signup"), uc.ForgotPassword)
+		UserRouter.POST("/password/reset", middleware.RateLimit(uc.RedisClient, "login"), uc.ResetPassword)
+		UserRouter.GET("/oidc/:provider/login", middleware.RateLimit(uc.RedisClient, "login"), uc.OIDCLogin)
+		UserRouter.GET("/oidc/:provider/callback", middleware.RateLimit(uc.RedisClient, "login"), uc.OIDCCallback)
 
 		authRoutes := UserRouter.Group("/")
-		authRoutes.Use(middleware.AuthMiddleware())
-		authRoutes.POST("/logout", uc.Logout)
-		authRoutes.GET("/:id", uc.GetUser)
-		authRoutes.GET("/", uc.GetUsers)
-		authRoutes.PUT("/change-password", uc.ChangePassword)
-		authRoutes.PUT("/:id", uc.UpdateUser)
-		authRoutes.DELETE("/:id", uc.DeleteUser)
+		authRoutes.Use(authMiddleware, csrf, middleware.RateLimit(uc.RedisClient, "users"))
+		authRoutes.POST("/logout", session, notImpersonated, uc.Logout)
+		authRoutes.POST("/logout-all", session, notImpersonated, uc.LogoutAll)
+		authRoutes.POST("/me/mfa/totp", session, notImpersonated, uc.EnrollTOTP)
+		authRoutes.POST("/me/mfa/totp/confirm", session, notImpersonated, uc.ConfirmTOTP)
+		authRoutes.DELETE("/me/mfa/totp", session, notImpersonated, uc.DisableTOTP)
+		authRoutes.POST("/me/mfa/recovery-codes", session, notImpersonated, uc.RegenerateRecoveryCodes)
+		authRoutes.GET("/me/sessions", session, notImpersonated, uc.ListSessions)
+		authRoutes.DELETE("/me/sessions/:id", session, notImpersonated, uc.RevokeSession)
+		authRoutes.POST("/me/tokens", notImpersonated, middleware.RequireScope("tokens:manage"), uc.CreateAPIToken)
+		authRoutes.GET("/me/tokens", notImpersonated, middleware.RequireScope("tokens:manage"), uc.ListAPITokens)
+		authRoutes.DELETE("/me/tokens/:tokenId", notImpersonated, middleware.RequireScope("tokens:manage"), uc.RevokeAPIToken)
+		authRoutes.POST("/impersonation/stop", uc.StopImpersonation)
+		authRoutes.GET("/search", verifiedEmail, middleware.RequireScope("users:read"), middleware.RequirePermission(uc.Queries, "users:search"), uc.SearchUsers)
+		authRoutes.GET("/:id", verifiedEmail, middleware.RequireScope("users:read"), uc.GetUser)
+		authRoutes.GET("/", verifiedEmail, middleware.RequireScope("users:read"), uc.GetUsers)
+		authRoutes.PUT("/change-password", session, notImpersonated, uc.ChangePassword)
+		authRoutes.PUT("/me/profile", notImpersonated, middleware.RequireScope("users:write"), uc.UpdateProfile)
+		authRoutes.PUT("/:id", notImpersonated, middleware.RequireScope("users:write"), middleware.RequireSelfOrRole(roleAdmin), uc.UpdateUser)
+		authRoutes.DELETE("/:id", notImpersonated, middleware.RequireScope("users:delete"), middleware.RequireSelfOrRole(roleAdmin), uc.DeleteUser)
 	}
 
 	wsRouter := router.Group("/ws")
 	{
-		authRoutes := wsRouter.Group("/").Use(middleware.AuthMiddleware())
+		authRoutes := wsRouter.Group("/").Use(authMiddleware, csrf, verifiedEmail, session)
 		authRoutes.POST("/create-room", ws.CreateRoom)
 		authRoutes.GET("/join-room/:roomId", ws.JoinRoom)
 		authRoutes.GET("/getRooms", ws.GetRooms)
 		authRoutes.GET("/getClients/:roomId", ws.GetClients)
+		authRoutes.DELETE("/delete-room/:roomId", middleware.RequireRoomOwnerOrRole(uc.Queries, roleModerator, roleAdmin), ws.DeleteRoom)
+	}
+}
+
+func RegisterAdminRoutes(router *gin.RouterGroup, uc *controller.UserController) {
+	adminRouter := router.Group("/admin")
+	adminRouter.Use(middleware.AuthMiddleware(uc.RedisClient, uc.Queries), middleware.CSRFProtection(), middleware.DenyImpersonation(), middleware.RequireRole(roleAdmin))
+	{
+		adminRouter.POST("/users/:id/roles", middleware.RequirePermission(uc.Queries, "roles:manage"), uc.AddUserRole)
+		adminRouter.DELETE("/users/:id/roles/:role", middleware.RequirePermission(uc.Queries, "roles:manage"), uc.RemoveUserRole)
+		adminRouter.POST("/users/:id/unlock", middleware.RequirePermission(uc.Queries, "users:write"), uc.UnlockUser)
+		adminRouter.POST("/users/:id/restore", middleware.RequirePermission(uc.Queries, "users:delete"), uc.RestoreUser)
+		adminRouter.PUT("/users/:id/status", middleware.RequirePermission(uc.Queries, "users:moderate"), uc.SetUserStatus)
+		adminRouter.POST("/users/:id/impersonate", middleware.RequirePermission(uc.Queries, "users:impersonate"), uc.ImpersonateUser)
+		adminRouter.GET("/audit-log", middleware.RequirePermission(uc.Queries, "audit:read"), uc.ListAuditLog)
+		adminRouter.POST("/oauth/clients", middleware.RequirePermission(uc.Queries, "clients:manage"), uc.CreateOAuthClient)
+		adminRouter.GET("/oauth/clients", middleware.RequirePermission(uc.Queries, "clients:manage"), uc.ListOAuthClients)
+		adminRouter.DELETE("/oauth/clients/:clientId", middleware.RequirePermission(uc.Queries, "clients:manage"), uc.DeleteOAuthClient)
 	}
 }
+
+func RegisterWellKnownRoutes(router *gin.RouterGroup, uc *controller.UserController) {
+	router.GET("/jwks.json", uc.JWKS)
+	router.GET("/openid-configuration", uc.OpenIDConfiguration)
+}
+
+// RegisterOAuthRoutes serves the endpoints other apps log their users in through.
+// The authorize endpoints are called by the consent page on behalf of the logged in user.
+func RegisterOAuthRoutes(router *gin.RouterGroup, uc *controller.UserController) {
+	authMiddleware := middleware.AuthMiddleware(uc.RedisClient, uc.Queries)
+	session := middleware.RequireSession()
+	notImpersonated := middleware.DenyImpersonation()
+	csrf := middleware.CSRFProtection()
+
+	router.GET("/authorize", authMiddleware, csrf, session, notImpersonated, uc.OAuthAuthorize)
+	router.POST("/authorize", authMiddleware, csrf, session, notImpersonated, uc.OAuthConsent)
+	router.POST("/token", middleware.RateLimit(uc.RedisClient, "login"), uc.OAuthToken)
+	// Apps call these for every token they see, and a wrong secret is only found out after the lookup
+	clientLimit := middleware.RateLimit(uc.RedisClient, "oauth_clients")
+	router.POST("/introspect", clientLimit, uc.OAuthIntrospect)
+	router.POST("/revoke", clientLimit, uc.OAuthRevoke)
+	router.GET("/userinfo", authMiddleware, csrf, middleware.RequireScope("openid"), uc.OAuthUserInfo)
+	router.POST("/userinfo", authMiddleware, csrf, middleware.RequireScope("openid"), uc.OAuthUserInfo)
+}
@@ -14,3 +14,150 @@ CREATE TABLE IF NOT EXISTS users (
     room_id INT REFERENCES rooms(id) ON DELETE SET NULL,
     created_at timestamp DEFAULT NOW()
 );
+
+ALTER TABLE rooms ADD COLUMN owner_id INT REFERENCES users(id) ON DELETE SET NULL;
+
+ALTER TABLE users ADD COLUMN email_verified_at timestamp;
+
+ALTER TABLE users ALTER COLUMN created_at SET NOT NULL;
+
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+ALTER TABLE users ADD COLUMN deleted_at timestamp;
+ALTER TABLE users ADD COLUMN purged_at timestamp;
+
+ALTER TABLE users ADD COLUMN status varchar(32) NOT NULL DEFAULT 'pending_verification';
+ALTER TABLE users ADD COLUMN status_reason text;
+ALTER TABLE users ADD COLUMN status_changed_by INT REFERENCES users(id) ON DELETE SET NULL;
+ALTER TABLE users ADD COLUMN status_changed_at timestamp;
+ALTER TABLE users ADD COLUMN status_expires_at timestamp;
+
+ALTER TABLE users ADD COLUMN display_name varchar(64);
+ALTER TABLE users ADD COLUMN bio text;
+ALTER TABLE users ADD COLUMN locale varchar(35);
+ALTER TABLE users ADD COLUMN timezone varchar(64);
+ALTER TABLE users ADD COLUMN pronouns varchar(40);
+ALTER TABLE users ADD COLUMN links text[] NOT NULL DEFAULT '{}';
+
+-- Roles Table
+CREATE TABLE roles (
+    id SERIAL PRIMARY KEY,
+    name varchar(64) NOT NULL UNIQUE
+);
+
+-- Permissions Table
+CREATE TABLE permissions (
+    id SERIAL PRIMARY KEY,
+    name varchar(128) NOT NULL UNIQUE
+);
+
+CREATE TABLE role_permissions (
+    role_id INT NOT NULL REFERENCES roles(id) ON DELETE CASCADE,
+    permission_id INT NOT NULL REFERENCES permissions(id) ON DELETE CASCADE,
+    PRIMARY KEY (role_id, permission_id)
+);
+
+CREATE TABLE user_roles (
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role_id INT NOT NULL REFERENCES roles(id) ON DELETE CASCADE,
+    PRIMARY KEY (user_id, role_id)
+);
+
+-- Two-factor authentication
+CREATE TABLE user_totp (
+    user_id INT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    secret varchar(64) NOT NULL,
+    confirmed_at timestamp,
+    created_at timestamp NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE recovery_codes (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    code_hash char(64) NOT NULL,
+    used_at timestamp,
+    UNIQUE (user_id, code_hash)
+);
+
+-- Previous password hashes, newest first by id
+CREATE TABLE password_history (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    password_hash varchar(255) NOT NULL,
+    created_at timestamp NOT NULL DEFAULT NOW()
+);
+
+-- Personal access tokens and service-account API keys, only a hash of the key is kept
+CREATE TABLE api_tokens (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name varchar(255) NOT NULL,
+    token_prefix varchar(16) NOT NULL,
+    token_hash char(64) NOT NULL UNIQUE,
+    scopes text[] NOT NULL DEFAULT '{}',
+    expires_at timestamp,
+    last_used_at timestamp,
+    last_used_ip varchar(45),
+    created_at timestamp NOT NULL DEFAULT NOW()
+);
+
+-- One row per login, the tokens of a session carry its id as the sid claim
+CREATE TABLE sessions (
+    id varchar(32) PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    token_id varchar(64) NOT NULL,
+    refresh_family varchar(64) NOT NULL,
+    user_agent text NOT NULL DEFAULT '',
+    ip varchar(45) NOT NULL DEFAULT '',
+    created_at timestamp NOT NULL DEFAULT NOW(),
+    last_seen_at timestamp NOT NULL DEFAULT NOW(),
+    expires_at timestamp NOT NULL,
+    revoked_at timestamp
+);
+
+-- Logins through external OpenID Connect providers
+CREATE TABLE user_identities (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    provider varchar(64) NOT NULL,
+    subject varchar(255) NOT NULL,
+    email varchar(255) NOT NULL DEFAULT '',
+    created_at timestamp NOT NULL DEFAULT NOW(),
+    last_login_at timestamp,
+    UNIQUE (provider, subject)
+);
+
+-- Apps that log their users in through this service
+CREATE TABLE oauth_clients (
+    id varchar(64) PRIMARY KEY,
+    name varchar(255) NOT NULL,
+    secret_hash char(64),
+    redirect_uris text[] NOT NULL DEFAULT '{}',
+    grant_types text[] NOT NULL DEFAULT '{}',
+    scopes text[] NOT NULL DEFAULT '{}',
+    service_user_id INT REFERENCES users(id) ON DELETE CASCADE,
+    created_at timestamp NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE oauth_consents (
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    client_id varchar(64) NOT NULL REFERENCES oauth_clients(id) ON DELETE CASCADE,
+    scopes text[] NOT NULL DEFAULT '{}',
+    granted_at timestamp NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, client_id)
+);
+
+-- Who did what to whose account, kept when either user is deleted
+CREATE TABLE audit_log (
+    id SERIAL PRIMARY KEY,
+    action varchar(64) NOT NULL,
+    actor_id INT REFERENCES users(id) ON DELETE SET NULL,
+    actor_username varchar(255) NOT NULL DEFAULT '',
+    target_user_id INT REFERENCES users(id) ON DELETE SET NULL,
+    target_username varchar(255) NOT NULL DEFAULT '',
+    token_id varchar(64) NOT NULL DEFAULT '',
+    reason text NOT NULL DEFAULT '',
+    ip varchar(45) NOT NULL DEFAULT '',
+    user_agent text NOT NULL DEFAULT '',
+    created_at timestamp NOT NULL DEFAULT NOW()
+);
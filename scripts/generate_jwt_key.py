@@ -1,16 +1,48 @@
 import os
+import sys
 import yaml
 import base64
+from datetime import datetime, timezone
 
-# Generate a random 32-byte key
-jwt_key = base64.urlsafe_b64encode(os.urandom(32)).decode('utf-8')
+from cryptography.hazmat.primitives import serialization
+from cryptography.hazmat.primitives.asymmetric import ed25519, rsa
+
+# Usage: python generate_jwt_key.py [HS256|RS256|EdDSA]
+algorithm = sys.argv[1] if len(sys.argv) > 1 else 'HS256'
 
 with open('../config/config.yaml', 'r') as file:
     config = yaml.safe_load(file)
 
-config['jwt']['key'] = jwt_key
+config['jwt']['algorithm'] = algorithm
+
+if algorithm == 'HS256':
+    # Generate a random 32-byte key
+    jwt_key = base64.urlsafe_b64encode(os.urandom(32)).decode('utf-8')
+    config['jwt']['key'] = jwt_key
+    print(f"Generated JWT key: {jwt_key}")
+else:
+    if algorithm == 'RS256':
+        private_key = rsa.generate_private_key(public_exponent=65537, key_size=2048)
+    elif algorithm == 'EdDSA':
+        private_key = ed25519.Ed25519PrivateKey.generate()
+    else:
+        sys.exit(f"Unsupported algorithm: {algorithm}")
+
+    # The key id is the creation time, the service uses it to schedule rotation
+    kid = datetime.now(timezone.utc).strftime('%Y%m%dT%H%M%SZ')
+    pem = private_key.private_bytes(
+        encoding=serialization.Encoding.PEM,
+        format=serialization.PrivateFormat.PKCS8,
+        encryption_algorithm=serialization.NoEncryption(),
+    )
+
+    os.makedirs('../config/keys', exist_ok=True)
+    key_file = f'../config/keys/{kid}.pem'
+    with open(key_file, 'wb') as file:
+        file.write(pem)
+    os.chmod(key_file, 0o600)
+
+    print(f"Generated {algorithm} signing key {kid} in {key_file}")
 
 with open('../config/config.yaml', 'w') as file:
     yaml.safe_dump(config, file)
-
-print(f"Generated JWT key: {jwt_key}")
\ No newline at end of file
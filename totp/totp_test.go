@@ -0,0 +1,61 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rfc6238Secret is the SHA1 test key of RFC 6238 appendix B
+var rfc6238Secret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+func TestCode(t *testing.T) {
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+
+	for _, tt := range tests {
+		code, err := Code(rfc6238Secret, Step(time.Unix(tt.unix, 0)))
+		require.NoError(t, err)
+		assert.Equal(t, tt.code, code)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+
+	step, ok := Validate(rfc6238Secret, "005924", now)
+	assert.True(t, ok)
+	assert.Equal(t, Step(now), step)
+
+	// one step of drift is tolerated, two are not
+	_, ok = Validate(rfc6238Secret, "005924", now.Add(Period))
+	assert.True(t, ok)
+	_, ok = Validate(rfc6238Secret, "005924", now.Add(2*Period))
+	assert.False(t, ok)
+
+	_, ok = Validate(rfc6238Secret, "123456", now)
+	assert.False(t, ok)
+	_, ok = Validate(rfc6238Secret, "", now)
+	assert.False(t, ok)
+}
+
+func TestURI(t *testing.T) {
+	secret, err := GenerateSecret()
+	require.NoError(t, err)
+
+	uri := URI("userManagementSystem", "tester", secret)
+	assert.True(t, strings.HasPrefix(uri, "otpauth://totp/userManagementSystem:tester?"))
+	assert.Contains(t, uri, "secret="+secret)
+}
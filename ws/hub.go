@@ -8,6 +8,8 @@ type Hub struct {
 	Register   chan *Client
 	Unregister chan *Client
 	BroadCast  chan *Message
+	CloseRoom  chan int32
+	Disconnect chan int32
 }
 
 type Room struct {
@@ -22,9 +24,16 @@ func NewHub() *Hub {
 		Register:   make(chan *Client),
 		Unregister: make(chan *Client),
 		BroadCast:  make(chan *Message, 5),
+		CloseRoom:  make(chan int32),
+		Disconnect: make(chan int32),
 	}
 }
 
+// DisconnectUser hangs up the user in every room they are in.
+func (h *Hub) DisconnectUser(userID int32) {
+	h.Disconnect <- userID
+}
+
 func (h *Hub) Run() {
 	for {
 		select {
@@ -50,6 +59,26 @@ func (h *Hub) Run() {
 					close(cl.Message)
 				}
 			}
+		case roomID := <-h.CloseRoom:
+			h.mu.Lock()
+			if room, ok := h.Rooms[roomID]; ok {
+				// closing the message channel makes the client hang up
+				for _, cl := range room.Clients {
+					close(cl.Message)
+				}
+				delete(h.Rooms, roomID)
+			}
+			h.mu.Unlock()
+		case userID := <-h.Disconnect:
+			h.mu.Lock()
+			for _, room := range h.Rooms {
+				if cl, ok := room.Clients[userID]; ok {
+					// the client unregisters once it hung up, by then it is gone already
+					delete(room.Clients, userID)
+					close(cl.Message)
+				}
+			}
+			h.mu.Unlock()
 		case msg := <-h.BroadCast:
 			if _, ok := h.Rooms[msg.RoomID]; ok {
 				for _, cl := range h.Rooms[msg.RoomID].Clients {
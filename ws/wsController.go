@@ -1,12 +1,16 @@
 package ws
 
 import (
+	"errors"
+	"main/auth"
 	"main/db"
+	"main/dto"
 	"net/http"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgtype"
 	"go.uber.org/zap"
 )
@@ -59,7 +63,16 @@ func (ws *WsController) CreateRoom(c *gin.Context) {
 	}
 	req.Name = name
 
-	room, err := ws.Queries.CreateRoom(c.Request.Context(), req.Name)
+	owner, err := ws.Queries.GetUserByUsername(c.Request.Context(), username.(string))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not retrieve user information"})
+		return
+	}
+
+	room, err := ws.Queries.CreateRoom(c.Request.Context(), db.CreateRoomParams{
+		Name:    req.Name,
+		OwnerID: pgtype.Int4{Int32: owner.ID, Valid: true},
+	})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -84,7 +97,7 @@ func (ws *WsController) CreateRoom(c *gin.Context) {
 	}
 	ws.hub.mu.Unlock()
 
-	c.JSON(http.StatusCreated, room)
+	c.JSON(http.StatusCreated, dto.NewRoom(room))
 }
 
 var Upgrader = websocket.Upgrader{
@@ -107,6 +120,22 @@ func (ws *WsController) JoinRoom(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "error parsing username from header"})
 		return
 	}
+	// Browsers send the session cookie along with handshakes any site starts, the origin tells them apart
+	if c.GetBool(auth.CookieAuthKey) && !auth.AllowedOrigin(c.Request) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "origin not allowed"})
+		return
+	}
+
+	user, err := ws.Queries.GetUserByUsername(c.Request.Context(), username)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not retrieve user information"})
+		return
+	}
+	// The token may predate the restriction, the account status is what counts
+	if status := auth.CurrentStatus(user.Status, user.StatusExpiresAt.Time, user.EmailVerifiedAt.Valid); auth.Restricted(status) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "account is " + status, "status": status})
+		return
+	}
 
 	conn, err := Upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
@@ -125,12 +154,6 @@ func (ws *WsController) JoinRoom(c *gin.Context) {
 		return
 	}
 
-	user, err := ws.Queries.GetUserByUsername(c.Request.Context(), username)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not retrieve user information"})
-		return
-	}
-
 	cl := &Client{
 		Conn:     conn,
 		Message:  make(chan *Message, 10),
@@ -160,12 +183,29 @@ func (ws *WsController) GetRooms(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, rooms)
+	c.JSON(http.StatusOK, dto.NewRooms(rooms))
 }
 
-type ClientRes struct {
-	ID       int32  `json:"id"`
-	Username string `json:"username"`
+func (ws *WsController) DeleteRoom(c *gin.Context) {
+	roomIdInt, err := strconv.Atoi(c.Param("roomId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid room ID"})
+		return
+	}
+
+	room, err := ws.Queries.DeleteRoom(c.Request.Context(), int32(roomIdInt))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Room not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ws.hub.CloseRoom <- room.ID
+
+	c.JSON(http.StatusOK, dto.NewRoom(room))
 }
 
 func (ws *WsController) GetClients(c *gin.Context) {
@@ -190,14 +230,5 @@ func (ws *WsController) GetClients(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	clients := make([]ClientRes, 0)
-
-	for _, client := range users {
-		clients = append(clients, ClientRes{
-			ID:       client.ID,
-			Username: client.Username,
-		})
-	}
-
-	c.JSON(http.StatusOK, clients)
+	c.JSON(http.StatusOK, dto.NewPublicUsers(users))
 }
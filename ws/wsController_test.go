@@ -6,11 +6,13 @@ import (
 	"encoding/json"
 	"errors"
 	"github.com/jackc/pgx/v5/pgtype"
+	"main/auth"
 	"main/db"
 	"main/mocks"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/jackc/pgx/v5"
@@ -115,6 +117,18 @@ func (m *MockRows) Conn() *pgx.Conn {
 	return nil
 }
 
+// userColumnCount is how many columns a row of the users table scans into
+const userColumnCount = 21
+
+// anyColumns matches a Scan of that many columns
+func anyColumns(count int) []interface{} {
+	matchers := make([]interface{}, count)
+	for i := range matchers {
+		matchers[i] = mock.Anything
+	}
+	return matchers
+}
+
 // MockRow implements pgx.Row interface
 type MockRow struct {
 	mock.Mock
@@ -147,15 +161,7 @@ func TestCreateRoom(t *testing.T) {
 			username: "tester",
 			mockBehavior: func(mockDB *mocks.DBTX) {
 				mockRow := new(MockRow)
-				mockRow.On("Scan",
-					mock.Anything,
-					mock.Anything,
-					mock.Anything,
-					mock.Anything,
-					mock.Anything,
-					mock.Anything,
-					mock.Anything,
-				).Run(func(args mock.Arguments) {
+				mockRow.On("Scan", anyColumns(userColumnCount)...).Run(func(args mock.Arguments) {
 					*args.Get(0).(*int32) = 1
 					*args.Get(1).(*string) = "Test Room"
 				}).Return(nil)
@@ -368,6 +374,7 @@ func TestGetClients(t *testing.T) {
 				mockRow.On("Scan",
 					mock.Anything,
 					mock.Anything,
+					mock.Anything,
 				).Return(pgx.ErrNoRows)
 
 				mockDB.On("QueryRow",
@@ -414,7 +421,113 @@ func TestGetClients(t *testing.T) {
 				assert.Equal(t, "Client 1", response[0]["username"])
 				assert.Equal(t, float64(2), response[1]["id"])
 				assert.Equal(t, "Client 2", response[1]["username"])
+				assert.NotContains(t, response[0], "password")
 			}
 		})
 	}
 }
+
+// mockUserLookup answers GetUserByUsername with a verified user in the status
+func mockUserLookup(mockDB *mocks.DBTX, status string, expiresAt pgtype.Timestamp) {
+	mockRow := new(MockRow)
+	mockRow.On("Scan", anyColumns(userColumnCount)...).Run(func(args mock.Arguments) {
+		*args.Get(0).(*int32) = 1
+		*args.Get(1).(*string) = "tester"
+		*args.Get(7).(*pgtype.Timestamp) = pgtype.Timestamp{Time: time.Now(), Valid: true}
+		*args.Get(10).(*string) = status
+		*args.Get(14).(*pgtype.Timestamp) = expiresAt
+	}).Return(nil)
+	mockDB.On("QueryRow", mock.Anything, mock.Anything, mock.Anything).Return(mockRow)
+}
+
+func TestJoinRoomOrigin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name         string
+		cookieAuth   bool
+		origin       string
+		expectedCode int
+	}{
+		// requests past the origin check fail the upgrade, as they are no real handshakes
+		{name: "header authentication from another site", origin: "https://evil.example", expectedCode: http.StatusBadRequest},
+		{name: "cookie authentication from the same site", cookieAuth: true, origin: "http://api.example.com", expectedCode: http.StatusBadRequest},
+		{name: "cookie authentication from another site", cookieAuth: true, origin: "https://evil.example", expectedCode: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB := &mocks.DBTX{}
+			mockUserLookup(mockDB, auth.StatusActive, pgtype.Timestamp{})
+			wsc := NewWsController(db.New(mockDB), NewHub(), zap.NewNop())
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request, _ = http.NewRequest("GET", "http://api.example.com/ws/join-room/1", nil)
+			c.Request.Header.Set("Origin", tt.origin)
+			c.Params = []gin.Param{{Key: "roomId", Value: "1"}}
+			c.Set("username", "tester")
+			c.Set(auth.CookieAuthKey, tt.cookieAuth)
+
+			wsc.JoinRoom(c)
+
+			assert.Equal(t, tt.expectedCode, w.Code)
+		})
+	}
+}
+
+func TestJoinRoomAccountStatus(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name         string
+		status       string
+		expiresAt    pgtype.Timestamp
+		expectedCode int
+	}{
+		// requests that may join fail the upgrade, as they are no real handshakes
+		{name: "active", status: auth.StatusActive, expectedCode: http.StatusBadRequest},
+		{name: "suspended", status: auth.StatusSuspended, expiresAt: pgtype.Timestamp{Time: time.Now().Add(time.Hour), Valid: true}, expectedCode: http.StatusForbidden},
+		{name: "suspension ran out", status: auth.StatusSuspended, expiresAt: pgtype.Timestamp{Time: time.Now().Add(-time.Minute), Valid: true}, expectedCode: http.StatusBadRequest},
+		{name: "deactivated", status: auth.StatusDeactivated, expectedCode: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB := &mocks.DBTX{}
+			mockUserLookup(mockDB, tt.status, tt.expiresAt)
+			wsc := NewWsController(db.New(mockDB), NewHub(), zap.NewNop())
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request, _ = http.NewRequest("GET", "/ws/join-room/1", nil)
+			c.Params = []gin.Param{{Key: "roomId", Value: "1"}}
+			c.Set("username", "tester")
+
+			wsc.JoinRoom(c)
+
+			assert.Equal(t, tt.expectedCode, w.Code)
+		})
+	}
+}
+
+func TestHubDisconnectUser(t *testing.T) {
+	hub := NewHub()
+	go hub.Run()
+
+	suspended := &Client{ID: 1, RoomID: 1, Message: make(chan *Message, 10)}
+	other := &Client{ID: 2, RoomID: 1, Message: make(chan *Message, 10)}
+	hub.Rooms[1] = &Room{ID: 1, Clients: map[int32]*Client{1: suspended, 2: other}}
+
+	hub.DisconnectUser(1)
+
+	_, open := <-suspended.Message
+	assert.False(t, open)
+
+	// the hub is done with the disconnect once it takes the next one
+	hub.DisconnectUser(3)
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	assert.NotContains(t, hub.Rooms[1].Clients, int32(1))
+	assert.Contains(t, hub.Rooms[1].Clients, int32(2))
+}